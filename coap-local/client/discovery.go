@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"devicetransport"
+)
+
+// discoveryTimeout is how long the devices wait for a server to answer the discovery
+const discoveryTimeout = 5 * time.Second
+
+// discoverServer finds the CoAP server through the All-CoAP-Nodes group, points the URLs
+// of cfg at it and registers every device with it
+func discoverServer(ctx context.Context, cfg *Config) error {
+	discoverCtx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+	server, err := devicetransport.DiscoverServer(discoverCtx, devicetransport.AllCoapNodes)
+	if err != nil {
+		return err
+	}
+	log.Printf("CoAP server discovered at %s", server)
+	cfg.LogURL, cfg.MetricURL, cfg.ConfigURL = server+"/batchLog", server+"/batchMetric", server+"/config"
+
+	for _, deviceID := range cfg.DeviceIDs {
+		c, err := devicetransport.Register(ctx, server, devicetransport.Registration{DeviceID: deviceID, Model: "simulator"})
+		if err != nil {
+			return err
+		}
+		log.Printf("Device %s registered, metrics every %v, logs every %v", deviceID, c.MetricInterval(), c.LogInterval())
+	}
+	return nil
+}
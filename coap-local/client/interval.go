@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// interval is a send interval the server can change while the senders run
+type interval struct {
+	mu      sync.Mutex
+	d       time.Duration
+	changed chan struct{} // Closed and replaced on every change
+}
+
+func newInterval(d time.Duration) *interval {
+	return &interval{d: d, changed: make(chan struct{})}
+}
+
+// get returns the interval and a channel closed when it changes
+func (i *interval) get() (time.Duration, <-chan struct{}) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.d, i.changed
+}
+
+// set changes the interval, reporting whether it was different
+func (i *interval) set(d time.Duration) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if d <= 0 || d == i.d {
+		return false
+	}
+	i.d = d
+	close(i.changed)
+	i.changed = make(chan struct{})
+	return true
+}
@@ -1,127 +1,52 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	cbor "github.com/fxamacker/cbor/v2"
-	"go.opentelemetry.io/otel/trace"
+	"devicetransport"
 	"log"
 
 	"sync"
 	"time"
-	"github.com/plgd-dev/go-coap/v3/udp"
-	"github.com/plgd-dev/go-coap/v3/udp/client"
-	"github.com/plgd-dev/go-coap/v3/message"
-	"github.com/plgd-dev/go-coap/v3/message/codes"
 )
 
-// definizione di vari id che serve alla parte server
-var eventDefinitions = map[uint8]struct {
-	Severity string
-	Message  string
-}{
-	1: {"DEBUG", "Dispositivo in fase di inizializzazione"},
-	2: {"DEBUG", "Controllo stato rete"},
-	3: {"DEBUG", "Avvio modulo sensore"},
-	4: {"DEBUG", "Sincronizzazione orologio"},
-
-	5: {"INFO", "Avvio completato"},
-	6: {"INFO", "Temperatura normale"},
-	7: {"INFO", "CPU sotto soglia"},
-	8: {"INFO", "Heartbeat inviato"},
-
-	9:  {"NOTICE", "Cambio configurazione"},
-	10: {"NOTICE", "Aggiornamento firmware disponibile"},
-	11: {"NOTICE", "Sensore temporaneamente inattivo"},
-	12: {"NOTICE", "Collegamento rete ristabilito"},
-
-	13: {"WARNING", "Temperatura elevata"},
-	14: {"WARNING", "Consumo CPU sopra la soglia"},
-	15: {"WARNING", "Batteria in esaurimento"},
-	16: {"WARNING", "Perdita pacchetti rilevata"},
-
-	17: {"ERROR", "Impossibile connettersi al server"},
-	18: {"ERROR", "Errore lettura sensore"},
-	19: {"ERROR", "Timeout nella risposta del server"},
-	20: {"ERROR", "Scrittura su memoria fallita"},
-
-	21: {"CRITICAL", "Perdita connessione permanente"},
-	22: {"CRITICAL", "Dati corrotti nella memoria"},
-
-	23: {"ALERT", "Accesso non autorizzato rilevato"},
-	24: {"ALERT", "Possibile attacco DoS in corso"},
-
-	25: {"EMERGENCY", "Sistema in stato critico - riavvio necessario"},
-	26: {"EMERGENCY", "Errore hardware irreversibile"},
-	27: {"EMERGENCY", "Guasto alimentazione principale"},
-}
-
-type LogEntryCompact [2]int64
+// LogEntryCompact is a log event: event ID and unix timestamp
+type LogEntryCompact = devicetransport.LogEntryCompact
 
 // LogSender represents a device that sends randomly generated logs
 type LogSender struct {
-	client   *client.Conn
-	tracer     trace.Tracer
+	transport  devicetransport.Transport
 	deviceID   string
-	url        string
 	logCache   []LogEntryCompact
 	cacheMutex sync.Mutex
 }
 
-// NewLogSender creates a new LogSender with its own CoAP client
-func NewLogSender(deviceID, serverAddr, url string, tracer trace.Tracer) *LogSender {
-	c, err := udp.Dial(serverAddr)
-	if err != nil {
-		log.Fatalf("Failed to create CoAP client for device %s: %v", deviceID, err)
-	}
+// NewLogSender creates a new LogSender sending through the shared transport
+func NewLogSender(deviceID string, transport devicetransport.Transport) *LogSender {
 	return &LogSender{
-		client:   c,
-		tracer:   tracer,
-		deviceID: deviceID,
-		url:      url,
+		transport: transport,
+		deviceID:  deviceID,
 	}
 }
 
-// Send sends a batch of log entries to the configured URL using CBOR encoding and OpenTelemetry tracing
+// Send sends a batch of log entries through the configured transport
 func (s *LogSender) Send(ctx context.Context, entries []LogEntryCompact) error {
 	if len(entries) == 0 {
 		return nil
 	}
 
-	ctx, span := s.tracer.Start(ctx, "send_log_batch")
-	defer span.End()
-
-	payload := map[string]interface{}{
-		"device_id": s.deviceID,
-		"logs":      entries,
-	}
-
-	data, err := cbor.Marshal(payload)
-	if err != nil {
-		span.RecordError(err)
-		return err
-	}
-
-	resp, err := s.client.Post(ctx, s.url, message.AppCBOR, bytes.NewReader(data))
-	if err != nil {
-		span.RecordError(err)
+	if err := s.transport.SendLogBatch(ctx, s.deviceID, entries, nil); err != nil {
 		log.Printf("[%s] Failed to send logs: %v", s.deviceID, err)
 		return err
 	}
-	//defer resp.Body().Close()
 
-	if resp.Code() != codes.Created && resp.Code() != codes.Changed {
-		log.Printf("[%s] Unexpected response code: %v", s.deviceID, resp.Code())
-	} else {
-		log.Printf("[%s] Sent %d logs successfully", s.deviceID, len(entries))
-	}
+	log.Printf("[%s] Sent %d logs successfully", s.deviceID, len(entries))
 	return nil
 }
 
 // addEvent adds a new event with the given ID to the log cache
 func (s *LogSender) addEvent(id uint8) {
 	// Check if the event ID is defined
-	if _, ok := eventDefinitions[id]; !ok {
+	if _, ok := devicetransport.EventDefinitions[id]; !ok {
 		log.Printf("Undefined event ID: %d", id)
 		return
 	}
@@ -166,9 +91,11 @@ func (s *LogSender) SendBatch(ctx context.Context, batchSize int) error {
     return s.Send(ctx, entries)
 }
 
-// runLogSenders runs a loop that periodically sends batches of logs for all devices until context is cancelled
-func runLogSenders(ctx context.Context, senders []*LogSender, interval time.Duration, batchSize int) {
-    ticker := time.NewTicker(interval)
+// runLogSenders runs a loop that periodically sends batches of logs for all devices until context is cancelled,
+// following the changes of the interval pushed by the server
+func runLogSenders(ctx context.Context, senders []*LogSender, interval *interval, batchSize int) {
+    d, changed := interval.get()
+    ticker := time.NewTicker(d)
     defer ticker.Stop()
 
     for {
@@ -176,6 +103,9 @@ func runLogSenders(ctx context.Context, senders []*LogSender, interval time.Dura
         case <-ctx.Done():
             log.Println("Stopping log senders...")
             return
+        case <-changed:
+            d, changed = interval.get()
+            ticker.Reset(d)
         case <-ticker.C:
             for _, sender := range senders {
                 if err := sender.SendBatch(ctx, batchSize); err != nil {
@@ -8,15 +8,20 @@ import (
 	"syscall"
 	"time"
 
+	"devicetransport"
 	"go.opentelemetry.io/otel"
 )
 
 // Config holds all configuration settings for the system
 type Config struct {
-	LogAddr          string        // CoAP server address for logs
-	MetricAddr       string        // CoAP server address for metrics
+	Protocol         string        // Transport of metrics and logs: "coap" or "http"
+	LogURL           string        // Server URL for logs, coap://host:port/path or http(s)://host/path
+	MetricURL        string        // Server URL for metrics
+	ConfigURL        string        // Config resource observed for interval changes, empty to keep the defaults
+	Discover         bool          // Find the CoAP server by multicast and register the devices, replacing the URLs
 	DeviceIDs        []string     
 	BatchSize        int           // Number of log entries to send per batch
+	BlockSize        int           // Size of the Block1 blocks of the payloads larger than one block
 	BatchInterval    time.Duration // Time interval between batch sends
 	MetricInterval   time.Duration // Time interval between sending metrics
 	EventGenInterval EventIntervalConfig // Configuration for event generation intervals
@@ -31,9 +36,12 @@ type EventIntervalConfig struct {
 // loadConfig loads the system configuration with default values
 func loadConfig() Config {
 	cfg := Config{
-		LogAddr:        "localhost:5683",  // Default CoAP port
-		MetricAddr:     "localhost:5683",  // Same server, different resource path
+		Protocol:       devicetransport.ProtocolCoAP,
+		LogURL:         "coap://localhost:5683/batchLog",    // Default CoAP port
+		MetricURL:      "coap://localhost:5683/batchMetric", // Same server, different resource path
+		ConfigURL:      "coap://localhost:5683/config",
 		BatchSize:      30,
+		BlockSize:      1024,
 		BatchInterval:  1 * time.Minute,
 		MetricInterval: 60 * time.Second,
 		DeviceIDs: []string{
@@ -67,6 +75,24 @@ func handleShutdown(cancelFunc context.CancelFunc) {
 	cancelFunc()
 }
 
+// applyDeviceConfig applies the intervals of a config pushed by the server and logs the
+// rest, which the simulated devices don't act on
+func applyDeviceConfig(c devicetransport.DeviceConfig, metricInterval, batchInterval *interval) {
+	if metricInterval.set(c.MetricInterval()) {
+		log.Printf("Metric interval set to %v by the server", c.MetricInterval())
+	}
+	if batchInterval.set(c.LogInterval()) {
+		log.Printf("Log batch interval set to %v by the server", c.LogInterval())
+	}
+	for field, l := range c.Thresholds {
+		log.Printf("Thresholds of %s: warning %.1f, critical %.1f, emergency %.1f", field, l.Warning, l.Critical, l.Emergency)
+	}
+	if c.EventCatalogVersion != devicetransport.DefaultEventCatalog.Version {
+		log.Printf("Server event catalog is version %d, the devices send version %d",
+			c.EventCatalogVersion, devicetransport.DefaultEventCatalog.Version)
+	}
+}
+
 func main() {
 	log.Println("Starting IoT device simulation system with CoAP...")
 
@@ -87,8 +113,24 @@ func main() {
 	}
 	defer shutdown(ctx)
 
-	// Create a tracer instance to be used by CoAP clients and senders
+	// Point the devices at the server that answers the multicast discovery
+	if cfg.Discover {
+		if err := discoverServer(ctx, &cfg); err != nil {
+			log.Fatalf("Discovery error: %v", err)
+		}
+	}
+
+	// Create a tracer instance and the transport shared by all senders
 	tracer := otel.Tracer("device-simulator")
+	transport, err := devicetransport.New(devicetransport.Config{
+		Protocol:  cfg.Protocol,
+		MetricURL: cfg.MetricURL,
+		LogURL:    cfg.LogURL,
+		BlockSize: cfg.BlockSize,
+	}, tracer)
+	if err != nil {
+		log.Fatalf("Transport error: %v", err)
+	}
 
 	logSenders := make([]*LogSender, 0, len(cfg.DeviceIDs))
 	metricSenders := make([]*MetricSender, 0, len(cfg.DeviceIDs))
@@ -96,35 +138,40 @@ func main() {
 	// For each device ID in configuration
 	for _, deviceID := range cfg.DeviceIDs {
 		// Create a log sender dedicated for this device
-		logSender := NewLogSender(deviceID, cfg.LogAddr, "/batchLog", tracer)
+		logSender := NewLogSender(deviceID, transport)
 		logSenders = append(logSenders, logSender)
 
 		// Initialize metric sender for this device
-		metricSender := NewMetricSender(deviceID, cfg.MetricAddr, "/batchMetric", tracer)
+		metricSender := NewMetricSender(deviceID, transport)
 		metricSenders = append(metricSenders, metricSender)
 		log.Printf("Started device: %s", deviceID)
 	}
 
+	// The send intervals follow the config the server pushes, without restarting the devices
+	batchInterval, metricInterval := newInterval(cfg.BatchInterval), newInterval(cfg.MetricInterval)
+	if cfg.ConfigURL != "" {
+		err := devicetransport.ObserveConfig(ctx, cfg.ConfigURL, func(c devicetransport.DeviceConfig) {
+			applyDeviceConfig(c, metricInterval, batchInterval)
+		})
+		if err != nil {
+			log.Printf("Config not observed, keeping the default intervals: %v", err)
+		}
+	}
+
 	// Casual events/logs to simulate a devices internal operation
 	go runEventGenerators(ctx, logSenders, cfg.EventGenInterval)
 
 	// Start a goroutine to send logs periodically for all logSenders
-	go runLogSenders(ctx, logSenders, cfg.BatchInterval, cfg.BatchSize)
+	go runLogSenders(ctx, logSenders, batchInterval, cfg.BatchSize)
 
 	// Start a goroutine to send metrics periodically (every 1 minute 30 seconds)
-	go runMetricSenders(ctx, metricSenders, cfg.MetricInterval)
+	go runMetricSenders(ctx, metricSenders, metricInterval)
 
 	// Wait for shutdown signal (context cancellation)
 	<-ctx.Done()
 	log.Println("Shutdown complete")
 
-	// Close all clients
-	for _, s := range logSenders {
-		s.client.Close()
-	}
-
-	for _, s := range metricSenders {
-		s.client.Close()
-	}
+	// Close the connections of all devices
+	transport.Close()
 
 }
\ No newline at end of file
@@ -1,23 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	//"fmt"
-	"github.com/fxamacker/cbor/v2"
-	//"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/attribute"
-	//"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/trace"
+	"devicetransport"
 	"gonum.org/v1/gonum/stat/distuv"
 	"log"
 	"math/rand"
-	//"net/http"
 	"time"
-	"github.com/plgd-dev/go-coap/v3/message"
-	"github.com/plgd-dev/go-coap/v3/message/codes"
-	"github.com/plgd-dev/go-coap/v3/udp"
-	"github.com/plgd-dev/go-coap/v3/udp/client"
 )
 
 // Metrics represents the telemetry data collected from a device.
@@ -34,10 +23,8 @@ type Metrics struct {
 
 // MetricSender simulates a device sending metrics to a remote server.
 type MetricSender struct {
-	deviceID string
-	client   *client.Conn
-	tracer   trace.Tracer
-	url      string
+	deviceID  string
+	transport devicetransport.Transport
 
 	// Anomaly simulation
 	anomalyStartTime    time.Time
@@ -47,47 +34,23 @@ type MetricSender struct {
 	baseTemp            float64
 }
 
-func NewMetricSender(deviceID, serverAddr, url string, tracer trace.Tracer) *MetricSender {
-	c, err := udp.Dial(serverAddr)
-	if err != nil {
-		log.Fatalf("Failed to create CoAP client for device %s: %v", deviceID, err)
-	}
+func NewMetricSender(deviceID string, transport devicetransport.Transport) *MetricSender {
 	return &MetricSender{
-		deviceID: deviceID,
-		client:   c,
-		tracer:   tracer,
-		url:      url,
+		deviceID:  deviceID,
+		transport: transport,
 	}
 }
 
 func (s *MetricSender) SendMetric(ctx context.Context) error {
 	maybeTriggerAnomaly(s)
 
-	ctx, span := s.tracer.Start(ctx, "send_metrics",
-		trace.WithAttributes(attribute.String("device.id", s.deviceID)))
-	defer span.End()
-
 	metric := s.GenerateMetrics()
-	data, err := cbor.Marshal(metric)
-	if err != nil {
-		span.RecordError(err)
-		log.Printf("[%s] CBOR marshal error: %v", s.deviceID, err)
-		return err
-	}
-
-	resp, err := s.client.Post(ctx, s.url, message.AppCBOR, bytes.NewReader(data))
-	if err != nil {
-		span.RecordError(err)
+	if err := s.transport.SendMetrics(ctx, s.deviceID, metric); err != nil {
 		log.Printf("[%s] Failed to send metrics: %v", s.deviceID, err)
 		return err
 	}
-	//defer resp.Body().Close()
 
-	if resp.Code() != codes.Created && resp.Code() != codes.Changed {
-		log.Printf("[%s] Unexpected response code: %v", s.deviceID, resp.Code())
-	} else {
-		log.Printf("[%s] Sent metric successfully", s.deviceID)
-	}
+	log.Printf("[%s] Sent metric successfully", s.deviceID)
 	return nil
 }
 
@@ -176,9 +139,10 @@ func clamp(val, min, max float64) float64 {
 	return val
 }
 
-// runMetricSenders starts all metric senders on a fixed interval.
-func runMetricSenders(ctx context.Context, senders []*MetricSender, interval time.Duration) {
-	ticker := time.NewTicker(interval)
+// runMetricSenders starts all metric senders on the interval, reset when the server pushes a new one.
+func runMetricSenders(ctx context.Context, senders []*MetricSender, interval *interval) {
+	d, changed := interval.get()
+	ticker := time.NewTicker(d)
 	defer ticker.Stop()
 
 	for {
@@ -186,6 +150,9 @@ func runMetricSenders(ctx context.Context, senders []*MetricSender, interval tim
 		case <-ctx.Done():
 			log.Println("Stopping metric senders...")
 			return
+		case <-changed:
+			d, changed = interval.get()
+			ticker.Reset(d)
 		case <-ticker.C:
 			// creo tutti metric sender necessari
 			for _, sender := range senders {
@@ -2,6 +2,7 @@ package main
 
 import(
 	"context"
+	"devicetransport"
 	"log"
 	"math/rand"
 	"time"
@@ -16,8 +17,8 @@ func runEventGenerators(ctx context.Context, senders []*LogSender, intervalRange
 // startRandomEventGenerator starts a random event generator for a single device
 func startRandomEventGenerator(ctx context.Context, sender *LogSender, config EventIntervalConfig) {
 	// Create a slice containing all available event IDs
-	eventIDs := make([]uint8, 0, len(eventDefinitions))
-	for id := range eventDefinitions {
+	eventIDs := make([]uint8, 0, len(devicetransport.EventDefinitions))
+	for id := range devicetransport.EventDefinitions {
 		eventIDs = append(eventIDs, id)
 	}
 
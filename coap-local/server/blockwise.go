@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v3/net/blockwise"
+	"github.com/plgd-dev/go-coap/v3/options"
+	udpServer "github.com/plgd-dev/go-coap/v3/udp/server"
+)
+
+// Defaults of the block-wise transfers: blocks that fit a datagram on any link, a whole
+// backlog of logs accumulated during an outage, and the time its blocks may take to arrive
+const (
+	defaultBlockSize        = 1024
+	defaultMaxMessageSize   = 1 << 20
+	defaultBlockwiseTimeout = 10 * time.Second
+)
+
+// blockSZX returns the block size exponent of size bytes, a power of two from 16 to 1024
+func blockSZX(size int) (blockwise.SZX, bool) {
+	for szx := blockwise.SZX16; szx <= blockwise.SZX1024; szx++ {
+		if szx.Size() == int64(size) {
+			return szx, true
+		}
+	}
+	return 0, false
+}
+
+// blockwiseConfig is the size of the blocks, the largest reassembled payload and the time the
+// blocks of a transfer may take
+type blockwiseConfig struct {
+	blockSize      int
+	maxMessageSize int
+	timeout        time.Duration
+}
+
+// loadBlockwiseConfig reads COAP_BLOCK_SIZE, the size of the blocks of the requests and
+// responses split with Block1 and Block2, COAP_MAX_MESSAGE_SIZE, the largest reassembled
+// payload, and COAP_BLOCKWISE_TIMEOUT, how long the blocks of a transfer may take; an
+// invalid value falls back to its default
+func loadBlockwiseConfig() blockwiseConfig {
+	cfg := blockwiseConfig{
+		blockSize:      defaultBlockSize,
+		maxMessageSize: defaultMaxMessageSize,
+		timeout:        defaultBlockwiseTimeout,
+	}
+	if raw := os.Getenv("COAP_BLOCK_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if _, ok := blockSZX(n); err != nil || !ok {
+			slog.Warn("Invalid COAP_BLOCK_SIZE, using the default",
+				slog.String("value", raw), slog.Int("default", defaultBlockSize))
+		} else {
+			cfg.blockSize = n
+		}
+	}
+
+	if raw := os.Getenv("COAP_MAX_MESSAGE_SIZE"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < cfg.blockSize {
+			slog.Warn("Invalid COAP_MAX_MESSAGE_SIZE, using the default",
+				slog.String("value", raw), slog.Int("default", defaultMaxMessageSize))
+		} else {
+			cfg.maxMessageSize = n
+		}
+	}
+
+	if raw := os.Getenv("COAP_BLOCKWISE_TIMEOUT"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			slog.Warn("Invalid COAP_BLOCKWISE_TIMEOUT, using the default",
+				slog.String("value", raw), slog.Duration("default", defaultBlockwiseTimeout))
+		} else {
+			cfg.timeout = d
+		}
+	}
+	return cfg
+}
+
+// blockwiseOptions returns the server options of the block-wise transfers of the environment
+func blockwiseOptions() []udpServer.Option {
+	cfg := loadBlockwiseConfig()
+	szx, _ := blockSZX(cfg.blockSize)
+	slog.Info("Block-wise transfers enabled", slog.Int("block_size", cfg.blockSize),
+		slog.Int("max_message_size", cfg.maxMessageSize), slog.Duration("timeout", cfg.timeout))
+	return []udpServer.Option{
+		options.WithBlockwise(true, szx, cfg.timeout),
+		options.WithMaxMessageSize(uint32(cfg.maxMessageSize)),
+	}
+}
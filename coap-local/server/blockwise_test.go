@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"devicetransport"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	coapNet "github.com/plgd-dev/go-coap/v3/net"
+	"github.com/plgd-dev/go-coap/v3/net/blockwise"
+	"github.com/plgd-dev/go-coap/v3/options"
+	"github.com/plgd-dev/go-coap/v3/udp"
+	udpServer "github.com/plgd-dev/go-coap/v3/udp/server"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestBlockSZX(t *testing.T) {
+	for szx, size := range []int{16, 32, 64, 128, 256, 512, 1024} {
+		got, ok := blockSZX(size)
+		if !ok || got != blockwise.SZX(szx) {
+			t.Errorf("blockSZX(%d) = %v, %v, want %v, true", size, got, ok, blockwise.SZX(szx))
+		}
+	}
+	for _, size := range []int{0, -16, 8, 17, 100, 2048} {
+		if _, ok := blockSZX(size); ok {
+			t.Errorf("blockSZX(%d) accepted an invalid block size", size)
+		}
+	}
+}
+
+func TestLoadBlockwiseConfig(t *testing.T) {
+	defaults := blockwiseConfig{defaultBlockSize, defaultMaxMessageSize, defaultBlockwiseTimeout}
+	tests := []struct {
+		name                    string
+		blockSize, max, timeout string
+		want                    blockwiseConfig
+	}{
+		{"unset", "", "", "", defaults},
+		{"valid", "64", "4096", "3s", blockwiseConfig{64, 4096, 3 * time.Second}},
+		{"block size not a number", "big", "", "", defaults},
+		{"block size not a power of two", "100", "", "", defaults},
+		{"block size too large", "2048", "", "", defaults},
+		{"max size not a number", "", "1MB", "", defaults},
+		{"max size under the block size", "512", "256", "", blockwiseConfig{512, defaultMaxMessageSize, defaultBlockwiseTimeout}},
+		{"timeout not a duration", "", "", "10", defaults},
+		{"timeout not positive", "", "", "-1s", defaults},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("COAP_BLOCK_SIZE", tt.blockSize)
+			t.Setenv("COAP_MAX_MESSAGE_SIZE", tt.max)
+			t.Setenv("COAP_BLOCKWISE_TIMEOUT", tt.timeout)
+			if got := loadBlockwiseConfig(); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBlockwiseLogBatch sends a log batch many blocks long through the CoAP transport of the
+// devices and checks the server reassembles all of it
+func TestBlockwiseLogBatch(t *testing.T) {
+	const blockSize = 64
+	t.Setenv("COAP_BLOCK_SIZE", "64")
+	t.Setenv("COAP_MAX_MESSAGE_SIZE", "")
+	t.Setenv("COAP_BLOCKWISE_TIMEOUT", "")
+
+	// The reassembled payload, decoded like the log handler does
+	type payload struct {
+		size  int
+		batch IncomingLogBatch
+		err   error
+	}
+	received := make(chan payload, 1)
+	router := mux.NewRouter()
+	router.Handle("/batchLog", mux.HandlerFunc(func(w mux.ResponseWriter, r *mux.Message) {
+		body, err := r.ReadBody()
+		p := payload{size: len(body), err: err}
+		if err == nil {
+			p.err = decodePayload(r, body, logBatchSchema, &p.batch)
+		}
+		received <- p
+		w.SetResponse(codes.Created, message.TextPlain, nil)
+	}))
+
+	l, err := coapNet.NewListenUDP("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := udp.NewServer(append([]udpServer.Option{options.WithMux(router)}, blockwiseOptions()...)...)
+	go s.Serve(l)
+	defer s.Stop()
+
+	base := "coap://" + l.LocalAddr().String()
+	transport, err := devicetransport.New(devicetransport.Config{
+		Protocol:  devicetransport.ProtocolCoAP,
+		MetricURL: base + "/batchMetric",
+		LogURL:    base + "/batchLog",
+		Timeout:   5 * time.Second,
+		BlockSize: blockSize,
+	}, noop.NewTracerProvider().Tracer("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+
+	entries := make([]devicetransport.LogEntryCompact, 200)
+	for i := range entries {
+		entries[i] = devicetransport.LogEntryCompact{int64(i % 32), 1760600000 + int64(i)}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := transport.SendLogBatch(ctx, "device-001", entries, nil); err != nil {
+		t.Fatalf("failed to send the log batch: %v", err)
+	}
+
+	var p payload
+	select {
+	case p = <-received:
+	case <-ctx.Done():
+		t.Fatal("the server never received the log batch")
+	}
+	if p.err != nil {
+		t.Fatalf("failed to read the reassembled payload: %v", p.err)
+	}
+	if p.size <= 4*blockSize {
+		t.Fatalf("payload of %d bytes, want one several %d byte blocks long", p.size, blockSize)
+	}
+	batch := p.batch
+	if batch.DeviceID != "device-001" || len(batch.Logs) != len(entries) {
+		t.Fatalf("got %d logs of %q, want %d of device-001", len(batch.Logs), batch.DeviceID, len(entries))
+	}
+	for i, entry := range batch.Logs {
+		if len(entry) != 2 || entry[0] != entries[i][0] || entry[1] != entries[i][1] {
+			t.Fatalf("log %d is %v, want %v", i, entry, entries[i])
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultLogBatchDedupWindow is how long a log batch ID is remembered by default, longer
+// than a device keeps retrying a batch
+const defaultLogBatchDedupWindow = 15 * time.Minute
+
+// batchDedup remembers the IDs of the recent log batches of every device, so a batch
+// retried after a lost acknowledgement is not logged twice
+type batchDedup struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time // first arrival by device and batch ID
+}
+
+// logBatchDedup is the dedup window of the log batches, 0 disables it
+var logBatchDedup = newBatchDedup(loadLogBatchDedupWindow())
+
+func newBatchDedup(window time.Duration) *batchDedup {
+	return &batchDedup{window: window, seen: make(map[string]time.Time)}
+}
+
+// loadLogBatchDedupWindow reads LOG_BATCH_DEDUP_WINDOW, "0" turns deduplication off
+func loadLogBatchDedupWindow() time.Duration {
+	raw := os.Getenv("LOG_BATCH_DEDUP_WINDOW")
+	if raw == "" {
+		return defaultLogBatchDedupWindow
+	}
+	window, err := time.ParseDuration(raw)
+	if err != nil || window < 0 {
+		slog.Warn("Invalid LOG_BATCH_DEDUP_WINDOW, using the default",
+			slog.String("value", raw), slog.Duration("default", defaultLogBatchDedupWindow))
+		return defaultLogBatchDedupWindow
+	}
+	return window
+}
+
+// firstSeen records the batch of a device and reports whether it is the first time it
+// arrived within the window; batches without an ID are always new
+func (d *batchDedup) firstSeen(deviceID, batchID string, now time.Time) bool {
+	if batchID == "" || d.window <= 0 {
+		return true
+	}
+	key := deviceID + "\x00" + batchID
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if at, ok := d.seen[key]; ok && now.Sub(at) < d.window {
+		return false
+	}
+	d.seen[key] = now
+	return true
+}
+
+// expire forgets the batches older than the window
+func (d *batchDedup) expire(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, at := range d.seen {
+		if now.Sub(at) >= d.window {
+			delete(d.seen, key)
+		}
+	}
+}
+
+// run expires the old batch IDs every window until ctx is done
+func (d *batchDedup) run(ctx context.Context) {
+	if d.window <= 0 {
+		return
+	}
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.expire(now)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"devicetransport"
+)
+
+// configReloadInterval is how often DEVICE_CONFIG_FILE and EVENT_CATALOG_FILE are checked for changes
+const configReloadInterval = 10 * time.Second
+
+// levels are the readings of a metric past which a sample is WARNING, CRITICAL or EMERGENCY
+type levels struct {
+	Warning   float64 `cbor:"warning" json:"warning"`
+	Critical  float64 `cbor:"critical" json:"critical"`
+	Emergency float64 `cbor:"emergency" json:"emergency"`
+}
+
+// deviceConfig is the configuration pushed to the devices on /config: how often they report,
+// the alert levels of their readings and the version of the event catalog to send logs with
+type deviceConfig struct {
+	MetricIntervalS     int               `cbor:"metric_interval_s" json:"metric_interval_s"`
+	LogIntervalS        int               `cbor:"log_interval_s" json:"log_interval_s"`
+	Thresholds          map[string]levels `cbor:"thresholds" json:"thresholds"`                       // By metric field, like temp_c
+	EventCatalogVersion int               `cbor:"event_catalog_version" json:"event_catalog_version"` // Set by the server
+}
+
+// defaultDeviceConfig is the configuration used without a DEVICE_CONFIG_FILE
+var defaultDeviceConfig = deviceConfig{
+	MetricIntervalS: 60,
+	LogIntervalS:    60,
+	Thresholds: map[string]levels{
+		"temp_c": {Warning: 75, Critical: 85, Emergency: 95},
+	},
+}
+
+// currentDeviceConfig is the configuration in use, swapped when its file changes
+var currentDeviceConfig atomic.Pointer[deviceConfig]
+
+func init() {
+	c := defaultDeviceConfig
+	c.EventCatalogVersion = devicetransport.DefaultEventCatalog.Version
+	currentDeviceConfig.Store(&c)
+}
+
+// loadDeviceConfig reads a configuration file over the defaults, the defaults when path is empty
+func loadDeviceConfig(path string) (*deviceConfig, error) {
+	c := defaultDeviceConfig
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// Decoded in a map of its own, the thresholds of the file replace the default ones
+		c.Thresholds = nil
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("invalid device config %s: %w", path, err)
+		}
+		if c.Thresholds == nil {
+			c.Thresholds = defaultDeviceConfig.Thresholds
+		}
+	}
+	if c.MetricIntervalS <= 0 || c.LogIntervalS <= 0 {
+		return nil, fmt.Errorf("invalid device config %s: intervals must be positive", path)
+	}
+	for field, l := range c.Thresholds {
+		if l.Warning > l.Critical || l.Critical > l.Emergency {
+			return nil, fmt.Errorf("invalid device config %s: levels of %s out of order", path, field)
+		}
+	}
+	c.EventCatalogVersion = eventCatalog.Load().Version
+	return &c, nil
+}
+
+// severity classifies a reading of field, INFO below its levels or without levels
+func (c *deviceConfig) severity(field string, v float64) string {
+	l, ok := c.Thresholds[field]
+	switch {
+	case !ok || v < l.Warning:
+		return "INFO"
+	case v < l.Critical:
+		return "WARNING"
+	case v < l.Emergency:
+		return "CRITICAL"
+	default:
+		return "EMERGENCY"
+	}
+}
+
+// watchDeviceConfig loads DEVICE_CONFIG_FILE and reloads it and EVENT_CATALOG_FILE when
+// they change, pushing the new configuration to the observers of /config
+func watchDeviceConfig(ctx context.Context, configPath, catalogPath string) error {
+	c, err := loadDeviceConfig(configPath)
+	if err != nil {
+		return err
+	}
+	currentDeviceConfig.Store(c)
+
+	go func() {
+		configMod, catalogMod := modTime(configPath), modTime(catalogPath)
+		ticker := time.NewTicker(configReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			changed := false
+			if t := modTime(catalogPath); !t.Equal(catalogMod) {
+				catalogMod = t
+				catalog, err := devicetransport.LoadEventCatalog(catalogPath)
+				if err != nil {
+					slog.Error("Failed to reload the event catalog, keeping the previous one", slog.Any("error", err))
+				} else {
+					eventCatalog.Store(&catalog)
+					slog.Info("Event catalog reloaded", slog.Int("version", catalog.Version))
+					changed = true
+				}
+			}
+			if t := modTime(configPath); !t.Equal(configMod) || changed {
+				configMod = t
+				c, err := loadDeviceConfig(configPath)
+				if err != nil {
+					slog.Error("Failed to reload the device config, keeping the previous one", slog.Any("error", err))
+					continue
+				}
+				currentDeviceConfig.Store(c)
+				slog.Info("Device config reloaded", slog.String("file", configPath))
+				configObservers.notify()
+			}
+		}
+	}()
+	return nil
+}
+
+// modTime returns when the file at path last changed, zero without a file
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
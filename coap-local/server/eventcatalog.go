@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"devicetransport"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+)
+
+// eventCatalog decodes the log events, the catalog built into devicetransport unless
+// EVENT_CATALOG_FILE is set, swapped when the file changes
+var eventCatalog atomic.Pointer[devicetransport.EventCatalog]
+
+func init() {
+	eventCatalog.Store(&devicetransport.DefaultEventCatalog)
+}
+
+// handleCoapEventCatalog returns the catalog the devices should send their events with,
+// in CBOR unless the Accept option asks for JSON; the catalog version is the ETag
+func handleCoapEventCatalog(w mux.ResponseWriter, r *mux.Message) {
+	catalog := eventCatalog.Load()
+	format, body, err := encodeResponse(r, catalog)
+	if err != nil {
+		log.Printf("Error encoding event catalog: %v", err)
+		w.SetResponse(codes.InternalServerError, message.TextPlain, nil)
+		return
+	}
+
+	if err := w.SetResponse(codes.Content, format, bytes.NewReader(body),
+		message.Option{ID: message.ETag, Value: []byte(fmt.Sprint(catalog.Version))}); err != nil {
+		log.Printf("Error sending event catalog: %v", err)
+	}
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"github.com/fxamacker/cbor/v2"
 	"github.com/plgd-dev/go-coap/v3/message"
 	"github.com/plgd-dev/go-coap/v3/message/codes"
 	"github.com/plgd-dev/go-coap/v3/mux"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"log"
 	"log/slog"
 	"strings"
@@ -14,49 +14,50 @@ import (
 
 // IncomingLogBatch represents the structure of a log batch sent by a device
 type IncomingLogBatch struct {
-	DeviceID string    `cbor:"device_id"`
-	Logs     [][]int64 `cbor:"logs"` // Each log is a pair: [event_id, timestamp]
+	DeviceID string     `cbor:"device_id" json:"device_id"`
+	BatchID  string     `cbor:"batch_id,omitempty" json:"batch_id,omitempty"` // Same for every retry of a batch, optional
+	Logs     [][]int64  `cbor:"logs" json:"logs"`                       // Each log is a pair: [event_id, timestamp]
+	Links    []SpanLink `cbor:"links,omitempty" json:"links,omitempty"` // Span of each log, by index, if sent
 }
 
-// Map of event IDs to their severity and message descriptions
-var eventDefinitions = map[uint8]struct {
-	Severity string
-	Message  string
-}{
-	1: {"DEBUG", "Dispositivo in fase di inizializzazione"},
-	2: {"DEBUG", "Controllo stato rete"},
-	3: {"DEBUG", "Avvio modulo sensore"},
-	4: {"DEBUG", "Sincronizzazione orologio"},
-
-	5: {"INFO", "Avvio completato"},
-	6: {"INFO", "Temperatura normale"},
-	7: {"INFO", "CPU sotto soglia"},
-	8: {"INFO", "Heartbeat inviato"},
-
-	9:  {"NOTICE", "Cambio configurazione"},
-	10: {"NOTICE", "Aggiornamento firmware disponibile"},
-	11: {"NOTICE", "Sensore temporaneamente inattivo"},
-	12: {"NOTICE", "Collegamento rete ristabilito"},
-
-	13: {"WARNING", "Temperatura elevata"},
-	14: {"WARNING", "Consumo CPU sopra la soglia"},
-	15: {"WARNING", "Batteria in esaurimento"},
-	16: {"WARNING", "Perdita pacchetti rilevata"},
-
-	17: {"ERROR", "Impossibile connettersi al server"},
-	18: {"ERROR", "Errore lettura sensore"},
-	19: {"ERROR", "Timeout nella risposta del server"},
-	20: {"ERROR", "Scrittura su memoria fallita"},
-
-	21: {"CRITICAL", "Perdita connessione permanente"},
-	22: {"CRITICAL", "Dati corrotti nella memoria"},
-
-	23: {"ALERT", "Accesso non autorizzato rilevato"},
-	24: {"ALERT", "Possibile attacco DoS in corso"},
-
-	25: {"EMERGENCY", "Sistema in stato critico - riavvio necessario"},
-	26: {"EMERGENCY", "Errore hardware irreversibile"},
-	27: {"EMERGENCY", "Guasto alimentazione principale"},
+// SpanLink is the hex trace and span that were active on the device when a log happened
+type SpanLink struct {
+	TraceID string `cbor:"trace_id" json:"trace_id"`
+	SpanID  string `cbor:"span_id" json:"span_id"`
+}
+
+// spanContext returns the device span of the log at index i, invalid when it was not sent
+func (b IncomingLogBatch) spanContext(i int) trace.SpanContext {
+	if len(b.Links) != len(b.Logs) {
+		return trace.SpanContext{}
+	}
+	traceID, err := trace.TraceIDFromHex(b.Links[i].TraceID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	spanID, err := trace.SpanIDFromHex(b.Links[i].SpanID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+}
+
+// spanLinks returns the distinct device spans of the batch, to link them to the batch span
+func (b IncomingLogBatch) spanLinks() []trace.Link {
+	var links []trace.Link
+	seen := make(map[trace.SpanID]bool)
+	for i := range b.Logs {
+		if sc := b.spanContext(i); sc.IsValid() && !seen[sc.SpanID()] {
+			seen[sc.SpanID()] = true
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return links
 }
 
 // Maps severity string to slog.Level
@@ -95,20 +96,41 @@ func handleCoapBatchLog(w mux.ResponseWriter, r *mux.Message) {
 		return
 	}
 
-	// Decode the CBOR-encoded request body into IncomingLogBatch
-	if err := cbor.Unmarshal(body, &batch); err != nil {
-		log.Printf("Error decoding CBOR: %v", err)
-		w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+	// Decode the CBOR or JSON request body into IncomingLogBatch
+	if err := decodePayload(r, body, logBatchSchema, &batch); err != nil {
+		log.Printf("Error decoding log batch: %v", err)
+		rejectPayload(w, r, codeInvalidLogBatch, batch.DeviceID, err)
+		return
+	}
+
+	// A device sending faster than COAP_RATE_LIMIT is told to back off
+	if rejectRateLimited(w, r, batch.DeviceID) {
+		return
+	}
+
+	// Check the IDs and the ranges of the fields
+	if err := validateLogBatch(batch); err != nil {
+		log.Printf("Invalid log batch: %v", err)
+		rejectPayload(w, r, codeInvalidLogBatch, batch.DeviceID, err)
 		return
 	}
 
 	// Extract tracing context and start a span
 	ctx := r.Context()
-	ctx, span := otel.Tracer("coap-server").Start(ctx, "handleCoapBatchLog")
+	ctx, span := otel.Tracer("coap-server").Start(ctx, "handleCoapBatchLog", trace.WithLinks(batch.spanLinks()...))
 	defer span.End()
 
+	// A retransmitted batch already handled is confirmed again without logging its events twice
+	if !logBatchDedup.firstSeen(batch.DeviceID, batch.BatchID, time.Now()) {
+		slog.InfoContext(ctx, "Duplicate log batch ignored",
+			slog.String("device_id", batch.DeviceID), slog.String("batch_id", batch.BatchID))
+		w.SetResponse(codes.Created, message.TextPlain, nil)
+		return
+	}
+
 	// Iterate over each compressed log entry
-	for _, entry := range batch.Logs {
+	catalog := eventCatalog.Load()
+	for i, entry := range batch.Logs {
 		// Each entry must be [eventID, timestamp]
 		if len(entry) != 2 {
 			log.Println("Invalid log entry, skipping:", entry)
@@ -118,7 +140,7 @@ func handleCoapBatchLog(w mux.ResponseWriter, r *mux.Message) {
 		id := uint8(entry[0])
 		ts := entry[1]
 
-		def, ok := eventDefinitions[id]
+		def, ok := catalog.Events[id]
 		if !ok {
 			log.Printf("Unknown event ID %d", id)
 			continue
@@ -127,8 +149,14 @@ func handleCoapBatchLog(w mux.ResponseWriter, r *mux.Message) {
 		t := time.Unix(ts, 0).UTC()
 		formattedTime := t.Format(time.RFC3339)
 
+		// Log the event in the trace of the device metric it happened during, if linked
+		logCtx := ctx
+		if sc := batch.spanContext(i); sc.IsValid() {
+			logCtx = trace.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
 		// Log the message with context and attributes
-		slog.LogAttrs(ctx, mapSeverityToLevel(def.Severity), def.Message,
+		slog.LogAttrs(logCtx, mapSeverityToLevel(def.Severity), def.Message,
 			slog.String("device_id", batch.DeviceID),
 			slog.String("timestamp", formattedTime),
 			slog.String("type", "devicelog"),
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"github.com/fxamacker/cbor/v2"
 	"github.com/plgd-dev/go-coap/v3/message"
 	"github.com/plgd-dev/go-coap/v3/message/codes"
 	"github.com/plgd-dev/go-coap/v3/mux"
@@ -20,42 +19,32 @@ var (
 
 // Metrics defines the structure for device metrics
 type Metrics struct {
-	DeviceID         string    `cbor:"device_id"`
-	Timestamp        time.Time `cbor:"timestamp"`
-	CPUPercent       float64   `cbor:"cpu_percent"`
-	MemUsedMB        float64   `cbor:"mem_used_mb"`
-	TempC            float64   `cbor:"temp_c"`
-	DiskUsagePercent float64   `cbor:"disk_usage_percent"`
-	DiskReadMBps     float64   `cbor:"disk_read_mbps"`
-	DiskWriteMBps    float64   `cbor:"disk_write_mbps"`
+	DeviceID         string    `cbor:"device_id" json:"device_id"`
+	Timestamp        time.Time `cbor:"timestamp" json:"timestamp"`
+	CPUPercent       float64   `cbor:"cpu_percent" json:"cpu_percent"`
+	MemUsedMB        float64   `cbor:"mem_used_mb" json:"mem_used_mb"`
+	TempC            float64   `cbor:"temp_c" json:"temp_c"`
+	DiskUsagePercent float64   `cbor:"disk_usage_percent" json:"disk_usage_percent"`
+	DiskReadMBps     float64   `cbor:"disk_read_mbps" json:"disk_read_mbps"`
+	DiskWriteMBps    float64   `cbor:"disk_write_mbps" json:"disk_write_mbps"`
 }
 
-// Convert temperature to a severity string
+// Convert temperature to a severity string, with the temp_c levels of the device config
 func tempToSeverityString(temp float64) string {
-	switch {
-	case temp < 75:
-		return "INFO"
-	case temp < 85:
-		return "WARNING"
-	case temp < 95:
-		return "CRITICAL"
-	case temp <= 100:
-		return "EMERGENCY"
-	default:
+	if temp > 100 {
 		return "INFO"
 	}
+	return currentDeviceConfig.Load().severity("temp_c", temp)
 }
 
 // Convert temperature to a human-readable message
 func tempToMessage(temp float64) string {
-	switch {
-	case temp < 75:
-		return "Temperature is fine"
-	case temp < 85:
+	switch tempToSeverityString(temp) {
+	case "WARNING":
 		return "Temperature rising – monitor closely"
-	case temp < 95:
+	case "CRITICAL":
 		return "Critical temperature – action needed"
-	case temp <= 100:
+	case "EMERGENCY":
 		return "Emergency – device may fail"
 	default:
 		return "Temperature is fine"
@@ -77,15 +66,33 @@ func handleCoapMetrics(w mux.ResponseWriter, r *mux.Message) {
 		return
 	}
 
-	// Decode the CBOR payload into the Metrics struct
-	if err := cbor.Unmarshal(body, &m); err != nil {
-		log.Printf("CBOR decode error: %v", err)
-		w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+	// Decode the CBOR or JSON payload into the Metrics struct
+	if err := decodePayload(r, body, metricsSchema, &m); err != nil {
+		log.Printf("Metrics decode error: %v", err)
+		rejectPayload(w, r, codeInvalidMetrics, m.DeviceID, err)
+		return
+	}
+
+	// A device sending faster than COAP_RATE_LIMIT is told to back off
+	if rejectRateLimited(w, r, m.DeviceID) {
+		return
+	}
+
+	// Check the IDs and the ranges of the fields
+	if err := validateMetrics(m, time.Now()); err != nil {
+		log.Printf("Invalid metrics: %v", err)
+		rejectPayload(w, r, codeInvalidMetrics, m.DeviceID, err)
 		return
 	}
 
 	// Update the in-memory cache with the latest metrics
 	updateMetricCache(m)
+	// Keep the sample in the local history when METRIC_STORE_FILE is set
+	if store != nil {
+		if err := store.append(m, time.Now()); err != nil {
+			slog.ErrorContext(ctx, "Failed to store the metric", slog.String("device_id", m.DeviceID), slog.Any("error", err))
+		}
+	}
 
 	// Determine severity and log the metric
 	severityStr := tempToSeverityString(m.TempC)
@@ -101,9 +108,10 @@ func handleCoapMetrics(w mux.ResponseWriter, r *mux.Message) {
 	w.SetResponse(codes.Changed, message.TextPlain, nil)
 }
 
-// Save or update the latest metric in the cache
+// Save or update the latest metric in the cache, and when the device was last seen
 func updateMetricCache(m Metrics) {
 	cacheMu.Lock()
 	defer cacheMu.Unlock()
 	globalMetricCache[m.DeviceID] = m
+	markSeen(m.DeviceID, time.Now())
 }
\ No newline at end of file
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// defaultHTTPPort is the port of the HTTP listener next to the CoAP server
+const defaultHTTPPort = "8081"
+
+// startedAt is when the server started, for the uptime of /healthz
+var startedAt = time.Now()
+
+// startHTTPServer serves /healthz, /metrics, /devices, /registrations and the stored history
+// on HTTP_PORT, so a CoAP-only deployment can be checked and scraped without a collector;
+// HTTP_PORT=0 disables it
+func startHTTPServer(ctx context.Context) {
+	port := os.Getenv("HTTP_PORT")
+	if port == "" {
+		port = defaultHTTPPort
+	}
+	if port == "0" {
+		return
+	}
+	addr := ":" + port
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /devices", handleDevices)
+	mux.HandleFunc("GET /registrations", handleRegistrations)
+	if store != nil {
+		mux.HandleFunc("GET /devices/{id}/history", handleDeviceHistory)
+	}
+	if prometheusReader != nil {
+		mux.Handle("GET /metrics", handlePrometheus)
+	}
+
+	slog.InfoContext(ctx, "Starting HTTP server", slog.String("addr", "0.0.0.0"+addr))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.ErrorContext(ctx, "HTTP server stopped", slog.Any("error", err))
+		}
+	}()
+}
+
+// handleHealthz reports that the server is up, with the devices in the cache
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	cacheMu.RLock()
+	devices := len(globalMetricCache)
+	cacheMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Status        string `json:"status"`
+		UptimeSeconds int64  `json:"uptime_seconds"`
+		Devices       int    `json:"devices"`
+	}{"ok", int64(time.Since(startedAt).Seconds()), devices})
+}
+
+// cachedDevice is a device of the cache as /devices shows it
+type cachedDevice struct {
+	DeviceID string    `json:"device_id"`
+	LastSeen time.Time `json:"last_seen"`
+	Missing  bool      `json:"missing"` // Missed its reporting intervals
+	Metrics  Metrics   `json:"metrics"`
+}
+
+// handleDevices lists the devices in the cache with their latest metrics, sorted by ID
+func handleDevices(w http.ResponseWriter, r *http.Request) {
+	cacheMu.RLock()
+	devices := make([]cachedDevice, 0, len(globalMetricCache))
+	for deviceID, m := range globalMetricCache {
+		_, missing := missingSince[deviceID]
+		devices = append(devices, cachedDevice{DeviceID: deviceID, LastSeen: lastSeen[deviceID], Missing: missing, Metrics: m})
+	}
+	cacheMu.RUnlock()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DeviceID < devices[j].DeviceID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Count   int            `json:"count"`
+		Devices []cachedDevice `json:"devices"`
+	}{len(devices), devices})
+}
+
+// handleRegistrations lists the devices registered on /register, sorted by ID
+func handleRegistrations(w http.ResponseWriter, r *http.Request) {
+	registrationsMu.RLock()
+	regs := make([]deviceRegistration, 0, len(registrations))
+	for _, reg := range registrations {
+		regs = append(regs, reg)
+	}
+	registrationsMu.RUnlock()
+	sort.Slice(regs, func(i, j int) bool { return regs[i].DeviceID < regs[j].DeviceID })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Count         int                  `json:"count"`
+		Registrations []deviceRegistration `json:"registrations"`
+	}{len(regs), regs})
+}
+
+// handleDeviceHistory returns the stored samples of a device received since the RFC 3339
+// time of ?since=, the whole retention by default
+func handleDeviceHistory(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().Add(-store.retention)
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			http.Error(w, "since must be an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+
+	deviceID := r.PathValue("id")
+	samples, err := store.history(deviceID, since)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read the metric history", slog.String("device_id", deviceID), slog.Any("error", err))
+		http.Error(w, "failed to read the metric history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		DeviceID string         `json:"device_id"`
+		Count    int            `json:"count"`
+		Samples  []storedSample `json:"samples"`
+	}{deviceID, len(samples), samples})
+}
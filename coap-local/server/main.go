@@ -6,6 +6,8 @@ import (
 	"log"
 	"log/slog"
 	"os"
+
+	"devicetransport"
 )
 
 func main() {
@@ -30,12 +32,53 @@ func main() {
 
 	// Initialize metrics instruments (e.g., counters, gauges) with the Meter
 	initMetrics(meter)
+	initStaleDeviceMetrics(meter)
+	initRateLimitMetrics(meter)
+	initValidationMetrics(meter)
+	initRegistrationMetrics(meter)
+
+	// Decode the log events with the catalog of EVENT_CATALOG_FILE, the built-in one if unset
+	catalogPath := os.Getenv("EVENT_CATALOG_FILE")
+	catalog, err := devicetransport.LoadEventCatalog(catalogPath)
+	if err != nil {
+		log.Fatalf("failed to load event catalog: %v", err)
+	}
+	eventCatalog.Store(&catalog)
+	slog.InfoContext(ctx, "Event catalog loaded", slog.Int("version", catalog.Version), slog.Int("events", len(catalog.Events)))
+
+	// Push the config of DEVICE_CONFIG_FILE to the devices observing /config whenever it
+	// or the event catalog changes
+	if err := watchDeviceConfig(ctx, os.Getenv("DEVICE_CONFIG_FILE"), catalogPath); err != nil {
+		log.Fatalf("failed to load device config: %v", err)
+	}
+
+	// Keep the received samples in METRIC_STORE_FILE and restore the cache from them
+	store, err = openMetricStore()
+	if err != nil {
+		log.Fatalf("failed to open metric store: %v", err)
+	}
+	if store != nil {
+		defer store.db.Close()
+		if err := store.warmCache(); err != nil {
+			log.Fatalf("failed to restore metric cache: %v", err)
+		}
+		go store.run(ctx)
+	}
+
+	// Forget the IDs of the log batches past the dedup window
+	go logBatchDedup.run(ctx)
+	// Forget the rate limit buckets of the devices gone quiet
+	go coapRateLimit.run(ctx)
+	// Report the devices that stopped sending metrics and evict them past DEVICE_TTL
+	go runStaleDeviceSweeper(ctx)
 
 	// Register all gauge observers that read data from the globalMetricCache
 	// Observers periodically collect metric values for reporting
 	if err := registerObservers(meter); err != nil {
 		log.Fatalf("failed to register observers: %v", err)
 	}
-	// Start the HTTP server which will handle incoming requests
+	// Serve health, Prometheus metrics and the cached devices over HTTP next to CoAP
+	startHTTPServer(ctx)
+	// Start the CoAP server which will handle incoming requests
 	startCoapServer(ctx)
 }
@@ -5,6 +5,7 @@ import (
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
 	"log"
+	"time"
 )
 
 var (
@@ -87,10 +88,17 @@ func registerObservers(meter metric.Meter) error {
 				// Uncomment for debug logging localy:
 				// log.Printf("Observed metrics for device %s: CPU %.2f%%, Temp %.2f°C", m.DeviceID, m.CPUPercent, m.TempC)
 			}
+
+			// And how long ago every device last reported, until it is evicted
+			now := time.Now()
+			for deviceID, seen := range lastSeen {
+				observer.ObserveFloat64(lastSeenAgeGauge, now.Sub(seen).Seconds(),
+					metric.WithAttributes(attribute.String("device_id", deviceID)))
+			}
 			return nil
 		},
 		// List all instruments to be observed in this callback
-		cpuGauge, tempGauge, memGauge, diskUsageGauge, diskReadGauge, diskWriteGauge,
+		cpuGauge, tempGauge, memGauge, diskUsageGauge, diskReadGauge, diskWriteGauge, lastSeenAgeGauge,
 	)
 	return err
 }
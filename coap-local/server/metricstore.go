@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Defaults of the metric store: a day of history, swept every hour
+const (
+	defaultStoreRetention = 24 * time.Hour
+	storeSweepInterval    = time.Hour
+)
+
+// metricsBucket holds a bucket per device, with its samples keyed by receive time
+var metricsBucket = []byte("metrics")
+
+// storedSample is a metric sample as the store keeps it
+type storedSample struct {
+	ReceivedAt time.Time `json:"received_at"`
+	Metrics    Metrics   `json:"metrics"`
+}
+
+// metricStore appends every received sample to a local bbolt file, so the recent history
+// of the devices survives a restart without a remote database
+type metricStore struct {
+	db        *bolt.DB
+	retention time.Duration
+}
+
+// store is the metric store of METRIC_STORE_FILE, nil when the samples are not persisted
+var store *metricStore
+
+// openMetricStore opens the store of METRIC_STORE_FILE keeping METRIC_STORE_RETENTION of
+// history, a Go duration; nil without a file
+func openMetricStore() (*metricStore, error) {
+	path := os.Getenv("METRIC_STORE_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	retention := defaultStoreRetention
+	if raw := os.Getenv("METRIC_STORE_RETENTION"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			slog.Warn("Invalid METRIC_STORE_RETENTION, using the default",
+				slog.String("value", raw), slog.Duration("default", defaultStoreRetention))
+		} else {
+			retention = d
+		}
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open metric store %s: %w", path, err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(metricsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open metric store %s: %w", path, err)
+	}
+	slog.Info("Metric store opened", slog.String("file", path), slog.Duration("retention", retention))
+	return &metricStore{db: db, retention: retention}, nil
+}
+
+// timeKey is the key of a sample received at t, ordered by time
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// append stores a sample of a device received at t
+func (s *metricStore) append(m Metrics, t time.Time) error {
+	value, err := json.Marshal(storedSample{ReceivedAt: t, Metrics: m})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		device, err := tx.Bucket(metricsBucket).CreateBucketIfNotExists([]byte(m.DeviceID))
+		if err != nil {
+			return err
+		}
+		return device.Put(timeKey(t), value)
+	})
+}
+
+// history returns the samples of a device received since, oldest first
+func (s *metricStore) history(deviceID string, since time.Time) ([]storedSample, error) {
+	samples := []storedSample{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		device := tx.Bucket(metricsBucket).Bucket([]byte(deviceID))
+		if device == nil {
+			return nil
+		}
+		c := device.Cursor()
+		for k, v := c.Seek(timeKey(since)); k != nil; k, v = c.Next() {
+			var sample storedSample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return err
+			}
+			samples = append(samples, sample)
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// latest returns the last stored sample of every device, to warm the cache after a restart
+func (s *metricStore) latest() (map[string]storedSample, error) {
+	samples := make(map[string]storedSample)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metricsBucket).ForEachBucket(func(name []byte) error {
+			_, v := tx.Bucket(metricsBucket).Bucket(name).Cursor().Last()
+			if v == nil {
+				return nil
+			}
+			var sample storedSample
+			if err := json.Unmarshal(v, &sample); err != nil {
+				return err
+			}
+			samples[string(name)] = sample
+			return nil
+		})
+	})
+	return samples, err
+}
+
+// sweep deletes the samples older than the retention and the devices left without samples
+func (s *metricStore) sweep(now time.Time) (int, error) {
+	cutoff := timeKey(now.Add(-s.retention))
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		metrics := tx.Bucket(metricsBucket)
+		var empty [][]byte
+		err := metrics.ForEachBucket(func(name []byte) error {
+			c := metrics.Bucket(name).Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.Next() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				deleted++
+			}
+			if k, _ := c.First(); k == nil {
+				empty = append(empty, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range empty {
+			if err := metrics.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// run sweeps the samples past the retention every storeSweepInterval until ctx is done
+func (s *metricStore) run(ctx context.Context) {
+	ticker := time.NewTicker(storeSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := s.sweep(now)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to sweep the metric store", slog.Any("error", err))
+				continue
+			}
+			slog.InfoContext(ctx, "Metric store swept", slog.Int("deleted", deleted))
+		}
+	}
+}
+
+// warmCache loads the last stored sample of every device into the cache, as last seen when
+// it was received, so the gauges and the stale device checks resume where they stopped
+func (s *metricStore) warmCache() error {
+	samples, err := s.latest()
+	if err != nil {
+		return err
+	}
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	for deviceID, sample := range samples {
+		globalMetricCache[deviceID] = sample.Metrics
+		lastSeen[deviceID] = sample.ReceivedAt
+	}
+	slog.Info("Metric cache restored from the store", slog.Int("devices", len(samples)))
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	coapNet "github.com/plgd-dev/go-coap/v3/net"
+	"github.com/plgd-dev/go-coap/v3/udp"
+	udpServer "github.com/plgd-dev/go-coap/v3/udp/server"
+)
+
+// All-CoAP-Nodes groups, RFC 7252 and RFC 7390: the IPv4 one and the IPv6 link-local and
+// site-local ones
+var (
+	allCoapNodesIPv4 = []net.IP{net.IPv4(224, 0, 1, 187)}
+	allCoapNodesIPv6 = []net.IP{net.ParseIP("ff02::fd"), net.ParseIP("ff05::fd")}
+)
+
+// multicastLeisure bounds the random delay of the responses to multicast requests, so the
+// servers of a LAN don't all answer a discovery at once, RFC 7252 section 8.2
+const multicastLeisure = time.Second
+
+// multicastEnabled reads COAP_MULTICAST, whether the server joins the All-CoAP-Nodes groups
+// to answer the discovery of the devices on the LAN
+func multicastEnabled() bool {
+	raw := os.Getenv("COAP_MULTICAST")
+	if raw == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		slog.Warn("Invalid COAP_MULTICAST, multicast discovery disabled", slog.String("value", raw))
+		return false
+	}
+	return enabled
+}
+
+// serveMulticast serves the CoAP requests on port over IPv4 and IPv6, each listener joined to
+// the All-CoAP-Nodes groups on every multicast interface, until one of them stops. IPv6 is
+// skipped when the host has none.
+func serveMulticast(ctx context.Context, port string, opts []udpServer.Option) error {
+	l4, err := coapNet.NewListenUDP("udp4", ":"+port)
+	if err != nil {
+		return err
+	}
+	joinGroups(ctx, l4, allCoapNodesIPv4)
+
+	stopped := make(chan error, 2)
+	go func() { stopped <- udp.NewServer(opts...).Serve(l4) }()
+
+	l6, err := coapNet.NewListenUDP("udp6", ":"+port)
+	if err != nil {
+		slog.WarnContext(ctx, "No IPv6 CoAP listener, multicast discovery on IPv4 only", slog.Any("error", err))
+	} else {
+		joinGroups(ctx, l6, allCoapNodesIPv6)
+		go func() { stopped <- udp.NewServer(opts...).Serve(l6) }()
+	}
+	return <-stopped
+}
+
+// joinGroups joins l to the groups on every interface that is up and supports multicast
+func joinGroups(ctx context.Context, l *coapNet.UDPConn, groups []net.IP) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list the network interfaces", slog.Any("error", err))
+		return
+	}
+	for _, group := range groups {
+		var joined []string
+		for _, iface := range ifaces {
+			if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+				continue
+			}
+			if err := l.JoinGroup(&iface, &net.UDPAddr{IP: group}); err != nil {
+				slog.DebugContext(ctx, "Failed to join the multicast group",
+					slog.String("group", group.String()), slog.String("interface", iface.Name), slog.Any("error", err))
+				continue
+			}
+			joined = append(joined, iface.Name)
+		}
+		if len(joined) == 0 {
+			slog.WarnContext(ctx, "Multicast group not joined on any interface", slog.String("group", group.String()))
+			continue
+		}
+		slog.InfoContext(ctx, "Joined the All-CoAP-Nodes group",
+			slog.String("group", group.String()), slog.Any("interfaces", joined))
+	}
+}
+
+// isMulticast reports whether a request was sent to a multicast group
+func isMulticast(r *mux.Message) bool {
+	cm := r.ControlMessage()
+	return cm != nil && cm.Dst.IsMulticast()
+}
+
+// handleCoapNotFound answers 4.04 to the unknown resources, except to multicast requests:
+// servers stay silent on the requests of a group they can't serve, RFC 7252 section 8.2
+func handleCoapNotFound(w mux.ResponseWriter, r *mux.Message) {
+	if isMulticast(r) {
+		return
+	}
+	w.SetResponse(codes.NotFound, message.TextPlain, nil)
+}
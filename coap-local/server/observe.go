@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"sync"
+
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+)
+
+// Values of the Observe option of a GET, RFC 7641
+const (
+	observeRegister   = 0
+	observeDeregister = 1
+)
+
+// observer is a device observing /config: its connection, the token of its GET and the
+// format it asked for
+type observer struct {
+	conn   mux.Conn
+	token  []byte
+	format message.MediaType
+}
+
+// observerSet are the observers of a resource; every notification carries the next sequence
+// number, so the devices drop the ones that arrive out of order
+type observerSet struct {
+	mu        sync.Mutex
+	observers map[string]observer // by remote address and token
+	seq       uint32
+}
+
+// configObservers are the devices notified when the device config changes
+var configObservers = &observerSet{observers: make(map[string]observer)}
+
+// observerKey identifies an observation by the connection and the token of its request
+func observerKey(conn mux.Conn, token []byte) string {
+	return conn.RemoteAddr().String() + "|" + string(token)
+}
+
+// add registers an observer, forgotten when its connection closes, and returns the sequence
+// number of the current state
+func (s *observerSet) add(o observer) uint32 {
+	key := observerKey(o.conn, o.token)
+	s.mu.Lock()
+	_, known := s.observers[key]
+	s.observers[key] = o
+	seq := s.seq
+	s.mu.Unlock()
+	if !known {
+		o.conn.AddOnClose(func() { s.remove(o.conn, o.token) })
+	}
+	return seq
+}
+
+// remove forgets the observation of token on conn
+func (s *observerSet) remove(conn mux.Conn, token []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.observers, observerKey(conn, token))
+}
+
+// notify sends the current device config to every observer, dropping the ones it fails to reach
+func (s *observerSet) notify() {
+	s.mu.Lock()
+	s.seq = (s.seq + 1) & 0xffffff // The option holds 24 bits
+	seq := s.seq
+	observers := make([]observer, 0, len(s.observers))
+	for _, o := range s.observers {
+		observers = append(observers, o)
+	}
+	s.mu.Unlock()
+
+	c := currentDeviceConfig.Load()
+	for _, o := range observers {
+		body, err := encodeAs(o.format, c)
+		if err == nil {
+			err = sendNotification(o, seq, body)
+		}
+		if err != nil {
+			slog.Warn("Failed to notify a config observer, dropping it",
+				slog.String("remote_addr", o.conn.RemoteAddr().String()), slog.Any("error", err))
+			s.remove(o.conn, o.token)
+		}
+	}
+	slog.Info("Device config pushed to observers", slog.Int("observers", len(observers)), slog.Uint64("seq", uint64(seq)))
+}
+
+// sendNotification sends a config notification with the sequence number seq to an observer
+func sendNotification(o observer, seq uint32, body []byte) error {
+	m := o.conn.AcquireMessage(o.conn.Context())
+	defer o.conn.ReleaseMessage(m)
+	m.SetCode(codes.Content)
+	m.SetToken(o.token)
+	m.SetContentFormat(o.format)
+	m.SetObserve(seq)
+	m.SetBody(bytes.NewReader(body))
+	return o.conn.WriteMessage(m)
+}
+
+// handleCoapConfig returns the device config, in CBOR unless the Accept option asks for JSON.
+// A GET with Observe 0 also registers the device for a notification on every change,
+// Observe 1 deregisters it.
+func handleCoapConfig(w mux.ResponseWriter, r *mux.Message) {
+	if r.Code() != codes.GET {
+		w.SetResponse(codes.MethodNotAllowed, message.TextPlain, nil)
+		return
+	}
+	format, body, err := encodeResponse(r, currentDeviceConfig.Load())
+	if err != nil {
+		slog.Error("Error encoding device config", slog.Any("error", err))
+		w.SetResponse(codes.InternalServerError, message.TextPlain, nil)
+		return
+	}
+
+	obs, err := r.Options().Observe()
+	observing := err == nil && obs == observeRegister
+	var seq uint32
+	switch {
+	case observing:
+		seq = configObservers.add(observer{conn: w.Conn(), token: r.Token(), format: format})
+		slog.Info("Device observing the config", slog.String("remote_addr", w.Conn().RemoteAddr().String()))
+	case err == nil && obs == observeDeregister:
+		configObservers.remove(w.Conn(), r.Token())
+	}
+
+	if err := w.SetResponse(codes.Content, format, bytes.NewReader(body)); err != nil {
+		slog.Error("Error sending device config", slog.Any("error", err))
+		return
+	}
+	if observing {
+		w.Message().SetObserve(seq)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/mux"
+)
+
+// errUnsupportedContentFormat is returned for payloads in a format the server does not decode
+var errUnsupportedContentFormat = errors.New("unsupported content format")
+
+// cborDecMode bounds the arrays, maps and nesting of the CBOR payloads and rejects
+// duplicate fields, so a small payload can't exhaust memory or hide a second value
+var cborDecMode = func() cbor.DecMode {
+	mode, err := cbor.DecOptions{
+		DupMapKey:        cbor.DupMapKeyEnforcedAPF,
+		MaxNestedLevels:  16,
+		MaxArrayElements: 65536,
+		MaxMapPairs:      1024,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// decodePayload decodes the body of a request into v as JSON or CBOR, following its
+// Content-Format option; requests without the option are CBOR. The payload must be a map
+// with the fields of schema, each of the type of v, otherwise an *invalidPayloadError
+// lists the fields that are not
+func decodePayload(r *mux.Message, body []byte, schema []payloadField, v interface{}) error {
+	format, err := r.Options().ContentFormat()
+	if err != nil {
+		format = message.AppCBOR
+	}
+
+	var fields []string
+	var decode func(data []byte, v interface{}) error
+	switch format {
+	case message.AppCBOR:
+		var m map[string]cbor.RawMessage
+		if err := cborDecMode.Unmarshal(body, &m); err != nil {
+			return fmt.Errorf("payload is not a CBOR map of fields: %w", err)
+		}
+		fields = slices.Collect(maps.Keys(m))
+		decode = cborDecMode.Unmarshal
+	case message.AppJSON:
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(body, &m); err != nil {
+			return fmt.Errorf("payload is not a JSON object: %w", err)
+		}
+		fields = slices.Collect(maps.Keys(m))
+		decode = json.Unmarshal
+	default:
+		return fmt.Errorf("%w %v", errUnsupportedContentFormat, format)
+	}
+
+	errs := checkSchema(fields, schema)
+	if err := decode(body, v); err != nil {
+		fe := typeError(err)
+		if fe == nil {
+			return err
+		}
+		errs = append(errs, *fe)
+	}
+	return payloadErrors(errs)
+}
+
+// encodeResponse encodes v for the response to r: CBOR unless the Accept option asks for JSON
+func encodeResponse(r *mux.Message, v interface{}) (message.MediaType, []byte, error) {
+	format := message.AppCBOR
+	if accept, err := r.Options().Accept(); err == nil && accept == message.AppJSON {
+		format = message.AppJSON
+	}
+	body, err := encodeAs(format, v)
+	return format, body, err
+}
+
+// encodeAs encodes v as JSON or, for any other format, CBOR
+func encodeAs(format message.MediaType, v interface{}) ([]byte, error) {
+	if format == message.AppJSON {
+		return json.Marshal(v)
+	}
+	return cbor.Marshal(v)
+}
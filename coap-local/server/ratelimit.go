@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+// Defaults of the per-device rate limit: a device reporting every few seconds never hits it,
+// a runaway loop is cut to a couple of requests per second
+const (
+	defaultDeviceRateLimit = 2.0
+	defaultDeviceRateBurst = 10
+)
+
+// deviceLimiterIdle is how long a device keeps its bucket after its last request
+const deviceLimiterIdle = 10 * time.Minute
+
+// rateLimitedRequests counts the requests rejected with 4.29
+var rateLimitedRequests metric.Int64Counter
+
+// deviceLimiter keeps a token bucket for every device, so a device flooding the server
+// can't starve the handlers and the logging pipeline of the others
+type deviceLimiter struct {
+	limit rate.Limit // requests per second of a device, 0 for no limit
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*deviceBucket
+}
+
+// deviceBucket is the token bucket of a device, when it was last used and whether its last
+// request was rejected
+type deviceBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+	limited  bool
+}
+
+// coapRateLimit is the rate limit of the CoAP requests, read at startup
+var coapRateLimit = loadDeviceLimiter()
+
+// loadDeviceLimiter reads COAP_RATE_LIMIT, the requests per second a device may send on
+// average, "0" turns the limit off, and COAP_RATE_BURST, the requests it may send at once
+func loadDeviceLimiter() *deviceLimiter {
+	l := &deviceLimiter{
+		limit:   rate.Limit(defaultDeviceRateLimit),
+		burst:   defaultDeviceRateBurst,
+		buckets: make(map[string]*deviceBucket),
+	}
+	if raw := os.Getenv("COAP_RATE_LIMIT"); raw != "" {
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+			slog.Warn("Invalid COAP_RATE_LIMIT, using the default",
+				slog.String("value", raw), slog.Float64("default", defaultDeviceRateLimit))
+		} else {
+			l.limit = rate.Limit(n)
+		}
+	}
+	if raw := os.Getenv("COAP_RATE_BURST"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			slog.Warn("Invalid COAP_RATE_BURST, using the default",
+				slog.String("value", raw), slog.Int("default", defaultDeviceRateBurst))
+		} else {
+			l.burst = n
+		}
+	}
+	return l
+}
+
+// initRateLimitMetrics creates the counter of the requests rejected by the rate limit
+func initRateLimitMetrics(meter metric.Meter) {
+	var err error
+	rateLimitedRequests, err = meter.Int64Counter("custom.googleapis.com/coap_requests_rate_limited",
+		metric.WithDescription("Richieste CoAP rifiutate per superamento del limite, per dispositivo e risorsa"))
+	if err != nil {
+		log.Fatalf("failed to create coap_requests_rate_limited counter: %v", err)
+	}
+}
+
+// allow takes a token from the bucket of a device and reports whether the request may go on;
+// when it may not, how long the device should wait and whether it was just cut off
+func (l *deviceLimiter) allow(deviceID string, now time.Time) (ok bool, wait time.Duration, first bool) {
+	if l.limit == 0 {
+		return true, 0, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, found := l.buckets[deviceID]
+	if !found {
+		b = &deviceBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.buckets[deviceID] = b
+	}
+	b.lastUsed = now
+	if b.limiter.AllowN(now, 1) {
+		b.limited = false
+		return true, 0, false
+	}
+	first = !b.limited
+	b.limited = true
+	return false, time.Duration(float64(time.Second) / float64(l.limit)), first
+}
+
+// expire forgets the buckets of the devices idle for deviceLimiterIdle, which are full again
+func (l *deviceLimiter) expire(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for deviceID, b := range l.buckets {
+		if now.Sub(b.lastUsed) >= deviceLimiterIdle {
+			delete(l.buckets, deviceID)
+		}
+	}
+}
+
+// run expires the idle buckets every deviceLimiterIdle until ctx is done
+func (l *deviceLimiter) run(ctx context.Context) {
+	if l.limit == 0 {
+		return
+	}
+	ticker := time.NewTicker(deviceLimiterIdle)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.expire(now)
+		}
+	}
+}
+
+// rejectRateLimited answers 4.29 Too Many Requests with the seconds to wait in Max-Age, RFC 8516,
+// when the device of a decoded payload is over its rate limit, and reports whether it did
+func rejectRateLimited(w mux.ResponseWriter, r *mux.Message, deviceID string) bool {
+	ok, wait, first := coapRateLimit.allow(deviceID, time.Now())
+	if ok {
+		return false
+	}
+
+	// Only the first rejection is logged, a flood of warnings would defeat the limit
+	ctx := r.Context()
+	path, _ := r.Options().Path()
+	if first {
+		slog.WarnContext(ctx, "Device over its CoAP rate limit, rejecting its requests",
+			slog.String("device_id", deviceID), slog.String("path", path))
+	}
+	if rateLimitedRequests != nil {
+		rateLimitedRequests.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("device_id", deviceID), attribute.String("path", path)))
+	}
+
+	buf := make([]byte, 4)
+	n, _ := message.EncodeUint32(buf, uint32(math.Ceil(wait.Seconds())))
+	w.SetResponse(codes.TooManyRequests, message.TextPlain, nil,
+		message.Option{ID: message.MaxAge, Value: buf[:n]})
+	return true
+}
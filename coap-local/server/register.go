@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// codeInvalidRegistration is the code of the 4.00 payload of a rejected registration
+const codeInvalidRegistration = "invalid_registration"
+
+// registrationSchema is the schema of the payload of /register
+var registrationSchema = []payloadField{
+	{"device_id", true}, {"model", false}, {"firmware", false},
+}
+
+// registrationRequest is what a device sends to /register after finding the server
+type registrationRequest struct {
+	DeviceID string `cbor:"device_id" json:"device_id"`
+	Model    string `cbor:"model,omitempty" json:"model,omitempty"`
+	Firmware string `cbor:"firmware,omitempty" json:"firmware,omitempty"`
+}
+
+// deviceRegistration is a registered device and where it registered from
+type deviceRegistration struct {
+	DeviceID     string    `json:"device_id"`
+	Model        string    `json:"model,omitempty"`
+	Firmware     string    `json:"firmware,omitempty"`
+	Addr         string    `json:"addr"`
+	RegisteredAt time.Time `json:"registered_at"`
+	RenewedAt    time.Time `json:"renewed_at"` // Last registration, after a reboot
+}
+
+// The devices registered since the server started, by device ID
+var (
+	registrationsMu sync.RWMutex
+	registrations   = make(map[string]deviceRegistration)
+)
+
+// deviceRegistrations counts the registrations, new or renewed
+var deviceRegistrations metric.Int64Counter
+
+// initRegistrationMetrics creates the counter of the registrations
+func initRegistrationMetrics(meter metric.Meter) {
+	var err error
+	deviceRegistrations, err = meter.Int64Counter("custom.googleapis.com/device_registrations",
+		metric.WithDescription("Registrazioni dei dispositivi su /register, nuove o rinnovate"))
+	if err != nil {
+		log.Fatalf("failed to create device_registrations counter: %v", err)
+	}
+}
+
+// validateRegistration checks the device ID and the length of the model and firmware
+func validateRegistration(req registrationRequest) error {
+	errs := validateID("device_id", req.DeviceID, true)
+	errs = append(errs, validateID("model", req.Model, false)...)
+	errs = append(errs, validateID("firmware", req.Firmware, false)...)
+	return payloadErrors(errs)
+}
+
+// register records the registration of a device from addr, reporting whether it is new
+func register(req registrationRequest, addr string, now time.Time) bool {
+	registrationsMu.Lock()
+	defer registrationsMu.Unlock()
+	reg, renewed := registrations[req.DeviceID]
+	if !renewed {
+		reg.RegisteredAt = now
+	}
+	reg.DeviceID, reg.Model, reg.Firmware, reg.Addr, reg.RenewedAt = req.DeviceID, req.Model, req.Firmware, addr, now
+	registrations[req.DeviceID] = reg
+	return !renewed
+}
+
+// handleCoapRegister registers a device that found the server, answering 2.01 the first time
+// and 2.04 afterwards with the device config, so it starts with the intervals of the server.
+// Registrations sent to a multicast group are ignored, a device registers with the server
+// that answered its discovery.
+func handleCoapRegister(w mux.ResponseWriter, r *mux.Message) {
+	if isMulticast(r) {
+		return
+	}
+	if r.Code() != codes.POST {
+		w.SetResponse(codes.MethodNotAllowed, message.TextPlain, nil)
+		return
+	}
+
+	body, err := r.ReadBody()
+	if err != nil {
+		log.Printf("Error reading CoAP message body: %v", err)
+		w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+		return
+	}
+	var req registrationRequest
+	if err := decodePayload(r, body, registrationSchema, &req); err != nil {
+		log.Printf("Registration decode error: %v", err)
+		rejectPayload(w, r, codeInvalidRegistration, req.DeviceID, err)
+		return
+	}
+	if rejectRateLimited(w, r, req.DeviceID) {
+		return
+	}
+	if err := validateRegistration(req); err != nil {
+		log.Printf("Invalid registration: %v", err)
+		rejectPayload(w, r, codeInvalidRegistration, req.DeviceID, err)
+		return
+	}
+
+	ctx := r.Context()
+	addr := w.Conn().RemoteAddr().String()
+	code, kind := codes.Changed, "renewed"
+	if register(req, addr, time.Now()) {
+		code, kind = codes.Created, "new"
+	}
+	slog.InfoContext(ctx, "Device registered",
+		slog.String("device_id", req.DeviceID), slog.String("addr", addr),
+		slog.String("model", req.Model), slog.String("firmware", req.Firmware), slog.String("registration", kind))
+	if deviceRegistrations != nil {
+		deviceRegistrations.Add(ctx, 1, metric.WithAttributes(attribute.String("registration", kind)))
+	}
+
+	format, resp, err := encodeResponse(r, currentDeviceConfig.Load())
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding device config", slog.Any("error", err))
+		w.SetResponse(code, message.TextPlain, nil)
+		return
+	}
+	w.SetResponse(code, format, bytes.NewReader(resp))
+}
@@ -5,8 +5,11 @@ import (
 	"log"
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/plgd-dev/go-coap/v3/mux"
+	"github.com/plgd-dev/go-coap/v3/options"
+	udpServer "github.com/plgd-dev/go-coap/v3/udp/server"
 	coap "github.com/plgd-dev/go-coap/v3"
 	//"go.opentelemetry.io/otel"
 )
@@ -27,16 +30,32 @@ func startCoapServer(ctx context.Context) {
 
 	slog.InfoContext(ctx, "Starting CoAP server", slog.String("addr", "0.0.0.0"+addr))
 
-	// Start CoAP UDP server using coap.ListenAndServe
-	// Use "udp" protocol since your client is using UDP
-	log.Fatal(coap.ListenAndServe("udp", addr, router))
+	// Start CoAP UDP server using coap.ListenAndServeWithOptions
+	// Use "udp" protocol since your client is using UDP; payloads larger than a block,
+	// like the log backlog of a device back online, arrive in Block1 transfers
+	udpOpts := append([]udpServer.Option{options.WithMux(router)}, blockwiseOptions()...)
+
+	// With COAP_MULTICAST the devices on the LAN find the server through the All-CoAP-Nodes groups
+	if multicastEnabled() {
+		log.Fatal(serveMulticast(ctx, port, udpOpts))
+	}
+	opts := make([]any, 0, len(udpOpts))
+	for _, o := range udpOpts {
+		opts = append(opts, o)
+	}
+	log.Fatal(coap.ListenAndServeWithOptions("udp", addr, opts...))
 }
 
-// registerCoapRoutes registers all CoAP routes to the provided router.
+// registerCoapRoutes registers all CoAP routes to the provided router, and their
+// discovery on /.well-known/core
 func registerCoapRoutes(router *mux.Router) {
-	// Register handlers for batch log and metric endpoints
-	router.Handle("/batchLog", mux.HandlerFunc(handleCoapBatchLog))
-	router.Handle("/batchMetric", mux.HandlerFunc(handleCoapMetrics))
-	
-	slog.Info("Registered CoAP routes: /batchLog, /batchMetric")
-}
\ No newline at end of file
+	paths := make([]string, 0, len(coapResources))
+	for _, res := range coapResources {
+		router.Handle(res.path, res.handler)
+		paths = append(paths, res.path)
+	}
+	router.Handle("/.well-known/core", mux.HandlerFunc(handleCoapWellKnownCore))
+	router.DefaultHandle(mux.HandlerFunc(handleCoapNotFound))
+
+	slog.Info("Registered CoAP routes: " + strings.Join(paths, ", ") + ", /.well-known/core")
+}
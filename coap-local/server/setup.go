@@ -8,10 +8,12 @@ import (
 	"time"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 )
 
@@ -33,6 +35,16 @@ func setupOpentelemetry(ctx context.Context) (shutdown func(context.Context) err
 	// Set the global propagator to TraceContext for trace context propagation over HTTP
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 
+	// Name the server on all its telemetry, to tell it from the HTTP server and the simulator;
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override it
+	res, err := resource.Merge(
+		resource.NewSchemaless(attribute.String("service.name", "coap-server")),
+		resource.Environment(),
+	)
+	if err != nil {
+		return
+	}
+
 	// Create a new OTLP trace exporter sending to a specific endpoint and URL path of the collector
 	tExporter, err := otlptracehttp.New(ctx,
 		otlptracehttp.WithEndpoint("localhost:4318"),
@@ -45,7 +57,7 @@ func setupOpentelemetry(ctx context.Context) (shutdown func(context.Context) err
 	}
 
 	// Create a tracer provider using the trace exporter and batch processing
-	tp := trace.NewTracerProvider(trace.WithBatcher(tExporter))
+	tp := trace.NewTracerProvider(trace.WithBatcher(tExporter), trace.WithResource(res))
 	shutdownFuncs = append(shutdownFuncs, tp.Shutdown)
 	// Set the global tracer provider for the application
 	otel.SetTracerProvider(tp)
@@ -61,14 +73,24 @@ func setupOpentelemetry(ctx context.Context) (shutdown func(context.Context) err
 		return
 	}
 
-	// Create a metric provider with a periodic reader that exports metrics every 1 minute
-	mp := metric.NewMeterProvider(
+	// Create a metric provider with a periodic reader that exports metrics every 1 minute,
+	// and the reader of the Prometheus endpoint unless it is disabled
+	opts := []metric.Option{
+		metric.WithResource(res),
 		metric.WithReader(
 			metric.NewPeriodicReader(mExporter,
 				metric.WithInterval(1*time.Minute), // Export metrics every 1 minute
 			),
 		),
-	)
+	}
+	if prometheusReader, err = newPrometheusReader(); err != nil {
+		err = errors.Join(err, shutdown(ctx))
+		return
+	}
+	if prometheusReader != nil {
+		opts = append(opts, metric.WithReader(prometheusReader))
+	}
+	mp := metric.NewMeterProvider(opts...)
 	shutdownFuncs = append(shutdownFuncs, mp.Shutdown)
 
 	// Set the global meter provider for metrics
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Defaults of the stale devices: silent for defaultMissedIntervals reporting intervals a
+// device is logged as missing, silent for defaultDeviceTTL it is dropped from the cache
+const (
+	defaultDeviceTTL       = 15 * time.Minute
+	defaultMissedIntervals = 3
+)
+
+// staleSweepInterval is how often the cache is checked for silent devices
+const staleSweepInterval = 15 * time.Second
+
+// Last time every device sent a metric, and the ones already reported as missing, guarded
+// by cacheMu along with globalMetricCache
+var (
+	lastSeen     = make(map[string]time.Time)
+	missingSince = make(map[string]time.Time)
+)
+
+// Instruments of the silent devices, created by initStaleDeviceMetrics
+var (
+	lastSeenAgeGauge metric.Float64ObservableGauge
+	missedReports    metric.Int64Counter
+)
+
+// staleDeviceConfig is when a silent device is missing and when it is forgotten
+type staleDeviceConfig struct {
+	ttl             time.Duration
+	missedIntervals int
+}
+
+// staleDevices are the limits of the silent devices, read at startup
+var staleDevices = loadStaleDeviceConfig()
+
+// loadStaleDeviceConfig reads DEVICE_TTL, a Go duration, and DEVICE_MISSED_INTERVALS, the
+// reporting intervals a device may miss before it is reported
+func loadStaleDeviceConfig() staleDeviceConfig {
+	c := staleDeviceConfig{ttl: defaultDeviceTTL, missedIntervals: defaultMissedIntervals}
+	if raw := os.Getenv("DEVICE_TTL"); raw != "" {
+		ttl, err := time.ParseDuration(raw)
+		if err != nil || ttl <= 0 {
+			slog.Warn("Invalid DEVICE_TTL, using the default",
+				slog.String("value", raw), slog.Duration("default", defaultDeviceTTL))
+		} else {
+			c.ttl = ttl
+		}
+	}
+	if raw := os.Getenv("DEVICE_MISSED_INTERVALS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			slog.Warn("Invalid DEVICE_MISSED_INTERVALS, using the default",
+				slog.String("value", raw), slog.Int("default", defaultMissedIntervals))
+		} else {
+			c.missedIntervals = n
+		}
+	}
+	return c
+}
+
+// initStaleDeviceMetrics creates the gauge of the time since every device last reported
+// and the counter of the devices that went silent
+func initStaleDeviceMetrics(meter metric.Meter) {
+	var err error
+	lastSeenAgeGauge, err = meter.Float64ObservableGauge("custom.googleapis.com/device_last_seen_seconds",
+		metric.WithDescription("Secondi dall'ultima metrica ricevuta dal dispositivo"),
+		metric.WithUnit("s"))
+	if err != nil {
+		log.Fatalf("failed to create device_last_seen_seconds gauge: %v", err)
+	}
+
+	missedReports, err = meter.Int64Counter("custom.googleapis.com/device_missed_reports",
+		metric.WithDescription("Dispositivi che hanno saltato troppi intervalli di invio"))
+	if err != nil {
+		log.Fatalf("failed to create device_missed_reports counter: %v", err)
+	}
+}
+
+// markSeen records a metric of a device received at now, logging when a missing device is back;
+// the caller holds cacheMu
+func markSeen(deviceID string, now time.Time) {
+	lastSeen[deviceID] = now
+	if since, ok := missingSince[deviceID]; ok {
+		delete(missingSince, deviceID)
+		slog.Info("Device reporting again",
+			slog.String("device_id", deviceID), slog.Duration("missing_for", now.Sub(since)))
+	}
+}
+
+// sweepStaleDevices reports the devices silent for more than the allowed reporting intervals
+// and forgets the ones silent for longer than the TTL, so their gauges stop being exported
+func sweepStaleDevices(ctx context.Context, now time.Time) {
+	interval := time.Duration(currentDeviceConfig.Load().MetricIntervalS) * time.Second
+	missedAfter := time.Duration(staleDevices.missedIntervals) * interval
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	for deviceID, seen := range lastSeen {
+		age := now.Sub(seen)
+		switch {
+		case age > staleDevices.ttl:
+			delete(globalMetricCache, deviceID)
+			delete(lastSeen, deviceID)
+			delete(missingSince, deviceID)
+			slog.InfoContext(ctx, "Stale device evicted from the metric cache",
+				slog.String("device_id", deviceID), slog.String("last_seen", seen.Format(time.RFC3339)))
+		case age > missedAfter:
+			if _, ok := missingSince[deviceID]; ok {
+				continue
+			}
+			missingSince[deviceID] = now
+			slog.WarnContext(ctx, "Device missed its reporting intervals",
+				slog.String("device_id", deviceID),
+				slog.String("last_seen", seen.Format(time.RFC3339)),
+				slog.Int("missed_intervals", int(age/interval)))
+			if missedReports != nil {
+				missedReports.Add(ctx, 1, metric.WithAttributes(attribute.String("device_id", deviceID)))
+			}
+		}
+	}
+}
+
+// runStaleDeviceSweeper sweeps the silent devices every staleSweepInterval until ctx is done
+func runStaleDeviceSweeper(ctx context.Context) {
+	ticker := time.NewTicker(staleSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sweepStaleDevices(ctx, now)
+		}
+	}
+}
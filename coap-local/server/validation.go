@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Limits of the payload fields
+const (
+	maxIDLength    = 64              // device and batch IDs
+	maxClockSkew   = 5 * time.Minute // how far in the future a sample can be stamped
+	maxFieldErrors = 20              // field errors listed in a response, the rest are only counted
+)
+
+// Codes of the 4.00 and 4.15 error payloads, stable for the firmware to act on
+const (
+	codeInvalidPayload     = "invalid_payload" // not a CBOR or JSON map
+	codeInvalidMetrics     = "invalid_metrics"
+	codeInvalidLogBatch    = "invalid_log_batch"
+	codeUnsupportedPayload = "unsupported_content_format"
+)
+
+// validationFailures counts the payloads rejected by the validation
+var validationFailures metric.Int64Counter
+
+// payloadField is a top-level field of a payload schema
+type payloadField struct {
+	name     string
+	required bool
+}
+
+// Schemas of the payloads: a field missing from the list is unknown and rejected
+var (
+	metricsSchema = []payloadField{
+		{"device_id", true}, {"timestamp", true},
+		{"cpu_percent", true}, {"mem_used_mb", true}, {"temp_c", true},
+		{"disk_usage_percent", true}, {"disk_read_mbps", true}, {"disk_write_mbps", true},
+	}
+	logBatchSchema = []payloadField{
+		{"device_id", true}, {"batch_id", false}, {"logs", true}, {"links", false},
+	}
+)
+
+// metricBound is the physical range of a reading of the samples
+type metricBound struct {
+	field    string
+	min, max float64
+	value    func(m Metrics) float64
+}
+
+// metricBounds are the physical ranges of the readings, beyond which a sample is garbage
+var metricBounds = []metricBound{
+	{"cpu_percent", 0, 100, func(m Metrics) float64 { return m.CPUPercent }},
+	{"mem_used_mb", 0, 1 << 20, func(m Metrics) float64 { return m.MemUsedMB }},
+	{"temp_c", -40, 150, func(m Metrics) float64 { return m.TempC }},
+	{"disk_usage_percent", 0, 100, func(m Metrics) float64 { return m.DiskUsagePercent }},
+	{"disk_read_mbps", 0, 10000, func(m Metrics) float64 { return m.DiskReadMBps }},
+	{"disk_write_mbps", 0, 10000, func(m Metrics) float64 { return m.DiskWriteMBps }},
+}
+
+// fieldError is a field of a payload that failed validation
+type fieldError struct {
+	Field   string `cbor:"field" json:"field"`
+	Message string `cbor:"message" json:"message"`
+}
+
+// invalidPayloadError lists the fields of a payload that failed validation
+type invalidPayloadError struct {
+	fields []fieldError
+}
+
+func (e *invalidPayloadError) Error() string {
+	msgs := make([]string, len(e.fields))
+	for i, f := range e.fields {
+		msgs[i] = f.Field + " " + f.Message
+	}
+	return "invalid fields: " + strings.Join(msgs, "; ")
+}
+
+// payloadErrors wraps the field errors in an error, nil when there are none
+func payloadErrors(errs []fieldError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return &invalidPayloadError{fields: errs}
+}
+
+// payloadProblem is the body of an error response, in the format of the other responses
+type payloadProblem struct {
+	Code     string       `cbor:"code" json:"code"`
+	Detail   string       `cbor:"detail" json:"detail"`
+	DeviceID string       `cbor:"device_id,omitempty" json:"device_id,omitempty"`
+	Fields   []fieldError `cbor:"fields,omitempty" json:"fields,omitempty"`
+}
+
+// initValidationMetrics creates the counter of the payloads rejected by the validation
+func initValidationMetrics(meter metric.Meter) {
+	var err error
+	validationFailures, err = meter.Int64Counter("custom.googleapis.com/coap_payload_validation_failures",
+		metric.WithDescription("Payload CoAP rifiutati dalla validazione, per risorsa e codice"))
+	if err != nil {
+		log.Fatalf("failed to create coap_payload_validation_failures counter: %v", err)
+	}
+}
+
+// checkSchema reports the unknown fields and the missing required ones among the fields of
+// a payload, sorted by field
+func checkSchema(fields []string, schema []payloadField) []fieldError {
+	var errs []fieldError
+	known := make(map[string]bool, len(schema))
+	for _, f := range schema {
+		known[f.name] = true
+		if f.required && !slices.Contains(fields, f.name) {
+			errs = append(errs, fieldError{f.name, "is required"})
+		}
+	}
+	for _, name := range fields {
+		if !known[name] {
+			errs = append(errs, fieldError{name, "is not a known field"})
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+// typeError turns the wrong type of a field into a field error, nil for any other error
+func typeError(err error) *fieldError {
+	var cborErr *cbor.UnmarshalTypeError
+	var jsonErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &cborErr):
+		// The CBOR decoder names the field after its Go type, like main.Metrics.temp_c
+		field := cborErr.StructFieldName[strings.LastIndex(cborErr.StructFieldName, ".")+1:]
+		return &fieldError{field, fmt.Sprintf("must be %s, not %s", cborErr.GoType, cborErr.CBORType)}
+	case errors.As(err, &jsonErr):
+		return &fieldError{jsonErr.Field, fmt.Sprintf("must be %s, not %s", jsonErr.Type, jsonErr.Value)}
+	}
+	return nil
+}
+
+// validateID checks a device or batch ID
+func validateID(field, id string, required bool) []fieldError {
+	switch {
+	case required && strings.TrimSpace(id) == "":
+		return []fieldError{{field, "must not be empty"}}
+	case len(id) > maxIDLength:
+		return []fieldError{{field, fmt.Sprintf("must be at most %d bytes", maxIDLength)}}
+	}
+	return nil
+}
+
+// validateMetrics checks the device ID, the range of every reading and the timestamp;
+// NaN and infinities are always out of range
+func validateMetrics(m Metrics, now time.Time) error {
+	errs := validateID("device_id", m.DeviceID, true)
+	for _, b := range metricBounds {
+		if v := b.value(m); !(v >= b.min && v <= b.max) {
+			errs = append(errs, fieldError{b.field, fmt.Sprintf("%g is outside [%g, %g]", v, b.min, b.max)})
+		}
+	}
+	switch {
+	case m.Timestamp.IsZero():
+		errs = append(errs, fieldError{"timestamp", "must be set"})
+	case m.Timestamp.After(now.Add(maxClockSkew)):
+		errs = append(errs, fieldError{"timestamp", fmt.Sprintf("%s is more than %v in the future", m.Timestamp.Format(time.RFC3339), maxClockSkew)})
+	}
+	return payloadErrors(errs)
+}
+
+// validateLogBatch checks the IDs, that every log is an [event_id, timestamp] pair and that
+// the links, when sent, are one span per log, empty for a log without one
+func validateLogBatch(b IncomingLogBatch) error {
+	errs := validateID("device_id", b.DeviceID, true)
+	errs = append(errs, validateID("batch_id", b.BatchID, false)...)
+	if len(b.Logs) == 0 {
+		errs = append(errs, fieldError{"logs", "must not be empty"})
+	}
+	for i, entry := range b.Logs {
+		switch {
+		case len(entry) != 2:
+			errs = append(errs, fieldError{fmt.Sprintf("logs[%d]", i), "must be an [event_id, timestamp] pair"})
+		case entry[0] < 0:
+			errs = append(errs, fieldError{fmt.Sprintf("logs[%d]", i), "event_id must not be negative"})
+		case entry[1] <= 0:
+			errs = append(errs, fieldError{fmt.Sprintf("logs[%d]", i), "timestamp must be positive"})
+		}
+	}
+	if b.Links != nil && len(b.Links) != len(b.Logs) {
+		errs = append(errs, fieldError{"links", fmt.Sprintf("has %d spans for %d logs", len(b.Links), len(b.Logs))})
+	}
+	for i, link := range b.Links {
+		if link != (SpanLink{}) && (!isHex(link.TraceID, 32) || !isHex(link.SpanID, 16)) {
+			errs = append(errs, fieldError{fmt.Sprintf("links[%d]", i), "must be a 32 hex digit trace_id and a 16 hex digit span_id"})
+		}
+	}
+	return payloadErrors(errs)
+}
+
+// isHex reports whether s is n hex digits
+func isHex(s string, n int) bool {
+	_, err := hex.DecodeString(s)
+	return len(s) == n && err == nil
+}
+
+// rejectPayload answers a payload that failed to decode or validate: 4.15 for an unknown
+// format, otherwise 4.00 with the failing fields, encoded like the other responses
+func rejectPayload(w mux.ResponseWriter, r *mux.Message, code, deviceID string, err error) {
+	status := codes.BadRequest
+	p := payloadProblem{Code: code, Detail: err.Error(), DeviceID: deviceID}
+	var invalid *invalidPayloadError
+	switch {
+	case errors.Is(err, errUnsupportedContentFormat):
+		status, p.Code = codes.UnsupportedMediaType, codeUnsupportedPayload
+	case errors.As(err, &invalid):
+		p.Fields = invalid.fields[:min(len(invalid.fields), maxFieldErrors)]
+		names := make([]string, len(p.Fields))
+		for i, f := range p.Fields {
+			names[i] = f.Field
+		}
+		p.Detail = "payload failed validation: " + strings.Join(names, ", ")
+		if more := len(invalid.fields) - len(p.Fields); more > 0 {
+			p.Detail += fmt.Sprintf(" and %d more", more)
+		}
+	default:
+		p.Code = codeInvalidPayload
+	}
+
+	path, _ := r.Options().Path()
+	if validationFailures != nil {
+		validationFailures.Add(r.Context(), 1, metric.WithAttributes(
+			attribute.String("path", path), attribute.String("code", p.Code)))
+	}
+	format, body, encErr := encodeResponse(r, p)
+	if encErr != nil {
+		w.SetResponse(status, message.TextPlain, nil)
+		return
+	}
+	w.SetResponse(status, format, bytes.NewReader(body))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/mux"
+)
+
+// coapResource is a resource of the server and its CoRE link attributes, RFC 6690
+type coapResource struct {
+	path       string
+	handler    mux.HandlerFunc
+	rt         string              // Resource type
+	title      string              // Human-readable description
+	ct         []message.MediaType // Content formats accepted or returned
+	observable bool                // Supports Observe
+}
+
+// coapResources are the resources routed and listed on /.well-known/core
+var coapResources = []coapResource{
+	{path: "/batchLog", handler: handleCoapBatchLog, rt: "telemetry.logs", title: "Log batches",
+		ct: []message.MediaType{message.AppCBOR, message.AppJSON}},
+	{path: "/batchMetric", handler: handleCoapMetrics, rt: "telemetry.metrics", title: "Device metrics",
+		ct: []message.MediaType{message.AppCBOR, message.AppJSON}},
+	{path: "/config", handler: handleCoapConfig, rt: "device.config", title: "Device configuration",
+		ct: []message.MediaType{message.AppCBOR, message.AppJSON}, observable: true},
+	{path: "/eventCatalog", handler: handleCoapEventCatalog, rt: "events.catalog", title: "Event catalog",
+		ct: []message.MediaType{message.AppCBOR, message.AppJSON}},
+	{path: "/register", handler: handleCoapRegister, rt: "device.registry", title: "Device registration",
+		ct: []message.MediaType{message.AppCBOR, message.AppJSON}},
+}
+
+// link returns the link of the resource in link format
+func (res coapResource) link() string {
+	formats := make([]string, len(res.ct))
+	for i, ct := range res.ct {
+		formats[i] = strconv.Itoa(int(ct))
+	}
+	link := fmt.Sprintf(`<%s>;rt="%s";title="%s";ct="%s"`, res.path, res.rt, res.title, strings.Join(formats, " "))
+	if res.observable {
+		link += ";obs"
+	}
+	return link
+}
+
+// matches reports whether the resource matches a query of the discovery, like rt=telemetry.*
+// or ct=50; a value ending in * matches by prefix, unknown attributes match nothing
+func (res coapResource) matches(query string) bool {
+	name, value, _ := strings.Cut(query, "=")
+	var values []string
+	switch name {
+	case "href":
+		values = []string{res.path}
+	case "rt":
+		values = []string{res.rt}
+	case "title":
+		values = []string{res.title}
+	case "ct":
+		for _, ct := range res.ct {
+			values = append(values, strconv.Itoa(int(ct)))
+		}
+	case "obs":
+		return res.observable
+	default:
+		return false
+	}
+	for _, v := range values {
+		if prefix, ok := strings.CutSuffix(value, "*"); ok && strings.HasPrefix(v, prefix) || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCoapWellKnownCore lists the resources of the server in CoRE link format, only the
+// ones matching every query parameter of the request
+func handleCoapWellKnownCore(w mux.ResponseWriter, r *mux.Message) {
+	if r.Code() != codes.GET {
+		w.SetResponse(codes.MethodNotAllowed, message.TextPlain, nil)
+		return
+	}
+	queries, _ := r.Options().Queries()
+	var links []string
+	for _, res := range coapResources {
+		matched := true
+		for _, q := range queries {
+			matched = matched && res.matches(q)
+		}
+		if matched {
+			links = append(links, res.link())
+		}
+	}
+	// A multicast discovery is answered only by the servers with matching resources, after a leisure
+	if isMulticast(r) {
+		if len(links) == 0 {
+			return
+		}
+		time.Sleep(rand.N(multicastLeisure))
+	}
+	if err := w.SetResponse(codes.Content, message.AppLinkFormat, bytes.NewReader([]byte(strings.Join(links, ",")))); err != nil {
+		slog.Error("Error sending resource discovery", slog.Any("error", err))
+	}
+}
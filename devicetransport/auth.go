@@ -0,0 +1,80 @@
+package devicetransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// APIKeyHeader is the header carrying the static API key
+const APIKeyHeader = "X-API-Key"
+
+// AuthConfig authenticates the HTTP requests with a static API key or with a bearer
+// token fetched from TokenURL with the OAuth2 client credentials flow
+type AuthConfig struct {
+	APIKey string `json:"api_key"`
+
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	Scopes       []string `json:"scopes"`
+	Audience     string   `json:"audience"` // Sent as the audience parameter, required by some providers
+}
+
+// enabled reports whether any authentication was configured
+func (c AuthConfig) enabled() bool {
+	return c.APIKey != "" || c.TokenURL != ""
+}
+
+// authenticator sets the credentials on every request
+type authenticator struct {
+	apiKey string
+	tokens oauth2.TokenSource // nil without client credentials
+}
+
+// newAuthenticator validates the auth config; the token is fetched on the first request
+// and refreshed shortly before it expires
+func newAuthenticator(cfg AuthConfig, timeout time.Duration) (authenticator, error) {
+	if cfg.APIKey != "" && cfg.TokenURL != "" {
+		return authenticator{}, errors.New("api_key and token_url are mutually exclusive")
+	}
+	if cfg.TokenURL == "" {
+		return authenticator{apiKey: cfg.APIKey}, nil
+	}
+	if cfg.ClientID == "" {
+		return authenticator{}, errors.New("client_id is required with token_url")
+	}
+
+	cc := clientcredentials.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		TokenURL:     cfg.TokenURL,
+		Scopes:       cfg.Scopes,
+	}
+	if cfg.Audience != "" {
+		cc.EndpointParams = map[string][]string{"audience": {cfg.Audience}}
+	}
+	// The token requests outlive the device requests, so they get their own client
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: timeout})
+	return authenticator{tokens: cc.TokenSource(ctx)}, nil
+}
+
+// authorize adds the API key or the bearer token to req
+func (a authenticator) authorize(req *http.Request) error {
+	if a.apiKey != "" {
+		req.Header.Set(APIKeyHeader, a.apiKey)
+	}
+	if a.tokens != nil {
+		token, err := a.tokens.Token()
+		if err != nil {
+			return fmt.Errorf("token fetch error: %w", err)
+		}
+		token.SetAuthHeader(req)
+	}
+	return nil
+}
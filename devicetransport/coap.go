@@ -0,0 +1,178 @@
+package devicetransport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/net/blockwise"
+	"github.com/plgd-dev/go-coap/v3/options"
+	"github.com/plgd-dev/go-coap/v3/udp"
+	"github.com/plgd-dev/go-coap/v3/udp/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// coapEndpoint is the server address and resource path of a coap:// URL
+type coapEndpoint struct {
+	addr string
+	path string
+}
+
+// parseCoAPURL splits coap://host:port/path, defaulting to the standard CoAP port
+func parseCoAPURL(raw string) (coapEndpoint, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return coapEndpoint{}, fmt.Errorf("invalid CoAP URL %q: %w", raw, err)
+	}
+	if u.Scheme != "coap" || u.Host == "" {
+		return coapEndpoint{}, fmt.Errorf("invalid CoAP URL %q, expected coap://host:port/path", raw)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr += ":5683"
+	}
+	return coapEndpoint{addr: addr, path: u.Path}, nil
+}
+
+// coapMaxMessageSize is the largest payload a device sends in blocks, a log backlog
+// accumulated during an outage
+const coapMaxMessageSize = 1 << 20
+
+// blockSZX returns the block size exponent of size bytes, a power of two from 16 to 1024
+func blockSZX(size int) (blockwise.SZX, error) {
+	for szx := blockwise.SZX16; szx <= blockwise.SZX1024; szx++ {
+		if szx.Size() == int64(size) {
+			return szx, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid CoAP block size %d, must be a power of two from 16 to 1024", size)
+}
+
+// coapTransport posts CBOR payloads over UDP; every device has its own connection
+// to each server, like a real device with its own socket. Payloads larger than a
+// block are sent in a Block1 transfer.
+type coapTransport struct {
+	tracer  trace.Tracer
+	metric  coapEndpoint
+	log     coapEndpoint
+	szx     blockwise.SZX
+	timeout time.Duration
+
+	mu    sync.Mutex
+	conns map[string]*client.Conn
+}
+
+// newCoAPTransport creates a CoAP transport for the configured URLs
+func newCoAPTransport(cfg Config, tracer trace.Tracer) (*coapTransport, error) {
+	metric, err := parseCoAPURL(cfg.MetricURL)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := parseCoAPURL(cfg.LogURL)
+	if err != nil {
+		return nil, err
+	}
+	szx, err := blockSZX(cfg.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	return &coapTransport{
+		tracer:  tracer,
+		metric:  metric,
+		log:     logs,
+		szx:     szx,
+		timeout: cfg.Timeout,
+		conns:   make(map[string]*client.Conn),
+	}, nil
+}
+
+// conn returns the connection of a device to addr, dialing it on first use
+func (t *coapTransport) conn(deviceID, addr string) (*client.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := deviceID + "|" + addr
+	if c, ok := t.conns[key]; ok {
+		return c, nil
+	}
+	c, err := udp.Dial(addr,
+		options.WithBlockwise(true, t.szx, t.timeout),
+		options.WithMaxMessageSize(coapMaxMessageSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CoAP client for device %s: %w", deviceID, err)
+	}
+	t.conns[key] = c
+	return c, nil
+}
+
+// SendMetrics posts the metrics of a device to the metric resource
+func (t *coapTransport) SendMetrics(ctx context.Context, deviceID string, metrics interface{}) error {
+	ctx, span := t.tracer.Start(ctx, "send_metrics",
+		trace.WithAttributes(attribute.String("device.id", deviceID)))
+	defer span.End()
+
+	return t.post(ctx, span, deviceID, t.metric, metrics)
+}
+
+// SendMetricBatch is not supported, CoAP devices send every metric on its own
+func (t *coapTransport) SendMetricBatch(ctx context.Context, deviceID string, metrics []interface{}) error {
+	return ErrBatchNotSupported
+}
+
+// SendLogBatch posts a log batch of a device to the log resource
+func (t *coapTransport) SendLogBatch(ctx context.Context, deviceID string, entries []LogEntryCompact, links []SpanLink) error {
+	ctx, span := t.tracer.Start(ctx, "send_log_batch",
+		trace.WithAttributes(attribute.String("device.id", deviceID)))
+	defer span.End()
+
+	return t.post(ctx, span, deviceID, t.log, logBatch(deviceID, entries, links))
+}
+
+// post encodes payload to CBOR and sends it, recording failures on the span
+func (t *coapTransport) post(ctx context.Context, span trace.Span, deviceID string, ep coapEndpoint, payload interface{}) error {
+	data, err := cbor.Marshal(payload)
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("CBOR marshal error: %w", err)
+	}
+
+	c, err := t.conn(deviceID, ep.addr)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	resp, err := c.Post(ctx, ep.path, message.AppCBOR, bytes.NewReader(data))
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	if resp.Code() != codes.Created && resp.Code() != codes.Changed {
+		err := fmt.Errorf("unexpected response code: %v", resp.Code())
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
+// Close closes the connections of all devices
+func (t *coapTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var errs error
+	for key, c := range t.conns {
+		errs = errors.Join(errs, c.Close())
+		delete(t.conns, key)
+	}
+	return errs
+}
@@ -0,0 +1,71 @@
+package devicetransport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Types of the commands the server sends back in the response of a log or metric request
+const (
+	CommandSetInterval      = "set_interval"      // send metrics every IntervalSeconds
+	CommandSelfTest         = "self_test"         // run the diagnostics and log their events
+	CommandUpdateThresholds = "update_thresholds" // alert locally past Thresholds
+)
+
+// Command is a command the server queued for a device
+type Command struct {
+	ID              string             `cbor:"id" json:"id"`
+	Type            string             `cbor:"type" json:"type"`
+	IntervalSeconds int                `cbor:"interval_s,omitempty" json:"interval_s,omitempty"`
+	Thresholds      map[string]float64 `cbor:"thresholds,omitempty" json:"thresholds,omitempty"`
+	CreatedAt       time.Time          `cbor:"created_at" json:"created_at"`
+}
+
+// CommandHandler receives the commands of a device piggybacked on a response
+type CommandHandler func(deviceID string, cmds []Command)
+
+// commandsResponse is the body of a response carrying commands, those of the device of
+// the request in Commands or, for a batch of several devices, those of each in Devices
+type commandsResponse struct {
+	Commands []Command            `cbor:"commands,omitempty" json:"commands,omitempty"`
+	Devices  map[string][]Command `cbor:"devices,omitempty" json:"devices,omitempty"`
+}
+
+// maxCommandsBody bounds the body of a response read for commands
+const maxCommandsBody = 64 << 10
+
+// decodeCommands reads the commands of a response body of contentType by device, the
+// commands of a single device response going to deviceID, none when the body is empty
+// or in another format
+func decodeCommands(body io.Reader, contentType, deviceID string) (map[string][]Command, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != ContentTypeCBOR && mediaType != ContentTypeJSON {
+		return nil, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxCommandsBody))
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	var resp commandsResponse
+	if mediaType == ContentTypeJSON {
+		err = json.Unmarshal(data, &resp)
+	} else {
+		err = cbor.Unmarshal(data, &resp)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid commands in response: %w", err)
+	}
+	cmds := resp.Devices
+	if len(resp.Commands) > 0 && deviceID != "" {
+		if cmds == nil {
+			cmds = make(map[string][]Command, 1)
+		}
+		cmds[deviceID] = append(cmds[deviceID], resp.Commands...)
+	}
+	return cmds, nil
+}
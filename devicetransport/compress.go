@@ -0,0 +1,63 @@
+package devicetransport
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of the HTTP payloads, sent as the Content-Encoding
+const (
+	CompressionNone = ""
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// defaultCompressionThreshold is the payload size below which compressing is not worth it
+const defaultCompressionThreshold = 1024
+
+// zstdEncoder is shared by all devices, EncodeAll is safe for concurrent use
+var zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+
+// compressor compresses the payloads above a size threshold
+type compressor struct {
+	encoding  string
+	threshold int
+}
+
+// newCompressor validates the configured compression
+func newCompressor(encoding string, threshold int) (compressor, error) {
+	switch encoding {
+	case CompressionNone, CompressionGzip, CompressionZstd:
+	default:
+		return compressor{}, fmt.Errorf("unknown compression %q, must be %q or %q", encoding, CompressionGzip, CompressionZstd)
+	}
+	if threshold <= 0 {
+		threshold = defaultCompressionThreshold
+	}
+	return compressor{encoding: encoding, threshold: threshold}, nil
+}
+
+// compress returns the payload to send and its Content-Encoding, empty if left uncompressed
+func (c compressor) compress(data []byte) ([]byte, string, error) {
+	if c.encoding == CompressionNone || len(data) < c.threshold {
+		return data, "", nil
+	}
+
+	switch c.encoding {
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2)), CompressionZstd, nil
+	default:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, "", fmt.Errorf("gzip error: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, "", fmt.Errorf("gzip error: %w", err)
+		}
+		return buf.Bytes(), CompressionGzip, nil
+	}
+}
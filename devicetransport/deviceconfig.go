@@ -0,0 +1,86 @@
+package devicetransport
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
+	"github.com/plgd-dev/go-coap/v3/options"
+	"github.com/plgd-dev/go-coap/v3/udp"
+	"github.com/plgd-dev/go-coap/v3/udp/client"
+)
+
+// DeviceConfig is the configuration a CoAP server pushes to its devices on /config
+type DeviceConfig struct {
+	MetricIntervalS     int               `cbor:"metric_interval_s" json:"metric_interval_s"`
+	LogIntervalS        int               `cbor:"log_interval_s" json:"log_interval_s"`
+	Thresholds          map[string]Levels `cbor:"thresholds" json:"thresholds"` // By metric field, like temp_c
+	EventCatalogVersion int               `cbor:"event_catalog_version" json:"event_catalog_version"`
+}
+
+// Levels are the readings of a metric past which a sample is WARNING, CRITICAL or EMERGENCY
+type Levels struct {
+	Warning   float64 `cbor:"warning" json:"warning"`
+	Critical  float64 `cbor:"critical" json:"critical"`
+	Emergency float64 `cbor:"emergency" json:"emergency"`
+}
+
+// MetricInterval is how often the devices send their metrics, 0 if not set
+func (c DeviceConfig) MetricInterval() time.Duration {
+	return time.Duration(c.MetricIntervalS) * time.Second
+}
+
+// LogInterval is how often the devices send their log batches, 0 if not set
+func (c DeviceConfig) LogInterval() time.Duration {
+	return time.Duration(c.LogIntervalS) * time.Second
+}
+
+// configCancelTimeout bounds the deregistration of an observation when ctx is done
+const configCancelTimeout = 5 * time.Second
+
+// configKeepAlive is how often the observing connection pings the server when idle, well
+// within the 16 seconds after which the server forgets an idle connection and its observations
+const configKeepAlive = 5 * time.Second
+
+// ObserveConfig observes the config resource at rawURL, coap://host:port/config, and calls
+// handle with the current config and then on every change pushed by the server, until
+// ctx is done. Notifications that fail to decode are skipped.
+func ObserveConfig(ctx context.Context, rawURL string, handle func(DeviceConfig)) error {
+	ep, err := parseCoAPURL(rawURL)
+	if err != nil {
+		return err
+	}
+	conn, err := udp.Dial(ep.addr, options.WithKeepAlive(3, configKeepAlive, func(cc *client.Conn) {
+		cc.Close()
+	}))
+	if err != nil {
+		return fmt.Errorf("failed to create CoAP client for the config: %w", err)
+	}
+
+	obs, err := conn.Observe(ctx, ep.path, func(n *pool.Message) {
+		body, err := n.ReadBody()
+		if err != nil {
+			return
+		}
+		var c DeviceConfig
+		if err := cbor.Unmarshal(body, &c); err != nil {
+			return
+		}
+		handle(c)
+	})
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to observe %s: %w", rawURL, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		cancelCtx, cancel := context.WithTimeout(context.Background(), configCancelTimeout)
+		defer cancel()
+		obs.Cancel(cancelCtx)
+		conn.Close()
+	}()
+	return nil
+}
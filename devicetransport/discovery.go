@@ -0,0 +1,115 @@
+package devicetransport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/plgd-dev/go-coap/v3/message"
+	"github.com/plgd-dev/go-coap/v3/message/codes"
+	"github.com/plgd-dev/go-coap/v3/message/pool"
+	coapNet "github.com/plgd-dev/go-coap/v3/net"
+	"github.com/plgd-dev/go-coap/v3/udp"
+	"github.com/plgd-dev/go-coap/v3/udp/client"
+)
+
+// AllCoapNodes is the IPv4 All-CoAP-Nodes group the servers join with COAP_MULTICAST
+const AllCoapNodes = "224.0.1.187:5683"
+
+// RegistryResourceType is the resource type of /register in the discovery of the servers
+const RegistryResourceType = "device.registry"
+
+// Registration is what a device tells the server it registers with
+type Registration struct {
+	DeviceID string `cbor:"device_id" json:"device_id"`
+	Model    string `cbor:"model,omitempty" json:"model,omitempty"`
+	Firmware string `cbor:"firmware,omitempty" json:"firmware,omitempty"`
+}
+
+// DiscoverServer multicasts a discovery of the registration resource to group, AllCoapNodes
+// when empty, and returns the coap://host:port URL of the first server that answers before
+// ctx is done
+func DiscoverServer(ctx context.Context, group string) (string, error) {
+	if group == "" {
+		group = AllCoapNodes
+	}
+	l, err := coapNet.NewListenUDP("udp4", "")
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for the discovery: %w", err)
+	}
+	defer l.Close()
+	s := udp.NewServer()
+	defer s.Stop()
+	go s.Serve(l)
+
+	token, err := message.GetToken()
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req := pool.NewMessage(ctx)
+	if err := req.SetupGet("/.well-known/core", token); err != nil {
+		return "", err
+	}
+	req.AddQuery("rt=" + RegistryResourceType)
+	req.SetMessageID(message.GetMID())
+	req.SetType(message.NonConfirmable)
+
+	found := make(chan string, 1)
+	err = s.DiscoveryRequest(req, group, func(cc *client.Conn, resp *pool.Message) {
+		if resp.Code() != codes.Content {
+			return
+		}
+		select {
+		case found <- "coap://" + cc.RemoteAddr().String():
+			cancel()
+		default:
+		}
+	})
+	select {
+	case server := <-found:
+		return server, nil
+	default:
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to discover a CoAP server on %s: %w", group, err)
+	}
+	return "", fmt.Errorf("no CoAP server answered on %s", group)
+}
+
+// Register registers a device with the server at baseURL, coap://host:port, and returns the
+// device config the server answers with
+func Register(ctx context.Context, baseURL string, reg Registration) (DeviceConfig, error) {
+	ep, err := parseCoAPURL(baseURL)
+	if err != nil {
+		return DeviceConfig{}, err
+	}
+	data, err := cbor.Marshal(reg)
+	if err != nil {
+		return DeviceConfig{}, fmt.Errorf("CBOR marshal error: %w", err)
+	}
+	conn, err := udp.Dial(ep.addr)
+	if err != nil {
+		return DeviceConfig{}, fmt.Errorf("failed to create CoAP client for the registration: %w", err)
+	}
+	defer conn.Close()
+
+	resp, err := conn.Post(ctx, "/register", message.AppCBOR, bytes.NewReader(data))
+	if err != nil {
+		return DeviceConfig{}, fmt.Errorf("failed to register %s: %w", reg.DeviceID, err)
+	}
+	if resp.Code() != codes.Created && resp.Code() != codes.Changed {
+		return DeviceConfig{}, fmt.Errorf("failed to register %s: unexpected response code: %v", reg.DeviceID, resp.Code())
+	}
+	body, err := resp.ReadBody()
+	if err != nil {
+		return DeviceConfig{}, err
+	}
+	var c DeviceConfig
+	if err := cbor.Unmarshal(body, &c); err != nil {
+		return DeviceConfig{}, fmt.Errorf("failed to decode the config of %s: %w", reg.DeviceID, err)
+	}
+	return c, nil
+}
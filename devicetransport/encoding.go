@@ -0,0 +1,143 @@
+package devicetransport
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"devicetransport/telemetrypb"
+
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// Encodings of the payloads
+const (
+	EncodingCBOR     = "cbor"
+	EncodingProtobuf = "protobuf"
+	EncodingJSON     = "json" // readable payloads for debugging
+)
+
+// Content types of the encodings, negotiated by the HTTP server
+const (
+	ContentTypeCBOR     = "application/cbor"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+)
+
+// PayloadSchemaVersion is the version of the metric payload schema the devices send in
+// schema_version, to increment with every change of its fields
+const PayloadSchemaVersion = 1
+
+// ProtoPayload is a payload that can be sent with the protobuf encoding
+type ProtoPayload interface {
+	Proto() proto.Message
+}
+
+// encoder marshals the payloads with the configured encoding
+type encoder struct {
+	encoding string
+}
+
+// newEncoder validates the configured encoding, CBOR by default
+func newEncoder(encoding string) (encoder, error) {
+	switch encoding {
+	case "":
+		encoding = EncodingCBOR
+	case EncodingCBOR, EncodingProtobuf, EncodingJSON:
+	default:
+		return encoder{}, fmt.Errorf("unknown encoding %q, must be %q, %q or %q", encoding, EncodingCBOR, EncodingProtobuf, EncodingJSON)
+	}
+	return encoder{encoding: encoding}, nil
+}
+
+// encode marshals payload, returning the data and its content type
+func (e encoder) encode(payload interface{}) ([]byte, string, error) {
+	switch e.encoding {
+	case EncodingJSON:
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, "", fmt.Errorf("JSON marshal error: %w", err)
+		}
+		return data, ContentTypeJSON, nil
+	case EncodingProtobuf:
+		p, ok := payload.(ProtoPayload)
+		if !ok {
+			return nil, "", fmt.Errorf("payload %T has no protobuf form", payload)
+		}
+		data, err := proto.Marshal(p.Proto())
+		if err != nil {
+			return nil, "", fmt.Errorf("protobuf marshal error: %w", err)
+		}
+		return data, ContentTypeProtobuf, nil
+	}
+
+	data, err := cbor.Marshal(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("CBOR marshal error: %w", err)
+	}
+	return data, ContentTypeCBOR, nil
+}
+
+// logBatchPayload is the payload of a log batch, the same for every protocol;
+// servers that do not know the links still decode the logs
+type logBatchPayload struct {
+	DeviceID string            `cbor:"device_id" json:"device_id"`
+	BatchID  string            `cbor:"batch_id,omitempty" json:"batch_id,omitempty"` // Same for every retry of the batch
+	Logs     []LogEntryCompact `cbor:"logs" json:"logs"`
+	Links    []SpanLink        `cbor:"links,omitempty" json:"links,omitempty"` // Span of each log, by index
+}
+
+// logBatch creates the payload of a log batch, dropping links that do not match the entries
+func logBatch(deviceID string, entries []LogEntryCompact, links []SpanLink) logBatchPayload {
+	if len(links) != len(entries) {
+		links = nil
+	}
+	return logBatchPayload{DeviceID: deviceID, BatchID: logBatchID(deviceID, entries), Logs: entries, Links: links}
+}
+
+// logBatchID derives the ID of a batch from its device and entries, so a batch sent
+// again after a timeout or from the spill file keeps the ID the server saw first
+func logBatchID(deviceID string, entries []LogEntryCompact) string {
+	h := sha256.New()
+	h.Write([]byte(deviceID))
+	var buf [16]byte
+	for _, e := range entries {
+		binary.BigEndian.PutUint64(buf[:8], uint64(e[0]))
+		binary.BigEndian.PutUint64(buf[8:], uint64(e[1]))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Proto returns the batch as a telemetrypb.IncomingLogBatch
+func (b logBatchPayload) Proto() proto.Message {
+	logs := make([]*telemetrypb.LogEntry, 0, len(b.Logs))
+	for i, e := range b.Logs {
+		entry := &telemetrypb.LogEntry{EventId: uint32(e[0]), Timestamp: e[1]}
+		if b.Links != nil {
+			entry.TraceId, entry.SpanId = b.Links[i].TraceID, b.Links[i].SpanID
+		}
+		logs = append(logs, entry)
+	}
+	return &telemetrypb.IncomingLogBatch{DeviceId: b.DeviceID, BatchId: b.BatchID, Logs: logs}
+}
+
+// metricBatchPayload is a batch of metrics payloads, an array of them in CBOR and JSON
+type metricBatchPayload []interface{}
+
+// Proto returns the batch as a telemetrypb.MetricsBatch; every payload must have a
+// protobuf form that is a telemetrypb.Metrics
+func (b metricBatchPayload) Proto() proto.Message {
+	batch := &telemetrypb.MetricsBatch{Metrics: make([]*telemetrypb.Metrics, 0, len(b))}
+	for _, m := range b {
+		if p, ok := m.(ProtoPayload); ok {
+			if pm, ok := p.Proto().(*telemetrypb.Metrics); ok {
+				batch.Metrics = append(batch.Metrics, pm)
+			}
+		}
+	}
+	return batch
+}
@@ -0,0 +1,158 @@
+package devicetransport
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+// EventDefinition is the severity and message a log event ID stands for
+type EventDefinition struct {
+	Severity string `cbor:"severity" json:"severity"`
+	Message  string `cbor:"message" json:"message"`
+}
+
+// EventCatalog maps the event IDs sent in LogEntryCompact to their definitions; Version
+// grows at every change so devices and servers can tell which catalog they decode with
+type EventCatalog struct {
+	Version int                       `cbor:"version" json:"version"`
+	Events  map[uint8]EventDefinition `cbor:"events" json:"events"`
+}
+
+// events.json is the catalog shared by the clients and the servers, the only copy in the repo
+//
+//go:embed events.json
+var defaultEventCatalog []byte
+
+// DefaultEventCatalog is the catalog built into the binary
+var DefaultEventCatalog = mustParseEventCatalog(defaultEventCatalog)
+
+// EventDefinitions are the log events a device can emit, by the ID sent in LogEntryCompact;
+// the servers decode the IDs with the same catalog
+var EventDefinitions = DefaultEventCatalog.Events
+
+// ParseEventCatalog decodes a JSON event catalog and checks every event has a known severity
+func ParseEventCatalog(data []byte) (EventCatalog, error) {
+	var c EventCatalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return EventCatalog{}, fmt.Errorf("invalid event catalog: %w", err)
+	}
+	if c.Version <= 0 {
+		return EventCatalog{}, fmt.Errorf("invalid event catalog version %d", c.Version)
+	}
+	if len(c.Events) == 0 {
+		return EventCatalog{}, errors.New("event catalog has no events")
+	}
+	for id, def := range c.Events {
+		if !slices.Contains(Severities, def.Severity) {
+			return EventCatalog{}, fmt.Errorf("event %d has unknown severity %q, must be one of %v", id, def.Severity, Severities)
+		}
+	}
+	return c, nil
+}
+
+func mustParseEventCatalog(data []byte) EventCatalog {
+	c, err := ParseEventCatalog(data)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// LoadEventCatalog reads the catalog of path, DefaultEventCatalog when path is empty; the
+// servers load EVENT_CATALOG_FILE with it
+func LoadEventCatalog(path string) (EventCatalog, error) {
+	if path == "" {
+		return DefaultEventCatalog, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return EventCatalog{}, err
+	}
+	c, err := ParseEventCatalog(data)
+	if err != nil {
+		return EventCatalog{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// UseEventCatalog makes c the catalog of EventDefinitions; it must be called before the
+// devices start emitting events
+func UseEventCatalog(c EventCatalog) {
+	EventDefinitions = c.Events
+}
+
+// FetchEventCatalog downloads the catalog the server tells the devices to use from url,
+// with the TLS and credentials of the device requests
+func FetchEventCatalog(ctx context.Context, url string, tlsCfg TLSConfig, authCfg AuthConfig, timeout time.Duration) (EventCatalog, error) {
+	auth, err := newAuthenticator(authCfg, timeout)
+	if err != nil {
+		return EventCatalog{}, err
+	}
+	transport := &http.Transport{}
+	if tlsCfg.enabled() {
+		if transport.TLSClientConfig, err = tlsCfg.baseTLSConfig(); err != nil {
+			return EventCatalog{}, err
+		}
+	}
+	client := &http.Client{Timeout: timeout, Transport: transport}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return EventCatalog{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if err := auth.authorize(req); err != nil {
+		return EventCatalog{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return EventCatalog{}, fmt.Errorf("event catalog request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return EventCatalog{}, fmt.Errorf("event catalog request failed: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return EventCatalog{}, fmt.Errorf("failed to read event catalog: %w", err)
+	}
+	return ParseEventCatalog(data)
+}
+
+// Severities are the severities of the events, from the least to the most severe
+var Severities = []string{"DEBUG", "INFO", "NOTICE", "WARNING", "ERROR", "CRITICAL", "ALERT", "EMERGENCY"}
+
+// Events of the firmware update cycle, emitted in order by the simulated OTA updates
+const (
+	EventBootCompleted            uint8 = 5
+	EventFirmwareAvailable        uint8 = 10
+	EventFirmwareDownloadStarted  uint8 = 28
+	EventFirmwareDownloadComplete uint8 = 29
+	EventFirmwareReboot           uint8 = 30
+	EventFirmwareUpdated          uint8 = 31
+)
+
+// Events of the generation processes of the simulator: the periodic heartbeat and the
+// warning that opens the burst of errors after an anomaly
+const (
+	EventHeartbeat       uint8 = 8
+	EventHighTemperature uint8 = 13
+)
+
+// Events of the commands of the server: the checks of a self-test and the warnings of
+// the readings past the thresholds it set
+const (
+	EventNetworkCheck uint8 = 2
+	EventSensorStart  uint8 = 3
+	EventHighCPU      uint8 = 14
+	EventBatteryLow   uint8 = 15
+)
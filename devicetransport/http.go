@@ -0,0 +1,231 @@
+package devicetransport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// httpTransport posts the payloads, propagating the trace context in the headers
+type httpTransport struct {
+	client   *http.Client
+	tracer   trace.Tracer
+	cfg      Config
+	compress compressor
+	encode   encoder
+	auth     authenticator
+
+	// Devices with their own certificate have their own client, like a real device;
+	// a nil entry means the device has none and uses the shared client
+	tlsBase       *tls.Config
+	mu            sync.Mutex
+	deviceClients map[string]*http.Client
+}
+
+// newHTTPTransport creates an HTTP transport sharing one connection pool across devices
+// that present no certificate of their own
+func newHTTPTransport(cfg Config, tracer trace.Tracer) (*httpTransport, error) {
+	compress, err := newCompressor(cfg.Compression, cfg.CompressionThreshold)
+	if err != nil {
+		return nil, err
+	}
+	encode, err := newEncoder(cfg.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newAuthenticator(cfg.Auth, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsBase *tls.Config
+	if cfg.TLS.enabled() {
+		if tlsBase, err = cfg.TLS.baseTLSConfig(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &httpTransport{
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConns:        max(100, cfg.MaxIdleConns),
+				MaxIdleConnsPerHost: max(10, cfg.MaxIdleConns),
+				IdleConnTimeout:     100 * time.Second,
+				TLSClientConfig:     tlsBase,
+			},
+		},
+		tracer:        tracer,
+		cfg:           cfg,
+		compress:      compress,
+		encode:        encode,
+		auth:          auth,
+		tlsBase:       tlsBase,
+		deviceClients: make(map[string]*http.Client),
+	}, nil
+}
+
+// clientFor returns the client of a device, loading its certificate on first use;
+// requests of no single device use the shared client
+func (t *httpTransport) clientFor(deviceID string) (*http.Client, error) {
+	if t.cfg.TLS.CertDir == "" || deviceID == "" {
+		return t.client, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.deviceClients[deviceID]; ok {
+		if c == nil {
+			return t.client, nil
+		}
+		return c, nil
+	}
+
+	cert, ok, err := t.cfg.TLS.deviceCertificate(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		t.deviceClients[deviceID] = nil
+		return t.client, nil
+	}
+
+	tlsCfg := t.tlsBase.Clone()
+	tlsCfg.Certificates = []tls.Certificate{cert}
+	c := &http.Client{
+		Timeout: t.cfg.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     100 * time.Second,
+			TLSClientConfig:     tlsCfg,
+		},
+	}
+	t.deviceClients[deviceID] = c
+	return c, nil
+}
+
+// SendMetrics posts the metrics of a device to MetricURL
+func (t *httpTransport) SendMetrics(ctx context.Context, deviceID string, metrics interface{}) error {
+	ctx, span := t.tracer.Start(ctx, "SendMetric",
+		trace.WithAttributes(attribute.String("device.id", deviceID)))
+	defer span.End()
+
+	return t.post(ctx, span, deviceID, t.cfg.MetricURL, metrics)
+}
+
+// SendMetricBatch posts several metrics in one request to MetricBatchURL
+func (t *httpTransport) SendMetricBatch(ctx context.Context, deviceID string, metrics []interface{}) error {
+	ctx, span := t.tracer.Start(ctx, "SendMetricBatch",
+		trace.WithAttributes(attribute.String("device.id", deviceID), attribute.Int("batch.size", len(metrics))))
+	defer span.End()
+
+	if t.cfg.MetricBatchURL == "" {
+		err := fmt.Errorf("no metric batch URL configured")
+		span.RecordError(err)
+		return err
+	}
+	return t.post(ctx, span, deviceID, t.cfg.MetricBatchURL, metricBatchPayload(metrics))
+}
+
+// SendLogBatch posts a log batch of a device to LogURL
+func (t *httpTransport) SendLogBatch(ctx context.Context, deviceID string, entries []LogEntryCompact, links []SpanLink) error {
+	ctx, span := t.tracer.Start(ctx, "SendLogBatch",
+		trace.WithAttributes(attribute.String("device.id", deviceID)))
+	defer span.End()
+
+	return t.post(ctx, span, deviceID, t.cfg.LogURL, logBatch(deviceID, entries, links))
+}
+
+// post encodes payload with the configured encoding and sends it, recording failures on the span
+func (t *httpTransport) post(ctx context.Context, span trace.Span, deviceID, url string, payload interface{}) error {
+	client, err := t.clientFor(deviceID)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	data, contentType, err := t.encode.encode(payload)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	body, encoding, err := t.compress.compress(data)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		span.RecordError(err)
+		return fmt.Errorf("request build error: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	if err := t.auth.authorize(req); err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// Inject trace context into HTTP headers
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		err := fmt.Errorf("unexpected status: %s", resp.Status)
+		span.RecordError(err)
+		return err
+	}
+
+	// The payload was accepted, a malformed commands body doesn't fail the send
+	if t.cfg.OnCommands != nil {
+		byDevice, err := decodeCommands(resp.Body, resp.Header.Get("Content-Type"), deviceID)
+		if err != nil {
+			span.RecordError(err)
+		}
+		n := 0
+		for id, cmds := range byDevice {
+			if len(cmds) > 0 {
+				n += len(cmds)
+				t.cfg.OnCommands(id, cmds)
+			}
+		}
+		if n > 0 {
+			span.SetAttributes(attribute.Int("commands", n))
+		}
+	}
+	return nil
+}
+
+// Close drops the idle connections of the shared and the per-device clients
+func (t *httpTransport) Close() error {
+	t.client.CloseIdleConnections()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range t.deviceClients {
+		if c != nil {
+			c.CloseIdleConnections()
+		}
+	}
+	return nil
+}
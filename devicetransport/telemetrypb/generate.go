@@ -0,0 +1,5 @@
+// Package telemetrypb is the protobuf form of the device payloads; telemetry.proto is
+// also the schema the HTTP server generates its own copy from.
+package telemetrypb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative telemetry.proto
@@ -0,0 +1,599 @@
+// Telemetry sent by the simulated devices when the protobuf encoding is selected,
+// field for field the same payloads as the CBOR ones.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: telemetry.proto
+
+package telemetrypb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// GeoPosition is the position of a device, altitude in meters above sea level
+type GeoPosition struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Latitude      float64                `protobuf:"fixed64,1,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude     float64                `protobuf:"fixed64,2,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	Altitude      float64                `protobuf:"fixed64,3,opt,name=altitude,proto3" json:"altitude,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GeoPosition) Reset() {
+	*x = GeoPosition{}
+	mi := &file_telemetry_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GeoPosition) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GeoPosition) ProtoMessage() {}
+
+func (x *GeoPosition) ProtoReflect() protoreflect.Message {
+	mi := &file_telemetry_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GeoPosition.ProtoReflect.Descriptor instead.
+func (*GeoPosition) Descriptor() ([]byte, []int) {
+	return file_telemetry_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GeoPosition) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *GeoPosition) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *GeoPosition) GetAltitude() float64 {
+	if x != nil {
+		return x.Altitude
+	}
+	return 0
+}
+
+// ExternalSensors are the readings of the sensors attached to a device
+type ExternalSensors struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ThermometerC  float64                `protobuf:"fixed64,1,opt,name=thermometer_c,json=thermometerC,proto3" json:"thermometer_c,omitempty"`
+	BarometerHpa  float64                `protobuf:"fixed64,2,opt,name=barometer_hpa,json=barometerHpa,proto3" json:"barometer_hpa,omitempty"`
+	HygrometerRh  float64                `protobuf:"fixed64,3,opt,name=hygrometer_rh,json=hygrometerRh,proto3" json:"hygrometer_rh,omitempty"`
+	AnemometerMps float64                `protobuf:"fixed64,4,opt,name=anemometer_mps,json=anemometerMps,proto3" json:"anemometer_mps,omitempty"`
+	Pm25Ugm3      float64                `protobuf:"fixed64,5,opt,name=pm25_ugm3,json=pm25Ugm3,proto3" json:"pm25_ugm3,omitempty"`
+	Co2Ppm        float64                `protobuf:"fixed64,6,opt,name=co2_ppm,json=co2Ppm,proto3" json:"co2_ppm,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExternalSensors) Reset() {
+	*x = ExternalSensors{}
+	mi := &file_telemetry_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExternalSensors) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExternalSensors) ProtoMessage() {}
+
+func (x *ExternalSensors) ProtoReflect() protoreflect.Message {
+	mi := &file_telemetry_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExternalSensors.ProtoReflect.Descriptor instead.
+func (*ExternalSensors) Descriptor() ([]byte, []int) {
+	return file_telemetry_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ExternalSensors) GetThermometerC() float64 {
+	if x != nil {
+		return x.ThermometerC
+	}
+	return 0
+}
+
+func (x *ExternalSensors) GetBarometerHpa() float64 {
+	if x != nil {
+		return x.BarometerHpa
+	}
+	return 0
+}
+
+func (x *ExternalSensors) GetHygrometerRh() float64 {
+	if x != nil {
+		return x.HygrometerRh
+	}
+	return 0
+}
+
+func (x *ExternalSensors) GetAnemometerMps() float64 {
+	if x != nil {
+		return x.AnemometerMps
+	}
+	return 0
+}
+
+func (x *ExternalSensors) GetPm25Ugm3() float64 {
+	if x != nil {
+		return x.Pm25Ugm3
+	}
+	return 0
+}
+
+func (x *ExternalSensors) GetCo2Ppm() float64 {
+	if x != nil {
+		return x.Co2Ppm
+	}
+	return 0
+}
+
+// Metrics is one telemetry sample of a device, posted to /batchMetric
+type Metrics struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId        string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	GeoPosition     *GeoPosition           `protobuf:"bytes,2,opt,name=geo_position,json=geoPosition,proto3" json:"geo_position,omitempty"`
+	Timestamp       *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	McuUsagePercent float64                `protobuf:"fixed64,4,opt,name=mcu_usage_percent,json=mcuUsagePercent,proto3" json:"mcu_usage_percent,omitempty"`
+	McuTempC        float64                `protobuf:"fixed64,5,opt,name=mcu_temp_c,json=mcuTempC,proto3" json:"mcu_temp_c,omitempty"`
+	BatteryPercent  float64                `protobuf:"fixed64,6,opt,name=battery_percent,json=batteryPercent,proto3" json:"battery_percent,omitempty"`
+	RssiDbm         float64                `protobuf:"fixed64,7,opt,name=rssi_dbm,json=rssiDbm,proto3" json:"rssi_dbm,omitempty"`
+	ExternalSensors *ExternalSensors       `protobuf:"bytes,8,opt,name=external_sensors,json=externalSensors,proto3" json:"external_sensors,omitempty"`
+	FirmwareVersion string                 `protobuf:"bytes,9,opt,name=firmware_version,json=firmwareVersion,proto3" json:"firmware_version,omitempty"`
+	Fleet           string                 `protobuf:"bytes,10,opt,name=fleet,proto3" json:"fleet,omitempty"`
+	Region          string                 `protobuf:"bytes,11,opt,name=region,proto3" json:"region,omitempty"`
+	Labels          map[string]string      `protobuf:"bytes,12,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Version of the payload schema the device sends, 0 for devices older than it
+	SchemaVersion uint32 `protobuf:"varint,13,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Metrics) Reset() {
+	*x = Metrics{}
+	mi := &file_telemetry_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Metrics) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metrics) ProtoMessage() {}
+
+func (x *Metrics) ProtoReflect() protoreflect.Message {
+	mi := &file_telemetry_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metrics.ProtoReflect.Descriptor instead.
+func (*Metrics) Descriptor() ([]byte, []int) {
+	return file_telemetry_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Metrics) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *Metrics) GetGeoPosition() *GeoPosition {
+	if x != nil {
+		return x.GeoPosition
+	}
+	return nil
+}
+
+func (x *Metrics) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Metrics) GetMcuUsagePercent() float64 {
+	if x != nil {
+		return x.McuUsagePercent
+	}
+	return 0
+}
+
+func (x *Metrics) GetMcuTempC() float64 {
+	if x != nil {
+		return x.McuTempC
+	}
+	return 0
+}
+
+func (x *Metrics) GetBatteryPercent() float64 {
+	if x != nil {
+		return x.BatteryPercent
+	}
+	return 0
+}
+
+func (x *Metrics) GetRssiDbm() float64 {
+	if x != nil {
+		return x.RssiDbm
+	}
+	return 0
+}
+
+func (x *Metrics) GetExternalSensors() *ExternalSensors {
+	if x != nil {
+		return x.ExternalSensors
+	}
+	return nil
+}
+
+func (x *Metrics) GetFirmwareVersion() string {
+	if x != nil {
+		return x.FirmwareVersion
+	}
+	return ""
+}
+
+func (x *Metrics) GetFleet() string {
+	if x != nil {
+		return x.Fleet
+	}
+	return ""
+}
+
+func (x *Metrics) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
+func (x *Metrics) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Metrics) GetSchemaVersion() uint32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+// MetricsBatch is several telemetry samples, of one or more devices, posted to /batchMetrics
+type MetricsBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*Metrics             `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetricsBatch) Reset() {
+	*x = MetricsBatch{}
+	mi := &file_telemetry_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetricsBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetricsBatch) ProtoMessage() {}
+
+func (x *MetricsBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_telemetry_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetricsBatch.ProtoReflect.Descriptor instead.
+func (*MetricsBatch) Descriptor() ([]byte, []int) {
+	return file_telemetry_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *MetricsBatch) GetMetrics() []*Metrics {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+// LogEntry is a log event of a device: the event ID, its unix timestamp and the
+// hex trace and span active on the device, empty when none
+type LogEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EventId       uint32                 `protobuf:"varint,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	TraceId       string                 `protobuf:"bytes,3,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	SpanId        string                 `protobuf:"bytes,4,opt,name=span_id,json=spanId,proto3" json:"span_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogEntry) Reset() {
+	*x = LogEntry{}
+	mi := &file_telemetry_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogEntry) ProtoMessage() {}
+
+func (x *LogEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_telemetry_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogEntry.ProtoReflect.Descriptor instead.
+func (*LogEntry) Descriptor() ([]byte, []int) {
+	return file_telemetry_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *LogEntry) GetEventId() uint32 {
+	if x != nil {
+		return x.EventId
+	}
+	return 0
+}
+
+func (x *LogEntry) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *LogEntry) GetTraceId() string {
+	if x != nil {
+		return x.TraceId
+	}
+	return ""
+}
+
+func (x *LogEntry) GetSpanId() string {
+	if x != nil {
+		return x.SpanId
+	}
+	return ""
+}
+
+// IncomingLogBatch is a batch of log events of a device, posted to /batchLog; batch_id
+// is the same for every retry of the batch, so the server handles it once
+type IncomingLogBatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	DeviceId      string                 `protobuf:"bytes,1,opt,name=device_id,json=deviceId,proto3" json:"device_id,omitempty"`
+	Logs          []*LogEntry            `protobuf:"bytes,2,rep,name=logs,proto3" json:"logs,omitempty"`
+	BatchId       string                 `protobuf:"bytes,3,opt,name=batch_id,json=batchId,proto3" json:"batch_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IncomingLogBatch) Reset() {
+	*x = IncomingLogBatch{}
+	mi := &file_telemetry_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IncomingLogBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IncomingLogBatch) ProtoMessage() {}
+
+func (x *IncomingLogBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_telemetry_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IncomingLogBatch.ProtoReflect.Descriptor instead.
+func (*IncomingLogBatch) Descriptor() ([]byte, []int) {
+	return file_telemetry_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *IncomingLogBatch) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *IncomingLogBatch) GetLogs() []*LogEntry {
+	if x != nil {
+		return x.Logs
+	}
+	return nil
+}
+
+func (x *IncomingLogBatch) GetBatchId() string {
+	if x != nil {
+		return x.BatchId
+	}
+	return ""
+}
+
+var File_telemetry_proto protoreflect.FileDescriptor
+
+const file_telemetry_proto_rawDesc = "" +
+	"\n" +
+	"\x0ftelemetry.proto\x12\ftelemetry.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"c\n" +
+	"\vGeoPosition\x12\x1a\n" +
+	"\blatitude\x18\x01 \x01(\x01R\blatitude\x12\x1c\n" +
+	"\tlongitude\x18\x02 \x01(\x01R\tlongitude\x12\x1a\n" +
+	"\baltitude\x18\x03 \x01(\x01R\baltitude\"\xdd\x01\n" +
+	"\x0fExternalSensors\x12#\n" +
+	"\rthermometer_c\x18\x01 \x01(\x01R\fthermometerC\x12#\n" +
+	"\rbarometer_hpa\x18\x02 \x01(\x01R\fbarometerHpa\x12#\n" +
+	"\rhygrometer_rh\x18\x03 \x01(\x01R\fhygrometerRh\x12%\n" +
+	"\x0eanemometer_mps\x18\x04 \x01(\x01R\ranemometerMps\x12\x1b\n" +
+	"\tpm25_ugm3\x18\x05 \x01(\x01R\bpm25Ugm3\x12\x17\n" +
+	"\aco2_ppm\x18\x06 \x01(\x01R\x06co2Ppm\"\xec\x04\n" +
+	"\aMetrics\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12<\n" +
+	"\fgeo_position\x18\x02 \x01(\v2\x19.telemetry.v1.GeoPositionR\vgeoPosition\x128\n" +
+	"\ttimestamp\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12*\n" +
+	"\x11mcu_usage_percent\x18\x04 \x01(\x01R\x0fmcuUsagePercent\x12\x1c\n" +
+	"\n" +
+	"mcu_temp_c\x18\x05 \x01(\x01R\bmcuTempC\x12'\n" +
+	"\x0fbattery_percent\x18\x06 \x01(\x01R\x0ebatteryPercent\x12\x19\n" +
+	"\brssi_dbm\x18\a \x01(\x01R\arssiDbm\x12H\n" +
+	"\x10external_sensors\x18\b \x01(\v2\x1d.telemetry.v1.ExternalSensorsR\x0fexternalSensors\x12)\n" +
+	"\x10firmware_version\x18\t \x01(\tR\x0ffirmwareVersion\x12\x14\n" +
+	"\x05fleet\x18\n" +
+	" \x01(\tR\x05fleet\x12\x16\n" +
+	"\x06region\x18\v \x01(\tR\x06region\x129\n" +
+	"\x06labels\x18\f \x03(\v2!.telemetry.v1.Metrics.LabelsEntryR\x06labels\x12%\n" +
+	"\x0eschema_version\x18\r \x01(\rR\rschemaVersion\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"?\n" +
+	"\fMetricsBatch\x12/\n" +
+	"\ametrics\x18\x01 \x03(\v2\x15.telemetry.v1.MetricsR\ametrics\"w\n" +
+	"\bLogEntry\x12\x19\n" +
+	"\bevent_id\x18\x01 \x01(\rR\aeventId\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp\x12\x19\n" +
+	"\btrace_id\x18\x03 \x01(\tR\atraceId\x12\x17\n" +
+	"\aspan_id\x18\x04 \x01(\tR\x06spanId\"v\n" +
+	"\x10IncomingLogBatch\x12\x1b\n" +
+	"\tdevice_id\x18\x01 \x01(\tR\bdeviceId\x12*\n" +
+	"\x04logs\x18\x02 \x03(\v2\x16.telemetry.v1.LogEntryR\x04logs\x12\x19\n" +
+	"\bbatch_id\x18\x03 \x01(\tR\abatchIdB\x1dZ\x1bdevicetransport/telemetrypbb\x06proto3"
+
+var (
+	file_telemetry_proto_rawDescOnce sync.Once
+	file_telemetry_proto_rawDescData []byte
+)
+
+func file_telemetry_proto_rawDescGZIP() []byte {
+	file_telemetry_proto_rawDescOnce.Do(func() {
+		file_telemetry_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_telemetry_proto_rawDesc), len(file_telemetry_proto_rawDesc)))
+	})
+	return file_telemetry_proto_rawDescData
+}
+
+var file_telemetry_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_telemetry_proto_goTypes = []any{
+	(*GeoPosition)(nil),           // 0: telemetry.v1.GeoPosition
+	(*ExternalSensors)(nil),       // 1: telemetry.v1.ExternalSensors
+	(*Metrics)(nil),               // 2: telemetry.v1.Metrics
+	(*MetricsBatch)(nil),          // 3: telemetry.v1.MetricsBatch
+	(*LogEntry)(nil),              // 4: telemetry.v1.LogEntry
+	(*IncomingLogBatch)(nil),      // 5: telemetry.v1.IncomingLogBatch
+	nil,                           // 6: telemetry.v1.Metrics.LabelsEntry
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+}
+var file_telemetry_proto_depIdxs = []int32{
+	0, // 0: telemetry.v1.Metrics.geo_position:type_name -> telemetry.v1.GeoPosition
+	7, // 1: telemetry.v1.Metrics.timestamp:type_name -> google.protobuf.Timestamp
+	1, // 2: telemetry.v1.Metrics.external_sensors:type_name -> telemetry.v1.ExternalSensors
+	6, // 3: telemetry.v1.Metrics.labels:type_name -> telemetry.v1.Metrics.LabelsEntry
+	2, // 4: telemetry.v1.MetricsBatch.metrics:type_name -> telemetry.v1.Metrics
+	4, // 5: telemetry.v1.IncomingLogBatch.logs:type_name -> telemetry.v1.LogEntry
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_telemetry_proto_init() }
+func file_telemetry_proto_init() {
+	if File_telemetry_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_telemetry_proto_rawDesc), len(file_telemetry_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_telemetry_proto_goTypes,
+		DependencyIndexes: file_telemetry_proto_depIdxs,
+		MessageInfos:      file_telemetry_proto_msgTypes,
+	}.Build()
+	File_telemetry_proto = out.File
+	file_telemetry_proto_goTypes = nil
+	file_telemetry_proto_depIdxs = nil
+}
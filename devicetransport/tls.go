@@ -0,0 +1,80 @@
+package devicetransport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TLSConfig configures HTTPS and mutual TLS authentication of the devices. A device
+// with <device_id>.crt and <device_id>.key in CertDir presents its own certificate,
+// the others the shared CertFile/KeyFile, if any.
+type TLSConfig struct {
+	CAFile             string `json:"ca_file"` // PEM bundle trusted for the server, system roots when empty
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	CertDir            string `json:"cert_dir"`
+	ServerName         string `json:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // Only for test servers with self-signed certificates
+}
+
+// enabled reports whether any TLS setting was configured
+func (c TLSConfig) enabled() bool {
+	return c != TLSConfig{}
+}
+
+// baseTLSConfig builds the TLS config shared by all devices, with the shared client certificate
+func (c TLSConfig) baseTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle %s: %w", c.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("cert_file and key_file must be set together")
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", c.CertFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// deviceCertificate loads the certificate of a device from CertDir; ok is false when
+// the device has none and uses the shared one
+func (c TLSConfig) deviceCertificate(deviceID string) (cert tls.Certificate, ok bool, err error) {
+	if c.CertDir == "" {
+		return tls.Certificate{}, false, nil
+	}
+	certFile := filepath.Join(c.CertDir, deviceID+".crt")
+	keyFile := filepath.Join(c.CertDir, deviceID+".key")
+	if _, err := os.Stat(certFile); errors.Is(err, fs.ErrNotExist) {
+		return tls.Certificate{}, false, nil
+	}
+
+	cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, false, fmt.Errorf("failed to load certificate of device %s: %w", deviceID, err)
+	}
+	return cert, true, nil
+}
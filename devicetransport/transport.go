@@ -0,0 +1,111 @@
+// Package devicetransport sends the telemetry of simulated devices to a server.
+// The HTTP and CoAP clients share it, so a new sensor or event only changes the
+// payload the client builds, never how it is delivered.
+package devicetransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Protocols a Transport can be created for
+const (
+	ProtocolHTTP = "http"
+	ProtocolCoAP = "coap"
+)
+
+// LogEntryCompact is a log event as sent by the devices: event ID and unix timestamp
+type LogEntryCompact [2]int64
+
+// SpanLink is the trace and span, hex encoded as in traceparent, that were active on
+// the device when a log event happened; empty when there were none
+type SpanLink struct {
+	TraceID string `cbor:"trace_id" json:"trace_id"`
+	SpanID  string `cbor:"span_id" json:"span_id"`
+}
+
+// ErrBatchNotSupported is returned by transports that send every metric on its own
+var ErrBatchNotSupported = errors.New("metric batches are not supported over CoAP")
+
+// Transport delivers the CBOR encoded metrics and log batches of any device
+type Transport interface {
+	// SendMetrics sends one metrics payload of a device
+	SendMetrics(ctx context.Context, deviceID string, metrics interface{}) error
+	// SendMetricBatch sends several metrics payloads in one request; deviceID is the
+	// device all of them belong to, empty when they come from several devices
+	SendMetricBatch(ctx context.Context, deviceID string, metrics []interface{}) error
+	// SendLogBatch sends a batch of log events of a device; links, when not nil, has
+	// the span of each entry at the same index
+	SendLogBatch(ctx context.Context, deviceID string, entries []LogEntryCompact, links []SpanLink) error
+	// Close releases the connections of the transport
+	Close() error
+}
+
+// Config selects the protocol and the endpoints; URLs are http(s)://host/path
+// for HTTP and coap://host:port/path for CoAP
+type Config struct {
+	Protocol  string        `json:"protocol"`
+	MetricURL string        `json:"metric_url"`
+	LogURL    string        `json:"log_url"`
+	Timeout   time.Duration `json:"timeout"`
+
+	// MetricBatchURL receives the metric batches, only sent over HTTP
+	MetricBatchURL string `json:"metric_batch_url"`
+
+	// MaxIdleConns is the number of HTTP connections kept open for reuse
+	MaxIdleConns int `json:"max_idle_conns"`
+
+	// Compression ("gzip" or "zstd") applies to HTTP payloads of at least
+	// CompressionThreshold bytes; CoAP payloads are always sent as they are
+	Compression          string `json:"compression"`
+	CompressionThreshold int    `json:"compression_threshold"`
+
+	// Encoding of the payloads, "cbor", "protobuf" or "json"; CoAP only sends CBOR
+	Encoding string `json:"encoding"`
+
+	// BlockSize is the size of the Block1 blocks CoAP payloads larger than a block are sent
+	// in, a power of two from 16 to 1024 (default)
+	BlockSize int `json:"block_size"`
+
+	// TLS configures the CA bundle and the client certificates of HTTPS
+	TLS TLSConfig `json:"tls"`
+
+	// Auth sets an API key or an OAuth2 bearer token on every HTTP request
+	Auth AuthConfig `json:"auth"`
+
+	// OnCommands receives the commands the server returns to a device with the response
+	// of its HTTP requests, nil ignores them
+	OnCommands CommandHandler `json:"-"`
+}
+
+// New creates the transport of the configured protocol, HTTP by default
+func New(cfg Config, tracer trace.Tracer) (Transport, error) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+
+	switch cfg.Protocol {
+	case "", ProtocolHTTP:
+		return newHTTPTransport(cfg, tracer)
+	case ProtocolCoAP:
+		if cfg.Encoding != "" && cfg.Encoding != EncodingCBOR {
+			return nil, fmt.Errorf("encoding %q is not supported over CoAP", cfg.Encoding)
+		}
+		if cfg.TLS.enabled() {
+			return nil, fmt.Errorf("TLS is not supported over CoAP")
+		}
+		if cfg.Auth.enabled() {
+			return nil, fmt.Errorf("auth is not supported over CoAP")
+		}
+		if cfg.BlockSize == 0 {
+			cfg.BlockSize = 1024
+		}
+		return newCoAPTransport(cfg, tracer)
+	default:
+		return nil, fmt.Errorf("unknown protocol %q, must be %q or %q", cfg.Protocol, ProtocolHTTP, ProtocolCoAP)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// Authentication modes of the Google clients
+const (
+	authCredentialsFile = "credentials_file"
+	authADC             = "adc"
+)
+
+// cloudPlatformScope covers BigQuery and the Storage Read API for impersonated tokens
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// AuthConfig selects how the service authenticates to Google Cloud. "credentials_file"
+// reads a service account JSON key; "adc" uses Application Default Credentials, i.e.
+// GOOGLE_APPLICATION_CREDENTIALS, gcloud user credentials or the metadata server of
+// GKE Workload Identity and Cloud Run. ImpersonateServiceAccount then exchanges those
+// credentials for tokens of another service account, through Delegates if set.
+type AuthConfig struct {
+	Mode                      string   `json:"mode,omitempty"`
+	CredentialsFile           string   `json:"credentials_file,omitempty"`
+	ImpersonateServiceAccount string   `json:"impersonate_service_account,omitempty"`
+	Delegates                 []string `json:"delegates,omitempty"`
+}
+
+// validate checks the mode and fills the default one: a configured key file, otherwise ADC
+func (a *AuthConfig) validate() error {
+	switch a.Mode {
+	case "":
+		a.Mode = authADC
+		if a.CredentialsFile != "" {
+			a.Mode = authCredentialsFile
+		}
+	case authCredentialsFile:
+		if a.CredentialsFile == "" {
+			return fmt.Errorf("auth mode %s needs credentials_file", authCredentialsFile)
+		}
+	case authADC:
+	default:
+		return fmt.Errorf("unknown auth mode %q, must be %q or %q", a.Mode, authCredentialsFile, authADC)
+	}
+	if len(a.Delegates) > 0 && a.ImpersonateServiceAccount == "" {
+		return fmt.Errorf("auth delegates need impersonate_service_account")
+	}
+	return nil
+}
+
+// clientOptions returns the options that authenticate the Google clients
+func (a AuthConfig) clientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	var opts []option.ClientOption
+	if a.Mode == authCredentialsFile {
+		opts = append(opts, option.WithCredentialsFile(a.CredentialsFile))
+	}
+	if a.ImpersonateServiceAccount == "" {
+		return opts, nil
+	}
+
+	// the base credentials only sign the token requests of the impersonated account
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: a.ImpersonateServiceAccount,
+		Scopes:          []string{cloudPlatformScope},
+		Delegates:       a.Delegates,
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate %s: %v", a.ImpersonateServiceAccount, err)
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BackfillOptions describes a one-shot sync of historical data
+type BackfillOptions struct {
+	From     time.Time
+	To       time.Time
+	Chunk    time.Duration
+	Pipeline string // empty means every pipeline
+}
+
+// parseBackfillOptions validates the backfill flags
+func parseBackfillOptions(from, to string, chunk time.Duration, pipeline string) (BackfillOptions, error) {
+	opts := BackfillOptions{Chunk: chunk, Pipeline: pipeline, To: time.Now().UTC()}
+
+	var err error
+	if opts.From, err = parseTimeFlag(from); err != nil {
+		return opts, fmt.Errorf("invalid --from: %v", err)
+	}
+	if to != "" {
+		if opts.To, err = parseTimeFlag(to); err != nil {
+			return opts, fmt.Errorf("invalid --to: %v", err)
+		}
+	}
+	if !opts.From.Before(opts.To) {
+		return opts, fmt.Errorf("--from %v must be before --to %v", opts.From, opts.To)
+	}
+	if opts.Chunk <= 0 {
+		return opts, fmt.Errorf("--chunk must be positive")
+	}
+	return opts, nil
+}
+
+// parseTimeFlag accepts RFC3339 timestamps or plain dates
+func parseTimeFlag(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// backfillCheckpointFile is where a pipeline records the last completed chunk of a backfill range
+func backfillCheckpointFile(p *Pipeline, opts BackfillOptions) string {
+	return fmt.Sprintf("sync-backfill-%s-%d-%d.json", p.Config.Name, opts.From.Unix(), opts.To.Unix())
+}
+
+// Backfill pages through [From, To) in chunks for the selected pipelines,
+// independently of the periodic loop and its checkpoints
+func (s *SyncService) Backfill(ctx context.Context, opts BackfillOptions) error {
+	matched := false
+	for _, p := range s.pipelines {
+		if opts.Pipeline != "" && p.Config.Name != opts.Pipeline {
+			continue
+		}
+		matched = true
+		if err := s.backfillPipeline(ctx, p, opts); err != nil {
+			return fmt.Errorf("pipeline %q: %v", p.Config.Name, err)
+		}
+	}
+	if !matched {
+		return fmt.Errorf("no pipeline named %q", opts.Pipeline)
+	}
+	return nil
+}
+
+// backfillPipeline indexes one chunk at a time and checkpoints after each, so a
+// restarted backfill with the same range resumes from the last completed chunk
+func (s *SyncService) backfillPipeline(ctx context.Context, p *Pipeline, opts BackfillOptions) error {
+	name := p.Config.Name
+	checkpointFile := backfillCheckpointFile(p, opts)
+
+	start := opts.From
+	saved, ok, err := loadCheckpoint(checkpointFile)
+	if err != nil {
+		return err
+	}
+	if ok && saved.After(start) {
+		start = saved
+		backfillLog.Info("resuming backfill", "pipeline", name, "from", start, "checkpoint", checkpointFile)
+	}
+	if !start.Before(opts.To) {
+		backfillLog.Info("backfill already completed", "pipeline", name, "from", opts.From, "to", opts.To)
+		return nil
+	}
+
+	if err := s.createIndexTemplate(ctx, p); err != nil {
+		backfillLog.Warn("failed to create index template", "pipeline", name, "error", err)
+	}
+
+	total := opts.To.Sub(opts.From)
+	var (
+		fetched, failed int
+		day, target     string
+	)
+	for chunkStart := start; chunkStart.Before(opts.To); {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		chunkEnd := backfillChunkEnd(p, chunkStart, opts)
+		if d := chunkStart.UTC().Format(time.DateOnly); d != day {
+			if target, err = s.backfillTarget(ctx, p, chunkStart); err != nil {
+				return fmt.Errorf("failed to resolve backfill index: %v", err)
+			}
+			day = d
+		}
+
+		res, err := s.syncRange(ctx, p, chunkStart, chunkEnd, target, 0)
+		if err != nil {
+			return fmt.Errorf("failed to sync chunk %v - %v: %v", chunkStart, chunkEnd, err)
+		}
+
+		if err := saveCheckpoint(checkpointFile, chunkEnd); err != nil {
+			backfillLog.Warn("failed to save backfill checkpoint", "pipeline", name, "error", err)
+		}
+
+		fetched += res.fetched
+		failed += res.failed
+		progress := float64(chunkEnd.Sub(opts.From)) / float64(total) * 100
+		backfillLog.Info("backfill chunk completed", "pipeline", name, "progress_percent", progress,
+			"chunk_start", chunkStart.Format(time.RFC3339), "chunk_end", chunkEnd.Format(time.RFC3339),
+			"rows", res.fetched, "failed", res.failed)
+		chunkStart = chunkEnd
+	}
+
+	backfillLog.Info("backfill completed", "pipeline", name, "rows", fetched, "failed", failed)
+	return nil
+}
+
+// backfillChunkEnd returns the end of the chunk starting at chunkStart. With rollover
+// a chunk never crosses midnight, so all of its rows belong to one daily index.
+func backfillChunkEnd(p *Pipeline, chunkStart time.Time, opts BackfillOptions) time.Time {
+	chunkEnd := chunkStart.Add(opts.Chunk)
+	if p.Config.Rollover {
+		day := chunkStart.UTC().Truncate(24 * time.Hour)
+		if midnight := day.AddDate(0, 0, 1); chunkEnd.After(midnight) {
+			chunkEnd = midnight
+		}
+	}
+	if chunkEnd.After(opts.To) {
+		chunkEnd = opts.To
+	}
+	return chunkEnd
+}
+
+// backfillTarget returns the index the rows of day are backfilled into. A rolling
+// pipeline writes them into the daily index of their day, not the one the write alias
+// points to today, so retention drops them with their day and the periodic sync and
+// the backfill index a row into the same daily index; a copy a reindex made of the
+// daily index is used instead of it.
+func (s *SyncService) backfillTarget(ctx context.Context, p *Pipeline, day time.Time) (string, error) {
+	if !p.Config.Rollover {
+		return s.writeTarget(ctx, p)
+	}
+
+	daily := dailyIndexName(p.Config.Index, day)
+	indices, err := s.listIndices(ctx, daily+"*")
+	if err != nil {
+		return "", err
+	}
+	var reindexed string
+	for _, index := range indices {
+		// the stamps sort in time order, the newest copy is the one in the read alias
+		if strings.HasPrefix(index, daily+reindexSuffix) && index > reindexed {
+			reindexed = index
+		}
+	}
+	if reindexed != "" {
+		return reindexed, nil
+	}
+
+	if err := s.createIndexIfMissing(ctx, daily); err != nil {
+		return "", err
+	}
+	if err := s.addReadAlias(ctx, p, daily); err != nil {
+		return "", err
+	}
+	return daily, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCluster answers the index and alias requests of a backfill from a set of indices
+type fakeCluster struct {
+	indices  map[string]bool
+	requests []string
+}
+
+func (c *fakeCluster) Perform(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req.Method+" "+req.URL.Path)
+	body := "{}"
+	switch {
+	case req.Method == http.MethodGet && strings.HasPrefix(req.URL.Path, "/_cat/indices/"):
+		prefix := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/_cat/indices/"), "*")
+		var rows []map[string]string
+		for index := range c.indices {
+			if strings.HasPrefix(index, prefix) {
+				rows = append(rows, map[string]string{"index": index})
+			}
+		}
+		data, _ := json.Marshal(rows)
+		body = string(data)
+	case req.Method == http.MethodPut:
+		c.indices[strings.TrimPrefix(req.URL.Path, "/")] = true
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+// TestBackfillRolloverDailyIndices checks a rolling pipeline backfills each day into
+// its own daily index, or the copy a reindex made of it, and never into the write alias
+func TestBackfillRolloverDailyIndices(t *testing.T) {
+	p := &Pipeline{Config: PipelineConfig{
+		Name: "logs", Index: "gcp-logs", Rollover: true,
+		WriteAlias: "gcp-logs-write", ReadAlias: "gcp-logs-read",
+	}}
+	opts := BackfillOptions{
+		From:  time.Date(2025, 7, 1, 20, 0, 0, 0, time.UTC),
+		To:    time.Date(2025, 7, 2, 9, 0, 0, 0, time.UTC),
+		Chunk: 6 * time.Hour,
+	}
+
+	var chunks []string
+	for start := opts.From; start.Before(opts.To); {
+		end := backfillChunkEnd(p, start, opts)
+		chunks = append(chunks, start.Format("02T15")+"-"+end.Format("02T15"))
+		start = end
+	}
+	if got, want := strings.Join(chunks, " "), "01T20-02T00 02T00-02T06 02T06-02T09"; got != want {
+		t.Fatalf("chunks %s, want %s", got, want)
+	}
+
+	cluster := &fakeCluster{indices: map[string]bool{
+		"gcp-logs-2025.07.01":                        true,
+		"gcp-logs-2025.07.01-reindex-20250801000000": true,
+		"gcp-logs-2025.07.01-reindex-20250901000000": true,
+	}}
+	s := &SyncService{osClient: cluster}
+	ctx := context.Background()
+
+	target, err := s.backfillTarget(ctx, p, opts.From)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "gcp-logs-2025.07.01-reindex-20250901000000" {
+		t.Fatalf("July 1st backfilled into %s, want its newest reindexed copy", target)
+	}
+
+	cluster.requests = nil
+	target, err = s.backfillTarget(ctx, p, opts.To)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "gcp-logs-2025.07.02" {
+		t.Fatalf("July 2nd backfilled into %s, want gcp-logs-2025.07.02", target)
+	}
+	if !cluster.indices["gcp-logs-2025.07.02"] {
+		t.Fatalf("gcp-logs-2025.07.02 not created, requests %v", cluster.requests)
+	}
+	if got := strings.Join(cluster.requests, ", "); !strings.Contains(got, "POST /_aliases") {
+		t.Fatalf("gcp-logs-2025.07.02 not added to the read alias, requests %s", got)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// bulkResponse is the subset of the OpenSearch _bulk response needed to detect per-item failures
+type bulkResponse struct {
+	Errors bool                  `json:"errors"`
+	Items  []map[string]bulkItem `json:"items"`
+}
+
+// bulkItem is the result of a single action inside a bulk request
+type bulkItem struct {
+	Index  string         `json:"_index"`
+	ID     string         `json:"_id"`
+	Status int            `json:"status"`
+	Error  *bulkItemError `json:"error,omitempty"`
+}
+
+// bulkItemError describes why OpenSearch rejected a document
+type bulkItemError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// Bulk write modes. Documents use their documentID (insertId for logs) as _id so replays are deduplicated:
+// "index" overwrites an existing copy, "create" keeps the first copy and reports
+// the replay as a conflict, "upsert" merges the new fields into the stored copy.
+const (
+	writeModeIndex  = "index"
+	writeModeCreate = "create"
+	writeModeUpsert = "upsert"
+)
+
+// errBulkThrottled is returned when the cluster rejects a whole bulk request with 429
+var errBulkThrottled = errors.New("bulk request throttled by the cluster (429)")
+
+// defaultCompressMinBytes is the smallest bulk body worth gzipping when compression is enabled
+const defaultCompressMinBytes = 64 << 10
+
+// isWriteBlocked reports whether an item was rejected by an index write block, set
+// while a reindex swaps the index out or when the cluster runs out of disk
+func isWriteBlocked(item bulkItem) bool {
+	return item.Status == http.StatusForbidden && item.Error != nil && item.Error.Type == "cluster_block_exception"
+}
+
+// isRetryableStatus reports whether an item status is transient and worth resending
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// buildBulkBody encodes logs as NDJSON actions for the _bulk API using the given
+// write mode, with the documents reshaped by the pipeline transforms
+func buildBulkBody(indexName, mode string, transforms fieldTransforms, docs []syncDocument) (string, error) {
+	var bulkBody strings.Builder
+
+	for _, entry := range docs {
+		// action metadata, keyed by insertId when available
+		meta := map[string]interface{}{
+			"_index": indexName,
+		}
+		id := entry.documentID()
+		if id != "" {
+			meta["_id"] = id
+		}
+
+		source, err := transforms.document(entry)
+		if err != nil {
+			return "", fmt.Errorf("failed to transform log entry: %v", err)
+		}
+
+		action := writeModeIndex
+		doc := source
+		switch {
+		case mode == writeModeCreate:
+			action = "create"
+		case mode == writeModeUpsert && id != "":
+			// update needs an _id, entries without one fall back to a plain index
+			action = "update"
+			doc = map[string]interface{}{
+				"doc":           source,
+				"doc_as_upsert": true,
+			}
+		}
+
+		indexOpJSON, err := json.Marshal(map[string]interface{}{action: meta})
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal index operation: %v", err)
+		}
+
+		bulkBody.WriteString(string(indexOpJSON))
+		bulkBody.WriteString("\n")
+
+		// doc data
+		docJSON, err := json.Marshal(doc)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal log entry: %v", err)
+		}
+
+		bulkBody.WriteString(string(docJSON))
+		bulkBody.WriteString("\n")
+	}
+
+	return bulkBody.String(), nil
+}
+
+// executeBulk sends one bulk request and returns the per-item results in request order
+func (s *SyncService) executeBulk(ctx context.Context, client opensearchapi.Transport, p *Pipeline, indexName string, logs []syncDocument) ([]bulkItem, error) {
+	body, err := buildBulkBody(indexName, p.Config.WriteMode, p.transforms, logs)
+	if err != nil {
+		return nil, err
+	}
+
+	// batch insert
+	req := opensearchapi.BulkRequest{
+		Body: strings.NewReader(body),
+	}
+	if s.config.OpenSearch.CompressBulk && len(body) >= s.config.OpenSearch.CompressMinBytes {
+		compressed, err := gzipBody(body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = compressed
+		req.Header = http.Header{"Content-Encoding": []string{"gzip"}}
+	}
+
+	res, err := req.Do(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute bulk request: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		return nil, errBulkThrottled
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("bulk request failed with status: %s", res.Status())
+	}
+
+	var parsed bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode bulk response: %v", err)
+	}
+	if len(parsed.Items) != len(logs) {
+		return nil, fmt.Errorf("bulk response has %d items, expected %d", len(parsed.Items), len(logs))
+	}
+
+	// each item is keyed by its action name ("index", "create", ...)
+	items := make([]bulkItem, len(parsed.Items))
+	for i, entry := range parsed.Items {
+		for _, item := range entry {
+			items[i] = item
+		}
+	}
+	return items, nil
+}
+
+// gzipBody compresses a bulk body for sending with Content-Encoding: gzip
+func gzipBody(body string) (io.Reader, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, body); err != nil {
+		return nil, fmt.Errorf("failed to compress bulk body: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress bulk body: %v", err)
+	}
+	return &buf, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Checkpoint is the sync watermark persisted between restarts
+type Checkpoint struct {
+	LastSync  time.Time `json:"last_sync"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// loadCheckpoint reads the last persisted watermark, ok is false when no checkpoint exists yet
+func loadCheckpoint(path string) (lastSync time.Time, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read checkpoint %s: %v", path, err)
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to parse checkpoint %s: %v", path, err)
+	}
+	if cp.LastSync.IsZero() {
+		return time.Time{}, false, nil
+	}
+
+	return cp.LastSync, true, nil
+}
+
+// saveCheckpoint writes the watermark to a temp file and renames it over path,
+// so a crash mid-write never leaves a truncated checkpoint behind
+func saveCheckpoint(path string, lastSync time.Time) error {
+	data, err := json.MarshalIndent(Checkpoint{
+		LastSync:  lastSync,
+		UpdatedAt: time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to flush checkpoint: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close checkpoint: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `Usage: bigqueryOpensearchSync <command> [flags]
+
+Commands:
+  run            sync all pipelines periodically (default)
+  backfill       index a historical time range in chunks, then exit
+  verify         compare BigQuery and OpenSearch counts per hour and device
+  init-template  create the index templates of all pipelines, then exit
+  reindex        copy the indices of a pipeline into a new index with the current template and swap its aliases
+
+Run "bigqueryOpensearchSync <command> -h" for the flags of a command.
+`
+
+// commonFlags override config values for every command
+type commonFlags struct {
+	configFile string
+	project    string
+	openSearch string
+	index      string
+	interval   time.Duration
+	httpAddr   string
+	logLevel   string
+}
+
+// addCommonFlags registers the config override flags on a command flag set
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	cf := &commonFlags{}
+	fs.StringVar(&cf.configFile, "config", os.Getenv("CONFIG_FILE"), "JSON config file (default $CONFIG_FILE)")
+	fs.StringVar(&cf.project, "project", "", "override BigQuery project ID")
+	fs.StringVar(&cf.openSearch, "opensearch", "", "override OpenSearch URLs, comma separated")
+	fs.StringVar(&cf.index, "index", "", "override the OpenSearch index of the default pipeline")
+	fs.DurationVar(&cf.interval, "interval", 0, "override the default sync interval")
+	fs.StringVar(&cf.httpAddr, "http-addr", "", "override the HTTP listen address (metrics, Pub/Sub push)")
+	fs.StringVar(&cf.logLevel, "log-level", "", "override the log level of every component (debug, info, warning, error)")
+	return cf
+}
+
+// loadConfig builds the config from defaults, the config file and the flag overrides
+func (cf *commonFlags) loadConfig() (*Config, error) {
+	config := defaultConfig()
+
+	// Try to load configuration (e.g. extra pipelines) from file if it exists
+	if cf.configFile != "" {
+		data, err := os.ReadFile(cf.configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %v", cf.configFile, err)
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %v", cf.configFile, err)
+		}
+	}
+
+	if cf.logLevel != "" {
+		config.LogLevel = cf.logLevel
+	}
+	if err := setLogLevels(config.LogLevel, config.LogLevels); err != nil {
+		return nil, fmt.Errorf("invalid log level: %v", err)
+	}
+	if cf.configFile != "" {
+		syncLog.Info("configuration loaded", "file", cf.configFile)
+	}
+
+	if cf.project != "" {
+		config.BigQuery.ProjectID = cf.project
+	}
+	if cf.openSearch != "" {
+		config.OpenSearch.URLs = strings.Split(cf.openSearch, ",")
+	}
+	if cf.index != "" {
+		config.OpenSearch.Index = cf.index
+	}
+	if cf.interval > 0 {
+		config.SyncInterval = cf.interval
+	}
+	if cf.httpAddr != "" {
+		config.HTTPAddr = cf.httpAddr
+	}
+	return config, nil
+}
+
+// runCLI dispatches the subcommand; without one the periodic sync runs as before
+func runCLI(ctx context.Context, args []string) error {
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fmt.Fprintf(fs.Output(), "\nFlags of %s:\n", command)
+		fs.PrintDefaults()
+	}
+	cf := addCommonFlags(fs)
+
+	switch command {
+	case "run":
+		fs.Parse(args)
+		return withService(cf, func(service *SyncService) error {
+			syncLog.Info("starting BigQuery to OpenSearch sync service")
+			return service.Start(ctx)
+		})
+
+	case "backfill":
+		from := fs.String("from", "", "backfill start (RFC3339 or YYYY-MM-DD)")
+		to := fs.String("to", "", "backfill end, exclusive (RFC3339 or YYYY-MM-DD), defaults to now")
+		chunk := fs.Duration("chunk", time.Hour, "backfill chunk size")
+		pipeline := fs.String("pipeline", "", "backfill only this pipeline")
+		fs.Parse(args)
+
+		opts, err := parseBackfillOptions(*from, *to, *chunk, *pipeline)
+		if err != nil {
+			return fmt.Errorf("invalid backfill options: %v", err)
+		}
+		return withService(cf, func(service *SyncService) error {
+			return service.Backfill(ctx, opts)
+		})
+
+	case "verify":
+		from := fs.String("from", "", "range start (RFC3339 or YYYY-MM-DD)")
+		to := fs.String("to", "", "range end, exclusive (RFC3339 or YYYY-MM-DD), defaults to now")
+		pipeline := fs.String("pipeline", "", "verify only this pipeline")
+		byDevice := fs.Bool("by-device", true, "bucket counts by device_id as well as by hour")
+		fs.Parse(args)
+
+		opts, err := parseVerifyOptions(*from, *to, *pipeline, *byDevice)
+		if err != nil {
+			return fmt.Errorf("invalid verify options: %v", err)
+		}
+		return withService(cf, func(service *SyncService) error {
+			return service.Verify(ctx, opts)
+		})
+
+	case "reindex":
+		pipeline := fs.String("pipeline", "", "reindex only this pipeline")
+		deleteSources := fs.Bool("delete-sources", false, "delete the old indices once their copies hold all of their documents")
+		fs.Parse(args)
+
+		return withService(cf, func(service *SyncService) error {
+			return service.Reindex(ctx, ReindexOptions{Pipeline: *pipeline, DeleteSources: *deleteSources})
+		})
+
+	case "init-template":
+		fs.Parse(args)
+		return withService(cf, func(service *SyncService) error {
+			return service.InitTemplates(ctx)
+		})
+
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", command)
+	}
+}
+
+// withService loads the config, creates the sync service and runs fn with it
+func withService(cf *commonFlags, fn func(service *SyncService) error) error {
+	config, err := cf.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	syncLog.Info("sync configuration",
+		"project", config.BigQuery.ProjectID,
+		"opensearch", config.OpenSearch.URLs,
+		"sync_interval", config.SyncInterval.String(),
+		"pipelines", max(len(config.Pipelines), 1))
+
+	// create sync service
+	service, err := NewSyncService(config)
+	if err != nil {
+		return fmt.Errorf("failed to create sync service: %v", err)
+	}
+	defer service.Close()
+
+	return fn(service)
+}
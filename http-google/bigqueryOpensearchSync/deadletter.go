@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// DeadLetter records a document OpenSearch permanently refused, with the reason
+type DeadLetter struct {
+	FailedAt  time.Time `json:"failed_at"`
+	Index     string    `json:"index"`
+	InsertID  string    `json:"insertId"`
+	Status    int       `json:"status"`
+	ErrorType string    `json:"error_type"`
+	Reason    string    `json:"reason"`
+	// Document is kept as raw JSON so the DLQ index never hits the same mapping conflict
+	Document string `json:"document"`
+}
+
+// newDeadLetter builds a dead-letter record from a rejected bulk item
+func newDeadLetter(indexName string, entry syncDocument, item bulkItem) DeadLetter {
+	dl := DeadLetter{
+		FailedAt: time.Now().UTC(),
+		Index:    indexName,
+		InsertID: entry.documentID(),
+		Status:   item.Status,
+	}
+	if item.Error != nil {
+		dl.ErrorType = item.Error.Type
+		dl.Reason = item.Error.Reason
+	}
+	if doc, err := json.Marshal(entry); err == nil {
+		dl.Document = string(doc)
+	}
+	return dl
+}
+
+// writeDeadLetters stores rejected documents in every configured dead-letter destination
+func (s *SyncService) writeDeadLetters(ctx context.Context, letters []DeadLetter) error {
+	if len(letters) == 0 {
+		return nil
+	}
+
+	cfg := s.config.DeadLetter
+	if cfg.File == "" && cfg.Index == "" {
+		bulkLog.Warn("no dead-letter destination configured, dropping documents", "documents", len(letters))
+		return nil
+	}
+
+	if cfg.File != "" {
+		if err := appendDeadLetterFile(cfg.File, letters); err != nil {
+			return err
+		}
+		bulkLog.Info("wrote dead letters", "documents", len(letters), "file", cfg.File)
+	}
+
+	if cfg.Index != "" {
+		if err := s.indexDeadLetters(ctx, cfg.Index, letters); err != nil {
+			return err
+		}
+		bulkLog.Info("wrote dead letters", "documents", len(letters), "index", cfg.Index)
+	}
+	return nil
+}
+
+// appendDeadLetterFile appends the records to a local NDJSON file
+func appendDeadLetterFile(path string, letters []DeadLetter) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file %s: %v", path, err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, dl := range letters {
+		if err := enc.Encode(dl); err != nil {
+			return fmt.Errorf("failed to write dead-letter file %s: %v", path, err)
+		}
+	}
+	return f.Sync()
+}
+
+// indexDeadLetters bulk-indexes the records into the dead-letter index
+func (s *SyncService) indexDeadLetters(ctx context.Context, indexName string, letters []DeadLetter) error {
+	var bulkBody strings.Builder
+	for _, dl := range letters {
+		indexOpJSON, err := json.Marshal(map[string]interface{}{
+			"index": map[string]interface{}{
+				"_index": indexName,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal index operation: %v", err)
+		}
+		docJSON, err := json.Marshal(dl)
+		if err != nil {
+			return fmt.Errorf("failed to marshal dead letter: %v", err)
+		}
+		bulkBody.WriteString(string(indexOpJSON))
+		bulkBody.WriteString("\n")
+		bulkBody.WriteString(string(docJSON))
+		bulkBody.WriteString("\n")
+	}
+
+	req := opensearchapi.BulkRequest{
+		Body: strings.NewReader(bulkBody.String()),
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to index dead letters: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("dead-letter bulk request failed with status: %s", res.Status())
+	}
+
+	var parsed bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return fmt.Errorf("failed to decode dead-letter bulk response: %v", err)
+	}
+	if parsed.Errors {
+		return fmt.Errorf("dead-letter index %s rejected some documents", indexName)
+	}
+	return nil
+}
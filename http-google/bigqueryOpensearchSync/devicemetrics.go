@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// metricsQueryTemplate pivots the exported custom metrics table, one row per
+// metric point (metric_type, device_id, value, timestamp and the device position),
+// into one row per device and timestamp. Must filter on {{.Since}}.
+const metricsQueryTemplate = `
+		SELECT
+		  device_id,
+		  timestamp,
+		  ANY_VALUE(latitude) AS latitude,
+		  ANY_VALUE(longitude) AS longitude,
+		  ANY_VALUE(altitude) AS altitude,
+		  MAX(IF(metric_type = 'custom.googleapis.com/mcu_percent', value, NULL)) AS mcu_percent,
+		  MAX(IF(metric_type = 'custom.googleapis.com/mcu_temp_celsius', value, NULL)) AS mcu_temp_celsius,
+		  MAX(IF(metric_type = 'custom.googleapis.com/external_thermometer_celsius', value, NULL)) AS external_thermometer_celsius,
+		  MAX(IF(metric_type = 'custom.googleapis.com/barometer_hpa', value, NULL)) AS barometer_hpa,
+		  MAX(IF(metric_type = 'custom.googleapis.com/hygrometer_rh', value, NULL)) AS hygrometer_rh,
+		  MAX(IF(metric_type = 'custom.googleapis.com/anemometer_mps', value, NULL)) AS anemometer_mps,
+		  MAX(IF(metric_type = 'custom.googleapis.com/battery_percent', value, NULL)) AS battery_percent,
+		  MAX(IF(metric_type = 'custom.googleapis.com/rssi_dbm', value, NULL)) AS rssi_dbm,
+		  MAX(IF(metric_type = 'custom.googleapis.com/pm25_ugm3', value, NULL)) AS pm25_ugm3,
+		  MAX(IF(metric_type = 'custom.googleapis.com/co2_ppm', value, NULL)) AS co2_ppm
+		FROM ` + "`{{.ProjectID}}.{{.Dataset}}.{{.Table}}`" + `
+		WHERE timestamp >= {{.Since}}
+		GROUP BY device_id, timestamp
+		ORDER BY timestamp ASC
+	`
+
+// MetricEntry is one device telemetry sample; metrics missing from a sample stay null
+type MetricEntry struct {
+	DeviceID                   string               `bigquery:"device_id" json:"device_id"`
+	Timestamp                  time.Time            `bigquery:"timestamp" json:"timestamp"`
+	Latitude                   bigquery.NullFloat64 `bigquery:"latitude" json:"-"`
+	Longitude                  bigquery.NullFloat64 `bigquery:"longitude" json:"-"`
+	Altitude                   bigquery.NullFloat64 `bigquery:"altitude" json:"altitude"`
+	MCUPercent                 bigquery.NullFloat64 `bigquery:"mcu_percent" json:"mcu_percent"`
+	MCUTempCelsius             bigquery.NullFloat64 `bigquery:"mcu_temp_celsius" json:"mcu_temp_celsius"`
+	ExternalThermometerCelsius bigquery.NullFloat64 `bigquery:"external_thermometer_celsius" json:"external_thermometer_celsius"`
+	BarometerHPa               bigquery.NullFloat64 `bigquery:"barometer_hpa" json:"barometer_hpa"`
+	HygrometerRH               bigquery.NullFloat64 `bigquery:"hygrometer_rh" json:"hygrometer_rh"`
+	AnemometerMPS              bigquery.NullFloat64 `bigquery:"anemometer_mps" json:"anemometer_mps"`
+	BatteryPercent             bigquery.NullFloat64 `bigquery:"battery_percent" json:"battery_percent"`
+	RSSIDBm                    bigquery.NullFloat64 `bigquery:"rssi_dbm" json:"rssi_dbm"`
+	PM25UGM3                   bigquery.NullFloat64 `bigquery:"pm25_ugm3" json:"pm25_ugm3"`
+	CO2PPM                     bigquery.NullFloat64 `bigquery:"co2_ppm" json:"co2_ppm"`
+}
+
+// geoPoint is the OpenSearch geo_point object form
+type geoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// MarshalJSON indexes latitude and longitude as one geo_point location field
+func (e *MetricEntry) MarshalJSON() ([]byte, error) {
+	type entry MetricEntry // without methods, so this does not recurse
+	doc := struct {
+		*entry
+		Location *geoPoint `json:"location,omitempty"`
+	}{entry: (*entry)(e)}
+	if e.Latitude.Valid && e.Longitude.Valid {
+		doc.Location = &geoPoint{Lat: e.Latitude.Float64, Lon: e.Longitude.Float64}
+	}
+	return json.Marshal(doc)
+}
+
+// documentID keys samples by device and time so replays overwrite the same document
+func (e *MetricEntry) documentID() string {
+	return fmt.Sprintf("%s-%d", e.DeviceID, e.Timestamp.UnixNano())
+}
+
+func (e *MetricEntry) documentTime() time.Time     { return e.Timestamp }
+func (e *MetricEntry) documentSeverity() string    { return "" }
+func (e *MetricEntry) documentReceived() time.Time { return time.Time{} }
+
+// metricMappingProperties returns the mapping of the MetricEntry fields
+func metricMappingProperties() map[string]interface{} {
+	properties := map[string]interface{}{
+		"device_id": map[string]interface{}{
+			"type": "keyword",
+		},
+		"timestamp": map[string]interface{}{
+			"type": "date",
+		},
+		"location": map[string]interface{}{
+			"type": "geo_point",
+		},
+	}
+	for _, field := range []string{
+		"altitude", "mcu_percent", "mcu_temp_celsius", "external_thermometer_celsius",
+		"barometer_hpa", "hygrometer_rh", "anemometer_mps",
+		"battery_percent", "rssi_dbm", "pm25_ugm3", "co2_ppm",
+	} {
+		properties[field] = map[string]interface{}{
+			"type": "float",
+		}
+	}
+	return properties
+}
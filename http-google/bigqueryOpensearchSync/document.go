@@ -0,0 +1,49 @@
+package main
+
+import (
+	"time"
+)
+
+// Pipeline kinds, selecting the row struct, default query and static mapping
+const (
+	kindLogs    = "logs"
+	kindMetrics = "metrics"
+)
+
+// syncDocument is a BigQuery row indexed into OpenSearch
+type syncDocument interface {
+	// documentID is the _id that deduplicates replays, empty lets OpenSearch assign one
+	documentID() string
+	// documentTime is the event time driving watermarks and daily routing
+	documentTime() time.Time
+	// documentSeverity is matched against severity routes, empty for rows without one
+	documentSeverity() string
+	// documentReceived is when the row was ingested, zero for tables without one
+	documentReceived() time.Time
+}
+
+// documentKind describes how the rows of one kind of table are read and mapped
+type documentKind struct {
+	query   string
+	newRow  func() syncDocument
+	mapping func() map[string]interface{}
+}
+
+// documentKinds lists the supported pipeline kinds
+var documentKinds = map[string]documentKind{
+	kindLogs: {
+		query:   defaultQueryTemplate,
+		newRow:  func() syncDocument { return &LogEntry{} },
+		mapping: staticMappingProperties,
+	},
+	kindMetrics: {
+		query:   metricsQueryTemplate,
+		newRow:  func() syncDocument { return &MetricEntry{} },
+		mapping: metricMappingProperties,
+	},
+}
+
+func (e *LogEntry) documentID() string          { return e.InsertID }
+func (e *LogEntry) documentTime() time.Time     { return e.Timestamp }
+func (e *LogEntry) documentSeverity() string    { return e.Severity }
+func (e *LogEntry) documentReceived() time.Time { return e.ReceiveTimestamp }
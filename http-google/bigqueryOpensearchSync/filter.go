@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// filterFields maps the filterable fields to the query columns they are pushed down to
+var filterFields = map[string]func(e *LogEntry) string{
+	"severity":         func(e *LogEntry) string { return e.Severity },
+	"device_id":        func(e *LogEntry) string { return e.DeviceID },
+	"jsonPayload_type": func(e *LogEntry) string { return e.JSONPayloadType },
+	"service_name":     func(e *LogEntry) string { return e.ServiceName },
+}
+
+// FilterConfig selects the logs a pipeline syncs. Include keeps only rows whose
+// field is one of the listed values, Exclude drops them; both are keyed by
+// severity, device_id, jsonPayload_type or service_name
+type FilterConfig struct {
+	Include map[string][]string `json:"include,omitempty"`
+	Exclude map[string][]string `json:"exclude,omitempty"`
+}
+
+// logFilter is the validated filter of a pipeline, usable both as a SQL
+// condition pushed down into BigQuery and on entries pushed by Pub/Sub
+type logFilter struct {
+	include map[string][]string
+	exclude map[string][]string
+}
+
+// newLogFilter validates the configured filter fields
+func newLogFilter(cfg FilterConfig) (*logFilter, error) {
+	for _, fields := range []map[string][]string{cfg.Include, cfg.Exclude} {
+		for field := range fields {
+			if _, ok := filterFields[field]; !ok {
+				return nil, fmt.Errorf("unknown filter field %q", field)
+			}
+		}
+	}
+	return &logFilter{include: cfg.Include, exclude: cfg.Exclude}, nil
+}
+
+// validateFilter checks that every filtered field is one of filterFields and a column of
+// the row struct of the pipeline kind, so a metrics pipeline can't filter on severity
+func validateFilter(cfg FilterConfig, kind documentKind) error {
+	known := rowFields(kind.newRow())
+	for _, fields := range []map[string][]string{cfg.Include, cfg.Exclude} {
+		for field := range fields {
+			if _, ok := filterFields[field]; !ok {
+				return fmt.Errorf("unknown filter field %q", field)
+			}
+			if !known[field] {
+				return fmt.Errorf("filter field %q is not a column of this pipeline kind", field)
+			}
+		}
+	}
+	return nil
+}
+
+// empty reports whether the filter keeps every row
+func (f *logFilter) empty() bool {
+	return len(f.include) == 0 && len(f.exclude) == 0
+}
+
+// where returns the SQL condition and its query parameters
+func (f *logFilter) where() (string, []bigquery.QueryParameter) {
+	var (
+		conditions []string
+		params     []bigquery.QueryParameter
+	)
+	add := func(kind string, fields map[string][]string) {
+		// sorted so the generated query is stable across runs
+		names := make([]string, 0, len(fields))
+		for field := range fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+
+		for _, field := range names {
+			param := fmt.Sprintf("%s_%s", kind, field)
+			if kind == "include" {
+				conditions = append(conditions, fmt.Sprintf("%s IN UNNEST(@%s)", field, param))
+			} else {
+				conditions = append(conditions, fmt.Sprintf("(%s IS NULL OR %s NOT IN UNNEST(@%s))", field, field, param))
+			}
+			params = append(params, bigquery.QueryParameter{
+				Name:  param,
+				Value: fields[field],
+			})
+		}
+	}
+	add("include", f.include)
+	add("exclude", f.exclude)
+	return strings.Join(conditions, " AND "), params
+}
+
+// apply wraps the pipeline query with the filter condition
+func (f *logFilter) apply(query string) (string, []bigquery.QueryParameter) {
+	if f.empty() {
+		return query, nil
+	}
+	where, params := f.where()
+	return fmt.Sprintf("SELECT * FROM (%s) WHERE %s ORDER BY timestamp ASC", query, where), params
+}
+
+// match reports whether an entry passes the filter, for entries that did not come from BigQuery
+func (f *logFilter) match(e *LogEntry) bool {
+	for field, values := range f.include {
+		if !containsString(values, filterFields[field](e)) {
+			return false
+		}
+	}
+	for field, values := range f.exclude {
+		if containsString(values, filterFields[field](e)) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString reports whether values contains v
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// healthCheckTimeout bounds each connectivity check of /readyz
+const healthCheckTimeout = 5 * time.Second
+
+// pipelineHealth is the sync status of one pipeline as reported by the probes
+type pipelineHealth struct {
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	CircuitOpen bool       `json:"circuit_open"`
+}
+
+// healthReport is the JSON body of /healthz and /readyz
+type healthReport struct {
+	Status     string                    `json:"status"`
+	BigQuery   string                    `json:"bigquery,omitempty"`
+	OpenSearch string                    `json:"opensearch,omitempty"`
+	Pipelines  map[string]pipelineHealth `json:"pipelines"`
+}
+
+// pipelinesHealth returns the last success and last error of every pipeline
+func (s *SyncService) pipelinesHealth() map[string]pipelineHealth {
+	names, stats := s.metrics.snapshot()
+	pipelines := make(map[string]pipelineHealth, len(names))
+	for i, name := range names {
+		st := stats[i]
+		pipelines[name] = pipelineHealth{
+			LastSuccess: optionalTime(st.lastSuccess),
+			LastError:   st.lastError,
+			LastErrorAt: optionalTime(st.lastErrorAt),
+			CircuitOpen: st.circuitOpen,
+		}
+	}
+	return pipelines
+}
+
+// handleHealthz is the liveness probe: the process serves requests, with the pipeline status for uptime checks
+func (s *SyncService) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthReport{
+		Status:    "ok",
+		Pipelines: s.pipelinesHealth(),
+	})
+}
+
+// handleReadyz is the readiness probe: it fails unless both BigQuery and the sink cluster answer
+func (s *SyncService) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{
+		Status:     "ok",
+		BigQuery:   "ok",
+		OpenSearch: "ok",
+		Pipelines:  s.pipelinesHealth(),
+	}
+	status := http.StatusOK
+
+	if err := s.checkBigQuery(r.Context()); err != nil {
+		report.Status, report.BigQuery = "unavailable", err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	if err := s.checkOpenSearch(r.Context()); err != nil {
+		report.Status, report.OpenSearch = "unavailable", err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, report)
+}
+
+// checkBigQuery reads the metadata of the first pipeline dataset
+func (s *SyncService) checkBigQuery(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	dataset := s.config.BigQuery.Dataset
+	if len(s.pipelines) > 0 {
+		dataset = s.pipelines[0].Config.Dataset
+	}
+	if _, err := s.bqClient.Dataset(dataset).Metadata(ctx); err != nil {
+		return fmt.Errorf("failed to reach dataset %s: %v", dataset, err)
+	}
+	return nil
+}
+
+// checkOpenSearch pings the sink cluster
+func (s *SyncService) checkOpenSearch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	res, err := opensearchapi.PingRequest{}.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to ping cluster: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to ping cluster: %s", res.Status())
+	}
+	return nil
+}
+
+// writeHealth writes a health report as JSON
+func writeHealth(w http.ResponseWriter, status int, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// LifecycleConfig is the ISM policy attached to the indices of a pipeline: they
+// stay hot, become read-only and force-merged after WarmAfterDays and are deleted
+// after DeleteAfterDays. A zero age skips that state.
+type LifecycleConfig struct {
+	WarmAfterDays   int `json:"warm_after_days,omitempty"`
+	DeleteAfterDays int `json:"delete_after_days,omitempty"`
+}
+
+// enabled reports whether the lifecycle has any transition
+func (lc LifecycleConfig) enabled() bool {
+	return lc.WarmAfterDays > 0 || lc.DeleteAfterDays > 0
+}
+
+// validate checks that the lifecycle ages are usable
+func (lc LifecycleConfig) validate() error {
+	if lc.WarmAfterDays < 0 || lc.DeleteAfterDays < 0 {
+		return fmt.Errorf("lifecycle ages must not be negative")
+	}
+	if lc.WarmAfterDays > 0 && lc.DeleteAfterDays > 0 && lc.DeleteAfterDays <= lc.WarmAfterDays {
+		return fmt.Errorf("lifecycle delete_after_days must be after warm_after_days")
+	}
+	return nil
+}
+
+// ismTransition moves an index to state once it is older than days
+func ismTransition(state string, days int) map[string]interface{} {
+	return map[string]interface{}{
+		"state_name": state,
+		"conditions": map[string]interface{}{
+			"min_index_age": fmt.Sprintf("%dd", days),
+		},
+	}
+}
+
+// ismStates builds the hot -> warm -> delete states of a lifecycle, leaving out skipped ones
+func ismStates(lc LifecycleConfig) []map[string]interface{} {
+	hot := map[string]interface{}{
+		"name":        "hot",
+		"actions":     []interface{}{},
+		"transitions": []interface{}{},
+	}
+	states := []map[string]interface{}{hot}
+
+	switch {
+	case lc.WarmAfterDays > 0:
+		hot["transitions"] = []map[string]interface{}{ismTransition("warm", lc.WarmAfterDays)}
+	case lc.DeleteAfterDays > 0:
+		hot["transitions"] = []map[string]interface{}{ismTransition("delete", lc.DeleteAfterDays)}
+	}
+
+	if lc.WarmAfterDays > 0 {
+		warm := map[string]interface{}{
+			"name": "warm",
+			"actions": []map[string]interface{}{
+				{"read_only": map[string]interface{}{}},
+				{"force_merge": map[string]interface{}{"max_num_segments": 1}},
+			},
+			"transitions": []interface{}{},
+		}
+		if lc.DeleteAfterDays > 0 {
+			warm["transitions"] = []map[string]interface{}{ismTransition("delete", lc.DeleteAfterDays)}
+		}
+		states = append(states, warm)
+	}
+
+	if lc.DeleteAfterDays > 0 {
+		states = append(states, map[string]interface{}{
+			"name": "delete",
+			"actions": []map[string]interface{}{
+				{"delete": map[string]interface{}{}},
+			},
+			"transitions": []interface{}{},
+		})
+	}
+	return states
+}
+
+// putLifecyclePolicy creates the ISM policy of a lifecycle and attaches it to
+// newly created indices matching patterns through its ism_template
+func (s *SyncService) putLifecyclePolicy(ctx context.Context, policyName, description string, patterns []string, priority int, lc LifecycleConfig) error {
+	if s.config.Sink == sinkElasticsearch {
+		// Elasticsearch manages lifecycles with ILM, which has no equivalent of ism_template
+		clusterLog.Warn("ISM policy skipped, not supported by the elasticsearch sink", "policy", policyName)
+		return nil
+	}
+
+	policy := map[string]interface{}{
+		"policy": map[string]interface{}{
+			"description":   description,
+			"default_state": "hot",
+			"states":        ismStates(lc),
+			"ism_template": []map[string]interface{}{
+				{
+					"index_patterns": patterns,
+					"priority":       priority,
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ISM policy: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, "/_plugins/_ism/policies/"+policyName, strings.NewReader(string(body)))
+	if err != nil {
+		return fmt.Errorf("failed to build ISM policy request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.osClient.Perform(req)
+	if err != nil {
+		return fmt.Errorf("failed to create ISM policy %s: %v", policyName, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusConflict { // 409 means policy already exists
+		return fmt.Errorf("failed to create ISM policy %s: %s", policyName, res.Status)
+	}
+
+	clusterLog.Info("ISM policy created", "policy", policyName)
+	return nil
+}
+
+// createLifecyclePolicy provisions the ISM policy of a pipeline next to its index template
+func (s *SyncService) createLifecyclePolicy(ctx context.Context, p *Pipeline) error {
+	lc := p.Config.Lifecycle
+	if !lc.enabled() {
+		return nil
+	}
+
+	index := p.Config.Index
+	description := fmt.Sprintf("%s indices: warm after %d days, delete after %d days (0 = never)",
+		index, lc.WarmAfterDays, lc.DeleteAfterDays)
+	return s.putLifecyclePolicy(ctx, index+"-lifecycle", description, []string{index, index + "-*"}, 0, lc)
+}
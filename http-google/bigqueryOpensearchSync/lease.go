@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// defaultLeaseIndex stores one lease document per pipeline
+const defaultLeaseIndex = "bqsync-leases"
+
+// LeaseConfig enables leader election between replicas: only the holder of a
+// pipeline lease syncs it, the others stand by and take over once it expires
+type LeaseConfig struct {
+	Enabled bool   `json:"enabled"`
+	Index   string `json:"index,omitempty"`
+
+	// TTL is how long a lease stays valid without renewal, at least twice the sync interval
+	TTL time.Duration `json:"ttl,omitempty"`
+
+	// HolderID identifies this replica, hostname and pid by default
+	HolderID string `json:"holder_id,omitempty"`
+}
+
+// leaseDoc is the lease document; writes are conditional on its sequence number
+// so two replicas can never both take an expired lease. LastSync carries the
+// holder's watermark so a replica taking over resumes where the previous one was
+type leaseDoc struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+	LastSync  time.Time `json:"last_sync"`
+}
+
+// leaseHolderID returns the configured holder ID or one derived from the host
+func (s *SyncService) leaseHolderID() string {
+	if s.config.Lease.HolderID != "" {
+		return s.config.Lease.HolderID
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// leaseTTL returns the lease lifetime of a pipeline, long enough to survive one interval
+func (s *SyncService) leaseTTL(p *Pipeline) time.Duration {
+	ttl := s.config.Lease.TTL
+	if floor := 2 * p.Config.SyncInterval; ttl < floor {
+		ttl = floor
+	}
+	return ttl
+}
+
+// acquireLease takes or renews the pipeline lease and reports whether this replica holds it
+func (s *SyncService) acquireLease(ctx context.Context, p *Pipeline) (bool, error) {
+	if !s.config.Lease.Enabled {
+		return true, nil
+	}
+
+	index := s.config.Lease.Index
+	if index == "" {
+		index = defaultLeaseIndex
+	}
+	holder := s.leaseHolderID()
+
+	current, seqNo, primaryTerm, found, err := s.getLease(ctx, index, p.Config.Name)
+	if err != nil {
+		return false, err
+	}
+	if found && current.Holder != holder && time.Now().Before(current.ExpiresAt) {
+		return false, nil
+	}
+
+	// taking over from another replica, continue from its watermark
+	lastSync := p.lastSync
+	if found && current.Holder != holder && current.LastSync.After(lastSync) {
+		lastSync = current.LastSync
+	}
+
+	body, err := json.Marshal(leaseDoc{
+		Holder:    holder,
+		ExpiresAt: time.Now().Add(s.leaseTTL(p)).UTC(),
+		LastSync:  lastSync.UTC(),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal lease: %v", err)
+	}
+
+	req := opensearchapi.IndexRequest{
+		Index:      index,
+		DocumentID: p.Config.Name,
+		Body:       strings.NewReader(string(body)),
+		Refresh:    "true",
+	}
+	if found {
+		req.IfSeqNo = &seqNo
+		req.IfPrimaryTerm = &primaryTerm
+	} else {
+		req.OpType = "create"
+	}
+
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return false, fmt.Errorf("failed to write lease: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusConflict {
+		// another replica took or renewed the lease since we read it
+		return false, nil
+	}
+	if res.IsError() {
+		return false, fmt.Errorf("failed to write lease: %s", res.Status())
+	}
+
+	if !found || current.Holder != holder {
+		leaseLog.Info("acquired sync lease", "pipeline", p.Config.Name, "holder", holder, "from", lastSync)
+		p.lastSync = lastSync
+		s.metrics.setWatermark(p.Config.Name, lastSync)
+	}
+	return true, nil
+}
+
+// getLease reads the lease document of a pipeline with its concurrency control fields
+func (s *SyncService) getLease(ctx context.Context, index, name string) (leaseDoc, int, int, bool, error) {
+	req := opensearchapi.GetRequest{
+		Index:      index,
+		DocumentID: name,
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return leaseDoc{}, 0, 0, false, fmt.Errorf("failed to read lease: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return leaseDoc{}, 0, 0, false, nil
+	}
+	if res.IsError() {
+		return leaseDoc{}, 0, 0, false, fmt.Errorf("failed to read lease: %s", res.Status())
+	}
+
+	var parsed struct {
+		SeqNo       int      `json:"_seq_no"`
+		PrimaryTerm int      `json:"_primary_term"`
+		Found       bool     `json:"found"`
+		Source      leaseDoc `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return leaseDoc{}, 0, 0, false, fmt.Errorf("failed to decode lease: %v", err)
+	}
+	return parsed.Source, parsed.SeqNo, parsed.PrimaryTerm, parsed.Found, nil
+}
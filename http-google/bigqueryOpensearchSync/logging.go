@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"devicetransport/gcplog"
+)
+
+// logLevelNames maps the --log-level and log_levels values to levels
+var logLevelNames = map[string]slog.Level{
+	"debug":     gcplog.LevelDebug,
+	"info":      gcplog.LevelInfo,
+	"notice":    gcplog.LevelNotice,
+	"warning":   gcplog.LevelWarning,
+	"error":     gcplog.LevelError,
+	"critical":  gcplog.LevelCritical,
+	"alert":     gcplog.LevelAlert,
+	"emergency": gcplog.LevelEmergency,
+}
+
+// componentLevels holds the level of every component logger, adjusted by setLogLevels
+var componentLevels = make(map[string]*slog.LevelVar)
+
+// logHandler writes Cloud Logging compatible JSON; filtering is left to the component levels
+var logHandler slog.Handler = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	Level:       gcplog.LevelDebug,
+	ReplaceAttr: gcplog.ReplaceAttr,
+})
+
+// Component loggers; each one can be set to its own level with log_levels
+var (
+	syncLog      = newComponentLogger("sync")
+	bulkLog      = newComponentLogger("bulk")
+	clusterLog   = newComponentLogger("cluster")
+	leaseLog     = newComponentLogger("lease")
+	retentionLog = newComponentLogger("retention")
+	pubsubLog    = newComponentLogger("pubsub")
+	httpLog      = newComponentLogger("http")
+	backfillLog  = newComponentLogger("backfill")
+	verifyLog    = newComponentLogger("verify")
+)
+
+// leveledHandler drops the records below the level of its component
+type leveledHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h *leveledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *leveledHandler) WithGroup(name string) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// newComponentLogger returns the logger of a component, tagging its records with the component name
+func newComponentLogger(component string) *slog.Logger {
+	level := new(slog.LevelVar)
+	componentLevels[component] = level
+	return slog.New(&leveledHandler{Handler: logHandler, level: level}).With("component", component)
+}
+
+// parseLogLevel converts a level name such as "debug" or "warning"
+func parseLogLevel(name string) (slog.Level, error) {
+	level, ok := logLevelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// setLogLevels sets every component to the default level, then applies the per-component overrides
+func setLogLevels(defaultLevel string, overrides map[string]string) error {
+	level := gcplog.LevelInfo
+	if defaultLevel != "" {
+		var err error
+		if level, err = parseLogLevel(defaultLevel); err != nil {
+			return err
+		}
+	}
+	for _, lv := range componentLevels {
+		lv.Set(level)
+	}
+
+	for component, name := range overrides {
+		lv, ok := componentLevels[component]
+		if !ok {
+			return fmt.Errorf("unknown log component %q", component)
+		}
+		level, err := parseLogLevel(name)
+		if err != nil {
+			return fmt.Errorf("component %s: %v", component, err)
+		}
+		lv.Set(level)
+	}
+	return nil
+}
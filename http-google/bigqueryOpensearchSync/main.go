@@ -3,16 +3,20 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/bigquery"
-	"github.com/opensearch-project/opensearch-go"
 	"github.com/opensearch-project/opensearch-go/opensearchapi"
-	"google.golang.org/api/iterator"
-	"google.golang.org/api/option"
+
+	"devicetransport/gcplog"
 )
 
 var (
@@ -26,19 +30,20 @@ var (
 func checkEnv() {
 	missing := false
 	if projectID == "" {
-		log.Println("Missing env var: GCP_PROJECT")
+		syncLog.Error("missing env var", "name", "GCP_PROJECT")
 		missing = true
 	}
 	if datasetID == "" {
-		log.Println("Missing env var: DATASET_ID")
+		syncLog.Error("missing env var", "name", "DATASET_ID")
 		missing = true
 	}
 	if tableID == "" {
-		log.Println("Missing env var: TABLE_ID")
+		syncLog.Error("missing env var", "name", "TABLE_ID")
 		missing = true
 	}
 	if missing {
-		log.Fatal("Required environment variables are missing.")
+		syncLog.Log(context.Background(), gcplog.LevelCritical, "required environment variables are missing")
+		os.Exit(1)
 	}
 }
 
@@ -49,6 +54,13 @@ type Config struct {
 		ProjectID string `json:"project_id"`
 		Dataset   string `json:"dataset"`
 		Table     string `json:"table"`
+
+		// StorageRead downloads query results through the BigQuery Storage Read API;
+		// reads that need no row order (backfills, uncapped passes) use several streams
+		StorageRead bool `json:"storage_read"`
+
+		// Auth selects a JSON key, Application Default Credentials or an impersonated service account
+		Auth AuthConfig `json:"auth"`
 	} `json:"bigquery"`
 
 	OpenSearch struct {
@@ -56,9 +68,80 @@ type Config struct {
 		Username string   `json:"username,omitempty"`
 		Password string   `json:"password,omitempty"`
 		Index    string   `json:"index"`
+
+		// TLS configures https connections: custom CA, client certificate, verification
+		TLS TLSConfig `json:"tls"`
+
+		// BulkMaxDocs and BulkMaxBytes bound each bulk request, BulkWorkers send them concurrently
+		BulkMaxDocs  int `json:"bulk_max_docs"`
+		BulkMaxBytes int `json:"bulk_max_bytes"`
+		BulkWorkers  int `json:"bulk_workers"`
+
+		// MaxRetries is how many times a document rejected with a transient status is resent
+		MaxRetries   int           `json:"max_retries"`
+		RetryBackoff time.Duration `json:"retry_backoff"`
+
+		// RateLimit bounds the indexing rate and the concurrent bulk requests
+		RateLimit RateLimitConfig `json:"rate_limit"`
+
+		// CompressBulk gzips bulk bodies of at least CompressMinBytes (Content-Encoding: gzip)
+		CompressBulk     bool `json:"compress_bulk"`
+		CompressMinBytes int  `json:"compress_min_bytes"`
 	} `json:"opensearch"`
 
+	// Sink selects the cluster documents are written to: "opensearch" (default)
+	// or "elasticsearch" for an Elasticsearch 8.x cluster configured under elasticsearch
+	Sink          string              `json:"sink,omitempty"`
+	Elasticsearch ElasticsearchConfig `json:"elasticsearch"`
+
 	SyncInterval time.Duration `json:"sync_interval"`
+
+	// MaxRowsPerSync caps the rows one periodic sync pass reads, 0 means unlimited;
+	// the remaining rows are picked up by the following passes
+	MaxRowsPerSync int `json:"max_rows_per_sync"`
+
+	// RetentionInterval is how often pipelines with retention_days are pruned, hourly by default
+	RetentionInterval time.Duration `json:"retention_interval"`
+
+	// ShutdownTimeout bounds how long in-flight syncs may finish after SIGTERM
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+
+	// Lease elects one replica per pipeline to sync when several instances run
+	Lease LeaseConfig `json:"lease"`
+
+	// Retry controls retries of failed sync passes and the per-pipeline circuit breaker
+	Retry RetryConfig `json:"retry"`
+
+	// DeadLetter receives documents OpenSearch permanently rejects; File is an NDJSON path, Index a dedicated index
+	DeadLetter struct {
+		File  string `json:"file,omitempty"`
+		Index string `json:"index,omitempty"`
+	} `json:"dead_letter"`
+
+	// CheckpointFile stores the last successful sync watermark across restarts
+	// for the default pipeline built from the bigquery/opensearch settings
+	CheckpointFile string `json:"checkpoint_file"`
+
+	// HTTPAddr is the listen address of /metrics and the Pub/Sub push endpoint, empty disables it
+	HTTPAddr string `json:"http_addr"`
+
+	// LogLevel is the level of every component logger (debug, info, notice, warning, error),
+	// LogLevels overrides it per component, e.g. {"bulk": "debug"}
+	LogLevel  string            `json:"log_level"`
+	LogLevels map[string]string `json:"log_levels,omitempty"`
+
+	// PubSub receives Cloud Logging entries from a Pub/Sub push subscription on PushPath
+	// and indexes them in near real time into Pipeline (the first one by default);
+	// the BigQuery poller keeps running as reconciliation path
+	PubSub struct {
+		PushPath      string        `json:"push_path,omitempty"`
+		Token         string        `json:"token,omitempty"`
+		Pipeline      string        `json:"pipeline,omitempty"`
+		FlushInterval time.Duration `json:"flush_interval,omitempty"`
+	} `json:"pubsub"`
+
+	// Pipelines declares independent table-to-index syncs that run concurrently
+	Pipelines []PipelineConfig `json:"pipelines,omitempty"`
 }
 
 // LogEntry 
@@ -88,78 +171,99 @@ type LogEntry struct {
 type SyncService struct {
 	config     *Config
 	bqClient   *bigquery.Client
-	osClient   *opensearch.Client
-	lastSync   time.Time
+	// osClient talks to the sink cluster (OpenSearch or Elasticsearch) for templates, aliases and leases
+	osClient   opensearchapi.Transport
+	osTransport http.RoundTripper
+	sink       Sink
+	pipelines  []*Pipeline
+	metrics    *syncMetrics
+	stream     *streamIngester
+	limiter    *indexLimiter
 }
 
 // NewSyncService 
 func NewSyncService(config *Config) (*SyncService, error) {
 	ctx := context.Background()
 	
-	// inti BigQuery client- with specift auth doc
-	var bqClient *bigquery.Client
-	var err error
-	
-	if credentialsFile != "" {
-		bqClient, err = bigquery.NewClient(ctx, projectID, option.WithCredentialsFile(credentialsFile))
-	} else {
-		bqClient, err = bigquery.NewClient(ctx, projectID)
+	// inti BigQuery client- with the configured auth
+	if err := config.BigQuery.Auth.validate(); err != nil {
+		return nil, fmt.Errorf("invalid auth config: %v", err)
 	}
-	
+	opts, err := config.BigQuery.Auth.clientOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bqClient, err := bigquery.NewClient(ctx, config.BigQuery.ProjectID, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
 	}
 
-	// init OpenSearch client
-	osConfig := opensearch.Config{
-		Addresses: config.OpenSearch.URLs,
+	// query results are then downloaded over parallel Storage Read API streams
+	if config.BigQuery.StorageRead {
+		if err := bqClient.EnableStorageReadClient(ctx, opts...); err != nil {
+			return nil, fmt.Errorf("failed to create BigQuery Storage Read client: %v", err)
+		}
 	}
-	
-	if config.OpenSearch.Username != "" && config.OpenSearch.Password != "" {
-		osConfig.Username = config.OpenSearch.Username
-		osConfig.Password = config.OpenSearch.Password
+
+	// init the client of the sink cluster
+	osClient, transport, err := newClusterClient(config)
+	if err != nil {
+		return nil, err
 	}
 
-	osClient, err := opensearch.NewClient(osConfig)
+	// init pipelines, each with its own query and checkpoint
+	pipelineConfigs, err := resolvePipelines(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create OpenSearch client: %v", err)
+		return nil, err
+	}
+	pipelines := make([]*Pipeline, 0, len(pipelineConfigs))
+	for _, pc := range pipelineConfigs {
+		p, err := newPipeline(pc, config.BigQuery.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		pipelines = append(pipelines, p)
 	}
 
-	return &SyncService{
+	s := &SyncService{
 		config:     config,
 		bqClient:   bqClient,
 		osClient:   osClient,
-		lastSync:   time.Now().Add(-config.SyncInterval),
-	}, nil
+		osTransport: transport,
+		pipelines:  pipelines,
+		metrics:    newSyncMetrics(),
+		limiter:    newIndexLimiter(config.OpenSearch.RateLimit),
+	}
+	s.sink = &bulkSink{service: s, client: osClient}
+
+	// optional near real-time path fed by a Cloud Logging Pub/Sub sink
+	if config.PubSub.PushPath != "" {
+		if config.HTTPAddr == "" {
+			return nil, fmt.Errorf("pubsub push endpoint requires http_addr")
+		}
+		if s.stream, err = newStreamIngester(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
 }
 
-// fetchLogsFromBigQuery 
-func (s *SyncService) fetchLogsFromBigQuery(ctx context.Context, since time.Time) ([]*LogEntry, error) {
-	query := s.bqClient.Query(fmt.Sprintf(`
-		SELECT
-  		  logName,
-  		  resource.type AS resource_type,
-  		  resource.labels.revision_name,
-  		  resource.labels.location,
-  		  resource.labels.project_id,
-  		  resource.labels.configuration_name,
-  		  resource.labels.service_name,
-		  jsonPayload.value AS jsonPayload_value,
-  		  jsonPayload.type AS jsonPayload_type,
-  		  jsonPayload.messages AS message,
-  		  jsonPayload.device_id AS device_id,
-  		  jsonPayload.timestamp AS log_timestamp,
-  		  timestamp,
-  		  receiveTimestamp,
-  		  severity,
-  		  insertId,
-  		  labels.instanceid,
-  		  trace,
-  		  spanId
-		FROM `+"`%s.%s.%s`"+`
-		WHERE timestamp >= @since_time
-		ORDER BY timestamp ASC
-	`, projectID, datasetID, tableID))
+// queryLogsFromBigQuery runs the pipeline query for rows newer than since and,
+// when until is set, older than until (used by backfill to bound each chunk).
+// Unordered results let the Storage Read API split them over parallel streams.
+func (s *SyncService) queryLogsFromBigQuery(ctx context.Context, p *Pipeline, since, until time.Time, ordered bool) (*bigquery.RowIterator, error) {
+	sql := p.query
+	switch {
+	case !until.IsZero() && ordered:
+		sql = fmt.Sprintf("SELECT * FROM (%s) WHERE timestamp < @until_time ORDER BY timestamp ASC", p.query)
+	case !until.IsZero():
+		sql = fmt.Sprintf("SELECT * FROM (%s) WHERE timestamp < @until_time", p.query)
+	case !ordered:
+		sql = fmt.Sprintf("SELECT * FROM (%s)", p.query)
+	}
+	query := s.bqClient.Query(sql)
 
 	query.Parameters = []bigquery.QueryParameter{
 		{
@@ -167,158 +271,213 @@ func (s *SyncService) fetchLogsFromBigQuery(ctx context.Context, since time.Time
 			Value: since,
 		},
 	}
+	if !until.IsZero() {
+		query.Parameters = append(query.Parameters, bigquery.QueryParameter{
+			Name:  "until_time",
+			Value: until,
+		})
+	}
+	query.Parameters = append(query.Parameters, p.params...)
 
 	it, err := query.Read(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute BigQuery query: %v", err)
 	}
 
-	var logs []*LogEntry
-	for {
-		var log LogEntry
-		err := it.Next(&log)
-		if err == iterator.Done {
+	return it, nil
+}
+
+// sendBulk send data to the sink cluster, retrying documents rejected with a
+// transient status and returning the number of documents that permanently failed
+func (s *SyncService) sendBulk(ctx context.Context, client opensearchapi.Transport, p *Pipeline, indexName string, logs []syncDocument) (int, error) {
+	if len(logs) == 0 {
+		bulkLog.Debug("no new logs to sync", "index", indexName)
+		return 0, nil
+	}
+
+	pending := logs
+	indexed, duplicates, failed := 0, 0, 0
+	var deadLetters []DeadLetter
+	for attempt := 0; ; attempt++ {
+		release, err := s.limiter.acquire(ctx, len(pending))
+		if err != nil {
+			return failed + len(pending), err
+		}
+		items, err := s.executeBulk(ctx, client, p, indexName, pending)
+		release()
+
+		var retry []syncDocument
+		switch {
+		case errors.Is(err, errBulkThrottled) && attempt < s.config.OpenSearch.MaxRetries:
+			// the whole request was pushed back, resend all of it more slowly
+			s.limiter.throttled()
+			retry = pending
+		case err != nil:
+			return failed, err
+		}
+
+		throttled := false
+		for i, item := range items {
+			if item.Error == nil {
+				indexed++
+				continue
+			}
+			if isWriteBlocked(item) {
+				// not a bad document: fail the pass so its window is synced again once writes resume
+				return failed, fmt.Errorf("index %s is write-blocked: %s", indexName, item.Error.Reason)
+			}
+			throttled = throttled || isThrottled(item)
+			// in create mode a conflict means this insertId is already indexed
+			if p.Config.WriteMode == writeModeCreate && item.Status == http.StatusConflict {
+				duplicates++
+				continue
+			}
+			if isRetryableStatus(item.Status) && attempt < s.config.OpenSearch.MaxRetries {
+				retry = append(retry, pending[i])
+				continue
+			}
+			failed++
+			bulkLog.Warn("document rejected by the sink", "pipeline", p.Config.Name, "id", pending[i].documentID(),
+				"status", item.Status, "error_type", item.Error.Type, "reason", item.Error.Reason)
+			deadLetters = append(deadLetters, newDeadLetter(indexName, pending[i], item))
+		}
+
+		if throttled {
+			s.limiter.throttled()
+		} else if err == nil {
+			s.limiter.succeeded()
+		}
+
+		if len(retry) == 0 {
 			break
 		}
-		if err != nil {
-			return nil, fmt.Errorf("failed to read query results: %v", err)
+
+		// exponential backoff before resending only the rejected documents
+		backoff := s.config.OpenSearch.RetryBackoff * time.Duration(1<<attempt)
+		bulkLog.Info("retrying rejected documents", "pipeline", p.Config.Name, "documents", len(retry),
+			"backoff", backoff.String(), "attempt", attempt+1, "max_retries", s.config.OpenSearch.MaxRetries)
+		select {
+		case <-ctx.Done():
+			return failed + len(retry), ctx.Err()
+		case <-time.After(backoff):
 		}
-		logs = append(logs, &log)
+		pending = retry
 	}
 
-	return logs, nil
+	// park permanent failures so they neither block the batch nor get lost
+	if err := s.writeDeadLetters(ctx, deadLetters); err != nil {
+		return failed, fmt.Errorf("failed to store dead letters: %v", err)
+	}
+
+	bulkLog.Info("indexed documents", "pipeline", p.Config.Name, "index", indexName,
+		"indexed", indexed, "duplicates", duplicates, "failed", failed)
+	return failed, nil
 }
 
-// sendToOpenSearch send data to OpenSearch
-func (s *SyncService) sendToOpenSearch(ctx context.Context, logs []*LogEntry) error {
-	if len(logs) == 0 {
-		log.Println("No new logs to sync")
-		return nil
+// staticMappingProperties returns the hardcoded mapping of the LogEntry fields
+func staticMappingProperties() map[string]interface{} {
+	return map[string]interface{}{
+		"logName": map[string]interface{}{
+			"type": "keyword",
+		},
+		"resource_type": map[string]interface{}{
+			"type": "keyword",
+		},
+		"revision_name": map[string]interface{}{
+			"type": "keyword",
+		},
+		"location": map[string]interface{}{
+			"type": "keyword",
+		},
+		"project_id": map[string]interface{}{
+			"type": "keyword",
+		},
+		"configuration_name": map[string]interface{}{
+			"type": "keyword",
+		},
+		"service_name": map[string]interface{}{
+			"type": "keyword",
+		},
+		"jsonPayload_value": map[string]interface{}{
+			"type": "float",
+		},
+		"jsonPayload_type": map[string]interface{}{
+			"type": "keyword",
+		},
+		"message": map[string]interface{}{
+			"type": "text",
+			"analyzer": "standard",
+		},
+		"device_id": map[string]interface{}{
+			"type": "keyword",
+		},
+		"log_timestamp": map[string]interface{}{
+			"type": "keyword",
+		},
+		"timestamp": map[string]interface{}{
+			"type": "date",
+		},
+		"receiveTimestamp": map[string]interface{}{
+			"type": "date",
+		},
+		"severity": map[string]interface{}{
+			"type": "keyword",
+		},
+		"insertId": map[string]interface{}{
+			"type": "keyword",
+		},
+		"instanceid": map[string]interface{}{
+			"type": "keyword",
+		},
+		"trace": map[string]interface{}{
+			"type": "keyword",
+		},
+		"spanId": map[string]interface{}{
+			"type": "keyword",
+		},
 	}
+}
 
-	// batch
-	var bulkBody strings.Builder
-	//faccendo come sotto si crea ad ogni giorno una nuova index
-	//indexName := fmt.Sprintf("%s-%s", s.config.OpenSearch.Index, time.Now().Format("2006-01-02"))
-	indexName := s.config.OpenSearch.Index
+// createIndexTemplate 
+func (s *SyncService) createIndexTemplate(ctx context.Context, p *Pipeline) error {
+	index := p.Config.Index
 
-	for _, logEntry := range logs {
-		// index
-		indexOp := map[string]interface{}{
-			"index": map[string]interface{}{
-				"_index": indexName,
-			},
-		}
-		
-		indexOpJSON, err := json.Marshal(indexOp)
-		if err != nil {
-			return fmt.Errorf("failed to marshal index operation: %v", err)
-		}
-		
-		bulkBody.WriteString(string(indexOpJSON))
-		bulkBody.WriteString("\n")
-		
-		// doc data
-		docJSON, err := json.Marshal(logEntry)
+	// mapping is either the hardcoded layout of the pipeline kind or generated from the table schema
+	properties := p.kind.mapping()
+	if p.Config.Mapping == mappingAuto {
+		generated, err := s.generateMappingProperties(ctx, p)
 		if err != nil {
-			return fmt.Errorf("failed to marshal log entry: %v", err)
+			return err
 		}
-		
-		bulkBody.WriteString(string(docJSON))
-		bulkBody.WriteString("\n")
+		properties = generated
 	}
+	properties = p.transforms.mappingProperties(properties)
 
-	// batch insert
-	req := opensearchapi.BulkRequest{
-		Body: strings.NewReader(bulkBody.String()),
+	if err := s.putIndexTemplate(ctx, index+"_template", []string{index + "-*"}, 0, properties, 0); err != nil {
+		return err
 	}
 
-	res, err := req.Do(ctx, s.osClient)
-	if err != nil {
-		return fmt.Errorf("failed to execute bulk request: %v", err)
+	// lifecycle of the pipeline indices is owned here rather than by manual cluster setup
+	if err := s.createLifecyclePolicy(ctx, p); err != nil {
+		return err
 	}
-	defer res.Body.Close()
 
-	if res.IsError() {
-		return fmt.Errorf("bulk request failed with status: %s", res.Status())
-	}
-
-	log.Printf("Successfully indexed %d documents to OpenSearch", len(logs))
-	return nil
+	// severity routes get their own template for replicas and a retention policy
+	return s.createRouteTemplates(ctx, p, properties)
 }
 
-// createIndexTemplate 
-func (s *SyncService) createIndexTemplate(ctx context.Context) error {
-	templateName := s.config.OpenSearch.Index + "_template"
-	
+// putIndexTemplate creates or replaces a composable index template
+func (s *SyncService) putIndexTemplate(ctx context.Context, templateName string, patterns []string, priority int, properties map[string]interface{}, replicas int) error {
 	template := map[string]interface{}{
-		"index_patterns": []string{s.config.OpenSearch.Index + "-*"},
+		"index_patterns": patterns,
+		"priority":       priority,
 		"template": map[string]interface{}{
 			"mappings": map[string]interface{}{
-				"properties": map[string]interface{}{
-					"logName": map[string]interface{}{
-						"type": "keyword",
-					},
-					"resource_type": map[string]interface{}{
-						"type": "keyword",
-					},
-					"revision_name": map[string]interface{}{
-						"type": "keyword",
-					},
-					"location": map[string]interface{}{
-						"type": "keyword",
-					},
-					"project_id": map[string]interface{}{
-						"type": "keyword",
-					},
-					"configuration_name": map[string]interface{}{
-						"type": "keyword",
-					},
-					"service_name": map[string]interface{}{
-						"type": "keyword",
-					},
-					"jsonPayload_value": map[string]interface{}{
-						"type": "keyword",
-					},
-					"jsonPayload_type": map[string]interface{}{
-						"type": "keyword",
-					},
-					"message": map[string]interface{}{
-						"type": "text",
-						"analyzer": "standard",
-					},
-					"device_id": map[string]interface{}{
-						"type": "keyword",
-					},
-					"log_timestamp": map[string]interface{}{
-						"type": "keyword",
-					},
-					"timestamp": map[string]interface{}{
-						"type": "date",
-					},
-					"receiveTimestamp": map[string]interface{}{
-						"type": "date",
-					},
-					"severity": map[string]interface{}{
-						"type": "keyword",
-					},
-					"insertId": map[string]interface{}{
-						"type": "keyword",
-					},
-					"instanceid": map[string]interface{}{
-						"type": "keyword",
-					},
-					"trace": map[string]interface{}{
-						"type": "keyword",
-					},
-					"spanId": map[string]interface{}{
-						"type": "keyword",
-					},
-				},
+				"properties": properties,
 			},
 			"settings": map[string]interface{}{
 				"number_of_shards":   1,
-				"number_of_replicas": 0,
+				"number_of_replicas": replicas,
 			},
 		},
 	}
@@ -343,77 +502,167 @@ func (s *SyncService) createIndexTemplate(ctx context.Context) error {
 		return fmt.Errorf("failed to create index template: %s", res.Status())
 	}
 
-	log.Printf("Index template '%s' created successfully", templateName)
+	clusterLog.Info("index template created", "template", templateName)
 	return nil
 }
 
-// syncOnce 
-func (s *SyncService) syncOnce(ctx context.Context) error {
+// syncOnce runs one sync pass of a pipeline and returns the number of documents OpenSearch permanently rejected.
+// Failed passes are retried with backoff; too many consecutive failures pause the pipeline.
+func (s *SyncService) syncOnce(ctx context.Context, p *Pipeline) (int, error) {
+	if !s.breakerAllows(p) {
+		syncLog.Info("circuit breaker open, skipping sync", "pipeline", p.Config.Name, "until", p.breaker.openUntil.Format(time.RFC3339))
+		return 0, nil
+	}
+
+	// with several replicas only the lease holder syncs
+	leader, err := s.acquireLease(ctx, p)
+	if err != nil {
+		s.recordOutcome(ctx, p, err)
+		return 0, fmt.Errorf("failed to acquire sync lease: %v", err)
+	}
+	if !leader {
+		leaseLog.Debug("another replica holds the sync lease, standing by", "pipeline", p.Config.Name)
+		return 0, nil
+	}
+
 	start := time.Now()
-	
-	// get BigQuery new data
-	logs, err := s.fetchLogsFromBigQuery(ctx, s.lastSync)
+
+	// the window ends at start, so the next pass picks up exactly where this one stopped
+	var res syncResult
+	err = s.withRetry(ctx, p, func() error {
+		// resolve the write alias (rolling over on day change) or the plain index
+		target, err := s.writeTarget(ctx, p)
+		if err != nil {
+			err = fmt.Errorf("failed to resolve write index: %v", err)
+			s.metrics.recordError(p.Config.Name, 0, 0, err)
+			return err
+		}
+
+		// stream BigQuery new data to OpenSearch in bounded concurrent batches;
+		// documents are keyed by insertId so a retried window is not duplicated
+		res, err = s.syncRange(ctx, p, p.syncSince(), start, target, s.config.MaxRowsPerSync)
+		if err != nil {
+			s.metrics.recordError(p.Config.Name, res.fetched, res.failed, err)
+		}
+		return err
+	})
+	s.recordOutcome(ctx, p, err)
 	if err != nil {
-		return fmt.Errorf("failed to fetch logs from BigQuery: %v", err)
+		return res.failed, err
 	}
 
-	log.Printf("Fetched %d logs from BigQuery", len(logs))
+	syncLog.Debug("fetched rows from BigQuery", "pipeline", p.Config.Name, "rows", res.fetched)
+
+	// update time; a capped pass resumes from its newest row, which the next
+	// pass reads again (since is inclusive) and deduplicates by insertId
+	p.catchingUp = res.capped
+	switch {
+	case !res.capped && p.Config.Lookback > 0:
+		// late rows are re-read through the lookback, so only advance to what BigQuery already holds
+		if res.maxReceived.After(p.lastSync) {
+			p.lastSync = res.maxReceived
+		}
+	case !res.capped:
+		p.lastSync = start
+	case res.maxTimestamp.After(p.lastSync):
+		syncLog.Info("reached max_rows_per_sync, continuing next pass", "pipeline", p.Config.Name,
+			"max_rows_per_sync", s.config.MaxRowsPerSync, "from", res.maxTimestamp)
+		p.lastSync = res.maxTimestamp
+	default:
+		// every row read shares one timestamp, step past it so the pipeline cannot stall
+		syncLog.Warn("more than max_rows_per_sync rows share one timestamp, skipping the rest of them",
+			"pipeline", p.Config.Name, "max_rows_per_sync", s.config.MaxRowsPerSync, "timestamp", res.maxTimestamp)
+		p.lastSync = res.maxTimestamp.Add(time.Microsecond)
+	}
 
-	// send to OpenSearch
-	if err := s.sendToOpenSearch(ctx, logs); err != nil {
-		return fmt.Errorf("failed to send logs to OpenSearch: %v", err)
+	// persist the watermark only after OpenSearch accepted the batch
+	if err := saveCheckpoint(p.Config.CheckpointFile, p.lastSync); err != nil {
+		syncLog.Warn("failed to save checkpoint", "pipeline", p.Config.Name, "error", err)
 	}
 
-	// update time
-	s.lastSync = start
-	
-	log.Printf("Sync completed in %v", time.Since(start))
-	return nil
+	s.metrics.recordSync(p.Config.Name, res.fetched, res.failed, res.maxTimestamp, time.Since(start))
+	s.metrics.setWatermark(p.Config.Name, p.lastSync)
+
+	syncLog.Info("sync completed", "pipeline", p.Config.Name, "rows", res.fetched, "failed", res.failed,
+		"duration", time.Since(start).String())
+	return res.failed, nil
 }
 
-// Start sync
+// Start runs all pipelines until the context is cancelled, then lets the
+// in-flight syncs and the pending Pub/Sub batch finish within ShutdownTimeout
 func (s *SyncService) Start(ctx context.Context) error {
-	// create index
-	if err := s.createIndexTemplate(ctx); err != nil {
-		log.Printf("Warning: failed to create index template: %v", err)
+	// work outlives ctx by up to ShutdownTimeout so a sync interrupted by
+	// SIGTERM can complete its bulk requests and save its checkpoint
+	work, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+	go func() {
+		<-ctx.Done()
+		timer := time.NewTimer(s.shutdownTimeout())
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			syncLog.Warn("shutdown timeout exceeded, aborting in-flight syncs", "timeout", s.shutdownTimeout().String())
+			cancelWork()
+		case <-work.Done():
+		}
+	}()
+
+	var wg sync.WaitGroup
+	if s.stream != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.stream.run(ctx, work)
+		}()
 	}
-
-	// init
-	log.Println("Starting initial sync...")
-	if err := s.syncOnce(ctx); err != nil {
-		log.Printf("Initial sync failed: %v", err)
+	if s.config.HTTPAddr != "" {
+		go s.startHTTPServer(ctx, s.config.HTTPAddr)
+	}
+	if s.hasRetention() {
+		go s.runRetention(ctx)
 	}
 
-	// ticker sync
-	ticker := time.NewTicker(s.config.SyncInterval)
-	defer ticker.Stop()
+	syncLog.Info("starting sync pipelines", "pipelines", len(s.pipelines))
+	s.runPipelines(ctx, work)
+	wg.Wait()
 
-	log.Printf("Starting periodic sync every %v", s.config.SyncInterval)
-	
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Sync service stopped")
-			return ctx.Err()
-		case <-ticker.C:
-			if err := s.syncOnce(ctx); err != nil {
-				log.Printf("Sync failed: %v", err)
-				// 可以添加重试逻辑或报警
-			}
+	syncLog.Info("sync service stopped")
+	return nil
+}
+
+// shutdownTimeout returns how long in-flight work may continue after a stop signal
+func (s *SyncService) shutdownTimeout() time.Duration {
+	if s.config.ShutdownTimeout > 0 {
+		return s.config.ShutdownTimeout
+	}
+	return defaultShutdownTimeout
+}
+
+// InitTemplates creates the index template of every pipeline
+func (s *SyncService) InitTemplates(ctx context.Context) error {
+	for _, p := range s.pipelines {
+		if err := s.createIndexTemplate(ctx, p); err != nil {
+			return fmt.Errorf("pipeline %q: %v", p.Config.Name, err)
 		}
 	}
+	return nil
 }
 
 // Close client
 func (s *SyncService) Close() error {
+	// the OpenSearch client has no Close, release its pooled connections instead
+	transport := s.osTransport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if t, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
 	return s.bqClient.Close()
 }
 
-func main() {
-	// check env
-	checkEnv()
-	
-	// config
+// defaultConfig returns the built-in settings, overridden by the config file and CLI flags
+func defaultConfig() *Config {
 	config := &Config{
 		SyncInterval: 5 * time.Minute,
 	}
@@ -421,31 +670,42 @@ func main() {
 	config.BigQuery.ProjectID = projectID
 	config.BigQuery.Dataset = datasetID
 	config.BigQuery.Table = tableID
+	config.BigQuery.Auth.CredentialsFile = credentialsFile
 	
 	// OpenSearch config 
 	config.OpenSearch.URLs = []string{"http://localhost:9200"}
 	config.OpenSearch.Index = "gcp-logs-table"
+	config.OpenSearch.BulkMaxDocs = defaultBulkMaxDocs
+	config.OpenSearch.BulkMaxBytes = defaultBulkMaxBytes
+	config.OpenSearch.BulkWorkers = defaultBulkWorkers
+	config.OpenSearch.MaxRetries = 3
+	config.OpenSearch.RetryBackoff = 2 * time.Second
+	config.OpenSearch.CompressMinBytes = defaultCompressMinBytes
+
+	config.DeadLetter.File = "sync-deadletter.ndjson"
+	// config.DeadLetter.Index = "gcp-logs-dlq"
+
+	config.CheckpointFile = "sync-checkpoint.json"
+	config.HTTPAddr = ":9464"
+	config.ShutdownTimeout = defaultShutdownTimeout
+	// config.PubSub.PushPath = "/pubsub/push"
 
 	// config.OpenSearch.Username = "admin"
 	// config.OpenSearch.Password = "password"
 
-	log.Printf("Starting BigQuery to OpenSearch sync service")
-	log.Printf("Project: %s", projectID)
-	log.Printf("Dataset: %s", datasetID) 
-	log.Printf("Table: %s", tableID)
-	log.Printf("OpenSearch: %v", config.OpenSearch.URLs)
-	log.Printf("Sync interval: %v", config.SyncInterval)
+	return config
+}
 
-	// create sync service
-	service, err := NewSyncService(config)
-	if err != nil {
-		log.Fatalf("Failed to create sync service: %v", err)
-	}
-	defer service.Close()
+func main() {
+	// check env
+	checkEnv()
 
-	// start sync
-	ctx := context.Background()
-	if err := service.Start(ctx); err != nil {
-		log.Fatalf("Sync service failed: %v", err)
+	// SIGTERM (Cloud Run, Kubernetes) and Ctrl-C stop the service gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := runCLI(ctx, os.Args[1:]); err != nil {
+		syncLog.Log(ctx, gcplog.LevelCritical, "sync service failed", "error", err)
+		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// Index template mapping modes
+const (
+	mappingStatic = "static"
+	mappingAuto   = "auto"
+)
+
+// defaultTextFields are mapped as analyzed text instead of keyword when generating mappings
+var defaultTextFields = []string{"message", "messages", "textPayload"}
+
+// generateMappingProperties reads the pipeline table schema from BigQuery and
+// builds the OpenSearch mapping properties for it
+func (s *SyncService) generateMappingProperties(ctx context.Context, p *Pipeline) (map[string]interface{}, error) {
+	meta, err := s.bqClient.Dataset(p.Config.Dataset).Table(p.Config.Table).Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata of %s.%s: %v", p.Config.Dataset, p.Config.Table, err)
+	}
+
+	textFields := p.Config.TextFields
+	if len(textFields) == 0 {
+		textFields = defaultTextFields
+	}
+	text := make(map[string]bool, len(textFields))
+	for _, f := range textFields {
+		text[f] = true
+	}
+
+	properties := make(map[string]interface{})
+	addSchemaProperties(properties, nil, meta.Schema, text)
+
+	clusterLog.Info("generated mapping from table schema", "pipeline", p.Config.Name,
+		"fields", len(properties), "table", p.Config.Dataset+"."+p.Config.Table)
+	return properties, nil
+}
+
+// addSchemaProperties flattens nested RECORD columns into the properties map.
+// Rows reach OpenSearch flattened by the pipeline query, so each leaf is mapped
+// under its underscore-joined path (resource_type, jsonPayload_value) and, when
+// free, under its own name, which is what BigQuery calls a selected nested column.
+func addSchemaProperties(properties map[string]interface{}, path []string, schema bigquery.Schema, text map[string]bool) {
+	for _, field := range schema {
+		fieldPath := append(append([]string{}, path...), field.Name)
+
+		if field.Type == bigquery.RecordFieldType {
+			addSchemaProperties(properties, fieldPath, field.Schema, text)
+			continue
+		}
+
+		mapping := fieldMapping(field, text)
+		properties[strings.Join(fieldPath, "_")] = mapping
+		if _, taken := properties[field.Name]; !taken {
+			properties[field.Name] = mapping
+		}
+	}
+}
+
+// fieldMapping converts a BigQuery column type into an OpenSearch field mapping
+func fieldMapping(field *bigquery.FieldSchema, text map[string]bool) map[string]interface{} {
+	switch field.Type {
+	case bigquery.TimestampFieldType, bigquery.DateFieldType, bigquery.DateTimeFieldType:
+		return map[string]interface{}{
+			"type": "date",
+		}
+	case bigquery.IntegerFieldType, bigquery.FloatFieldType,
+		bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		return map[string]interface{}{
+			"type": "float",
+		}
+	}
+
+	if text[field.Name] {
+		return map[string]interface{}{
+			"type":     "text",
+			"analyzer": "standard",
+		}
+	}
+	return map[string]interface{}{
+		"type": "keyword",
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// pipelineStats holds the counters and gauges of one pipeline
+type pipelineStats struct {
+	rowsFetched        uint64
+	docsIndexed        uint64
+	docsFailed         uint64
+	syncErrors         uint64
+	syncs              uint64
+	lastDuration       time.Duration
+	lastSuccess        time.Time
+	maxSyncedTimestamp time.Time
+	circuitOpen        bool
+	docsPruned         uint64
+	indicesDropped     uint64
+	lastError          string
+	lastErrorAt        time.Time
+	lastFetched        int
+	lastFailed         int
+	watermark          time.Time
+	nextRun            time.Time
+}
+
+// syncMetrics collects per-pipeline sync statistics for /status and /health and
+// mirrors them into Prometheus instruments, labelled by pipeline, served on /metrics
+type syncMetrics struct {
+	mu    sync.Mutex
+	stats map[string]*pipelineStats
+
+	handler        http.Handler
+	rowsFetched    *prometheus.CounterVec
+	docsIndexed    *prometheus.CounterVec
+	docsFailed     *prometheus.CounterVec
+	syncErrors     *prometheus.CounterVec
+	syncs          *prometheus.CounterVec
+	docsPruned     *prometheus.CounterVec
+	indicesDropped *prometheus.CounterVec
+	syncDuration   *prometheus.GaugeVec
+	lastSuccess    *prometheus.GaugeVec
+	circuitOpen    *prometheus.GaugeVec
+}
+
+// newSyncMetrics creates an empty metrics registry
+func newSyncMetrics() *syncMetrics {
+	registry := prometheus.NewRegistry()
+	counter := func(name, help string) *prometheus.CounterVec {
+		c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, []string{"pipeline"})
+		registry.MustRegister(c)
+		return c
+	}
+	gauge := func(name, help string) *prometheus.GaugeVec {
+		g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, []string{"pipeline"})
+		registry.MustRegister(g)
+		return g
+	}
+
+	m := &syncMetrics{
+		stats:          make(map[string]*pipelineStats),
+		handler:        promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+		rowsFetched:    counter("bqsync_rows_fetched_total", "Rows fetched from BigQuery."),
+		docsIndexed:    counter("bqsync_docs_indexed_total", "Documents accepted by OpenSearch."),
+		docsFailed:     counter("bqsync_bulk_failures_total", "Documents OpenSearch permanently rejected."),
+		syncErrors:     counter("bqsync_sync_errors_total", "Sync passes that failed."),
+		syncs:          counter("bqsync_syncs_total", "Sync passes that completed."),
+		docsPruned:     counter("bqsync_docs_pruned_total", "Expired documents deleted by the retention loop."),
+		indicesDropped: counter("bqsync_indices_dropped_total", "Expired daily indices deleted by the retention loop."),
+		syncDuration:   gauge("bqsync_sync_duration_seconds", "Duration of the last completed sync pass."),
+		lastSuccess:    gauge("bqsync_last_success_timestamp_seconds", "Unix time of the last completed sync pass."),
+		circuitOpen:    gauge("bqsync_circuit_open", "Whether the circuit breaker is pausing the pipeline (1) or not (0)."),
+	}
+	registry.MustRegister(syncLag{m: m, desc: prometheus.NewDesc("bqsync_sync_lag_seconds",
+		"Seconds between now and the newest log timestamp synced.", []string{"pipeline"}, nil)})
+	return m
+}
+
+// syncLag reports the lag of every pipeline when scraped, it grows between sync passes
+type syncLag struct {
+	m    *syncMetrics
+	desc *prometheus.Desc
+}
+
+func (l syncLag) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.desc
+}
+
+func (l syncLag) Collect(ch chan<- prometheus.Metric) {
+	names, snapshot := l.m.snapshot()
+	now := time.Now()
+	for i, name := range names {
+		if st := snapshot[i]; !st.maxSyncedTimestamp.IsZero() {
+			ch <- prometheus.MustNewConstMetric(l.desc, prometheus.GaugeValue, now.Sub(st.maxSyncedTimestamp).Seconds(), name)
+		}
+	}
+}
+
+// pipeline returns the stats of a pipeline, creating them on first use; caller holds mu.
+// Its counters and circuit gauge start at 0, so they are exported before the first pass.
+func (m *syncMetrics) pipeline(name string) *pipelineStats {
+	st, ok := m.stats[name]
+	if !ok {
+		st = &pipelineStats{}
+		m.stats[name] = st
+		for _, c := range []*prometheus.CounterVec{m.rowsFetched, m.docsIndexed, m.docsFailed,
+			m.syncErrors, m.syncs, m.docsPruned, m.indicesDropped} {
+			c.WithLabelValues(name)
+		}
+		m.circuitOpen.WithLabelValues(name).Set(0)
+	}
+	return st
+}
+
+// recordSync stores the outcome of a successful sync pass
+func (m *syncMetrics) recordSync(name string, fetched, failed int, maxTimestamp time.Time, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.pipeline(name)
+	st.syncs++
+	st.rowsFetched += uint64(fetched)
+	st.docsIndexed += uint64(fetched - failed)
+	st.docsFailed += uint64(failed)
+	st.lastDuration = duration
+	st.lastSuccess = time.Now()
+	st.lastFetched = fetched
+	st.lastFailed = failed
+	if maxTimestamp.After(st.maxSyncedTimestamp) {
+		st.maxSyncedTimestamp = maxTimestamp
+	}
+
+	m.syncs.WithLabelValues(name).Inc()
+	m.rowsFetched.WithLabelValues(name).Add(float64(fetched))
+	m.docsIndexed.WithLabelValues(name).Add(float64(fetched - failed))
+	m.docsFailed.WithLabelValues(name).Add(float64(failed))
+	m.syncDuration.WithLabelValues(name).Set(duration.Seconds())
+	m.lastSuccess.WithLabelValues(name).Set(float64(st.lastSuccess.Unix()))
+}
+
+// recordError counts a sync pass that failed before completing and keeps its error
+func (m *syncMetrics) recordError(name string, fetched, failed int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.pipeline(name)
+	st.syncErrors++
+	st.lastError = err.Error()
+	st.lastErrorAt = time.Now()
+	st.rowsFetched += uint64(fetched)
+	st.docsFailed += uint64(failed)
+
+	m.syncErrors.WithLabelValues(name).Inc()
+	m.rowsFetched.WithLabelValues(name).Add(float64(fetched))
+	m.docsFailed.WithLabelValues(name).Add(float64(failed))
+}
+
+// setCircuitOpen records whether the circuit breaker of a pipeline is pausing it
+func (m *syncMetrics) setCircuitOpen(name string, open bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pipeline(name).circuitOpen = open
+	value := 0.0
+	if open {
+		value = 1
+	}
+	m.circuitOpen.WithLabelValues(name).Set(value)
+}
+
+// setWatermark records the time the next sync pass of a pipeline reads from
+func (m *syncMetrics) setWatermark(name string, watermark time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pipeline(name).watermark = watermark
+}
+
+// setNextRun records when the next periodic sync pass of a pipeline is scheduled
+func (m *syncMetrics) setNextRun(name string, next time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pipeline(name).nextRun = next
+}
+
+// get copies the stats of one pipeline
+func (m *syncMetrics) get(name string) pipelineStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return *m.pipeline(name)
+}
+
+// recordPruned counts the documents and dated indices removed by the retention loop
+func (m *syncMetrics) recordPruned(name string, docs, indices int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st := m.pipeline(name)
+	st.docsPruned += uint64(docs)
+	st.indicesDropped += uint64(indices)
+
+	m.docsPruned.WithLabelValues(name).Add(float64(docs))
+	m.indicesDropped.WithLabelValues(name).Add(float64(indices))
+}
+
+// snapshot copies the stats of every pipeline, ordered by pipeline name
+func (m *syncMetrics) snapshot() ([]string, []pipelineStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.stats))
+	for name := range m.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	snapshot := make([]pipelineStats, len(names))
+	for i, name := range names {
+		snapshot[i] = *m.stats[name]
+	}
+	return names, snapshot
+}
+
+// ServeHTTP serves the registered instruments in the Prometheus exposition format
+func (m *syncMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.handler.ServeHTTP(w, r)
+}
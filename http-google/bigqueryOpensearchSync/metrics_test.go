@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// TestMetricsExposition checks /metrics stays parseable whatever the pipeline names hold
+func TestMetricsExposition(t *testing.T) {
+	m := newSyncMetrics()
+	const name = "log\\s \"ünïcode\"\n\x01"
+	m.recordSync(name, 10, 2, time.Now().Add(-time.Minute), time.Second)
+	m.recordError("plain", 3, 1, errors.New("bulk request failed"))
+	m.setCircuitOpen("plain", true)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(rec.Body.String()))
+	if err != nil {
+		t.Fatalf("invalid exposition: %v\n%s", err, rec.Body)
+	}
+
+	value := func(family, pipeline string) float64 {
+		t.Helper()
+		f, ok := families[family]
+		if !ok {
+			t.Fatalf("%s missing", family)
+		}
+		for _, metric := range f.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "pipeline" && label.GetValue() == pipeline {
+					if c := metric.GetCounter(); c != nil {
+						return c.GetValue()
+					}
+					return metric.GetGauge().GetValue()
+				}
+			}
+		}
+		t.Fatalf("%s has no series of pipeline %q", family, pipeline)
+		return 0
+	}
+	if got := value("bqsync_docs_indexed_total", name); got != 8 {
+		t.Errorf("docs indexed %v, want 8", got)
+	}
+	if got := value("bqsync_sync_errors_total", "plain"); got != 1 {
+		t.Errorf("sync errors %v, want 1", got)
+	}
+	if got := value("bqsync_circuit_open", "plain"); got != 1 {
+		t.Errorf("circuit open %v, want 1", got)
+	}
+	if got := value("bqsync_sync_lag_seconds", name); got < 60 {
+		t.Errorf("sync lag %v, want at least a minute", got)
+	}
+}
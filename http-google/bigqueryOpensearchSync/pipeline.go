@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// defaultQueryTemplate selects the Cloud Run stdout log columns mapped onto LogEntry.
+// Templates are rendered with ProjectID, Dataset, Table and Since (the @since_time
+// parameter) and must filter on it.
+const defaultQueryTemplate = `
+		SELECT
+  		  logName,
+  		  resource.type AS resource_type,
+  		  resource.labels.revision_name,
+  		  resource.labels.location,
+  		  resource.labels.project_id,
+  		  resource.labels.configuration_name,
+  		  resource.labels.service_name,
+		  jsonPayload.value AS jsonPayload_value,
+  		  jsonPayload.type AS jsonPayload_type,
+  		  jsonPayload.messages AS message,
+  		  jsonPayload.device_id AS device_id,
+  		  jsonPayload.timestamp AS log_timestamp,
+  		  timestamp,
+  		  receiveTimestamp,
+  		  severity,
+  		  insertId,
+  		  labels.instanceid,
+  		  trace,
+  		  spanId
+		FROM ` + "`{{.ProjectID}}.{{.Dataset}}.{{.Table}}`" + `
+		WHERE timestamp >= {{.Since}}
+		ORDER BY timestamp ASC
+	`
+
+// PipelineConfig maps one BigQuery table onto one OpenSearch index
+type PipelineConfig struct {
+	Name    string `json:"name"`
+	Dataset string `json:"dataset"`
+	Table   string `json:"table"`
+	Index   string `json:"index"`
+
+	// Kind is the table layout: "logs" (default, LogEntry rows) or "metrics"
+	// (MetricEntry device telemetry), selecting the default query and static mapping
+	Kind string `json:"kind,omitempty"`
+
+	// Query overrides the default query of Kind for tables with a different layout;
+	// Fields renames its result columns (document field -> query column) so a
+	// sink with another schema still fills the LogEntry/MetricEntry fields
+	Query  string            `json:"query,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// Filter keeps only matching logs; it is pushed down into the BigQuery query
+	Filter FilterConfig `json:"filter,omitempty"`
+
+	// Mapping selects how the index template is built: "static" (default) or "auto"
+	// to generate it from the BigQuery table schema; TextFields are mapped as full text
+	Mapping    string   `json:"mapping,omitempty"`
+	TextFields []string `json:"text_fields,omitempty"`
+
+	// WriteMode is how documents keyed by insertId are written: "index" (default), "create" or "upsert"
+	WriteMode string `json:"write_mode,omitempty"`
+
+	// Transforms reshape every document before indexing (rename, drop, parse_date,
+	// lowercase, extract_trace_id), applied in order
+	Transforms []TransformConfig `json:"transforms,omitempty"`
+
+	// FieldTypes are type hints (field -> float, long, date, keyword or text) applied
+	// after Transforms, both to the index template and to the indexed documents
+	FieldTypes map[string]string `json:"field_types,omitempty"`
+
+	// Routes send selected severities to their own daily indices with separate
+	// replicas and retention; other severities go to Index as usual
+	Routes []SeverityRoute `json:"routes,omitempty"`
+
+	// Rollover writes into daily indices (Index-YYYY.MM.DD) through WriteAlias,
+	// with ReadAlias spanning all of them; aliases default to Index-write and Index-read
+	Rollover   bool   `json:"rollover,omitempty"`
+	WriteAlias string `json:"write_alias,omitempty"`
+	ReadAlias  string `json:"read_alias,omitempty"`
+
+	// Lookback re-reads this much before the watermark on every pass to catch rows
+	// that reach BigQuery after their timestamp; the watermark then advances to the
+	// newest receiveTimestamp synced instead of the pass start, and insertId keys
+	// keep the overlap from duplicating documents
+	Lookback time.Duration `json:"lookback,omitempty"`
+
+	// SyncInterval and CheckpointFile fall back to service-wide defaults when empty
+	SyncInterval   time.Duration `json:"sync_interval,omitempty"`
+	CheckpointFile string        `json:"checkpoint_file,omitempty"`
+
+	// Lifecycle provisions an ISM policy (hot -> warm -> delete) for the pipeline indices
+	Lifecycle LifecycleConfig `json:"lifecycle,omitempty"`
+
+	// RetentionDays prunes documents older than this many days: dated indices are
+	// dropped with rollover, otherwise expired documents are deleted by query; 0 keeps them
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+// Pipeline is the runtime state of one configured table-to-index sync
+type Pipeline struct {
+	Config     PipelineConfig
+	kind       documentKind
+	query      string
+	params     []bigquery.QueryParameter
+	filter     *logFilter
+	transforms fieldTransforms
+	routes     map[string]string
+	lastSync   time.Time
+	breaker    circuitBreaker
+
+	// catchingUp is set while capped passes work through a backlog, which skips
+	// the lookback so the re-read overlap cannot fill the row cap on its own
+	catchingUp bool
+
+	// currentDaily is the dated index the write alias points to when rolling over,
+	// guarded by rolloverMu since the poller and the Pub/Sub stream both write
+	rolloverMu   sync.Mutex
+	currentDaily string
+}
+
+// resolvePipelines returns the configured pipelines with defaults filled in.
+// Without explicit pipelines the legacy bigquery/opensearch settings define a single one.
+func resolvePipelines(config *Config) ([]PipelineConfig, error) {
+	pipelines := config.Pipelines
+	if len(pipelines) == 0 {
+		pipelines = []PipelineConfig{{
+			Name:           "default",
+			Dataset:        config.BigQuery.Dataset,
+			Table:          config.BigQuery.Table,
+			Index:          config.OpenSearch.Index,
+			CheckpointFile: config.CheckpointFile,
+		}}
+	}
+
+	seen := make(map[string]bool)
+	resolved := make([]PipelineConfig, 0, len(pipelines))
+	for _, p := range pipelines {
+		if p.Name == "" {
+			return nil, fmt.Errorf("pipeline for table %s has no name", p.Table)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate pipeline name %q", p.Name)
+		}
+		seen[p.Name] = true
+
+		if p.Dataset == "" || p.Table == "" || p.Index == "" {
+			return nil, fmt.Errorf("pipeline %q needs dataset, table and index", p.Name)
+		}
+		switch p.Mapping {
+		case "":
+			p.Mapping = mappingStatic
+		case mappingStatic, mappingAuto:
+		default:
+			return nil, fmt.Errorf("pipeline %q: unknown mapping mode %q", p.Name, p.Mapping)
+		}
+		switch p.WriteMode {
+		case "":
+			p.WriteMode = writeModeIndex
+		case writeModeIndex, writeModeCreate, writeModeUpsert:
+		default:
+			return nil, fmt.Errorf("pipeline %q: unknown write mode %q", p.Name, p.WriteMode)
+		}
+		if p.Lookback < 0 {
+			return nil, fmt.Errorf("pipeline %q: lookback must not be negative", p.Name)
+		}
+		if p.RetentionDays < 0 {
+			return nil, fmt.Errorf("pipeline %q: retention_days must not be negative", p.Name)
+		}
+		if err := p.Lifecycle.validate(); err != nil {
+			return nil, fmt.Errorf("pipeline %q: %v", p.Name, err)
+		}
+		if err := validateRoutes(p.Routes); err != nil {
+			return nil, fmt.Errorf("pipeline %q: %v", p.Name, err)
+		}
+		if p.Kind == "" {
+			p.Kind = kindLogs
+		}
+		kind, ok := documentKinds[p.Kind]
+		if !ok {
+			return nil, fmt.Errorf("pipeline %q: unknown kind %q", p.Name, p.Kind)
+		}
+		defaultQuery := p.Query == ""
+		if defaultQuery {
+			p.Query = kind.query
+		}
+		if err := validateFieldMapping(p.Fields, kind, defaultQuery); err != nil {
+			return nil, fmt.Errorf("pipeline %q: %v", p.Name, err)
+		}
+		if err := validateFilter(p.Filter, kind); err != nil {
+			return nil, fmt.Errorf("pipeline %q: %v", p.Name, err)
+		}
+		if p.Rollover {
+			if p.WriteAlias == "" {
+				p.WriteAlias = p.Index + "-write"
+			}
+			if p.ReadAlias == "" {
+				p.ReadAlias = p.Index + "-read"
+			}
+		}
+		if p.SyncInterval <= 0 {
+			p.SyncInterval = config.SyncInterval
+		}
+		if p.CheckpointFile == "" {
+			p.CheckpointFile = fmt.Sprintf("sync-checkpoint-%s.json", p.Name)
+		}
+		resolved = append(resolved, p)
+	}
+	return resolved, nil
+}
+
+// newPipeline renders the query template and restores the pipeline watermark
+func newPipeline(cfg PipelineConfig, projectID string) (*Pipeline, error) {
+	tmpl, err := template.New(cfg.Name).Parse(cfg.Query)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %q: invalid query template: %v", cfg.Name, err)
+	}
+
+	var query strings.Builder
+	err = tmpl.Execute(&query, struct {
+		ProjectID, Dataset, Table, Since string
+	}{projectID, cfg.Dataset, cfg.Table, "@since_time"})
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %q: failed to render query template: %v", cfg.Name, err)
+	}
+
+	filter, err := newLogFilter(cfg.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %q: %v", cfg.Name, err)
+	}
+	// columns are renamed first so the filter sees the document field names
+	filtered, params := filter.apply(applyFieldMapping(query.String(), cfg.Fields))
+
+	transforms, err := newFieldTransforms(withFieldTypes(cfg.Transforms, cfg.FieldTypes))
+	if err != nil {
+		return nil, fmt.Errorf("pipeline %q: %v", cfg.Name, err)
+	}
+
+	// restore the watermark from the checkpoint, otherwise start one interval back
+	lastSync := time.Now().Add(-cfg.SyncInterval)
+	saved, ok, err := loadCheckpoint(cfg.CheckpointFile)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		lastSync = saved
+		syncLog.Info("resuming from checkpoint", "pipeline", cfg.Name, "checkpoint", cfg.CheckpointFile, "last_sync", lastSync)
+	} else {
+		syncLog.Info("no checkpoint found", "pipeline", cfg.Name, "checkpoint", cfg.CheckpointFile, "from", lastSync)
+	}
+
+	return &Pipeline{
+		Config:     cfg,
+		kind:       documentKinds[cfg.Kind],
+		query:      filtered,
+		params:     params,
+		filter:     filter,
+		transforms: transforms,
+		routes:     newRouteTable(cfg.Routes),
+		lastSync:   lastSync,
+	}, nil
+}
+
+// runPipelines starts every pipeline in its own goroutine and waits until all stop.
+// ctx stops the loops, work is used by the syncs so an in-flight pass can complete.
+func (s *SyncService) runPipelines(ctx, work context.Context) {
+	var wg sync.WaitGroup
+	for _, p := range s.pipelines {
+		wg.Add(1)
+		go func(p *Pipeline) {
+			defer wg.Done()
+			s.runPipeline(ctx, work, p)
+		}(p)
+	}
+	wg.Wait()
+}
+
+// runPipeline performs the initial sync of a pipeline and then syncs on its own interval
+func (s *SyncService) runPipeline(ctx, work context.Context, p *Pipeline) {
+	name := p.Config.Name
+
+	// create index
+	if err := s.createIndexTemplate(work, p); err != nil {
+		syncLog.Warn("failed to create index template", "pipeline", name, "error", err)
+	}
+
+	s.metrics.setWatermark(name, p.lastSync)
+
+	// init
+	syncLog.Info("starting initial sync", "pipeline", name)
+	if failed, err := s.syncOnce(work, p); err != nil {
+		syncLog.Error("initial sync failed", "pipeline", name, "error", err)
+	} else if failed > 0 {
+		syncLog.Warn("initial sync finished with permanently failed documents", "pipeline", name, "failed", failed)
+	}
+
+	// ticker sync
+	ticker := time.NewTicker(p.Config.SyncInterval)
+	defer ticker.Stop()
+	s.metrics.setNextRun(name, time.Now().Add(p.Config.SyncInterval))
+
+	syncLog.Info("starting periodic sync", "pipeline", name, "table", p.Config.Dataset+"."+p.Config.Table,
+		"index", p.Config.Index, "interval", p.Config.SyncInterval.String())
+
+	for {
+		select {
+		case <-ctx.Done():
+			syncLog.Info("pipeline stopped", "pipeline", name)
+			return
+		case <-ticker.C:
+			s.metrics.setNextRun(name, time.Now().Add(p.Config.SyncInterval))
+			if failed, err := s.syncOnce(work, p); err != nil {
+				syncLog.Error("sync failed", "pipeline", name, "error", err)
+				// 可以添加重试逻辑或报警
+			} else if failed > 0 {
+				syncLog.Warn("sync finished with permanently failed documents", "pipeline", name, "failed", failed)
+			}
+		}
+	}
+}
+
+// syncSince returns the start of the next periodic pass: the watermark, moved
+// back by the lookback window unless a capped backlog is being worked through
+func (p *Pipeline) syncSince() time.Time {
+	if p.catchingUp {
+		return p.lastSync
+	}
+	return p.lastSync.Add(-p.Config.Lookback)
+}
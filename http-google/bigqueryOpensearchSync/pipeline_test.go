@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestResolvePipelinesFilter checks a filter on a field missing from the rows of the
+// pipeline kind fails at startup instead of in the first BigQuery query
+func TestResolvePipelinesFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		filter  FilterConfig
+		wantErr string
+	}{
+		{"logs by severity", kindLogs, FilterConfig{Exclude: map[string][]string{"severity": {"DEBUG"}}}, ""},
+		{"metrics by device", kindMetrics, FilterConfig{Include: map[string][]string{"device_id": {"device-001"}}}, ""},
+		{"metrics by severity", kindMetrics, FilterConfig{Exclude: map[string][]string{"severity": {"DEBUG"}}}, `filter field "severity"`},
+		{"metrics by service", kindMetrics, FilterConfig{Include: map[string][]string{"service_name": {"server"}}}, `filter field "service_name"`},
+		{"unknown field", kindLogs, FilterConfig{Include: map[string][]string{"message": {"boot"}}}, `unknown filter field "message"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Pipelines: []PipelineConfig{{
+				Name: "test", Dataset: "telemetry", Table: "rows", Index: "rows", Kind: tt.kind, Filter: tt.filter,
+			}}}
+			_, err := resolvePipelines(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestResolvePipelinesFieldMapping checks a mapping onto a column the default query already
+// selects is rejected, the renamed query would select the column twice
+func TestResolvePipelinesFieldMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		fields  map[string]string
+		wantErr string
+	}{
+		{"custom query", "SELECT ts AS timestamp, msg FROM t WHERE ts >= {{.Since}}", map[string]string{"message": "msg"}, ""},
+		{"same column", "", map[string]string{"severity": "severity"}, ""},
+		{"swapped columns", "", map[string]string{"message": "severity", "severity": "message"}, ""},
+		{"onto an existing column", "", map[string]string{"message": "severity"}, `"message" is already a column of the query`},
+		{"chained onto an existing column", "", map[string]string{"message": "trace", "trace": "spanId"}, `"message" is already a column of the query`},
+		{"unknown field", "", map[string]string{"msg": "message"}, `unknown document field "msg"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{Pipelines: []PipelineConfig{{
+				Name: "test", Dataset: "logs", Table: "rows", Index: "rows", Query: tt.query, Fields: tt.fields,
+			}}}
+			_, err := resolvePipelines(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %s", err, tt.wantErr)
+			}
+		})
+	}
+}
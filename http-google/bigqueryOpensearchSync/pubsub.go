@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultStreamFlushInterval bounds how long a pushed entry waits before its batch is indexed
+const defaultStreamFlushInterval = time.Second
+
+// pushRequest is the body of a Pub/Sub push delivery; Data is base64 in JSON
+type pushRequest struct {
+	Message struct {
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+		MessageID  string            `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// cloudLogEntry is the Cloud Logging LogEntry as published by a log sink
+type cloudLogEntry struct {
+	LogName  string `json:"logName"`
+	Resource struct {
+		Type   string            `json:"type"`
+		Labels map[string]string `json:"labels"`
+	} `json:"resource"`
+	JSONPayload struct {
+		Value     interface{} `json:"value"`
+		Type      string      `json:"type"`
+		Messages  string      `json:"messages"`
+		DeviceID  string      `json:"device_id"`
+		Timestamp string      `json:"timestamp"`
+	} `json:"jsonPayload"`
+	TextPayload      string            `json:"textPayload"`
+	Timestamp        time.Time         `json:"timestamp"`
+	ReceiveTimestamp time.Time         `json:"receiveTimestamp"`
+	Severity         string            `json:"severity"`
+	InsertID         string            `json:"insertId"`
+	Labels           map[string]string `json:"labels"`
+	Trace            string            `json:"trace"`
+	SpanID           string            `json:"spanId"`
+}
+
+// toLogEntry flattens a Cloud Logging entry the same way the BigQuery query does
+func (e *cloudLogEntry) toLogEntry() *LogEntry {
+	// value is numeric for device metrics but may be anything in other logs
+	value, _ := e.JSONPayload.Value.(float64)
+
+	message := e.JSONPayload.Messages
+	if message == "" {
+		message = e.TextPayload
+	}
+	return &LogEntry{
+		LogName:           e.LogName,
+		ResourceType:      e.Resource.Type,
+		RevisionName:      e.Resource.Labels["revision_name"],
+		Location:          e.Resource.Labels["location"],
+		ProjectID:         e.Resource.Labels["project_id"],
+		ConfigurationName: e.Resource.Labels["configuration_name"],
+		ServiceName:       e.Resource.Labels["service_name"],
+		JSONPayloadValue:  float32(value),
+		JSONPayloadType:   e.JSONPayload.Type,
+		Message:           message,
+		DeviceID:          e.JSONPayload.DeviceID,
+		LogTimestamp:      e.JSONPayload.Timestamp,
+		Timestamp:         e.Timestamp,
+		ReceiveTimestamp:  e.ReceiveTimestamp,
+		Severity:          e.Severity,
+		InsertID:          e.InsertID,
+		InstanceID:        e.Labels["instanceId"],
+		Trace:             e.Trace,
+		SpanID:            e.SpanID,
+	}
+}
+
+// streamItem is a pushed entry waiting to be indexed; done receives the outcome
+type streamItem struct {
+	entry *LogEntry
+	done  chan error
+}
+
+// streamIngester indexes entries pushed by Pub/Sub in small batches, acknowledging
+// each push only once its batch reached OpenSearch so failures are redelivered
+type streamIngester struct {
+	service  *SyncService
+	pipeline *Pipeline
+	items    chan streamItem
+	stopped  chan struct{}
+}
+
+// newStreamIngester binds the Pub/Sub push endpoint to the configured pipeline,
+// by default the first logs pipeline
+func newStreamIngester(s *SyncService) (*streamIngester, error) {
+	name := s.config.PubSub.Pipeline
+	for _, p := range s.pipelines {
+		if name != "" && p.Config.Name != name {
+			continue
+		}
+		// pushed entries are Cloud Logging entries, only a logs pipeline can index them
+		if p.Config.Kind != kindLogs {
+			if name == "" {
+				continue
+			}
+			return nil, fmt.Errorf("pubsub: pipeline %q does not sync logs", name)
+		}
+		return &streamIngester{
+			service:  s,
+			pipeline: p,
+			items:    make(chan streamItem),
+			stopped:  make(chan struct{}),
+		}, nil
+	}
+	return nil, fmt.Errorf("pubsub: no pipeline named %q", name)
+}
+
+// run batches pushed entries and indexes them until ctx is cancelled, indexing
+// with work so the batch pending at shutdown is still flushed
+func (si *streamIngester) run(ctx, work context.Context) {
+	defer close(si.stopped)
+
+	maxDocs, _, _ := si.service.bulkLimits()
+	interval := si.service.config.PubSub.FlushInterval
+	if interval <= 0 {
+		interval = defaultStreamFlushInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pending []streamItem
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		err := si.index(work, pending)
+		for _, item := range pending {
+			item.done <- err
+		}
+		pending = nil
+	}
+
+	pubsubLog.Info("streaming Pub/Sub pushes", "pipeline", si.pipeline.Config.Name, "path", si.service.config.PubSub.PushPath)
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case item := <-si.items:
+			pending = append(pending, item)
+			if len(pending) >= maxDocs {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// index bulk-indexes one batch of pushed entries into the pipeline write target
+func (si *streamIngester) index(ctx context.Context, items []streamItem) error {
+	start := time.Now()
+	p := si.pipeline
+
+	entries := make([]syncDocument, len(items))
+	var maxTimestamp time.Time
+	for i, item := range items {
+		entries[i] = item.entry
+		if item.entry.Timestamp.After(maxTimestamp) {
+			maxTimestamp = item.entry.Timestamp
+		}
+	}
+
+	target, err := si.service.writeTarget(ctx, p)
+	if err != nil {
+		return fmt.Errorf("failed to resolve write index: %v", err)
+	}
+
+	failed, err := si.service.sendRouted(ctx, p, target, entries)
+	if err != nil {
+		si.service.metrics.recordError(p.Config.Name+"-pubsub", len(entries), failed, err)
+		return err
+	}
+	si.service.metrics.recordSync(p.Config.Name+"-pubsub", len(entries), failed, maxTimestamp, time.Since(start))
+	return nil
+}
+
+// ServeHTTP handles a Pub/Sub push delivery of one Cloud Logging entry
+func (si *streamIngester) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// push subscriptions are configured with ?token=... to authenticate the sender
+	token := si.service.config.PubSub.Token
+	if token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var push pushRequest
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		http.Error(w, "invalid push body", http.StatusBadRequest)
+		return
+	}
+
+	var entry cloudLogEntry
+	if err := json.Unmarshal(push.Message.Data, &entry); err != nil {
+		// acknowledge undecodable messages, redelivering them would never succeed
+		pubsubLog.Warn("dropping undecodable Pub/Sub message", "pipeline", si.pipeline.Config.Name,
+			"message_id", push.Message.MessageID, "error", err)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	logEntry := entry.toLogEntry()
+	if !si.pipeline.filter.match(logEntry) {
+		// filtered out like the BigQuery query would, nothing to index
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	item := streamItem{entry: logEntry, done: make(chan error, 1)}
+	select {
+	case si.items <- item:
+	case <-si.stopped:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		http.Error(w, "request cancelled", http.StatusServiceUnavailable)
+		return
+	}
+
+	// a non-2xx answer makes Pub/Sub redeliver the message later
+	if err := <-item.done; err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.Canceled) {
+			status = http.StatusServiceUnavailable
+		}
+		pubsubLog.Error("failed to index Pub/Sub message", "pipeline", si.pipeline.Config.Name,
+			"message_id", push.Message.MessageID, "error", err)
+		http.Error(w, "indexing failed", status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
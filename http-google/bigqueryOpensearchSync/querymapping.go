@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// columnName matches the plain BigQuery column names a field mapping may refer to
+var columnName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// rowFields returns the BigQuery column names a row struct is loaded from
+func rowFields(row syncDocument) map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(row).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("bigquery"); tag != "" && tag != "-" {
+			fields[tag] = true
+		}
+	}
+	return fields
+}
+
+// validateFieldMapping checks that every mapped field exists in the row struct of
+// the pipeline kind and that every source is a plain column of the query result.
+// With the default query of the kind, whose columns are the row fields, a field
+// can only be mapped when its own column is renamed away too, otherwise the
+// result would have it twice
+func validateFieldMapping(fields map[string]string, kind documentKind, defaultQuery bool) error {
+	known := rowFields(kind.newRow())
+	sources := make(map[string]bool, len(fields))
+	for _, column := range fields {
+		sources[column] = true
+	}
+	for field, column := range fields {
+		if !known[field] {
+			return fmt.Errorf("unknown document field %q in fields", field)
+		}
+		if !columnName.MatchString(column) {
+			return fmt.Errorf("fields: %q is not a column name", column)
+		}
+		if defaultQuery && field != column && !sources[field] {
+			return fmt.Errorf("fields: %q is already a column of the query, mapping %q to it would select it twice", field, column)
+		}
+	}
+	return nil
+}
+
+// applyFieldMapping wraps the rendered query so its columns are renamed to the
+// document fields they are mapped to; unmapped columns are kept as they are
+func applyFieldMapping(query string, fields map[string]string) string {
+	if len(fields) == 0 {
+		return query
+	}
+
+	// sorted so the generated query is stable across runs
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	var (
+		renamed []string
+		except  []string
+		seen    = make(map[string]bool)
+	)
+	for _, field := range names {
+		column := fields[field]
+		renamed = append(renamed, fmt.Sprintf("%s AS %s", column, field))
+		if !seen[column] {
+			seen[column] = true
+			except = append(except, column)
+		}
+	}
+
+	return fmt.Sprintf("SELECT * EXCEPT(%s), %s FROM (%s) ORDER BY timestamp ASC",
+		strings.Join(except, ", "), strings.Join(renamed, ", "), query)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// minRateFraction is how far throttling may lower the indexing rate, as a fraction of the configured rate
+const minRateFraction = 16
+
+// RateLimitConfig protects small clusters from a large backlog. DocsPerSecond caps
+// the documents sent per second, halving on 429/circuit_breaking_exception and
+// recovering gradually; MaxConcurrentBulks caps the bulk requests in flight across
+// all pipelines. 0 leaves a limit off.
+type RateLimitConfig struct {
+	DocsPerSecond      int `json:"docs_per_second,omitempty"`
+	MaxConcurrentBulks int `json:"max_concurrent_bulks,omitempty"`
+}
+
+// indexLimiter applies the rate limit to every bulk request of the service
+type indexLimiter struct {
+	slots chan struct{}
+
+	mu      sync.Mutex
+	limiter *rate.Limiter
+	max     rate.Limit
+}
+
+// newIndexLimiter creates the limiter of the configured limits, nil when none is set
+func newIndexLimiter(c RateLimitConfig) *indexLimiter {
+	if c.DocsPerSecond <= 0 && c.MaxConcurrentBulks <= 0 {
+		return nil
+	}
+
+	l := &indexLimiter{}
+	if c.MaxConcurrentBulks > 0 {
+		l.slots = make(chan struct{}, c.MaxConcurrentBulks)
+	}
+	if c.DocsPerSecond > 0 {
+		l.max = rate.Limit(c.DocsPerSecond)
+		l.limiter = rate.NewLimiter(l.max, c.DocsPerSecond)
+	}
+	return l
+}
+
+// acquire waits for a bulk slot and for docs tokens; release frees the slot
+func (l *indexLimiter) acquire(ctx context.Context, docs int) (release func(), err error) {
+	release = func() {}
+	if l == nil {
+		return release, nil
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+			release = func() { <-l.slots }
+		case <-ctx.Done():
+			return release, ctx.Err()
+		}
+	}
+
+	if l.limiter != nil {
+		// WaitN refuses more than the burst at once, so large batches wait in steps
+		burst := l.limiter.Burst()
+		for docs > 0 {
+			n := min(docs, burst)
+			if err := l.limiter.WaitN(ctx, n); err != nil {
+				release()
+				return func() {}, err
+			}
+			docs -= n
+		}
+	}
+	return release, nil
+}
+
+// throttled halves the rate after the cluster pushed back
+func (l *indexLimiter) throttled() {
+	if l == nil || l.limiter == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := l.limiter.Limit()
+	lowered := max(current/2, l.max/minRateFraction)
+	if lowered < current {
+		l.limiter.SetLimit(lowered)
+		bulkLog.Warn("cluster is throttling, lowering indexing rate", "docs_per_second", float64(lowered))
+	}
+}
+
+// succeeded raises a throttled rate by a tenth of the configured one
+func (l *indexLimiter) succeeded() {
+	if l == nil || l.limiter == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current := l.limiter.Limit()
+	if current < l.max {
+		l.limiter.SetLimit(min(current+l.max/10, l.max))
+	}
+}
+
+// isThrottled reports whether a bulk item was rejected because the cluster is overloaded
+func isThrottled(item bulkItem) bool {
+	if item.Status == http.StatusTooManyRequests {
+		return true
+	}
+	return item.Error != nil && item.Error.Type == "circuit_breaking_exception"
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// reindexPollInterval is how often the progress of a running _reindex task is checked
+const reindexPollInterval = 10 * time.Second
+
+// ReindexOptions selects the pipelines whose indices are rebuilt with the current template
+type ReindexOptions struct {
+	Pipeline string // empty means every pipeline
+
+	// DeleteSources deletes the old indices once their copies hold all of their
+	// documents, otherwise they are kept write-blocked
+	DeleteSources bool
+}
+
+// reindexSuffix separates the index a reindex copied from the time of the reindex,
+// so the copy of a daily index keeps its day for retention
+const reindexSuffix = "-reindex-"
+
+// reindexIndexName returns the index a reindex started at stamp copies source into;
+// the suffix of an earlier reindex is replaced, not stacked
+func reindexIndexName(source, stamp string) string {
+	base, _, _ := strings.Cut(source, reindexSuffix)
+	return base + reindexSuffix + stamp
+}
+
+// Reindex rebuilds the indices of the selected pipelines after a mapping change
+func (s *SyncService) Reindex(ctx context.Context, opts ReindexOptions) error {
+	matched := false
+	for _, p := range s.pipelines {
+		if opts.Pipeline != "" && p.Config.Name != opts.Pipeline {
+			continue
+		}
+		matched = true
+		if err := s.reindexPipeline(ctx, p, opts); err != nil {
+			return fmt.Errorf("pipeline %q: %v", p.Config.Name, err)
+		}
+	}
+	if !matched {
+		return fmt.Errorf("no pipeline named %q", opts.Pipeline)
+	}
+	return nil
+}
+
+// reindexPipeline copies every index of a pipeline into a new one created from the
+// updated template, each daily index into its own copy, then atomically points the
+// pipeline aliases at the copies. The old indices are write-blocked before the final
+// catch-up copy, the sync passes failing meanwhile are synced again through the
+// aliases once they point to the copies, so no document written during the reindex
+// is left behind. A plain index is replaced by an alias of the same name, which
+// deletes it in the swap, so its copy is checked first.
+func (s *SyncService) reindexPipeline(ctx context.Context, p *Pipeline, opts ReindexOptions) error {
+	if err := s.createIndexTemplate(ctx, p); err != nil {
+		return err
+	}
+
+	alias := readTarget(p)
+	sources, err := s.aliasIndices(ctx, alias)
+	if err != nil {
+		return err
+	}
+	concrete := len(sources) == 0
+	if concrete && p.Config.Rollover {
+		return fmt.Errorf("read alias %s points to no index, nothing to reindex", alias)
+	}
+	if concrete {
+		sources = []string{alias}
+	}
+	sort.Strings(sources)
+
+	stamp := time.Now().UTC().Format("20060102150405")
+	dests := make(map[string]string, len(sources))
+	for _, source := range sources {
+		dest := reindexIndexName(source, stamp)
+		if err := s.createIndexIfMissing(ctx, dest); err != nil {
+			return err
+		}
+		clusterLog.Info("reindexing", "pipeline", p.Config.Name, "from", source, "to", dest)
+		if err := s.copyDocuments(ctx, []string{source}, dest); err != nil {
+			return err
+		}
+		dests[source] = dest
+	}
+
+	if concrete {
+		// the index is deleted by the swap, so it stops taking writes and what was
+		// written since the first pass is copied before it goes
+		if err := s.setWriteBlock(ctx, sources, true); err != nil {
+			return err
+		}
+		err := s.catchUp(ctx, dests)
+		if err == nil {
+			err = s.swapAliases(ctx, p, dests, true)
+		}
+		if err != nil {
+			if unblockErr := s.setWriteBlock(ctx, sources, false); unblockErr != nil {
+				clusterLog.Error("failed to lift the write block, the pipeline can't sync until it is removed",
+					"pipeline", p.Config.Name, "index", alias, "error", unblockErr)
+			}
+			return err
+		}
+		clusterLog.Info("reindex completed, index replaced by an alias", "pipeline", p.Config.Name,
+			"alias", alias, "index", dests[alias])
+		return nil
+	}
+
+	if err := s.swapAliases(ctx, p, dests, false); err != nil {
+		return err
+	}
+	// writes already go to the copies, a bulk request still aimed at an old index fails and is retried
+	if err := s.setWriteBlock(ctx, sources, true); err != nil {
+		return err
+	}
+	if err := s.catchUp(ctx, dests); err != nil {
+		return err
+	}
+	if !opts.DeleteSources {
+		clusterLog.Info("reindex completed, old indices kept write-blocked", "pipeline", p.Config.Name,
+			"alias", alias, "old_indices", sources)
+		return nil
+	}
+	if err := s.deleteIndices(ctx, sources); err != nil {
+		return err
+	}
+	clusterLog.Info("reindex completed, old indices deleted", "pipeline", p.Config.Name,
+		"alias", alias, "old_indices", sources)
+	return nil
+}
+
+// catchUp copies what was written into the write-blocked sources since the first pass
+// and checks every copy holds at least the documents of its source
+func (s *SyncService) catchUp(ctx context.Context, dests map[string]string) error {
+	for source, dest := range dests {
+		if err := s.copyDocuments(ctx, []string{source}, dest); err != nil {
+			return err
+		}
+		copied, err := s.countDocuments(ctx, dest)
+		if err != nil {
+			return err
+		}
+		// the source no longer changes, the copy may already hold newer writes
+		total, err := s.countDocuments(ctx, source)
+		if err != nil {
+			return err
+		}
+		if copied < total {
+			return fmt.Errorf("%s holds %d documents, fewer than the %d of %s", dest, copied, total, source)
+		}
+	}
+	return nil
+}
+
+// countDocuments refreshes an index and returns the number of its documents
+func (s *SyncService) countDocuments(ctx context.Context, index string) (int, error) {
+	refresh := opensearchapi.IndicesRefreshRequest{
+		Index: []string{index},
+	}
+	res, err := refresh.Do(ctx, s.osClient)
+	if err != nil {
+		return 0, fmt.Errorf("failed to refresh %s: %v", index, err)
+	}
+	res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("failed to refresh %s: %s", index, res.Status())
+	}
+
+	req := opensearchapi.CountRequest{
+		Index: []string{index},
+	}
+	res, err = req.Do(ctx, s.osClient)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the documents of %s: %v", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return 0, fmt.Errorf("failed to count the documents of %s: %s", index, res.Status())
+	}
+	var parsed struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return 0, fmt.Errorf("failed to decode the count of %s: %v", index, err)
+	}
+	return parsed.Count, nil
+}
+
+// deleteIndices deletes indices a reindex has copied
+func (s *SyncService) deleteIndices(ctx context.Context, indices []string) error {
+	req := opensearchapi.IndicesDeleteRequest{
+		Index: indices,
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to delete indices %v: %v", indices, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to delete indices %v: %s", indices, res.Status())
+	}
+	return nil
+}
+
+// setWriteBlock sets or lifts the write block of indices. Bulk requests into a
+// blocked index fail, so the sync pass retries its window instead of losing it.
+func (s *SyncService) setWriteBlock(ctx context.Context, indices []string, blocked bool) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"index": map[string]interface{}{"blocks": map[string]interface{}{"write": blocked}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal index settings: %v", err)
+	}
+
+	req := opensearchapi.IndicesPutSettingsRequest{
+		Index: indices,
+		Body:  strings.NewReader(string(body)),
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to set the write block of %v: %v", indices, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to set the write block of %v: %s", indices, res.Status())
+	}
+	return nil
+}
+
+// copyDocuments runs a _reindex task from sources into dest and waits for it.
+// Documents already in dest are skipped, so running it again only copies new ones.
+func (s *SyncService) copyDocuments(ctx context.Context, sources []string, dest string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"conflicts": "proceed",
+		"source":    map[string]interface{}{"index": sources},
+		"dest":      map[string]interface{}{"index": dest, "op_type": "create"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal reindex request: %v", err)
+	}
+
+	wait := false
+	req := opensearchapi.ReindexRequest{
+		Body:              strings.NewReader(string(body)),
+		WaitForCompletion: &wait,
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to start reindex into %s: %v", dest, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to start reindex into %s: %s", dest, res.Status())
+	}
+
+	var started struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&started); err != nil {
+		return fmt.Errorf("failed to decode reindex response: %v", err)
+	}
+	return s.waitReindexTask(ctx, started.Task, dest)
+}
+
+// waitReindexTask polls a _reindex task until it completes, logging its progress
+func (s *SyncService) waitReindexTask(ctx context.Context, task, dest string) error {
+	ticker := time.NewTicker(reindexPollInterval)
+	defer ticker.Stop()
+
+	for {
+		status, err := s.reindexTaskStatus(ctx, task)
+		if err != nil {
+			return err
+		}
+		if status.Completed {
+			if status.Error != nil {
+				return fmt.Errorf("reindex into %s failed: %s: %s", dest, status.Error.Type, status.Error.Reason)
+			}
+			if n := len(status.Response.Failures); n > 0 {
+				return fmt.Errorf("reindex into %s failed for %d documents", dest, n)
+			}
+			clusterLog.Info("reindex pass completed", "index", dest, "created", status.Response.Created,
+				"skipped", status.Response.VersionConflicts)
+			return nil
+		}
+		clusterLog.Info("reindex in progress", "index", dest, "copied", status.Task.Status.Created,
+			"total", status.Task.Status.Total)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// reindexTask is the part of the tasks API response used to follow a _reindex
+type reindexTask struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status struct {
+			Total   int `json:"total"`
+			Created int `json:"created"`
+		} `json:"status"`
+	} `json:"task"`
+	Response struct {
+		Created          int               `json:"created"`
+		VersionConflicts int               `json:"version_conflicts"`
+		Failures         []json.RawMessage `json:"failures"`
+	} `json:"response"`
+	Error *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// reindexTaskStatus reads the status of a _reindex task
+func (s *SyncService) reindexTaskStatus(ctx context.Context, task string) (reindexTask, error) {
+	var status reindexTask
+
+	req := opensearchapi.TasksGetRequest{
+		TaskID: task,
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return status, fmt.Errorf("failed to get reindex task %s: %v", task, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return status, fmt.Errorf("failed to get reindex task %s: %s", task, res.Status())
+	}
+	if err := json.NewDecoder(res.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("failed to decode reindex task %s: %v", task, err)
+	}
+	return status, nil
+}
+
+// swapAliases moves the read and write aliases of a pipeline from every source in
+// dests to its copy in a single request. With replace the single source is a plain
+// index that is deleted in the same request and recreated as an alias of its copy.
+func (s *SyncService) swapAliases(ctx context.Context, p *Pipeline, dests map[string]string, replace bool) error {
+	var actions []map[string]interface{}
+
+	if !p.Config.Rollover {
+		// the alias named after the index both reads and writes
+		for source, dest := range dests {
+			remove := map[string]interface{}{
+				"remove": map[string]interface{}{"index": source, "alias": p.Config.Index},
+			}
+			if replace {
+				remove = map[string]interface{}{
+					"remove_index": map[string]interface{}{"index": source},
+				}
+			}
+			actions = append(actions, remove, map[string]interface{}{
+				"add": map[string]interface{}{
+					"index":          dest,
+					"alias":          p.Config.Index,
+					"is_write_index": true,
+				},
+			})
+		}
+	} else {
+		for source, dest := range dests {
+			actions = append(actions,
+				map[string]interface{}{
+					"remove": map[string]interface{}{"index": source, "alias": p.Config.ReadAlias},
+				},
+				map[string]interface{}{
+					"add": map[string]interface{}{"index": dest, "alias": p.Config.ReadAlias},
+				},
+			)
+		}
+		// the copy of the daily index taking writes takes them until the next day moves the write alias on
+		holders, err := s.aliasIndices(ctx, p.Config.WriteAlias)
+		if err != nil {
+			return err
+		}
+		for _, index := range holders {
+			dest, ok := dests[index]
+			if !ok {
+				continue
+			}
+			actions = append(actions,
+				map[string]interface{}{
+					"remove": map[string]interface{}{"index": index, "alias": p.Config.WriteAlias},
+				},
+				map[string]interface{}{
+					"add": map[string]interface{}{
+						"index":          dest,
+						"alias":          p.Config.WriteAlias,
+						"is_write_index": true,
+					},
+				},
+			)
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alias actions: %v", err)
+	}
+
+	req := opensearchapi.IndicesUpdateAliasesRequest{
+		Body: strings.NewReader(string(body)),
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to swap aliases: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to swap aliases: %s", res.Status())
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestReindexedDailyKeepsItsDay checks the copy a reindex makes of a daily index is
+// still dropped by retention on the day of its source
+func TestReindexedDailyKeepsItsDay(t *testing.T) {
+	const stamp = "20251016093000"
+	day := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	daily := dailyIndexName("gcp-logs", day)
+
+	copied := reindexIndexName(daily, stamp)
+	if copied != "gcp-logs-2025.07.01-reindex-20251016093000" {
+		t.Fatalf("copy of %s named %s", daily, copied)
+	}
+	if again := reindexIndexName(copied, "20251017093000"); again != "gcp-logs-2025.07.01-reindex-20251017093000" {
+		t.Fatalf("second copy of %s named %s", daily, again)
+	}
+
+	tests := []struct {
+		index string
+		want  time.Time
+		ok    bool
+	}{
+		{daily, day, true},
+		{copied, day, true},
+		{reindexIndexName("gcp-logs", stamp), time.Time{}, false},
+		{"gcp-logs-errors-2025.07.01", time.Time{}, false},
+		{"other-2025.07.01", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := indexDay("gcp-logs", tt.index)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("indexDay(%s) = %v, %v, want %v, %v", tt.index, got, ok, tt.want, tt.ok)
+		}
+	}
+}
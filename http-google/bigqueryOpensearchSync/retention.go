@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// defaultRetentionInterval is how often expired documents are pruned when not configured
+const defaultRetentionInterval = time.Hour
+
+// retentionCutoff returns the time before which documents of a pipeline expire
+func retentionCutoff(p *Pipeline, now time.Time) time.Time {
+	return now.UTC().AddDate(0, 0, -p.Config.RetentionDays)
+}
+
+// runRetention prunes the pipelines with a retention period every RetentionInterval
+// until ctx is cancelled; deletes are idempotent, so every replica may run it
+func (s *SyncService) runRetention(ctx context.Context) {
+	interval := s.config.RetentionInterval
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+
+	retentionLog.Info("pruning expired documents", "interval", interval.String())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.pruneAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneAll applies the retention period of every pipeline that has one
+func (s *SyncService) pruneAll(ctx context.Context) {
+	for _, p := range s.pipelines {
+		if p.Config.RetentionDays <= 0 {
+			continue
+		}
+		if err := s.pruneExpired(ctx, p); err != nil {
+			retentionLog.Warn("failed to prune expired documents", "pipeline", p.Config.Name, "error", err)
+		}
+	}
+}
+
+// pruneExpired drops the dated indices of a rolling pipeline that lie entirely
+// before the cutoff, or deletes the expired documents of a plain index by query
+func (s *SyncService) pruneExpired(ctx context.Context, p *Pipeline) error {
+	cutoff := retentionCutoff(p, time.Now())
+	if p.Config.Rollover {
+		return s.dropExpiredIndices(ctx, p, cutoff)
+	}
+	return s.deleteExpiredDocuments(ctx, p, cutoff)
+}
+
+// dropExpiredIndices deletes the daily indices whose whole day precedes cutoff
+func (s *SyncService) dropExpiredIndices(ctx context.Context, p *Pipeline, cutoff time.Time) error {
+	indices, err := s.listIndices(ctx, p.Config.Index+"-*")
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	for _, index := range indices {
+		day, ok := indexDay(p.Config.Index, index)
+		if !ok {
+			continue // not one of the dated indices of this pipeline
+		}
+		if !day.AddDate(0, 0, 1).After(cutoff) {
+			expired = append(expired, index)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	req := opensearchapi.IndicesDeleteRequest{
+		Index: expired,
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to delete indices %v: %v", expired, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to delete indices %v: %s", expired, res.Status())
+	}
+
+	s.metrics.recordPruned(p.Config.Name, 0, len(expired))
+	retentionLog.Info("dropped expired indices", "pipeline", p.Config.Name,
+		"retention_days", p.Config.RetentionDays, "indices", expired)
+	return nil
+}
+
+// indexDay returns the day of a daily index of base, or of the copy a reindex made of one
+func indexDay(base, index string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(index, base+"-")
+	if !ok {
+		return time.Time{}, false
+	}
+	suffix, _, _ = strings.Cut(suffix, reindexSuffix)
+	day, err := time.Parse("2006.01.02", suffix)
+	return day, err == nil
+}
+
+// deleteExpiredDocuments removes the documents of the pipeline index older than cutoff
+func (s *SyncService) deleteExpiredDocuments(ctx context.Context, p *Pipeline, cutoff time.Time) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"timestamp": map[string]interface{}{
+					"lt": cutoff.Format(time.RFC3339Nano),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal retention query: %v", err)
+	}
+
+	req := opensearchapi.DeleteByQueryRequest{
+		Index:     []string{p.Config.Index},
+		Body:      strings.NewReader(string(body)),
+		Conflicts: "proceed",
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to delete expired documents of %s: %v", p.Config.Index, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil // nothing indexed yet
+	}
+	if res.IsError() {
+		return fmt.Errorf("failed to delete expired documents of %s: %s", p.Config.Index, res.Status())
+	}
+
+	var parsed struct {
+		Deleted int `json:"deleted"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return fmt.Errorf("failed to decode delete response of %s: %v", p.Config.Index, err)
+	}
+
+	if parsed.Deleted > 0 {
+		s.metrics.recordPruned(p.Config.Name, parsed.Deleted, 0)
+		retentionLog.Info("deleted expired documents", "pipeline", p.Config.Name,
+			"documents", parsed.Deleted, "before", cutoff.Format(time.RFC3339), "index", p.Config.Index)
+	}
+	return nil
+}
+
+// listIndices returns the names of the indices matching pattern
+func (s *SyncService) listIndices(ctx context.Context, pattern string) ([]string, error) {
+	req := opensearchapi.CatIndicesRequest{
+		Index:  []string{pattern},
+		Format: "json",
+		H:      []string{"index"},
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list indices %s: %v", pattern, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to list indices %s: %s", pattern, res.Status())
+	}
+
+	var rows []struct {
+		Index string `json:"index"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("failed to decode indices %s: %v", pattern, err)
+	}
+	indices := make([]string, 0, len(rows))
+	for _, row := range rows {
+		indices = append(indices, row.Index)
+	}
+	return indices, nil
+}
+
+// hasRetention reports whether any pipeline prunes its expired documents
+func (s *SyncService) hasRetention() bool {
+	for _, p := range s.pipelines {
+		if p.Config.RetentionDays > 0 {
+			return true
+		}
+	}
+	return false
+}
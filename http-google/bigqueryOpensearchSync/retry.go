@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"devicetransport/gcplog"
+)
+
+// Defaults used when the retry settings are not configured
+const (
+	defaultRetryAttempts    = 3
+	defaultRetryInitial     = 2 * time.Second
+	defaultRetryMax         = time.Minute
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 15 * time.Minute
+)
+
+// RetryConfig controls how failed sync passes are retried and when a pipeline is paused
+type RetryConfig struct {
+	// Attempts is how many times a sync pass is tried before it counts as failed,
+	// waiting a jittered exponential backoff between InitialBackoff and MaxBackoff
+	Attempts       int           `json:"attempts"`
+	InitialBackoff time.Duration `json:"initial_backoff"`
+	MaxBackoff     time.Duration `json:"max_backoff"`
+
+	// BreakerThreshold consecutive failed passes open the circuit breaker, which
+	// pauses the pipeline for BreakerCooldown and posts an alert to AlertWebhook
+	BreakerThreshold int           `json:"breaker_threshold"`
+	BreakerCooldown  time.Duration `json:"breaker_cooldown"`
+	AlertWebhook     string        `json:"alert_webhook,omitempty"`
+}
+
+// circuitBreaker tracks consecutive failed sync passes of one pipeline
+type circuitBreaker struct {
+	failures  int
+	openUntil time.Time
+}
+
+// retryLimits returns the configured retry settings with defaults applied
+func (s *SyncService) retryLimits() RetryConfig {
+	rc := s.config.Retry
+	if rc.Attempts <= 0 {
+		rc.Attempts = defaultRetryAttempts
+	}
+	if rc.InitialBackoff <= 0 {
+		rc.InitialBackoff = defaultRetryInitial
+	}
+	if rc.MaxBackoff <= 0 {
+		rc.MaxBackoff = defaultRetryMax
+	}
+	if rc.BreakerThreshold <= 0 {
+		rc.BreakerThreshold = defaultBreakerThreshold
+	}
+	if rc.BreakerCooldown <= 0 {
+		rc.BreakerCooldown = defaultBreakerCooldown
+	}
+	return rc
+}
+
+// backoff returns the full-jitter exponential delay before retry attempt n (0-based)
+func (rc RetryConfig) backoff(attempt int) time.Duration {
+	delay := rc.MaxBackoff
+	if attempt < 30 && rc.InitialBackoff<<attempt < rc.MaxBackoff {
+		delay = rc.InitialBackoff << attempt
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// withRetry runs fn until it succeeds, the attempts are exhausted or ctx is done
+func (s *SyncService) withRetry(ctx context.Context, p *Pipeline, fn func() error) error {
+	rc := s.retryLimits()
+
+	var err error
+	for attempt := 0; attempt < rc.Attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == rc.Attempts-1 || ctx.Err() != nil {
+			break
+		}
+
+		delay := rc.backoff(attempt)
+		syncLog.Warn("sync attempt failed, retrying", "pipeline", p.Config.Name, "attempt", attempt+1,
+			"attempts", rc.Attempts, "error", err, "backoff", delay.String())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
+
+// breakerAllows reports whether the pipeline may sync, closing the breaker once its cooldown passed
+func (s *SyncService) breakerAllows(p *Pipeline) bool {
+	if p.breaker.openUntil.IsZero() {
+		return true
+	}
+	if time.Now().Before(p.breaker.openUntil) {
+		return false
+	}
+	syncLog.Info("circuit breaker cooldown elapsed, resuming sync", "pipeline", p.Config.Name)
+	p.breaker.openUntil = time.Time{}
+	s.metrics.setCircuitOpen(p.Config.Name, false)
+	return true
+}
+
+// recordOutcome updates the breaker after a sync pass and opens it after too many failures
+func (s *SyncService) recordOutcome(ctx context.Context, p *Pipeline, err error) {
+	if err == nil {
+		p.breaker.failures = 0
+		return
+	}
+
+	rc := s.retryLimits()
+	p.breaker.failures++
+	if p.breaker.failures < rc.BreakerThreshold {
+		return
+	}
+
+	p.breaker.openUntil = time.Now().Add(rc.BreakerCooldown)
+	s.metrics.setCircuitOpen(p.Config.Name, true)
+	syncLog.Log(ctx, gcplog.LevelAlert, "too many consecutive sync failures, pausing pipeline", "pipeline", p.Config.Name,
+		"failures", p.breaker.failures, "cooldown", rc.BreakerCooldown.String(), "error", err)
+
+	if rc.AlertWebhook != "" {
+		if err := postAlert(ctx, rc.AlertWebhook, p, err); err != nil {
+			syncLog.Warn("failed to send alert", "pipeline", p.Config.Name, "error", err)
+		}
+	}
+}
+
+// postAlert sends a JSON description of the opened circuit breaker to the alert webhook
+func postAlert(ctx context.Context, url string, p *Pipeline, cause error) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"pipeline":             p.Config.Name,
+		"consecutive_failures": p.breaker.failures,
+		"paused_until":         p.breaker.openUntil.UTC(),
+		"error":                cause.Error(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %s", res.Status)
+	}
+	return nil
+}
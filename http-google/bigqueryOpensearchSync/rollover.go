@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// dailyIndexName returns the dated index of base for the given day
+func dailyIndexName(base string, day time.Time) string {
+	return fmt.Sprintf("%s-%s", base, day.UTC().Format("2006.01.02"))
+}
+
+// writeTarget returns the index or alias a pipeline bulk-indexes into.
+// With rollover enabled it moves the write alias to a new dated index on day change.
+func (s *SyncService) writeTarget(ctx context.Context, p *Pipeline) (string, error) {
+	if !p.Config.Rollover {
+		return p.Config.Index, nil
+	}
+
+	p.rolloverMu.Lock()
+	defer p.rolloverMu.Unlock()
+
+	daily := dailyIndexName(p.Config.Index, time.Now())
+	if daily != p.currentDaily {
+		if err := s.rolloverTo(ctx, p, daily); err != nil {
+			return "", err
+		}
+		p.currentDaily = daily
+	}
+	return p.Config.WriteAlias, nil
+}
+
+// readTarget returns the index or alias that covers every document of a pipeline
+func readTarget(p *Pipeline) string {
+	if p.Config.Rollover {
+		return p.Config.ReadAlias
+	}
+	return p.Config.Index
+}
+
+// rolloverTo creates the dated index if needed and atomically points the write
+// alias at it, also adding it to the read alias that spans all dailies. When a
+// reindex already moved the write alias to its copy of the day, the copy keeps it.
+func (s *SyncService) rolloverTo(ctx context.Context, p *Pipeline, daily string) error {
+	holders, err := s.aliasIndices(ctx, p.Config.WriteAlias)
+	if err != nil {
+		return err
+	}
+	for _, index := range holders {
+		if strings.HasPrefix(index, daily+reindexSuffix) {
+			return nil
+		}
+	}
+
+	if err := s.createIndexIfMissing(ctx, daily); err != nil {
+		return err
+	}
+
+	var actions []map[string]interface{}
+	for _, index := range holders {
+		if index == daily {
+			continue
+		}
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]interface{}{
+				"index": index,
+				"alias": p.Config.WriteAlias,
+			},
+		})
+	}
+	actions = append(actions,
+		map[string]interface{}{
+			"add": map[string]interface{}{
+				"index":          daily,
+				"alias":          p.Config.WriteAlias,
+				"is_write_index": true,
+			},
+		},
+		map[string]interface{}{
+			"add": map[string]interface{}{
+				"index": daily,
+				"alias": p.Config.ReadAlias,
+			},
+		},
+	)
+
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alias actions: %v", err)
+	}
+
+	req := opensearchapi.IndicesUpdateAliasesRequest{
+		Body: strings.NewReader(string(body)),
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to update aliases: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to update aliases: %s", res.Status())
+	}
+
+	clusterLog.Info("rolled over", "pipeline", p.Config.Name, "write_alias", p.Config.WriteAlias,
+		"index", daily, "read_alias", p.Config.ReadAlias)
+	return nil
+}
+
+// addReadAlias adds an index to the read alias of a rolling pipeline, a no-op when it is already in it
+func (s *SyncService) addReadAlias(ctx context.Context, p *Pipeline, index string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"actions": []map[string]interface{}{
+			{"add": map[string]interface{}{"index": index, "alias": p.Config.ReadAlias}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal alias actions: %v", err)
+	}
+
+	req := opensearchapi.IndicesUpdateAliasesRequest{
+		Body: strings.NewReader(string(body)),
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to %s: %v", index, p.Config.ReadAlias, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("failed to add %s to %s: %s", index, p.Config.ReadAlias, res.Status())
+	}
+	return nil
+}
+
+// createIndexIfMissing creates an index, treating "already exists" as success
+func (s *SyncService) createIndexIfMissing(ctx context.Context, index string) error {
+	req := opensearchapi.IndicesCreateRequest{
+		Index: index,
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %v", index, err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != http.StatusBadRequest { // 400 means index already exists
+		return fmt.Errorf("failed to create index %s: %s", index, res.Status())
+	}
+	return nil
+}
+
+// aliasIndices returns the indices an alias currently points to
+func (s *SyncService) aliasIndices(ctx context.Context, alias string) ([]string, error) {
+	req := opensearchapi.IndicesGetAliasRequest{
+		Name: []string{alias},
+	}
+	res, err := req.Do(ctx, s.osClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get alias %s: %v", alias, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("failed to get alias %s: %s", alias, res.Status())
+	}
+
+	// response is keyed by index name: {"gcp-logs-2025.01.01": {"aliases": {...}}}
+	var parsed map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode alias %s: %v", alias, err)
+	}
+	indices := make([]string, 0, len(parsed))
+	for index := range parsed {
+		indices = append(indices, index)
+	}
+	return indices, nil
+}
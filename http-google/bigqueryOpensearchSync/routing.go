@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// routePriority lets route templates win over the pipeline template when patterns overlap
+const routePriority = 100
+
+// SeverityRoute sends logs of the listed severities to a dedicated set of daily
+// indices (Index-YYYY.MM.DD by log timestamp) with their own replicas and retention
+type SeverityRoute struct {
+	Severities []string `json:"severities"`
+	Index      string   `json:"index"`
+	Replicas   int      `json:"replicas,omitempty"`
+
+	// RetentionDays deletes route indices older than this through an ISM policy, 0 keeps them
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+// validateRoutes checks the severity routes of a pipeline
+func validateRoutes(routes []SeverityRoute) error {
+	seen := make(map[string]bool)
+	for _, r := range routes {
+		if r.Index == "" || len(r.Severities) == 0 {
+			return fmt.Errorf("severity route needs index and severities")
+		}
+		for _, severity := range r.Severities {
+			severity = strings.ToUpper(severity)
+			if seen[severity] {
+				return fmt.Errorf("severity %s is routed twice", severity)
+			}
+			seen[severity] = true
+		}
+	}
+	return nil
+}
+
+// newRouteTable maps each upper-case severity to the base index of its route
+func newRouteTable(routes []SeverityRoute) map[string]string {
+	table := make(map[string]string)
+	for _, r := range routes {
+		for _, severity := range r.Severities {
+			table[strings.ToUpper(severity)] = r.Index
+		}
+	}
+	return table
+}
+
+// routeIndexPatterns returns the index patterns covering all routed documents of a pipeline
+func routeIndexPatterns(p *Pipeline) []string {
+	patterns := make([]string, 0, len(p.Config.Routes))
+	for _, r := range p.Config.Routes {
+		patterns = append(patterns, r.Index+"-*")
+	}
+	return patterns
+}
+
+// sendRouted splits logs by severity route and indexes each group into its
+// target; logs without a route go to the pipeline target
+func (s *SyncService) sendRouted(ctx context.Context, p *Pipeline, target string, logs []syncDocument) (int, error) {
+	if len(p.routes) == 0 {
+		return s.sink.Index(ctx, p, target, logs)
+	}
+
+	groups := make(map[string][]syncDocument)
+	var order []string
+	for _, entry := range logs {
+		index := target
+		if base, ok := p.routes[strings.ToUpper(entry.documentSeverity())]; ok {
+			index = dailyIndexName(base, entry.documentTime())
+		}
+		if _, ok := groups[index]; !ok {
+			order = append(order, index)
+		}
+		groups[index] = append(groups[index], entry)
+	}
+
+	failed := 0
+	for _, index := range order {
+		f, err := s.sink.Index(ctx, p, index, groups[index])
+		failed += f
+		if err != nil {
+			return failed, err
+		}
+	}
+	return failed, nil
+}
+
+// createRouteTemplates installs the index template and retention policy of every severity route
+func (s *SyncService) createRouteTemplates(ctx context.Context, p *Pipeline, properties map[string]interface{}) error {
+	for _, r := range p.Config.Routes {
+		patterns := []string{r.Index + "-*"}
+		if err := s.putIndexTemplate(ctx, r.Index+"_template", patterns, routePriority, properties, r.Replicas); err != nil {
+			return err
+		}
+		if r.RetentionDays > 0 {
+			if err := s.putRetentionPolicy(ctx, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// putRetentionPolicy creates the ISM policy deleting route indices after RetentionDays
+func (s *SyncService) putRetentionPolicy(ctx context.Context, r SeverityRoute) error {
+	description := fmt.Sprintf("delete %s indices after %d days", r.Index, r.RetentionDays)
+	return s.putLifecyclePolicy(ctx, r.Index+"-retention", description, []string{r.Index + "-*"}, routePriority,
+		LifecycleConfig{DeleteAfterDays: r.RetentionDays})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// defaultShutdownTimeout bounds the graceful shutdown when not configured
+const defaultShutdownTimeout = 30 * time.Second
+
+// registerRoutes registers the sync service HTTP endpoints on the mux
+func (s *SyncService) registerRoutes(mux *http.ServeMux) {
+	mux.Handle("/metrics", s.metrics)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/status", s.handleStatus)
+	if s.stream != nil {
+		mux.Handle(s.config.PubSub.PushPath, s.stream)
+	}
+}
+
+// startHTTPServer serves the sync service endpoints on addr until the context is cancelled
+func (s *SyncService) startHTTPServer(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	s.registerRoutes(mux)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	httpLog.Info("serving HTTP endpoints", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		httpLog.Error("HTTP server failed", "error", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+	"github.com/opensearch-project/opensearch-go/opensearchtransport"
+)
+
+// Supported sinks
+const (
+	sinkOpenSearch    = "opensearch"
+	sinkElasticsearch = "elasticsearch"
+)
+
+// Sink is the search cluster synced documents are written to
+type Sink interface {
+	// Index writes docs into index with the pipeline write mode and transforms and
+	// returns how many of them the cluster permanently rejected
+	Index(ctx context.Context, p *Pipeline, index string, docs []syncDocument) (int, error)
+}
+
+// ElasticsearchConfig connects the elasticsearch sink to an Elasticsearch 8.x cluster;
+// APIKey is the base64 "id:key" credential and takes precedence over Username/Password
+type ElasticsearchConfig struct {
+	URLs     []string  `json:"urls"`
+	Username string    `json:"username,omitempty"`
+	Password string    `json:"password,omitempty"`
+	APIKey   string    `json:"api_key,omitempty"`
+	TLS      TLSConfig `json:"tls"`
+}
+
+// bulkSink writes through the _bulk API, which OpenSearch and Elasticsearch share
+type bulkSink struct {
+	service *SyncService
+	client  opensearchapi.Transport
+}
+
+// Index sends docs in one bulk request, retrying transient rejections and dead-lettering permanent ones
+func (k *bulkSink) Index(ctx context.Context, p *Pipeline, index string, docs []syncDocument) (int, error) {
+	return k.service.sendBulk(ctx, k.client, p, index, docs)
+}
+
+// newClusterClient connects to the cluster of the configured sink. Templates, aliases,
+// leases and counts go through the same client, as their APIs are compatible.
+func newClusterClient(config *Config) (opensearchapi.Transport, http.RoundTripper, error) {
+	switch config.Sink {
+	case "", sinkOpenSearch:
+		return newOpenSearchClient(config)
+	case sinkElasticsearch:
+		return newElasticsearchClient(config.Elasticsearch)
+	default:
+		return nil, nil, fmt.Errorf("unknown sink %q", config.Sink)
+	}
+}
+
+// newOpenSearchClient creates the OpenSearch client from the opensearch settings
+func newOpenSearchClient(config *Config) (opensearchapi.Transport, http.RoundTripper, error) {
+	osConfig := opensearch.Config{
+		Addresses: config.OpenSearch.URLs,
+	}
+
+	if config.OpenSearch.Username != "" && config.OpenSearch.Password != "" {
+		osConfig.Username = config.OpenSearch.Username
+		osConfig.Password = config.OpenSearch.Password
+	}
+
+	transport, err := newOpenSearchTransport(config.OpenSearch.TLS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure OpenSearch TLS: %v", err)
+	}
+	if transport != nil {
+		osConfig.Transport = transport
+	}
+
+	osClient, err := opensearch.NewClient(osConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create OpenSearch client: %v", err)
+	}
+	return osClient, transport, nil
+}
+
+// newElasticsearchClient creates a plain transport to Elasticsearch; the OpenSearch
+// client is not used since its product check refuses Elasticsearch 8 clusters
+func newElasticsearchClient(c ElasticsearchConfig) (opensearchapi.Transport, http.RoundTripper, error) {
+	if len(c.URLs) == 0 {
+		return nil, nil, fmt.Errorf("elasticsearch sink needs elasticsearch.urls")
+	}
+
+	urls := make([]*url.URL, 0, len(c.URLs))
+	for _, raw := range c.URLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid Elasticsearch URL %q: %v", raw, err)
+		}
+		urls = append(urls, u)
+	}
+
+	transport, err := newOpenSearchTransport(c.TLS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure Elasticsearch TLS: %v", err)
+	}
+
+	esConfig := opensearchtransport.Config{
+		URLs:      urls,
+		Transport: transport,
+	}
+	if c.APIKey != "" {
+		esConfig.Header = http.Header{"Authorization": []string{"ApiKey " + c.APIKey}}
+	} else if c.Username != "" && c.Password != "" {
+		esConfig.Username = c.Username
+		esConfig.Password = c.Password
+	}
+
+	client, err := opensearchtransport.New(esConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Elasticsearch client: %v", err)
+	}
+	return client, transport, nil
+}
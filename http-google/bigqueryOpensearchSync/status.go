@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// pipelineStatus is the sync state of one configured pipeline as listed by /status
+type pipelineStatus struct {
+	Name        string     `json:"name"`
+	Table       string     `json:"table"`
+	Index       string     `json:"index"`
+	Interval    string     `json:"interval"`
+	Watermark   *time.Time `json:"watermark,omitempty"`
+	LastRows    int        `json:"last_rows"`
+	LastFailed  int        `json:"last_failed"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	Syncs       uint64     `json:"syncs"`
+	SyncErrors  uint64     `json:"sync_errors"`
+	DocsFailed  uint64     `json:"docs_failed"`
+	CircuitOpen bool       `json:"circuit_open"`
+	NextRun     *time.Time `json:"next_run,omitempty"`
+}
+
+// optionalTime returns nil for the zero time so it is left out of the JSON
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// handleStatus lists every configured pipeline with its watermark, last cycle and error counts
+func (s *SyncService) handleStatus(w http.ResponseWriter, r *http.Request) {
+	pipelines := make([]pipelineStatus, 0, len(s.pipelines))
+	for _, p := range s.pipelines {
+		st := s.metrics.get(p.Config.Name)
+		pipelines = append(pipelines, pipelineStatus{
+			Name:        p.Config.Name,
+			Table:       p.Config.Dataset + "." + p.Config.Table,
+			Index:       p.Config.Index,
+			Interval:    p.Config.SyncInterval.String(),
+			Watermark:   optionalTime(st.watermark),
+			LastRows:    st.lastFetched - st.lastFailed,
+			LastFailed:  st.lastFailed,
+			LastSuccess: optionalTime(st.lastSuccess),
+			LastError:   st.lastError,
+			LastErrorAt: optionalTime(st.lastErrorAt),
+			Syncs:       st.syncs,
+			SyncErrors:  st.syncErrors,
+			DocsFailed:  st.docsFailed,
+			CircuitOpen: st.circuitOpen,
+			NextRun:     optionalTime(st.nextRun),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"pipelines": pipelines})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field transform types
+const (
+	transformRename         = "rename"
+	transformDrop           = "drop"
+	transformParseDate      = "parse_date"
+	transformLowercase      = "lowercase"
+	transformExtractTraceID = "extract_trace_id"
+	transformConvert        = "convert"
+)
+
+// fieldTypeMappings are the types a convert transform or a field type hint accepts,
+// with the OpenSearch mapping each of them gets in the index template
+var fieldTypeMappings = map[string]map[string]interface{}{
+	"float":   {"type": "float"},
+	"long":    {"type": "long"},
+	"date":    {"type": "date"},
+	"keyword": {"type": "keyword"},
+	"text":    {"type": "text", "analyzer": "standard"},
+}
+
+// defaultDateLayouts are tried by parse_date when no layout is configured
+var defaultDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+}
+
+// TransformConfig is one per-field transform applied to every document before indexing
+type TransformConfig struct {
+	// Type is one of rename, drop, parse_date, lowercase, extract_trace_id, convert
+	Type  string `json:"type"`
+	Field string `json:"field"`
+
+	// Target is the output field of rename (required) and of parse_date and
+	// extract_trace_id (defaults to Field, replacing it)
+	Target string `json:"target,omitempty"`
+
+	// Layout is the Go time layout of parse_date; common formats are tried when empty
+	Layout string `json:"layout,omitempty"`
+
+	// As is the type convert coerces the value to: float, long, date, keyword or text
+	As string `json:"as,omitempty"`
+}
+
+// fieldTransforms is the validated transform chain of a pipeline
+type fieldTransforms []TransformConfig
+
+// newFieldTransforms validates the configured transforms and fills in their defaults
+func newFieldTransforms(configs []TransformConfig) (fieldTransforms, error) {
+	transforms := make(fieldTransforms, 0, len(configs))
+	for i, t := range configs {
+		if t.Field == "" {
+			return nil, fmt.Errorf("transform %d (%s) has no field", i, t.Type)
+		}
+		switch t.Type {
+		case transformRename:
+			if t.Target == "" {
+				return nil, fmt.Errorf("transform %d: rename of %s needs a target", i, t.Field)
+			}
+		case transformParseDate, transformExtractTraceID:
+			if t.Target == "" {
+				t.Target = t.Field
+			}
+		case transformConvert:
+			if _, ok := fieldTypeMappings[t.As]; !ok {
+				return nil, fmt.Errorf("transform %d: cannot convert %s to %q", i, t.Field, t.As)
+			}
+		case transformDrop, transformLowercase:
+		default:
+			return nil, fmt.Errorf("transform %d: unknown type %q", i, t.Type)
+		}
+		transforms = append(transforms, t)
+	}
+	return transforms, nil
+}
+
+// document returns the JSON document indexed for entry, with the transforms applied in order
+func (transforms fieldTransforms) document(entry syncDocument) (interface{}, error) {
+	if len(transforms) == 0 {
+		return entry, nil
+	}
+
+	// round-trip through JSON so fields are addressed by their document names
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	for _, t := range transforms {
+		value, ok := doc[t.Field]
+		if !ok {
+			continue
+		}
+
+		switch t.Type {
+		case transformRename:
+			delete(doc, t.Field)
+			doc[t.Target] = value
+		case transformDrop:
+			delete(doc, t.Field)
+		case transformLowercase:
+			if s, ok := value.(string); ok {
+				doc[t.Field] = strings.ToLower(s)
+			}
+		case transformParseDate:
+			// unparsable values are left out rather than failing the whole document on the date mapping
+			if s, ok := value.(string); ok {
+				if parsed, ok := parseDate(s, t.Layout); ok {
+					doc[t.Target] = parsed.UTC().Format(time.RFC3339Nano)
+				} else if t.Target == t.Field {
+					delete(doc, t.Field)
+				}
+			}
+		case transformExtractTraceID:
+			// Cloud Logging traces look like projects/<project>/traces/<trace id>
+			if s, ok := value.(string); ok && s != "" {
+				doc[t.Target] = s[strings.LastIndex(s, "/")+1:]
+			}
+		case transformConvert:
+			// like parse_date, values that do not fit the type are left out
+			if converted, ok := convertValue(value, t.As); ok {
+				doc[t.Field] = converted
+			} else {
+				delete(doc, t.Field)
+			}
+		}
+	}
+	return doc, nil
+}
+
+// mappingProperties returns the mapping of the fields the transforms produce or
+// move, derived from the base properties, so templates stay in line with documents
+func (transforms fieldTransforms) mappingProperties(properties map[string]interface{}) map[string]interface{} {
+	for _, t := range transforms {
+		switch t.Type {
+		case transformRename:
+			if mapping, ok := properties[t.Field]; ok {
+				properties[t.Target] = mapping
+			}
+		case transformParseDate:
+			properties[t.Target] = map[string]interface{}{
+				"type": "date",
+			}
+		case transformExtractTraceID:
+			properties[t.Target] = map[string]interface{}{
+				"type": "keyword",
+			}
+		case transformConvert:
+			properties[t.Field] = fieldTypeMappings[t.As]
+		}
+	}
+	return properties
+}
+
+// parseDate parses value with layout, or with the default layouts when layout is empty
+func parseDate(value, layout string) (time.Time, bool) {
+	layouts := defaultDateLayouts
+	if layout != "" {
+		layouts = []string{layout}
+	}
+	for _, l := range layouts {
+		if t, err := time.Parse(l, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// withFieldTypes appends a convert transform per field type hint to the configured
+// transforms, so hints apply to the final document field names
+func withFieldTypes(configs []TransformConfig, types map[string]string) []TransformConfig {
+	if len(types) == 0 {
+		return configs
+	}
+
+	// sorted so the transform chain is stable across runs
+	fields := make([]string, 0, len(types))
+	for field := range types {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	chain := append([]TransformConfig{}, configs...)
+	for _, field := range fields {
+		chain = append(chain, TransformConfig{Type: transformConvert, Field: field, As: types[field]})
+	}
+	return chain
+}
+
+// convertValue coerces a decoded JSON value to a field type, reporting false when it does not fit
+func convertValue(value interface{}, as string) (interface{}, bool) {
+	if value == nil {
+		return nil, true
+	}
+
+	switch as {
+	case "float", "long":
+		var f float64
+		switch v := value.(type) {
+		case float64:
+			f = v
+		case string:
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, false
+			}
+			f = parsed
+		default:
+			return nil, false
+		}
+		if as == "long" {
+			return int64(f), true
+		}
+		return f, true
+	case "date":
+		switch v := value.(type) {
+		case string:
+			parsed, ok := parseDate(v, "")
+			if !ok {
+				return nil, false
+			}
+			return parsed.UTC().Format(time.RFC3339Nano), true
+		case float64:
+			// numbers are taken as epoch milliseconds, the OpenSearch date default
+			return int64(v), true
+		default:
+			return nil, false
+		}
+	default: // keyword, text
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		default:
+			return fmt.Sprint(v), true
+		}
+	}
+}
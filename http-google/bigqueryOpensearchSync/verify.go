@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+	"google.golang.org/api/iterator"
+)
+
+// VerifyOptions describes the time range whose counts are compared
+type VerifyOptions struct {
+	From     time.Time
+	To       time.Time
+	Pipeline string // empty means every pipeline
+
+	// ByDevice buckets counts by device_id in addition to the hour
+	ByDevice bool
+}
+
+// parseVerifyOptions validates the verify flags
+func parseVerifyOptions(from, to, pipeline string, byDevice bool) (VerifyOptions, error) {
+	opts := VerifyOptions{Pipeline: pipeline, To: time.Now().UTC(), ByDevice: byDevice}
+
+	var err error
+	if opts.From, err = parseTimeFlag(from); err != nil {
+		return opts, fmt.Errorf("invalid --from: %v", err)
+	}
+	if to != "" {
+		if opts.To, err = parseTimeFlag(to); err != nil {
+			return opts, fmt.Errorf("invalid --to: %v", err)
+		}
+	}
+	if !opts.From.Before(opts.To) {
+		return opts, fmt.Errorf("--from %v must be before --to %v", opts.From, opts.To)
+	}
+	return opts, nil
+}
+
+// verifyBucket identifies one hour (and device) of compared counts
+type verifyBucket struct {
+	hour   time.Time
+	device string
+}
+
+// verifyGap is a bucket whose BigQuery and OpenSearch counts differ
+type verifyGap struct {
+	verifyBucket
+	bigQuery   int64
+	openSearch int64
+}
+
+// Verify compares the number of BigQuery rows with the number of OpenSearch
+// documents in [From, To), bucketed by hour and device, and reports the gaps
+func (s *SyncService) Verify(ctx context.Context, opts VerifyOptions) error {
+	matched := false
+	var mismatched []string
+	for _, p := range s.pipelines {
+		if opts.Pipeline != "" && p.Config.Name != opts.Pipeline {
+			continue
+		}
+		matched = true
+
+		bqCounts, err := s.bucketBigQuery(ctx, p, opts)
+		if err != nil {
+			return fmt.Errorf("pipeline %q: %v", p.Config.Name, err)
+		}
+		indices := append([]string{readTarget(p)}, routeIndexPatterns(p)...)
+		osCounts, err := s.bucketOpenSearch(ctx, indices, opts)
+		if err != nil {
+			return fmt.Errorf("pipeline %q: %v", p.Config.Name, err)
+		}
+
+		gaps, bqTotal, osTotal := compareBuckets(bqCounts, osCounts)
+		for _, gap := range gaps {
+			device := gap.device
+			if !opts.ByDevice {
+				device = "*"
+			}
+			verifyLog.Warn("gap", "pipeline", p.Config.Name, "hour", gap.hour.Format(time.RFC3339), "device", device,
+				"bigquery_rows", gap.bigQuery, "opensearch_documents", gap.openSearch, "difference", gap.openSearch-gap.bigQuery)
+		}
+
+		if len(gaps) > 0 {
+			mismatched = append(mismatched, p.Config.Name)
+			verifyLog.Error("mismatch", "pipeline", p.Config.Name, "from", opts.From.Format(time.RFC3339), "to", opts.To.Format(time.RFC3339),
+				"buckets", len(gaps), "bigquery_rows", bqTotal, "opensearch_documents", osTotal)
+		} else {
+			verifyLog.Info("ok", "pipeline", p.Config.Name, "from", opts.From.Format(time.RFC3339), "to", opts.To.Format(time.RFC3339),
+				"rows", bqTotal, "buckets", len(bqCounts))
+		}
+	}
+	if !matched {
+		return fmt.Errorf("no pipeline named %q", opts.Pipeline)
+	}
+	if len(mismatched) > 0 {
+		return fmt.Errorf("count mismatch in pipelines: %s", strings.Join(mismatched, ", "))
+	}
+	return nil
+}
+
+// compareBuckets returns the buckets whose counts differ, ordered by hour and device, and both totals
+func compareBuckets(bqCounts, osCounts map[verifyBucket]int64) ([]verifyGap, int64, int64) {
+	var (
+		gaps             []verifyGap
+		bqTotal, osTotal int64
+	)
+	for bucket, n := range bqCounts {
+		bqTotal += n
+		if osCounts[bucket] != n {
+			gaps = append(gaps, verifyGap{verifyBucket: bucket, bigQuery: n, openSearch: osCounts[bucket]})
+		}
+	}
+	for bucket, n := range osCounts {
+		osTotal += n
+		if _, ok := bqCounts[bucket]; !ok {
+			gaps = append(gaps, verifyGap{verifyBucket: bucket, openSearch: n})
+		}
+	}
+
+	sort.Slice(gaps, func(i, j int) bool {
+		if !gaps[i].hour.Equal(gaps[j].hour) {
+			return gaps[i].hour.Before(gaps[j].hour)
+		}
+		return gaps[i].device < gaps[j].device
+	})
+	return gaps, bqTotal, osTotal
+}
+
+// bucketBigQuery counts the pipeline query rows in [From, To) per hour and device
+func (s *SyncService) bucketBigQuery(ctx context.Context, p *Pipeline, opts VerifyOptions) (map[verifyBucket]int64, error) {
+	device := "''"
+	if opts.ByDevice {
+		device = "IFNULL(device_id, '')"
+	}
+	sql := fmt.Sprintf(`SELECT TIMESTAMP_TRUNC(timestamp, HOUR) AS hour, %s AS device, COUNT(*) AS n
+		FROM (%s) WHERE timestamp < @until_time GROUP BY hour, device`, device, p.query)
+
+	query := s.bqClient.Query(sql)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "since_time",
+			Value: opts.From,
+		},
+		{
+			Name:  "until_time",
+			Value: opts.To,
+		},
+	}
+	query.Parameters = append(query.Parameters, p.params...)
+
+	it, err := query.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute BigQuery count: %v", err)
+	}
+
+	counts := make(map[verifyBucket]int64)
+	for {
+		var row struct {
+			Hour   time.Time `bigquery:"hour"`
+			Device string    `bigquery:"device"`
+			N      int64     `bigquery:"n"`
+		}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read BigQuery count: %v", err)
+		}
+		counts[verifyBucket{hour: row.Hour.UTC(), device: row.Device}] = row.N
+	}
+	return counts, nil
+}
+
+// bucketOpenSearch counts the documents of indices in [From, To) per hour and
+// device with a composite aggregation, paging through all buckets
+func (s *SyncService) bucketOpenSearch(ctx context.Context, indices []string, opts VerifyOptions) (map[verifyBucket]int64, error) {
+	index := strings.Join(indices, ",")
+
+	sources := []map[string]interface{}{
+		{"hour": map[string]interface{}{
+			"date_histogram": map[string]interface{}{
+				"field":          "timestamp",
+				"fixed_interval": "1h",
+			},
+		}},
+	}
+	if opts.ByDevice {
+		sources = append(sources, map[string]interface{}{"device": map[string]interface{}{
+			"terms": map[string]interface{}{
+				"field":          "device_id",
+				"missing_bucket": true,
+			},
+		}})
+	}
+
+	counts := make(map[verifyBucket]int64)
+	var after map[string]interface{}
+	for {
+		composite := map[string]interface{}{
+			"size":    1000,
+			"sources": sources,
+		}
+		if after != nil {
+			composite["after"] = after
+		}
+		body, err := json.Marshal(map[string]interface{}{
+			"size": 0,
+			"query": map[string]interface{}{
+				"range": map[string]interface{}{
+					"timestamp": map[string]interface{}{
+						"gte": opts.From.Format(time.RFC3339Nano),
+						"lt":  opts.To.Format(time.RFC3339Nano),
+					},
+				},
+			},
+			"aggs": map[string]interface{}{
+				"buckets": map[string]interface{}{
+					"composite": composite,
+				},
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal count query: %v", err)
+		}
+
+		req := opensearchapi.SearchRequest{
+			Index: indices,
+			Body:  strings.NewReader(string(body)),
+		}
+		res, err := req.Do(ctx, s.osClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count %s: %v", index, err)
+		}
+
+		var parsed struct {
+			Aggregations struct {
+				Buckets struct {
+					AfterKey map[string]interface{} `json:"after_key"`
+					Buckets  []struct {
+						Key struct {
+							Hour   int64   `json:"hour"`
+							Device *string `json:"device"`
+						} `json:"key"`
+						DocCount int64 `json:"doc_count"`
+					} `json:"buckets"`
+				} `json:"buckets"`
+			} `json:"aggregations"`
+		}
+		if res.IsError() {
+			res.Body.Close()
+			return nil, fmt.Errorf("failed to count %s: %s", index, res.Status())
+		}
+		err = json.NewDecoder(res.Body).Decode(&parsed)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode counts of %s: %v", index, err)
+		}
+
+		for _, b := range parsed.Aggregations.Buckets.Buckets {
+			bucket := verifyBucket{hour: time.UnixMilli(b.Key.Hour).UTC()}
+			if b.Key.Device != nil {
+				bucket.device = *b.Key.Device
+			}
+			counts[bucket] += b.DocCount
+		}
+
+		if len(parsed.Aggregations.Buckets.Buckets) == 0 || parsed.Aggregations.Buckets.AfterKey == nil {
+			break
+		}
+		after = parsed.Aggregations.Buckets.AfterKey
+	}
+	return counts, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// Defaults used when the bulk batching settings are not configured
+const (
+	defaultBulkMaxDocs  = 500
+	defaultBulkMaxBytes = 5 << 20
+	defaultBulkWorkers  = 4
+)
+
+// bulkActionOverhead approximates the size of the action line preceding each document
+const bulkActionOverhead = 128
+
+// syncResult summarizes a streamed sync of one time range
+type syncResult struct {
+	fetched      int
+	failed       int
+	maxTimestamp time.Time
+
+	// maxReceived is the newest ingestion time read, or event time for rows without one
+	maxReceived time.Time
+
+	// capped is set when the row cap stopped the read before the end of the range
+	capped bool
+}
+
+// syncRange streams the rows of [since, until) from BigQuery into bulk batches
+// bounded by document count and encoded size, which a pool of workers indexes
+// concurrently, so memory stays bounded however large the window is.
+// A positive maxRows stops reading after that many rows.
+func (s *SyncService) syncRange(ctx context.Context, p *Pipeline, since, until time.Time, target string, maxRows int) (syncResult, error) {
+	var res syncResult
+
+	// only a capped read depends on timestamp order, to resume from its newest row
+	ordered := maxRows > 0 || !s.config.BigQuery.StorageRead
+	it, err := s.queryLogsFromBigQuery(ctx, p, since, until, ordered)
+	if err != nil {
+		return res, fmt.Errorf("failed to fetch logs from BigQuery: %v", err)
+	}
+
+	maxDocs, maxBytes, workers := s.bulkLimits()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	batches := make(chan []syncDocument, workers)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		indexErr error
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				failed, err := s.sendRouted(ctx, p, target, batch)
+
+				mu.Lock()
+				res.failed += failed
+				if err != nil && indexErr == nil {
+					// stop the reader and the other workers on the first failed batch
+					indexErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	readErr := s.readBatches(ctx, it, p.kind.newRow, maxDocs, maxBytes, maxRows, batches, &res)
+	close(batches)
+	wg.Wait()
+
+	if indexErr != nil {
+		return res, fmt.Errorf("failed to send logs to OpenSearch: %v", indexErr)
+	}
+	if readErr != nil {
+		return res, readErr
+	}
+	if res.fetched == 0 {
+		syncLog.Debug("no new logs to sync", "pipeline", p.Config.Name, "index", target)
+	}
+	return res, nil
+}
+
+// readBatches reads rows from the iterator and hands them to the workers in bounded batches
+func (s *SyncService) readBatches(ctx context.Context, it *bigquery.RowIterator, newRow func() syncDocument, maxDocs, maxBytes, maxRows int, batches chan<- []syncDocument, res *syncResult) error {
+	var (
+		batch []syncDocument
+		size  int
+	)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		select {
+		case batches <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		batch, size = nil, 0
+		return nil
+	}
+
+	for {
+		if maxRows > 0 && res.fetched >= maxRows {
+			res.capped = true
+			break
+		}
+
+		entry := newRow()
+		err := it.Next(entry)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read query results: %v", err)
+		}
+
+		res.fetched++
+		if entry.documentTime().After(res.maxTimestamp) {
+			res.maxTimestamp = entry.documentTime()
+		}
+		received := entry.documentReceived()
+		if received.IsZero() {
+			received = entry.documentTime()
+		}
+		if received.After(res.maxReceived) {
+			res.maxReceived = received
+		}
+
+		docSize := bulkActionOverhead
+		if doc, err := json.Marshal(entry); err == nil {
+			docSize += len(doc)
+		}
+
+		// close the current batch before it would exceed the size limit
+		if len(batch) > 0 && size+docSize > maxBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		batch = append(batch, entry)
+		size += docSize
+
+		if len(batch) >= maxDocs {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+// bulkLimits returns the configured batching settings with defaults applied
+func (s *SyncService) bulkLimits() (maxDocs, maxBytes, workers int) {
+	maxDocs = s.config.OpenSearch.BulkMaxDocs
+	if maxDocs <= 0 {
+		maxDocs = defaultBulkMaxDocs
+	}
+	maxBytes = s.config.OpenSearch.BulkMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultBulkMaxBytes
+	}
+	workers = s.config.OpenSearch.BulkWorkers
+	if workers <= 0 {
+		workers = defaultBulkWorkers
+	}
+	return maxDocs, maxBytes, workers
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// adminServer lets demos and tests drive the simulated devices, e.g. start an anomaly
+// right away instead of waiting for the random trigger, and reports how the simulation is going
+type adminServer struct {
+	devices map[string]*MetricSender
+	logs    map[string]*LogSender
+	pool    *sendPool
+	ramp    *loadRamp
+	started time.Time
+}
+
+// deviceStatus is a device as listed by the admin API
+type deviceStatus struct {
+	DeviceID    string        `json:"device_id"`
+	GeoPosition GeoPosition   `json:"geo_position"`
+	Anomaly     anomalyState  `json:"anomaly"`
+	Firmware    firmwareState `json:"firmware"`
+
+	// Set by the commands of the server
+	MetricInterval string             `json:"metric_interval,omitempty"`
+	Thresholds     map[string]float64 `json:"thresholds,omitempty"`
+}
+
+// anomalyRequest is the body of POST /devices/{id}/anomaly; fields left out use the
+// defaults of the random anomalies
+type anomalyRequest struct {
+	Duration   string   `json:"duration"`      // Time to reach the target temperature, e.g. "2m"
+	Hold       string   `json:"hold"`          // Time the target temperature is held
+	TargetTemp *float64 `json:"target_temp_c"` // Peak MCU temperature in Celsius
+}
+
+// newAdminServer creates the admin API of the given senders, sending on pool
+func newAdminServer(senders []*MetricSender, logSenders []*LogSender, pool *sendPool, ramp *loadRamp) *adminServer {
+	devices := make(map[string]*MetricSender, len(senders))
+	for _, s := range senders {
+		devices[s.Config.DeviceID] = s
+	}
+	logs := make(map[string]*LogSender, len(logSenders))
+	for _, s := range logSenders {
+		logs[s.DeviceID] = s
+	}
+	return &adminServer{devices: devices, logs: logs, pool: pool, ramp: ramp, started: time.Now()}
+}
+
+// registerRoutes registers the admin endpoints on the mux
+func (a *adminServer) registerRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /status", a.handleStatus)
+	mux.HandleFunc("GET /devices", a.handleListDevices)
+	mux.HandleFunc("GET /devices/{id}", a.handleGetDevice)
+	mux.HandleFunc("POST /devices/{id}/anomaly", a.handleStartAnomaly)
+	mux.HandleFunc("DELETE /devices/{id}/anomaly", a.handleStopAnomaly)
+}
+
+// run serves the admin API on addr until the context is cancelled
+func (a *adminServer) run(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	a.registerRoutes(mux)
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Admin API listening on %s", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Admin API failed: %v", err)
+	}
+}
+
+// status returns the listed state of a device
+func status(s *MetricSender) deviceStatus {
+	st := deviceStatus{
+		DeviceID:    s.Config.DeviceID,
+		GeoPosition: s.move.position(),
+		Anomaly:     s.AnomalyState(),
+		Firmware:    s.firmware.state(),
+		Thresholds:  s.thresholds(),
+	}
+	if interval := s.metricInterval(); interval > 0 {
+		st.MetricInterval = interval.String()
+	}
+	return st
+}
+
+// handleListDevices lists every device with its anomaly state, sorted by ID
+func (a *adminServer) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	devices := make([]deviceStatus, 0, len(a.devices))
+	for _, s := range a.devices {
+		devices = append(devices, status(s))
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DeviceID < devices[j].DeviceID })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"devices": devices})
+}
+
+// handleGetDevice returns one device with its anomaly state
+func (a *adminServer) handleGetDevice(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.device(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, status(s))
+}
+
+// handleStartAnomaly starts an anomaly on a device, replacing a running one
+func (a *adminServer) handleStartAnomaly(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.device(w, r)
+	if !ok {
+		return
+	}
+
+	var req anomalyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	duration, err := parseAnomalyDuration(req.Duration, defaultAnomalyDuration)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid duration: %v", err), http.StatusBadRequest)
+		return
+	}
+	hold, err := parseAnomalyDuration(req.Hold, defaultAnomalyHold)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid hold: %v", err), http.StatusBadRequest)
+		return
+	}
+	peakTemp := defaultAnomalyPeakTemp
+	if req.TargetTemp != nil {
+		peakTemp = *req.TargetTemp
+	}
+
+	s.StartAnomaly(duration, hold, peakTemp)
+	log.Printf("[%s] Anomaly started from the admin API: %.1fC in %v, held %v", s.Config.DeviceID, peakTemp, duration, hold)
+	writeJSON(w, http.StatusAccepted, status(s))
+}
+
+// handleStopAnomaly ends the anomaly of a device
+func (a *adminServer) handleStopAnomaly(w http.ResponseWriter, r *http.Request) {
+	s, ok := a.device(w, r)
+	if !ok {
+		return
+	}
+	s.StopAnomaly()
+	log.Printf("[%s] Anomaly stopped from the admin API", s.Config.DeviceID)
+	writeJSON(w, http.StatusOK, status(s))
+}
+
+// device returns the device of the {id} path value, answering 404 if unknown
+func (a *adminServer) device(w http.ResponseWriter, r *http.Request) (*MetricSender, bool) {
+	id := r.PathValue("id")
+	s, ok := a.devices[id]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown device %q", id), http.StatusNotFound)
+	}
+	return s, ok
+}
+
+// parseAnomalyDuration parses a non-negative duration, def when empty
+func parseAnomalyDuration(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%s is negative", value)
+	}
+	return d, nil
+}
+
+// writeJSON writes v as the JSON response with the given status
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// ocvCurve is the open circuit voltage of a Li-ion cell by state of charge:
+// a quick drop when full, a long plateau and a knee when almost empty
+var ocvCurve = []struct{ soc, volts float64 }{
+	{0.00, 3.00}, {0.05, 3.45}, {0.10, 3.60}, {0.20, 3.68}, {0.40, 3.75},
+	{0.60, 3.85}, {0.80, 3.97}, {0.90, 4.05}, {1.00, 4.20},
+}
+
+// Voltages the device reads as an empty and a full battery
+const (
+	batteryEmptyVolts = 3.00
+	batteryFullVolts  = 4.20
+)
+
+// battery simulates the cell of a device; the charge drains with the MCU load and,
+// like cheap devices do, the reported percentage is estimated from the cell voltage
+type battery struct {
+	soc        float64 // state of charge, 0 to 1
+	lifeHours  float64 // hours a full charge lasts at 50% MCU usage
+	lastUpdate time.Time
+	rng        *rand.Rand
+}
+
+// newBattery creates a battery with a random charge, so the fleet does not drain in step
+func newBattery(lifeHours float64, rng *rand.Rand) *battery {
+	if lifeHours <= 0 {
+		lifeHours = 72
+	}
+	return &battery{
+		soc:        0.3 + 0.7*rng.Float64(),
+		lifeHours:  lifeHours,
+		lastUpdate: time.Now(),
+		rng:        rng,
+	}
+}
+
+// read drains the charge used since the previous reading and returns the percentage;
+// an empty battery is replaced by a full one
+func (b *battery) read(mcuUsagePercent float64) float64 {
+	now := time.Now()
+	elapsed := now.Sub(b.lastUpdate).Hours()
+	b.lastUpdate = now
+
+	// Idle draw plus the MCU load, so a full charge lasts lifeHours at 50% usage
+	load := 0.5 + mcuUsagePercent/100
+	b.soc -= elapsed * load / b.lifeHours
+	if b.soc <= 0 {
+		b.soc = 1
+	}
+
+	volts := ocv(b.soc) + distuv.Normal{Mu: 0, Sigma: 0.01, Src: b.rng}.Rand()
+	return clamp(100*(volts-batteryEmptyVolts)/(batteryFullVolts-batteryEmptyVolts), 0, 100)
+}
+
+// ocv interpolates the open circuit voltage at a state of charge
+func ocv(soc float64) float64 {
+	if soc <= ocvCurve[0].soc {
+		return ocvCurve[0].volts
+	}
+	for i := 1; i < len(ocvCurve); i++ {
+		lo, hi := ocvCurve[i-1], ocvCurve[i]
+		if soc <= hi.soc {
+			return lo.volts + (soc-lo.soc)/(hi.soc-lo.soc)*(hi.volts-lo.volts)
+		}
+	}
+	return ocvCurve[len(ocvCurve)-1].volts
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// commandUsage lists the subcommands of the simulator
+const commandUsage = `Usage: client <command> [flags]
+
+Commands:
+  run              simulate the devices of the config (default)
+  validate-config  check the config and the devices or replay file, then exit
+  gen-devices      write a devices.json of synthetic devices
+  replay <file>    send the metrics recorded in file instead of simulating
+
+Run "client <command> -h" for the flags of a command.
+`
+
+// runCommand runs the subcommand in args; without one, or with flags only, it runs the simulation
+func runCommand(args []string) error {
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		cfg, in, err := parseRunFlags(command, args, nil)
+		if err != nil {
+			return err
+		}
+		runSimulation(cfg, in)
+	case "replay":
+		cfg, in, err := parseRunFlags(command, args, bindReplayFlags)
+		if err != nil {
+			return err
+		}
+		runSimulation(cfg, in)
+	case "validate-config":
+		cfg, in, err := parseRunFlags(command, args, nil)
+		if err != nil {
+			return err
+		}
+		if in.replay != nil {
+			fmt.Printf("Configuration valid: %d recorded metrics to replay from %s over %s\n", len(in.replay), cfg.Replay.File, cfg.Transport)
+		} else {
+			fmt.Printf("Configuration valid: %d devices from %s over %s\n", len(in.devices), cfg.DeviceConfigFile, cfg.Transport)
+		}
+	case "gen-devices":
+		if err := runGenDevices(args); err != nil {
+			return fmt.Errorf("gen-devices: %w", err)
+		}
+	case "help":
+		fmt.Print(commandUsage)
+	default:
+		fmt.Fprint(os.Stderr, commandUsage)
+		return fmt.Errorf("unknown command %q", command)
+	}
+	return nil
+}
+
+// parseRunFlags parses the flags of a command that loads the config, binding the extra
+// flags of the command with bind, then loads the config and what the run sends
+func parseRunFlags(command string, args []string, bind func(fs *flag.FlagSet) func(cfg *Config, args []string) error) (Config, simulationInputs, error) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON config file, $CONFIG_FILE by default")
+	overrides := bindConfigFlags(fs)
+	var extra func(cfg *Config, args []string) error
+	if bind != nil {
+		extra = bind(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, simulationInputs{}, err // flag.ErrHelp after -h
+	}
+
+	var extraErr error
+	cfg, err := loadConfig(*configFile, func(cfg *Config) {
+		// only the flags given on the command line override the file
+		fs.Visit(func(f *flag.Flag) {
+			if set, ok := overrides[f.Name]; ok {
+				set(cfg)
+			}
+		})
+		if extra != nil {
+			extraErr = extra(cfg, fs.Args())
+		} else if fs.NArg() > 0 {
+			extraErr = fmt.Errorf("%s takes no arguments, got %q", command, fs.Args())
+		}
+	})
+	if err == nil {
+		err = extraErr
+	}
+	if err != nil {
+		return Config{}, simulationInputs{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	in, err := loadInputs(cfg)
+	if err != nil {
+		return Config{}, simulationInputs{}, err
+	}
+	return cfg, in, nil
+}
+
+// bindConfigFlags defines the flags overriding the config file, returning how each one
+// sets the config. Durations take Go syntax, like 90s or 5m.
+func bindConfigFlags(fs *flag.FlagSet) map[string]func(cfg *Config) {
+	overrides := make(map[string]func(cfg *Config))
+	str := func(name, usage string, set func(cfg *Config, v string)) {
+		v := fs.String(name, "", usage)
+		overrides[name] = func(cfg *Config) { set(cfg, *v) }
+	}
+	integer := func(name, usage string, set func(cfg *Config, v int)) {
+		v := fs.Int(name, 0, usage)
+		overrides[name] = func(cfg *Config) { set(cfg, *v) }
+	}
+	duration := func(name, usage string, set func(cfg *Config, v time.Duration)) {
+		v := fs.Duration(name, 0, usage)
+		overrides[name] = func(cfg *Config) { set(cfg, *v) }
+	}
+
+	str("devices", "device config file (device_config_file)", func(cfg *Config, v string) { cfg.DeviceConfigFile = v })
+	str("transport", "http, coap or otlp (transport)", func(cfg *Config, v string) { cfg.Transport = v })
+	str("metric-url", "metric endpoint (metric_url)", func(cfg *Config, v string) { cfg.MetricURL = v })
+	str("metric-batch-url", "metric batch endpoint (metric_batch_url)", func(cfg *Config, v string) { cfg.MetricBatchURL = v })
+	str("log-url", "log endpoint (log_url)", func(cfg *Config, v string) { cfg.LogURL = v })
+	str("encoding", "payload encoding: cbor, json or protobuf (encoding)", func(cfg *Config, v string) { cfg.Encoding = v })
+	str("compression", "gzip or zstd (compression)", func(cfg *Config, v string) { cfg.Compression = v })
+	str("admin-addr", "admin API address, empty disables it (admin_addr)", func(cfg *Config, v string) { cfg.AdminAddr = v })
+	str("otlp-endpoint", "OTLP collector (otlp.endpoint)", func(cfg *Config, v string) { cfg.OTLP.Endpoint = v })
+	integer("workers", "send workers (workers)", func(cfg *Config, v int) { cfg.Workers = v })
+	integer("queue-size", "send queue size (queue_size)", func(cfg *Config, v int) { cfg.QueueSize = v })
+	integer("batch-size", "log events per batch (batch_size)", func(cfg *Config, v int) { cfg.BatchSize = v })
+	duration("metric-interval", "interval of the metrics (metric_interval)", func(cfg *Config, v time.Duration) { cfg.MetricInterval = v })
+	duration("batch-interval", "interval of the log batches (batch_interval)", func(cfg *Config, v time.Duration) { cfg.BatchInterval = v })
+	duration("drain-timeout", "log drain at shutdown, 0 disables it (drain_timeout)", func(cfg *Config, v time.Duration) { cfg.DrainTimeout = v })
+
+	seed := fs.Uint64("seed", 0, "seed of the device readings and events (seed)")
+	overrides["seed"] = func(cfg *Config) { cfg.Seed = *seed }
+	return overrides
+}
+
+// bindReplayFlags defines the flags of replay, which takes the file to replay as argument
+func bindReplayFlags(fs *flag.FlagSet) func(cfg *Config, args []string) error {
+	speed := fs.Float64("speed", 0, "1 replays in real time, 60 an hour in a minute (replay.speed)")
+	loop := fs.Bool("loop", false, "start over at the end of the file (replay.loop)")
+	keep := fs.Bool("keep-timestamps", false, "send the recorded timestamps (replay.keep_timestamps)")
+	return func(cfg *Config, args []string) error {
+		switch len(args) {
+		case 0:
+			if cfg.Replay.File == "" {
+				return fmt.Errorf("replay needs the file to replay")
+			}
+		case 1:
+			cfg.Replay.File = args[0]
+		default:
+			return fmt.Errorf("replay takes one file, got %q", args)
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "speed":
+				cfg.Replay.Speed = *speed
+			case "loop":
+				cfg.Replay.Loop = *loop
+			case "keep-timestamps":
+				cfg.Replay.KeepTimestamps = *keep
+			}
+		})
+		return nil
+	}
+}
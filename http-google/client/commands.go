@@ -0,0 +1,150 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"devicetransport"
+)
+
+// commandRouter hands the commands the server returns to the device they are for
+type commandRouter struct {
+	mu      sync.RWMutex
+	senders map[string]*MetricSender
+}
+
+func newCommandRouter() *commandRouter {
+	return &commandRouter{senders: make(map[string]*MetricSender)}
+}
+
+// register makes s receive the commands of its device
+func (r *commandRouter) register(s *MetricSender) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.senders[s.Config.DeviceID] = s
+}
+
+// handle applies the commands of a device, it is the devicetransport.CommandHandler
+func (r *commandRouter) handle(deviceID string, cmds []devicetransport.Command) {
+	r.mu.RLock()
+	s, ok := r.senders[deviceID]
+	r.mu.RUnlock()
+	if !ok {
+		log.Printf("[%s] %d commands for an unknown device ignored", deviceID, len(cmds))
+		return
+	}
+	for _, cmd := range cmds {
+		s.applyCommand(cmd)
+	}
+}
+
+// deviceControl is the state the server changes with its commands
+type deviceControl struct {
+	interval atomic.Int64 // metric interval in nanoseconds, 0 for the configured one
+
+	mu         sync.Mutex
+	thresholds map[string]float64 // local alert thresholds, by metric
+	applied    []string           // IDs of the last commands applied, oldest first
+}
+
+// maxAppliedCommands bounds the IDs a device remembers to skip a redelivered command,
+// as many as the server queues for it
+const maxAppliedCommands = 32
+
+// firstDelivery records the command ID as applied, false if it already was: the server
+// redelivers the commands of a response it failed to write
+func (c *deviceControl) firstDelivery(id string) bool {
+	if id == "" {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if slices.Contains(c.applied, id) {
+		return false
+	}
+	if len(c.applied) == maxAppliedCommands {
+		c.applied = c.applied[1:]
+	}
+	c.applied = append(c.applied, id)
+	return true
+}
+
+// localAlerts are the metrics a device checks against its thresholds and the event it
+// logs past them; below alerts when the reading drops under the threshold
+var localAlerts = map[string]struct {
+	event uint8
+	below bool
+	read  func(m Metrics) float64
+}{
+	"mcu_temp_c":        {devicetransport.EventHighTemperature, false, func(m Metrics) float64 { return m.MCUTempC }},
+	"mcu_usage_percent": {devicetransport.EventHighCPU, false, func(m Metrics) float64 { return m.MCUUsagePercent }},
+	"battery_percent":   {devicetransport.EventBatteryLow, true, func(m Metrics) float64 { return m.BatteryPercent }},
+}
+
+// applyCommand executes a command of the server on the device
+func (s *MetricSender) applyCommand(cmd devicetransport.Command) {
+	if !s.control.firstDelivery(cmd.ID) {
+		log.Printf("[%s] Command %s: already applied, ignored", s.Config.DeviceID, cmd.ID)
+		return
+	}
+	switch cmd.Type {
+	case devicetransport.CommandSetInterval:
+		interval := time.Duration(cmd.IntervalSeconds) * time.Second
+		if interval <= 0 {
+			log.Printf("[%s] Command %s: invalid interval %ds", s.Config.DeviceID, cmd.ID, cmd.IntervalSeconds)
+			return
+		}
+		s.control.interval.Store(int64(interval))
+		log.Printf("[%s] Command %s: metric interval set to %v", s.Config.DeviceID, cmd.ID, interval)
+	case devicetransport.CommandSelfTest:
+		log.Printf("[%s] Command %s: running self-test", s.Config.DeviceID, cmd.ID)
+		if s.events != nil {
+			s.events.addEvent(devicetransport.EventNetworkCheck)
+			s.events.addEvent(devicetransport.EventSensorStart)
+		}
+	case devicetransport.CommandUpdateThresholds:
+		thresholds := make(map[string]float64, len(cmd.Thresholds))
+		for name, value := range cmd.Thresholds {
+			if _, ok := localAlerts[name]; !ok {
+				log.Printf("[%s] Command %s: no local alert for %s, ignored", s.Config.DeviceID, cmd.ID, name)
+				continue
+			}
+			thresholds[name] = value
+		}
+		s.control.mu.Lock()
+		s.control.thresholds = thresholds
+		s.control.mu.Unlock()
+		log.Printf("[%s] Command %s: alert thresholds set to %v", s.Config.DeviceID, cmd.ID, thresholds)
+	default:
+		log.Printf("[%s] Command %s: unknown type %q ignored", s.Config.DeviceID, cmd.ID, cmd.Type)
+	}
+}
+
+// metricInterval returns the interval the server set for the device, 0 if it set none
+func (s *MetricSender) metricInterval() time.Duration {
+	return time.Duration(s.control.interval.Load())
+}
+
+// thresholds returns a copy of the local alert thresholds the server set for the device
+func (s *MetricSender) thresholds() map[string]float64 {
+	s.control.mu.Lock()
+	defer s.control.mu.Unlock()
+	return maps.Clone(s.control.thresholds)
+}
+
+// checkThresholds logs the event of every reading of m past the thresholds of the device
+func (s *MetricSender) checkThresholds(m Metrics) {
+	if s.events == nil {
+		return
+	}
+	for name, threshold := range s.thresholds() {
+		alert := localAlerts[name]
+		if v := alert.read(m); (alert.below && v < threshold) || (!alert.below && v > threshold) {
+			s.events.addEvent(alert.event)
+		}
+	}
+}
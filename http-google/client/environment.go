@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Parameters of the environment model
+const (
+	tempAmplitude  = 6.0 // half the day/night swing, °C
+	tempNoiseSigma = 1.0 // weather noise around the daily cycle, °C
+	tempNoiseTau   = 30 * time.Minute
+
+	dewPointSigma = 1.5 // drift of the air moisture, °C of dew point
+	dewPointTau   = 6 * time.Hour
+
+	pressureSigma = 6.0 // weather systems passing by, hPa
+	pressureTau   = 24 * time.Hour
+	pressureTide  = 1.0 // semi-diurnal atmospheric tide, hPa
+
+	windNoise    = 0.15             // relative variation of the mean wind
+	gustEvery    = 20 * time.Minute // mean time between two gusts
+	gustMin      = 30 * time.Second
+	gustMax      = 3 * time.Minute
+	gustStrength = 0.4 // sigma of the log of the gust speed
+)
+
+// Magnus formula coefficients, relating temperature, dew point and relative humidity
+const (
+	magnusA = 17.625
+	magnusB = 243.04
+)
+
+// environment simulates the weather around a device, so the external sensors are
+// correlated like real ones: the temperature follows the sun, the humidity falls as the
+// air warms, the pressure drifts slowly and the wind blows harder by day, with gusts
+type environment struct {
+	longitude    float64 // sets the local solar time
+	baseTemp     float64 // daily mean temperature
+	baseDewPoint float64 // from the base temperature and humidity
+	basePressure float64
+	baseWind     float64
+	rng          *rand.Rand
+
+	last          time.Time
+	tempNoise     float64 // deviations from the base values, mean reverting
+	dewPointDrift float64
+	pressureDrift float64
+	gustUntil     time.Time
+	gustSpeed     float64
+}
+
+// weather is the state of the environment at a time
+type weather struct {
+	temperature float64 // °C
+	humidity    float64 // %RH
+	pressure    float64 // hPa
+	wind        float64 // m/s
+}
+
+// newEnvironment creates the environment of a device around its base values,
+// starting from a random state of the weather
+func newEnvironment(c DeviceConfig, rng *rand.Rand) *environment {
+	baseRH := clamp(c.BaseHygrometer, 1, 100)
+	e := &environment{
+		longitude:    c.GeoPosition.Longitude,
+		baseTemp:     c.BaseThermometer,
+		baseDewPoint: dewPoint(c.BaseThermometer, baseRH),
+		basePressure: c.BaseBarometer,
+		baseWind:     c.BaseAnemometer,
+		rng:          rng,
+	}
+	e.tempNoise = tempNoiseSigma * rng.NormFloat64()
+	e.dewPointDrift = dewPointSigma * rng.NormFloat64()
+	e.pressureDrift = pressureSigma * rng.NormFloat64()
+	return e
+}
+
+// sample advances the weather to now and returns it
+func (e *environment) sample(now time.Time) weather {
+	dt := time.Duration(0)
+	if !e.last.IsZero() {
+		dt = now.Sub(e.last)
+	}
+	e.last = now
+	e.tempNoise = e.ou(e.tempNoise, tempNoiseSigma, tempNoiseTau, dt)
+	e.dewPointDrift = e.ou(e.dewPointDrift, dewPointSigma, dewPointTau, dt)
+	e.pressureDrift = e.ou(e.pressureDrift, pressureSigma, pressureTau, dt)
+
+	hour := solarHour(now, e.longitude)
+	// Coldest before dawn, warmest mid afternoon
+	temp := e.baseTemp + tempAmplitude*math.Sin(2*math.Pi*(hour-9)/24) + e.tempNoise
+	// The air keeps its moisture, so the humidity falls as it warms; fog above the dew point
+	dew := math.Min(e.baseDewPoint+e.dewPointDrift, temp)
+	// Tide highs at 10 and 22 local time
+	pressure := e.basePressure + e.pressureDrift + pressureTide*math.Cos(2*math.Pi*(hour-10)/12)
+
+	return weather{
+		temperature: temp,
+		humidity:    relativeHumidity(temp, dew),
+		pressure:    pressure,
+		wind:        e.wind(now, hour, dt),
+	}
+}
+
+// wind returns the wind speed: the mean wind grows with the daytime mixing of the air,
+// and gusts start at random and blow for a while
+func (e *environment) wind(now time.Time, hour float64, dt time.Duration) float64 {
+	daylight := math.Max(0, math.Sin(2*math.Pi*(hour-6)/24))
+	mean := e.baseWind * (0.7 + 0.6*daylight)
+	speed := math.Max(0, mean*(1+windNoise*e.rng.NormFloat64()))
+
+	if now.After(e.gustUntil) && e.rng.Float64() < 1-math.Exp(-float64(dt)/float64(gustEvery)) {
+		e.gustUntil = now.Add(gustMin + time.Duration(e.rng.Int64N(int64(gustMax-gustMin))))
+		e.gustSpeed = distuv.LogNormal{Mu: math.Log(mean + 1), Sigma: gustStrength, Src: e.rng}.Rand()
+	}
+	if now.Before(e.gustUntil) {
+		speed += e.gustSpeed
+	}
+	return speed
+}
+
+// ou moves an Ornstein-Uhlenbeck deviation x forward by dt: it reverts to 0 with time
+// constant tau, keeping sigma as its long run spread
+func (e *environment) ou(x, sigma float64, tau, dt time.Duration) float64 {
+	if dt <= 0 {
+		return x
+	}
+	decay := math.Exp(-float64(dt) / float64(tau))
+	return x*decay + sigma*math.Sqrt(1-decay*decay)*e.rng.NormFloat64()
+}
+
+// solarHour returns the local solar time at longitude, in hours from 0 to 24
+func solarHour(t time.Time, longitude float64) float64 {
+	t = t.UTC()
+	hour := float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600 + longitude/15
+	return math.Mod(hour+24, 24)
+}
+
+// dewPoint returns the dew point of air at temp °C and rh %RH
+func dewPoint(temp, rh float64) float64 {
+	g := math.Log(rh/100) + magnusA*temp/(magnusB+temp)
+	return magnusB * g / (magnusA - g)
+}
+
+// relativeHumidity returns the relative humidity of air at temp °C with the given dew point
+func relativeHumidity(temp, dew float64) float64 {
+	return 100 * math.Exp(magnusA*dew/(magnusB+dew)-magnusA*temp/(magnusB+temp))
+}
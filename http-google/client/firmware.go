@@ -0,0 +1,196 @@
+package main
+
+import (
+	"devicetransport"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Phases of the firmware update cycle
+const (
+	firmwareRunning     = "running"
+	firmwareDownloading = "downloading"
+	firmwareRebooting   = "rebooting"
+)
+
+// Defaults of the firmware of a device that declares none
+const (
+	defaultFirmwareVersion   = "1.0.0"
+	defaultUpdateProbability = 0.002 // about twice a day with the default metric interval
+	defaultDownloadDuration  = 3 * time.Minute
+	defaultRebootDuration    = 45 * time.Second
+
+	// downloadMCULoad is the MCU usage added while the image is downloaded and verified
+	downloadMCULoad = 35.0
+)
+
+// firmwareEvents are emitted only by the update cycle, never by the random event generator
+var firmwareEvents = []uint8{
+	devicetransport.EventFirmwareAvailable,
+	devicetransport.EventFirmwareDownloadStarted,
+	devicetransport.EventFirmwareDownloadComplete,
+	devicetransport.EventFirmwareReboot,
+	devicetransport.EventFirmwareUpdated,
+}
+
+// FirmwareConfig sets the firmware version of a device and how its OTA updates go;
+// an update_probability of 0 disables the updates
+type FirmwareConfig struct {
+	Version           string        `json:"version"`
+	UpdateProbability *float64      `json:"update_probability"` // chance per metric send of an update
+	DownloadDuration  time.Duration `json:"download_duration"`
+	RebootDuration    time.Duration `json:"reboot_duration"`
+}
+
+// resolveFirmware fills in the defaults of the firmware config and validates it
+func (c *DeviceConfig) resolveFirmware() error {
+	fw := &c.Firmware
+	if fw.Version == "" {
+		fw.Version = defaultFirmwareVersion
+	}
+	if fw.UpdateProbability == nil {
+		p := defaultUpdateProbability
+		fw.UpdateProbability = &p
+	}
+	if p := *fw.UpdateProbability; p < 0 || p > 1 {
+		return fmt.Errorf("update_probability %v must be between 0 and 1", p)
+	}
+	if fw.DownloadDuration < 0 || fw.RebootDuration < 0 {
+		return fmt.Errorf("download_duration and reboot_duration must not be negative")
+	}
+	if fw.DownloadDuration == 0 {
+		fw.DownloadDuration = defaultDownloadDuration
+	}
+	if fw.RebootDuration == 0 {
+		fw.RebootDuration = defaultRebootDuration
+	}
+	return nil
+}
+
+// firmware simulates the OTA update cycle of a device: an update becomes available,
+// the image is downloaded with the MCU busy, the device reboots and sends no metrics,
+// then it comes back with the new version
+type firmware struct {
+	cfg    FirmwareConfig
+	events eventSink
+
+	mu       sync.Mutex
+	version  string
+	target   string // version being installed
+	phase    string
+	phaseEnd time.Time
+}
+
+// firmwareState is a snapshot of the firmware of a device
+type firmwareState struct {
+	Version string     `json:"version"`
+	Phase   string     `json:"phase"`
+	Target  string     `json:"target_version,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// newFirmware creates the firmware of a device running the configured version
+func newFirmware(cfg FirmwareConfig, events eventSink) *firmware {
+	return &firmware{
+		cfg:     cfg,
+		events:  events,
+		version: cfg.Version,
+		phase:   firmwareRunning,
+	}
+}
+
+// step advances the update cycle at a metric send, possibly starting an update
+func (f *firmware) step(deviceID string, rng *rand.Rand) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	switch f.phase {
+	case firmwareRunning:
+		if f.cfg.UpdateProbability == nil || rng.Float64() >= *f.cfg.UpdateProbability {
+			return
+		}
+		f.target = nextFirmwareVersion(f.version, rng)
+		log.Printf("[%s] Firmware update available: %s -> %s", deviceID, f.version, f.target)
+		f.emit(devicetransport.EventFirmwareAvailable, devicetransport.EventFirmwareDownloadStarted)
+		f.phase, f.phaseEnd = firmwareDownloading, now.Add(f.cfg.DownloadDuration)
+	case firmwareDownloading:
+		if now.Before(f.phaseEnd) {
+			return
+		}
+		log.Printf("[%s] Firmware %s downloaded, rebooting", deviceID, f.target)
+		f.emit(devicetransport.EventFirmwareDownloadComplete, devicetransport.EventFirmwareReboot)
+		f.phase, f.phaseEnd = firmwareRebooting, now.Add(f.cfg.RebootDuration)
+	case firmwareRebooting:
+		if now.Before(f.phaseEnd) {
+			return
+		}
+		log.Printf("[%s] Firmware updated to %s", deviceID, f.target)
+		f.version, f.target = f.target, ""
+		f.emit(devicetransport.EventBootCompleted, devicetransport.EventFirmwareUpdated)
+		f.phase, f.phaseEnd = firmwareRunning, time.Time{}
+	}
+}
+
+// emit sends the events of the cycle, when the device has an event sink
+func (f *firmware) emit(ids ...uint8) {
+	if f.events == nil {
+		return
+	}
+	for _, id := range ids {
+		f.events.addEvent(id)
+	}
+}
+
+// current returns the running version and the phase of the update cycle
+func (f *firmware) current() (version, phase string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.version, f.phase
+}
+
+// state returns a snapshot of the firmware for the admin API
+func (f *firmware) state() firmwareState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	st := firmwareState{Version: f.version, Phase: f.phase, Target: f.target}
+	if f.phase != firmwareRunning {
+		until := f.phaseEnd
+		st.Until = &until
+	}
+	return st
+}
+
+// nextFirmwareVersion bumps the patch of a MAJOR.MINOR.PATCH version, or once in
+// five updates the minor; other version formats get a numeric suffix
+func nextFirmwareVersion(version string, rng *rand.Rand) string {
+	prefix, rest := "", version
+	if strings.HasPrefix(rest, "v") {
+		prefix, rest = "v", rest[1:]
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return version + ".1"
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return version + ".1"
+		}
+		nums[i] = n
+	}
+
+	if rng.IntN(5) == 0 {
+		nums[1], nums[2] = nums[1]+1, 0
+	} else {
+		nums[2]++
+	}
+	return fmt.Sprintf("%s%d.%d.%d", prefix, nums[0], nums[1], nums[2])
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"devicetransport"
+	"fmt"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FleetConfig groups devices of devices.json, so one process can simulate several regions
+// or tenants: the devices of a fleet send to its endpoints, falling back to the ones of
+// the config, and their metrics carry its name, region and labels
+type FleetConfig struct {
+	Name           string            `json:"name"`
+	Region         string            `json:"region"`
+	MetricURL      string            `json:"metric_url"`
+	MetricBatchURL string            `json:"metric_batch_url"`
+	LogURL         string            `json:"log_url"`
+	Labels         map[string]string `json:"labels"`
+}
+
+// reservedLabels are the attributes the metrics already have, a fleet label can't replace them
+var reservedLabels = []string{"device_id", "latitude", "longitude", "altitude", "firmware_version", "fleet", "region"}
+
+// resolveFleets validates the fleets and attaches each device to the fleet it names
+func (c *DevicesConfig) resolveFleets() error {
+	fleets := make(map[string]FleetConfig, len(c.Fleets))
+	for _, f := range c.Fleets {
+		if f.Name == "" {
+			return fmt.Errorf("fleet without a name")
+		}
+		if _, ok := fleets[f.Name]; ok {
+			return fmt.Errorf("fleet %s declared twice", f.Name)
+		}
+		for k := range f.Labels {
+			if slices.Contains(reservedLabels, k) {
+				return fmt.Errorf("fleet %s: label %q is reserved", f.Name, k)
+			}
+		}
+		fleets[f.Name] = f
+	}
+
+	for i, d := range c.Devices {
+		if d.FleetName == "" {
+			continue
+		}
+		f, ok := fleets[d.FleetName]
+		if !ok {
+			return fmt.Errorf("device %s: unknown fleet %q", d.DeviceID, d.FleetName)
+		}
+		c.Devices[i].fleet = f
+	}
+	return nil
+}
+
+// attributes returns the OTel attributes of the fleet, none for a device without one
+func (f FleetConfig) attributes() []attribute.KeyValue {
+	if f.Name == "" {
+		return nil
+	}
+	attrs := []attribute.KeyValue{attribute.String("fleet", f.Name), attribute.String("region", f.Region)}
+	for k, v := range f.Labels {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	return attrs
+}
+
+// fleetTransports creates the transports of the devices, one for each fleet that
+// overrides an endpoint; the other devices share the default one
+type fleetTransports struct {
+	base       devicetransport.Config
+	tracer     trace.Tracer
+	meter      metric.Meter
+	shared     *instrumentedTransport
+	transports map[string]*instrumentedTransport
+}
+
+// newFleetTransports creates the default transport from base
+func newFleetTransports(base devicetransport.Config, tracer trace.Tracer, meter metric.Meter) (*fleetTransports, error) {
+	shared, err := newInstrumentedTransport(base, tracer, meter)
+	if err != nil {
+		return nil, err
+	}
+	return &fleetTransports{
+		base:       base,
+		tracer:     tracer,
+		meter:      meter,
+		shared:     shared,
+		transports: make(map[string]*instrumentedTransport),
+	}, nil
+}
+
+// newInstrumentedTransport creates a transport with the request instruments of the simulator
+func newInstrumentedTransport(cfg devicetransport.Config, tracer trace.Tracer, meter metric.Meter) (*instrumentedTransport, error) {
+	transport, err := devicetransport.New(cfg, tracer)
+	if err != nil {
+		return nil, err
+	}
+	instrumented, err := instrumentTransport(transport, cfg, meter)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return instrumented, nil
+}
+
+// forDevice returns the transport of a device of fleet f, which labels its requests with the fleet
+func (t *fleetTransports) forDevice(deviceID string, f FleetConfig) (devicetransport.Transport, error) {
+	transport, err := t.forFleet(f)
+	if err != nil {
+		return nil, err
+	}
+	transport.assign(deviceID, f.Name)
+	return transport, nil
+}
+
+// forFleet returns the transport of the devices of a fleet
+func (t *fleetTransports) forFleet(f FleetConfig) (*instrumentedTransport, error) {
+	if f.MetricURL == "" && f.MetricBatchURL == "" && f.LogURL == "" {
+		return t.shared, nil
+	}
+	if transport, ok := t.transports[f.Name]; ok {
+		return transport, nil
+	}
+
+	cfg := t.base
+	if f.MetricURL != "" {
+		cfg.MetricURL = f.MetricURL
+	}
+	if f.MetricBatchURL != "" {
+		cfg.MetricBatchURL = f.MetricBatchURL
+	}
+	if f.LogURL != "" {
+		cfg.LogURL = f.LogURL
+	}
+	transport, err := newInstrumentedTransport(cfg, t.tracer, t.meter)
+	if err != nil {
+		return nil, fmt.Errorf("fleet %s: %w", f.Name, err)
+	}
+	t.transports[f.Name] = transport
+	return transport, nil
+}
+
+// Close closes the transports of every fleet
+func (t *fleetTransports) Close() error {
+	err := t.shared.Close()
+	for _, transport := range t.transports {
+		if e := transport.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// generatedDevice is a device written by gen-devices, with only the fields it fills in
+type generatedDevice struct {
+	DeviceID         string      `json:"device_id"`
+	GeoPosition      GeoPosition `json:"geo_position"`
+	BaseMCUTemp      float64     `json:"base_mcu_temp"`
+	BaseThermometer  float64     `json:"base_thermometer"`
+	BaseBarometer    float64     `json:"base_barometer"`
+	BaseHygrometer   float64     `json:"base_hygrometer"`
+	BaseAnemometer   float64     `json:"base_anemometer"`
+	BaseRSSI         float64     `json:"base_rssi"`
+	BasePM25         float64     `json:"base_pm25"`
+	BaseCO2          float64     `json:"base_co2"`
+	BatteryLifeHours float64     `json:"battery_life_hours"`
+}
+
+// boundingBox is the area the generated devices are placed in, in degrees
+type boundingBox struct {
+	minLat, minLon, maxLat, maxLon float64
+}
+
+// parseBoundingBox parses "min_lat,min_lon,max_lat,max_lon"
+func parseBoundingBox(s string) (boundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return boundingBox{}, fmt.Errorf("bbox %q must be min_lat,min_lon,max_lat,max_lon", s)
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return boundingBox{}, fmt.Errorf("bbox %q: %w", s, err)
+		}
+		v[i] = f
+	}
+	b := boundingBox{minLat: v[0], minLon: v[1], maxLat: v[2], maxLon: v[3]}
+	if b.minLat > b.maxLat || b.minLon > b.maxLon || b.minLat < -90 || b.maxLat > 90 || b.minLon < -180 || b.maxLon > 180 {
+		return boundingBox{}, fmt.Errorf("bbox %q is not a valid area", s)
+	}
+	return b, nil
+}
+
+// runGenDevices is the gen-devices subcommand: it writes a devices.json of synthetic
+// devices for scaling tests, placed at random in a bounding box with base values that
+// follow their latitude and altitude
+func runGenDevices(args []string) error {
+	fs := flag.NewFlagSet("gen-devices", flag.ContinueOnError)
+	count := fs.Int("n", 100, "number of devices")
+	out := fs.String("out", "devices.json", "file to write, - for stdout")
+	bbox := fs.String("bbox", "36.6,6.6,47.1,18.5", "area of the devices as min_lat,min_lon,max_lat,max_lon (Italy by default)")
+	prefix := fs.String("prefix", "device-", "prefix of the device IDs, followed by their number")
+	seed := fs.Uint64("seed", 0, "seed of the generated values, 0 picks a random one")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *count <= 0 {
+		return fmt.Errorf("-n must be positive")
+	}
+	box, err := parseBoundingBox(*bbox)
+	if err != nil {
+		return err
+	}
+	if *seed == 0 {
+		*seed = rand.Uint64()
+	}
+
+	rng := rand.New(rand.NewPCG(*seed, 0))
+	width := max(3, len(strconv.Itoa(*count)))
+	devices := make([]generatedDevice, 0, *count)
+	for i := 1; i <= *count; i++ {
+		devices = append(devices, generateDevice(fmt.Sprintf("%s%0*d", *prefix, width, i), box, rng))
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Devices []generatedDevice `json:"devices"`
+	}{devices}, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if *out != "-" {
+		fmt.Fprintf(os.Stderr, "Generated %d devices in %s (seed %d)\n", *count, *out, *seed)
+	}
+	return nil
+}
+
+// generateDevice draws a device in box: the temperature falls with latitude and
+// altitude, the wind grows with altitude while the particulate falls. The pressure
+// is reduced to sea level like weather stations report it, within the barometer range
+func generateDevice(id string, box boundingBox, rng *rand.Rand) generatedDevice {
+	lat := box.minLat + rng.Float64()*(box.maxLat-box.minLat)
+	lon := box.minLon + rng.Float64()*(box.maxLon-box.minLon)
+	// Most devices sit low, a few in the mountains
+	alt := math.Min(rng.ExpFloat64()*300, 2500)
+
+	temp := 28 - 0.35*math.Abs(lat) - 6.5*alt/1000 + 1.5*rng.NormFloat64()
+	pressure := 1013.25 + 4*rng.NormFloat64()
+
+	return generatedDevice{
+		DeviceID:         id,
+		GeoPosition:      GeoPosition{Latitude: round(lat, 5), Longitude: round(lon, 5), Altitude: math.Round(alt)},
+		BaseMCUTemp:      round(temp+15+5*rng.Float64(), 1),
+		BaseThermometer:  round(temp, 1),
+		BaseBarometer:    round(pressure, 1),
+		BaseHygrometer:   round(55+25*rng.Float64(), 1),
+		BaseAnemometer:   round(math.Exp(1+0.4*rng.NormFloat64())*(1+alt/1500), 1),
+		BaseRSSI:         round(-90+30*rng.Float64(), 0),
+		BasePM25:         round((8+17*rng.Float64())/(1+alt/1000), 1),
+		BaseCO2:          round(410+40*rng.Float64(), 0),
+		BatteryLifeHours: round(48+72*rng.Float64(), 0),
+	}
+}
+
+// round rounds v to the given decimals, keeping the generated file readable
+func round(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	return math.Round(v*p) / p
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"devicetransport"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// defaultSpillMaxEntries is how many events a device keeps on disk by default
+const defaultSpillMaxEntries = 10000
+
+// logSeverities are the severities of the events, least severe first
+var logSeverities = devicetransport.Severities
+
+// severityRank returns the position of a severity in logSeverities, -1 if unknown
+func severityRank(severity string) int {
+	return slices.Index(logSeverities, severity)
+}
+
+// LogSpillConfig moves the events a device can't keep in its log cache to a file on disk,
+// replayed once the server answers again, so a long outage doesn't lose them
+type LogSpillConfig struct {
+	Dir         string `json:"dir"`          // Folder of the files, one per device; empty disables the spill
+	MaxEntries  int    `json:"max_entries"`  // Events kept on disk per device, 10000 by default
+	MinSeverity string `json:"min_severity"` // Least severe event spilled, all by default
+}
+
+// enabled reports whether the events are spilled to disk
+func (c LogSpillConfig) enabled() bool {
+	return c.Dir != ""
+}
+
+// validate checks the spill config, fills in its defaults and creates its folder
+func (c *LogSpillConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	if c.MaxEntries < 0 {
+		return fmt.Errorf("log spill max_entries must not be negative")
+	}
+	if c.MaxEntries == 0 {
+		c.MaxEntries = defaultSpillMaxEntries
+	}
+	if c.MinSeverity == "" {
+		c.MinSeverity = logSeverities[0]
+	}
+	c.MinSeverity = strings.ToUpper(c.MinSeverity)
+	if !slices.Contains(logSeverities, c.MinSeverity) {
+		return fmt.Errorf("unknown log spill min_severity %q, must be one of %v", c.MinSeverity, logSeverities)
+	}
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create log spill dir: %w", err)
+	}
+	return nil
+}
+
+// spilledEvent is a line of a spill file
+type spilledEvent struct {
+	ID        int64  `json:"id"`
+	Timestamp int64  `json:"ts"`
+	TraceID   string `json:"trace_id,omitempty"`
+	SpanID    string `json:"span_id,omitempty"`
+}
+
+// logSpill is the append-only file of the events a device spilled, oldest first.
+// Events past MaxEntries or below MinSeverity are dropped.
+type logSpill struct {
+	path       string
+	maxEntries int
+	minRank    int
+
+	replaying sync.Mutex // one replay at a time, a drain may overlap the last scheduled send
+
+	mu      sync.Mutex
+	count   int    // events in the file
+	dropped uint64 // events that did not fit or were not severe enough
+}
+
+// newLogSpill opens the spill file of a device; the events a previous run left there
+// count towards the cap and are replayed like the new ones
+func newLogSpill(cfg LogSpillConfig, deviceID string) (*logSpill, error) {
+	s := &logSpill{
+		path:       filepath.Join(cfg.Dir, url.PathEscape(deviceID)+".ndjson"),
+		maxEntries: cfg.MaxEntries,
+		minRank:    severityRank(cfg.MinSeverity),
+	}
+	events, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	s.count = len(events)
+	if s.count > 0 {
+		log.Printf("[Device %s] %d spilled log events to replay from %s", deviceID, s.count, s.path)
+	}
+	return s, nil
+}
+
+// len returns the number of events in the file
+func (s *logSpill) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// write appends the events severe enough to the file while it has room
+func (s *logSpill) write(entries []LogEntryCompact, links []SpanLink) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf []byte
+	written := 0
+	for i, e := range entries {
+		def := devicetransport.EventDefinitions[uint8(e[0])]
+		if severityRank(def.Severity) < s.minRank || s.count+written >= s.maxEntries {
+			s.dropped++
+			continue
+		}
+		line, err := json.Marshal(spilledEvent{ID: e[0], Timestamp: e[1], TraceID: links[i].TraceID, SpanID: links[i].SpanID})
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+		written++
+	}
+	if written == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(buf); err != nil {
+		return err
+	}
+	s.count += written
+	return nil
+}
+
+// read returns the events in the file, none if it does not exist
+func (s *logSpill) read() ([]spilledEvent, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []spilledEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e spilledEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("corrupt spill file %s: %w", s.path, err)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// remove drops the oldest n events from the file, keeping the ones written since they were read
+func (s *logSpill) remove(n int) error {
+	events, err := s.read()
+	if err != nil {
+		return err
+	}
+	events = events[min(n, len(events)):]
+	if len(events) == 0 {
+		s.count = 0
+		return os.Remove(s.path)
+	}
+
+	var buf []byte
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.count = len(events)
+	return nil
+}
+
+// replay sends the spilled events oldest first in batches of batchSize, stopping at the
+// first failure so the rest stay on disk. The file is not locked while sending.
+func (s *logSpill) replay(ctx context.Context, batchSize int, send func(ctx context.Context, entries []LogEntryCompact, links []SpanLink) error) (int, error) {
+	s.replaying.Lock()
+	defer s.replaying.Unlock()
+
+	s.mu.Lock()
+	events, err := s.read()
+	s.mu.Unlock()
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	entries := make([]LogEntryCompact, len(events))
+	links := make([]SpanLink, len(events))
+	for i, e := range events {
+		entries[i] = LogEntryCompact{e.ID, e.Timestamp}
+		links[i] = SpanLink{TraceID: e.TraceID, SpanID: e.SpanID}
+	}
+
+	sent := 0
+	var sendErr error
+	for sent < len(entries) {
+		n := min(max(batchSize, 1), len(entries)-sent)
+		if sendErr = send(ctx, entries[sent:sent+n], links[sent:sent+n]); sendErr != nil {
+			break
+		}
+		sent += n
+	}
+	if sent > 0 {
+		s.mu.Lock()
+		err = s.remove(sent)
+		s.mu.Unlock()
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, sendErr
+}
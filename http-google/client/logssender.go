@@ -1,184 +1,289 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	cbor "github.com/fxamacker/cbor/v2"
-	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/propagation"
-	"go.opentelemetry.io/otel/trace"
+	"devicetransport"
 	"log"
-	"net/http"
+	"math/rand/v2"
 	"sync"
+	"sync/atomic"
 	"time"
-)
 
-// definizione di vari id che serve alla parte server
-var eventDefinitions = map[uint8]struct {
-	Severity string
-	Message  string
-}{
-	1: {"DEBUG", "Dispositivo in fase di inizializzazione"},
-	2: {"DEBUG", "Controllo stato rete"},
-	3: {"DEBUG", "Avvio modulo sensore"},
-	4: {"DEBUG", "Sincronizzazione orologio"},
+	"go.opentelemetry.io/otel/trace"
+)
 
-	5: {"INFO", "Avvio completato"},
-	6: {"INFO", "Temperatura normale"},
-	7: {"INFO", "CPU sotto soglia"},
-	8: {"INFO", "Heartbeat inviato"},
+// LogEntryCompact is a log event: event ID and unix timestamp
+type LogEntryCompact = devicetransport.LogEntryCompact
 
-	9:  {"NOTICE", "Cambio configurazione"},
-	10: {"NOTICE", "Aggiornamento firmware disponibile"},
-	11: {"NOTICE", "Sensore temporaneamente inattivo"},
-	12: {"NOTICE", "Collegamento rete ristabilito"},
+// SpanLink is the span active on the device when a log event happened
+type SpanLink = devicetransport.SpanLink
 
-	13: {"WARNING", "Temperatura elevata"},
-	14: {"WARNING", "Consumo CPU sopra la soglia"},
-	15: {"WARNING", "Batteria in esaurimento"},
-	16: {"WARNING", "Perdita pacchetti rilevata"},
+// LogSender represents a device that sends randomly generated logs
+type LogSender struct {
+	Transport  devicetransport.Transport
+	DeviceID   string
+	rng        *rand.Rand // events of the device, reproducible with a seed
+	logCache   []LogEntryCompact
+	linkCache  []SpanLink // span of each cached log, same index
+	cacheMutex sync.Mutex
 
-	17: {"ERROR", "Impossibile connettersi al server"},
-	18: {"ERROR", "Errore lettura sensore"},
-	19: {"ERROR", "Timeout nella risposta del server"},
-	20: {"ERROR", "Scrittura su memoria fallita"},
+	// activeSpan is the span of the last metric of the device, linked to the events
+	activeSpan atomic.Pointer[SpanLink]
 
-	21: {"CRITICAL", "Perdita connessione permanente"},
-	22: {"CRITICAL", "Dati corrotti nella memoria"},
+	// anomalies signals the event generator that an anomaly started on the device
+	anomalies chan struct{}
 
-	23: {"ALERT", "Accesso non autorizzato rilevato"},
-	24: {"ALERT", "Possibile attacco DoS in corso"},
+	// spill keeps on disk the events the cache can't hold or failed to send, nil drops them
+	spill *logSpill
 
-	25: {"EMERGENCY", "Sistema in stato critico - riavvio necessario"},
-	26: {"EMERGENCY", "Errore hardware irreversibile"},
-	27: {"EMERGENCY", "Guasto alimentazione principale"},
+	// prioritySeverity is the least severe event sent right away instead of batched, empty batches all
+	prioritySeverity string
 }
 
-type LogEntryCompact [2]int64
+// prioritySendTimeout bounds the immediate send of a high severity event
+const prioritySendTimeout = 10 * time.Second
 
-// LogSender represents a device that sends randomly generated logs
-type LogSender struct {
-	Client     *http.Client
-	Tracer     trace.Tracer
-	DeviceID   string
-	URL        string
-	logCache   []LogEntryCompact
-	cacheMutex sync.Mutex
+// eventSink receives the events of a device, the LogSender batching them to the server
+type eventSink interface {
+	addEvent(id uint8)
+	// setActiveSpan links the next events to the span of a metric of the device
+	setActiveSpan(sc trace.SpanContext)
+	// anomalyStarted tells the event generator an anomaly started, to emit its burst of errors
+	anomalyStarted()
 }
 
 // NewLogSender creates a new LogSender instance
-func NewLogSender(client *http.Client, tracer trace.Tracer, deviceID, url string) *LogSender {
+func NewLogSender(transport devicetransport.Transport, deviceID string, rng *rand.Rand) *LogSender {
 	return &LogSender{
-		Client:   client,
-		Tracer:   tracer,
-		DeviceID: deviceID,
-		URL:      url,
+		Transport: transport,
+		DeviceID:  deviceID,
+		rng:       rng,
+		anomalies: make(chan struct{}, 1),
 	}
 }
 
-// Send sends a batch of log entries to the configured URL using CBOR encoding and OpenTelemetry tracing
-func (s *LogSender) Send(ctx context.Context, entries []LogEntryCompact) error {
-	ctx, span := s.Tracer.Start(ctx, "SendLogBatch")
-	defer span.End()
-
-	payload := map[string]interface{}{
-		"device_id": s.DeviceID,
-		"logs":      entries,
-	}
-
-	// Encode payload to CBOR format
-	cborData, err := cbor.Marshal(payload)
-	if err != nil {
-		span.RecordError(err)
-		return err
-	}
-
-	// Create HTTP request with context
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(cborData))
-	if err != nil {
-		span.RecordError(err)
+// Send sends a batch of log entries through the configured transport
+func (s *LogSender) Send(ctx context.Context, entries []LogEntryCompact, links []SpanLink) error {
+	if err := s.Transport.SendLogBatch(ctx, s.DeviceID, entries, links); err != nil {
 		return err
 	}
 
-	req.Header.Set("Content-Type", "application/cbor")
-	// Inject tracing headers into the request
-	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
-
-	resp, err := s.Client.Do(req)
-	if err != nil {
-		span.RecordError(err)
-		return err
-	}
-	defer resp.Body.Close()
-
-	log.Printf("Sent %d logs:%s – HTTP %s", len(entries), s.DeviceID, resp.Status)
+	log.Printf("Sent %d logs:%s", len(entries), s.DeviceID)
 	return nil
 }
 
 // addEvent adds a new event with the given ID to the log cache
 func (s *LogSender) addEvent(id uint8) {
 	// Check if the event ID is defined
-	if _, ok := eventDefinitions[id]; !ok {
+	def, ok := devicetransport.EventDefinitions[id]
+	if !ok {
 		log.Printf("Undefined event ID: %d", id)
 		return
 	}
 	ts := time.Now().Unix()
-	// Append the event ID and timestamp to the log cache
-	s.AddLog(LogEntryCompact{int64(id), ts})
+	// Append the event ID and timestamp to the log cache, linked to the metric span
+	var link SpanLink
+	if active := s.activeSpan.Load(); active != nil {
+		link = *active
+	}
 	log.Printf("Device %s generated event ID: %d", s.DeviceID, id)
+	if s.prioritySeverity != "" && severityRank(def.Severity) >= severityRank(s.prioritySeverity) {
+		go s.sendNow(LogEntryCompact{int64(id), ts}, link)
+		return
+	}
+	s.AddLog(LogEntryCompact{int64(id), ts}, link)
+}
+
+// sendNow sends a high severity event on its own, traced like the batches; if the
+// server can't be reached the event goes back to the cache to be batched
+func (s *LogSender) sendNow(entry LogEntryCompact, link SpanLink) {
+	ctx, cancel := context.WithTimeout(context.Background(), prioritySendTimeout)
+	defer cancel()
+	if err := s.Send(ctx, []LogEntryCompact{entry}, []SpanLink{link}); err != nil {
+		log.Printf("[Device %s] Error sending priority event %d, batching it: %v", s.DeviceID, entry[0], err)
+		s.AddLog(entry, link)
+	}
+}
+
+// queued returns the number of events waiting in the cache
+func (s *LogSender) queued() int {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+	return len(s.logCache)
+}
+
+// setActiveSpan records the span of the metric being sent by the device
+func (s *LogSender) setActiveSpan(sc trace.SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+	s.activeSpan.Store(&SpanLink{TraceID: sc.TraceID().String(), SpanID: sc.SpanID().String()})
+}
+
+// anomalyStarted signals the event generator without waiting; an anomaly starting while
+// the previous signal is pending shares its burst
+func (s *LogSender) anomalyStarted() {
+	select {
+	case s.anomalies <- struct{}{}:
+	default:
+	}
 }
 
-// AddLog safely appends a log entry to the cache with mutex locking
-func (s *LogSender) AddLog(entry LogEntryCompact) {
+// AddLog safely appends a log entry and its span link to the cache with mutex locking
+func (s *LogSender) AddLog(entry LogEntryCompact, link SpanLink) {
 	s.cacheMutex.Lock()
 	defer s.cacheMutex.Unlock()
 	// Append entry to the cache
 	s.logCache = append(s.logCache, entry)
+	s.linkCache = append(s.linkCache, link)
 
 	// Limit cache size to last 200 entries to avoid unbounded growth
 	if len(s.logCache) > 200 {
+		overflow := len(s.logCache) - 200
+		s.spillEvents(s.logCache[:overflow], s.linkCache[:overflow])
     s.logCache = s.logCache[len(s.logCache)-200:]
+    s.linkCache = s.linkCache[len(s.linkCache)-200:]
+}
+}
+
+// spillEvents writes events to the spill file, if the device has one
+func (s *LogSender) spillEvents(entries []LogEntryCompact, links []SpanLink) {
+	if s.spill == nil {
+		return
+	}
+	if err := s.spill.write(entries, links); err != nil {
+		log.Printf("[Device %s] Error spilling %d log events: %v", s.DeviceID, len(entries), err)
+	}
 }
+
+// spilled returns the number of events waiting on disk
+func (s *LogSender) spilled() int {
+	if s.spill == nil {
+		return 0
+	}
+	return s.spill.len()
 }
 // SendBatch copies a batch of logs from cache and sends them without holding the lock during send
 func (s *LogSender) SendBatch(ctx context.Context, batchSize int) error {
     s.cacheMutex.Lock()
     if len(s.logCache) == 0 {
         s.cacheMutex.Unlock()
-        return nil
+		return s.replaySpilled(ctx, batchSize)
     }
 
     var entries []LogEntryCompact
+    var links []SpanLink
     if len(s.logCache) > batchSize {
         entries = make([]LogEntryCompact, batchSize)
         copy(entries, s.logCache[:batchSize])
         s.logCache = s.logCache[batchSize:]
+        links = make([]SpanLink, batchSize)
+        copy(links, s.linkCache[:batchSize])
+        s.linkCache = s.linkCache[batchSize:]
     } else {
-        entries = s.logCache
-        s.logCache = nil
+        entries, links = s.logCache, s.linkCache
+        s.logCache, s.linkCache = nil, nil
     }
     s.cacheMutex.Unlock()
 
    	// Send logs without holding the mutex lock
-    return s.Send(ctx, entries)
-}
-
-// runLogSenders runs a loop that periodically sends batches of logs for all devices until context is cancelled
-func runLogSenders(ctx context.Context, senders []*LogSender, interval time.Duration, batchSize int) {
-    ticker := time.NewTicker(interval)
-    defer ticker.Stop()
-
-    for {
-        select {
-        case <-ctx.Done():
-            log.Println("Stopping log senders...")
-            return
-        case <-ticker.C:
-            for _, sender := range senders {
-                if err := sender.SendBatch(ctx, batchSize); err != nil {
-                    log.Printf("[Device %s] Error sending logs: %v", sender.DeviceID, err)
-                }
-            }
-        }
-    }
+	if err := s.Send(ctx, entries, links); err != nil {
+		// the batch would be lost, keep it on disk until the server answers again
+		s.spillEvents(entries, links)
+		return err
+	}
+	return s.replaySpilled(ctx, batchSize)
+}
+
+// replaySpilled sends the events spilled to disk once a batch went through
+func (s *LogSender) replaySpilled(ctx context.Context, batchSize int) error {
+	if s.spilled() == 0 {
+		return nil
+	}
+	sent, err := s.spill.replay(ctx, batchSize, s.Send)
+	if sent > 0 {
+		log.Printf("[Device %s] Server reachable again, replayed %d spilled log events", s.DeviceID, sent)
+	}
+	return err
+}
+
+// spillCache moves the events still in the cache to disk, returning how many it moved
+func (s *LogSender) spillCache() int {
+	if s.spill == nil {
+		return 0
+	}
+	s.cacheMutex.Lock()
+	entries, links := s.logCache, s.linkCache
+	s.logCache, s.linkCache = nil, nil
+	s.cacheMutex.Unlock()
+	s.spillEvents(entries, links)
+	return len(entries)
+}
+
+// runLogSenders schedules a log batch send per device every interval on the send pool until context is cancelled
+func runLogSenders(ctx context.Context, pool *sendPool, senders []*LogSender, interval time.Duration, batchSize int, jitter float64, ramp *loadRamp) {
+	jobs := make([]sendJob, 0, len(senders))
+	for _, sender := range senders {
+		jobs = append(jobs, newSendJob("logs", sender.DeviceID, ramp, func(ctx context.Context) error {
+			return sender.SendBatch(ctx, batchSize)
+		}))
+	}
+
+	pool.schedule(ctx, jobs, interval, jitter)
+	log.Println("Stopping log senders...")
+}
+
+// drainLogSenders sends what is left in the cache of every device once the simulation
+// stops, on up to workers devices at a time, giving up after timeout; 0 skips the drain
+func drainLogSenders(senders []*LogSender, batchSize, workers int, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	pending := 0
+	for _, s := range senders {
+		pending += s.queued()
+	}
+	if pending == 0 {
+		return
+	}
+	log.Printf("Draining %d pending log events (timeout %v)...", pending, timeout)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(workers, 1))
+	for _, s := range senders {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for s.queued() > 0 {
+				if err := s.SendBatch(ctx, batchSize); err != nil {
+					log.Printf("[Device %s] Drain stopped with %d events left: %v", s.DeviceID, s.queued(), err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	lost := 0
+	for _, s := range senders {
+		lost += s.queued()
+	}
+	log.Printf("Log drain complete, %d events sent, %d lost", pending-lost, lost)
+}
+
+// spillLogSenders moves the events the drain did not send to the spill files, so the
+// next run replays them
+func spillLogSenders(senders []*LogSender) {
+	spilled := 0
+	for _, s := range senders {
+		spilled += s.spillCache()
+	}
+	if spilled > 0 {
+		log.Printf("Spilled %d pending log events to disk", spilled)
+	}
 }
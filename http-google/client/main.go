@@ -3,30 +3,100 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"net/http"
+	"maps"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
+	"devicetransport"
 	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
 )
 
 // Config holds all configuration settings for the system
 type Config struct {
 	LogURL           string                `json:"log_url"`
 	MetricURL        string                `json:"metric_url"`
+	MetricBatchURL   string                `json:"metric_batch_url"`
 	BatchSize        int                   `json:"batch_size"`
 	BatchInterval    time.Duration         `json:"batch_interval"`
 	MetricInterval   time.Duration         `json:"metric_interval"`
 	EventGenInterval EventIntervalConfig   `json:"event_gen_interval"`
+	EventGen         EventGenConfig        `json:"event_gen"`
 	DeviceConfigFile string                `json:"device_config_file"`
+
+	// Workers send for all devices from a queue of QueueSize jobs; SendJitter spreads
+	// each device's sends by up to ±that fraction of the interval
+	Workers    int     `json:"workers"`
+	QueueSize  int     `json:"queue_size"`
+	SendJitter float64 `json:"send_jitter"`
+
+	// Transport is how devices send: "http" or "coap" (CBOR to MetricURL/LogURL, which
+	// are coap://host:port/path for CoAP) or "otlp" (metrics as OTLP/gRPC to the
+	// collector, bypassing the server; logs still go over HTTP)
+	Transport string     `json:"transport"`
+	OTLP      OTLPConfig `json:"otlp"`
+
+	// Compression ("gzip" or "zstd") of the HTTP payloads of at least CompressionThreshold bytes
+	Compression          string `json:"compression"`
+	CompressionThreshold int    `json:"compression_threshold"`
+
+	// Encoding of the payloads sent over http, "cbor", "protobuf" or "json" to read them while debugging
+	Encoding string `json:"encoding"`
+
+	// TLS sets the CA bundle and the client certificates for mutual TLS over https,
+	// shared by all devices or per device from cert_dir
+	TLS devicetransport.TLSConfig `json:"tls"`
+
+	// Auth sets a static API key or an OAuth2 client credentials token on the http requests
+	Auth devicetransport.AuthConfig `json:"auth"`
+
+	// Seed makes the readings and events of every device repeat at each run,
+	// 0 picks a random one; a device can override it with its own seed
+	Seed uint64 `json:"seed"`
+
+	// AdminAddr is where the admin API to inspect devices and trigger anomalies listens, empty disables it
+	AdminAddr string `json:"admin_addr"`
+
+	// Retry configures the retries and the offline buffer of the metrics sent over http or coap
+	Retry RetryConfig `json:"retry"`
+
+	// MetricBatch sends several metric samples per request to MetricBatchURL, over http only
+	MetricBatch MetricBatchConfig `json:"metric_batch"`
+
+	// PrioritySeverity is the least severe log event sent right away instead of batched, "NONE" batches all
+	PrioritySeverity string `json:"priority_severity"`
+
+	// LogSpill keeps on disk the log events the devices can't hold in memory or send
+	LogSpill LogSpillConfig `json:"log_spill"`
+
+	// DrainTimeout bounds how long the pending log events are sent for at shutdown
+	DrainTimeout time.Duration `json:"drain_timeout"`
+
+	// Replay sends the metrics recorded in a file instead of simulating the devices
+	Replay ReplayConfig `json:"replay"`
+
+	// Ramp grows or swings the number of active devices to load test gradually
+	Ramp RampConfig `json:"ramp"`
+
+	// SelfTelemetry exports the simulator's own request and queue metrics to the otlp endpoint
+	SelfTelemetry SelfTelemetryConfig `json:"self_telemetry"`
+
+	// EventCatalogURL is where the devices fetch the catalog of their log events at startup,
+	// over http with the TLS and auth settings; empty keeps the built-in catalog
+	EventCatalogURL string `json:"event_catalog_url"`
 }
 
 // DevicesConfig represents the structure of the devices configuration file
 type DevicesConfig struct {
+	Fleets  []FleetConfig  `json:"fleets"`
 	Devices []DeviceConfig `json:"devices"`
 }
 
@@ -36,11 +106,13 @@ type EventIntervalConfig struct {
     Max time.Duration `json:"max"`
 }
 
-// loadConfig loads the system configuration with default values
-func loadConfig() Config {
+// loadConfig loads the system configuration with default values, then the config file
+// if one is given, then the overrides of the command line flags
+func loadConfig(configFile string, override func(cfg *Config)) (Config, error) {
 	cfg := Config{
 		LogURL:         "https://http-server-1094805005874.europe-west1.run.app/batchLog",
 		MetricURL:      "https://http-server-1094805005874.europe-west1.run.app/batchMetric",
+		MetricBatchURL: "https://http-server-1094805005874.europe-west1.run.app/batchMetrics",
 		/* local test
 		cfg.LogURL = "http://localhost:8080/batchLog"         // Local testing endpoint
 		cfg.MetricURL = "http://localhost:8080/batchMetric"   // Local testing endpoint*/
@@ -49,27 +121,80 @@ func loadConfig() Config {
 		BatchInterval:  5 * time.Minute,
 		MetricInterval: 90 * time.Second,
 		DeviceConfigFile: "devices.json",
+		Workers:          50,
+		QueueSize:        1000,
+		SendJitter:       0.1,
+		Transport:        devicetransport.ProtocolHTTP,
+		OTLP: OTLPConfig{
+			Endpoint: "localhost:4317",
+			Insecure: true,
+		},
+		AdminAddr:        "localhost:8081",
+		DrainTimeout:     10 * time.Second,
+		PrioritySeverity: "ALERT",
+		Retry: RetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: 1 * time.Second,
+			MaxBackoff:     30 * time.Second,
+			BufferSize:     60,
+		},
 		EventGenInterval: EventIntervalConfig{
 			Min: 10 * time.Second,
 			Max: 15 * time.Second,
 		},
 	}
 	
-	// Try to load configuration from file if it exists
-	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
-		if data, err := os.ReadFile(configFile); err == nil {
-			if err := json.Unmarshal(data, &cfg); err != nil {
-				log.Printf("Warning: Failed to parse config file %s: %v", configFile, err)
-			} else {
-				log.Printf("Configuration loaded from %s", configFile)
-			}
+	// Load the configuration file, then let the flags override it
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to read config file %s: %w", configFile, err)
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("failed to parse config file %s: %w", configFile, err)
 		}
+		log.Printf("Configuration loaded from %s", configFile)
+	}
+	if override != nil {
+		override(&cfg)
+	}
+
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = cfg.Workers
+	}
+	if cfg.Retry.MaxAttempts <= 0 {
+		cfg.Retry.MaxAttempts = 1
+	}
+	// The senders start at a random offset within their interval, which must be positive
+	if cfg.MetricInterval <= 0 {
+		return Config{}, fmt.Errorf("metric_interval must be positive, got %v", cfg.MetricInterval)
+	}
+	if cfg.BatchInterval <= 0 {
+		return Config{}, fmt.Errorf("batch_interval must be positive, got %v", cfg.BatchInterval)
+	}
+	if err := cfg.MetricBatch.validate(cfg.MetricInterval); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.EventGen.validate(cfg.EventGenInterval); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.LogSpill.validate(); err != nil {
+		return Config{}, err
+	}
+	switch cfg.PrioritySeverity = strings.ToUpper(cfg.PrioritySeverity); {
+	case cfg.PrioritySeverity == "NONE":
+		cfg.PrioritySeverity = ""
+	case severityRank(cfg.PrioritySeverity) < 0:
+		return Config{}, fmt.Errorf("unknown priority_severity %q, must be one of %v or NONE", cfg.PrioritySeverity, logSeverities)
 	}
 
-	log.Printf("Configuration loaded: batch size: %d, metric interval: %v", 
-		cfg.BatchSize, cfg.MetricInterval)
+	log.Printf("Configuration loaded: batch size: %d, metric interval: %v, workers: %d", 
+		cfg.BatchSize, cfg.MetricInterval, cfg.Workers)
 	
-	return cfg
+	return cfg, nil
 }
 
 // loadDevicesConfig loads device configurations from external JSON file
@@ -84,19 +209,22 @@ func loadDevicesConfig(filename string) ([]DeviceConfig, error) {
 		return nil, fmt.Errorf("failed to parse device config file %s: %w", filename, err)
 	}
 
-	return devicesConfig.Devices, nil
-}
-
-// newHTTPClient creates an HTTP client with a specified timeout and optimized connection settings
-func newHTTPClient(timeout time.Duration) *http.Client {
-	return &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     100 * time.Second,
-		},
+	if err := devicesConfig.resolveFleets(); err != nil {
+		return nil, fmt.Errorf("invalid fleets in %s: %w", filename, err)
 	}
+	for i := range devicesConfig.Devices {
+		if err := devicesConfig.Devices[i].resolveSensors(); err != nil {
+			return nil, fmt.Errorf("invalid sensors of device %s in %s: %w", devicesConfig.Devices[i].DeviceID, filename, err)
+		}
+		if err := devicesConfig.Devices[i].resolveFirmware(); err != nil {
+			return nil, fmt.Errorf("invalid firmware of device %s in %s: %w", devicesConfig.Devices[i].DeviceID, filename, err)
+		}
+		if err := devicesConfig.Devices[i].resolveMovement(); err != nil {
+			return nil, fmt.Errorf("invalid movement of device %s in %s: %w", devicesConfig.Devices[i].DeviceID, filename, err)
+		}
+	}
+
+	return devicesConfig.Devices, nil
 }
 
 // handleShutdown handles graceful shutdown on system signals
@@ -110,6 +238,53 @@ func handleShutdown(cancelFunc context.CancelFunc) {
 }
 
 func main() {
+	if err := runCommand(os.Args[1:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+		log.Fatal(err)
+	}
+}
+
+// simulationInputs are what a run sends: the simulated devices, or the recorded metrics to replay
+type simulationInputs struct {
+	devices []DeviceConfig
+	replay  []Metrics
+	ramp    *loadRamp
+}
+
+// loadInputs loads the device configurations from their file, or the recorded metrics
+// to replay, and the load ramp of the devices
+func loadInputs(cfg Config) (simulationInputs, error) {
+	var in simulationInputs
+	var err error
+	if cfg.Replay.File != "" {
+		if cfg.Transport == transportOTLP {
+			return in, fmt.Errorf("replay sends over %q or %q, not %q", devicetransport.ProtocolHTTP, devicetransport.ProtocolCoAP, transportOTLP)
+		}
+		if in.replay, err = loadReplay(cfg.Replay.File); err != nil {
+			return in, fmt.Errorf("failed to load replay: %w", err)
+		}
+	} else {
+		if in.devices, err = loadDevicesConfig(cfg.DeviceConfigFile); err != nil {
+			return in, fmt.Errorf("failed to load device configurations: %w", err)
+		}
+		log.Printf("Loaded %d device configurations from %s", len(in.devices), cfg.DeviceConfigFile)
+	}
+
+	deviceIDs := make([]string, 0, len(in.devices))
+	for _, deviceConfig := range in.devices {
+		deviceIDs = append(deviceIDs, deviceConfig.DeviceID)
+	}
+	if in.ramp, err = newLoadRamp(cfg.Ramp, deviceIDs); err != nil {
+		return in, fmt.Errorf("invalid ramp configuration: %w", err)
+	}
+	if cfg.MetricBatch.enabled() && cfg.Transport == devicetransport.ProtocolCoAP {
+		return in, fmt.Errorf("metric batches are sent over %q, not %q", devicetransport.ProtocolHTTP, devicetransport.ProtocolCoAP)
+	}
+	return in, nil
+}
+
+// runSimulation simulates the devices of cfg, or replays the recorded metrics, until
+// SIGTERM or SIGINT
+func runSimulation(cfg Config, in simulationInputs) {
 	log.Println("Starting IoT device simulation system...")
 
 	// Start root context with cancel function
@@ -119,17 +294,11 @@ func main() {
 	// Start a goroutine to handle shutdown signals
 	go handleShutdown(cancel)
 
-	// Load main configuration settings
-	cfg := loadConfig()
-
-	// Load device configurations from external file
-	deviceConfigs, err := loadDevicesConfig(cfg.DeviceConfigFile)
-	if err != nil {
-		log.Fatalf("Failed to load device configurations: %v", err)
+	deviceConfigs, replay, ramp := in.devices, in.replay, in.ramp
+	if cfg.Seed != 0 {
+		log.Printf("Seeded run with seed %d, device readings and events repeat at every run", cfg.Seed)
 	}
 
-	log.Printf("Loaded %d device configurations from %s", len(deviceConfigs), cfg.DeviceConfigFile)
-
 	// Setup OpenTelemetry tracer
 	shutdown, err := setupTracer()
 	if err != nil {
@@ -137,44 +306,175 @@ func main() {
 	}
 	defer shutdown(ctx)
 
-	// Create a tracer instance and HTTP client
+	// Setup the meter of the simulator's own metrics, shut down after the senders stop
+	shutdownMeter, err := setupMeter(ctx, cfg.SelfTelemetry, cfg.OTLP)
+	if err != nil {
+		log.Fatalf("Meter error: %v", err)
+	}
+	defer shutdownMeter(context.Background())
+	meter := otel.Meter("device-simulator")
+
+	// Emit the events of the catalog the server hands out, the built-in one if it can't be fetched
+	if cfg.EventCatalogURL != "" {
+		catalog, err := devicetransport.FetchEventCatalog(ctx, cfg.EventCatalogURL, cfg.TLS, cfg.Auth, 30*time.Second)
+		if err != nil {
+			log.Printf("Event catalog fetch failed, using the built-in catalog version %d: %v", devicetransport.DefaultEventCatalog.Version, err)
+		} else {
+			devicetransport.UseEventCatalog(catalog)
+			log.Printf("Using event catalog version %d with %d events from %s", catalog.Version, len(catalog.Events), cfg.EventCatalogURL)
+		}
+	}
+
+	// Create a tracer instance and the transport shared by all devices, keeping one
+	// idle connection per worker so the pool reuses them; fleets with their own
+	// endpoints get a transport of their own; the commands the server returns go to
+	// the device they are for
 	tracer := otel.Tracer("device-simulator")
-	client := newHTTPClient(30 * time.Second)
+	commands := newCommandRouter()
+	protocol := cfg.Transport
+	if protocol == transportOTLP {
+		protocol = devicetransport.ProtocolHTTP
+	}
+	transports, err := newFleetTransports(devicetransport.Config{
+		Protocol:     protocol,
+		MetricURL:    cfg.MetricURL,
+		LogURL:       cfg.LogURL,
+		Timeout:      30 * time.Second,
+		MaxIdleConns: cfg.Workers,
+
+		MetricBatchURL: cfg.MetricBatchURL,
+
+		Compression:          cfg.Compression,
+		CompressionThreshold: cfg.CompressionThreshold,
+		Encoding:             cfg.Encoding,
+		TLS:                  cfg.TLS,
+		Auth:                 cfg.Auth,
+		OnCommands:           commands.handle,
+	}, tracer, meter)
+	if err != nil {
+		log.Fatalf("Transport error: %v", err)
+	}
+	defer transports.Close()
+
+	// Replay mode sends the recorded metrics in place of the simulated devices
+	if replay != nil {
+		pool := newSendPool(cfg.Workers, cfg.QueueSize)
+		go pool.run(ctx)
+		runReplay(ctx, pool, transports.shared, replay, cfg.Replay)
+		log.Println("Shutdown complete")
+		return
+	}
+
+	// Metrics are batched per endpoint, the otlp transport has its own export batches
+	batched := cfg.MetricBatch.enabled() && cfg.Transport != transportOTLP
+	batchers := make(map[devicetransport.Transport]*metricBatcher)
+
+	// Devices exporting OTLP share one gRPC connection to the collector
+	var exporter sdkmetric.Exporter
+	switch cfg.Transport {
+	case transportOTLP:
+		exporter, err = newOTLPExporter(ctx, cfg.OTLP)
+		if err != nil {
+			log.Fatalf("OTLP exporter error: %v", err)
+		}
+		defer exporter.Shutdown(context.Background())
+	}
 
 	// Initialize senders for all devices
 	logSenders := make([]*LogSender, 0, len(deviceConfigs))
-	metricSenders := make([]*MetricSender, 0, len(deviceConfigs))
+	metricSenders := make([]deviceMetricSender, 0, len(deviceConfigs))
+	simulated := make([]*MetricSender, 0, len(deviceConfigs))
 
 	for _, deviceConfig := range deviceConfigs {
+		// Metrics and events are drawn from separate sources, reproducible with a seed
+		seed := cfg.Seed
+		if deviceConfig.Seed != 0 {
+			seed = deviceConfig.Seed
+		}
+
+		transport, err := transports.forDevice(deviceConfig.DeviceID, deviceConfig.fleet)
+		if err != nil {
+			log.Fatalf("Transport error: %v", err)
+		}
+
 		// Create log sender for this device
-		logSender := NewLogSender(client, tracer, deviceConfig.DeviceID, cfg.LogURL)
+		logSender := NewLogSender(transport, deviceConfig.DeviceID, newDeviceRand(seed, deviceConfig.DeviceID, "events"))
+		logSender.prioritySeverity = cfg.PrioritySeverity
+		if cfg.LogSpill.enabled() {
+			if logSender.spill, err = newLogSpill(cfg.LogSpill, deviceConfig.DeviceID); err != nil {
+				log.Fatalf("Log spill error: %v", err)
+			}
+		}
 		logSenders = append(logSenders, logSender)
 
 		// Create metric sender for this device
-		metricSender := NewMetricSender(deviceConfig, client, tracer, cfg.MetricURL)
-		metricSenders = append(metricSenders, metricSender)
+		metricSender := NewMetricSender(deviceConfig, transport, cfg.Retry, newDeviceRand(seed, deviceConfig.DeviceID, "metrics"), logSender)
+		if batched {
+			if batchers[transport] == nil {
+				batchers[transport] = newMetricBatcher(cfg.MetricBatch, cfg.Retry, transport)
+			}
+			metricSender.batcher = batchers[transport]
+		}
+		simulated = append(simulated, metricSender)
+		commands.register(metricSender)
+		if exporter == nil {
+			metricSenders = append(metricSenders, metricSender)
+		} else {
+			otlpSender, err := NewOTLPSender(metricSender, exporter)
+			if err != nil {
+				log.Fatalf("Failed to create OTLP sender: %v", err)
+			}
+			defer otlpSender.Shutdown(context.Background())
+			metricSenders = append(metricSenders, otlpSender)
+		}
 
-		log.Printf("Started device: %s at location (%.4f, %.4f, %.0fm)", 
+		log.Printf("Started device: %s at location (%.4f, %.4f, %.0fm), firmware %s", 
 			deviceConfig.DeviceID, 
 			deviceConfig.GeoPosition.Latitude, 
 			deviceConfig.GeoPosition.Longitude,
-			deviceConfig.GeoPosition.Altitude)
+			deviceConfig.GeoPosition.Altitude,
+			deviceConfig.Firmware.Version)
 	}
 
 	// Start background goroutines
 	// Casual events/logs to simulate devices' internal operations
-	go runEventGenerators(ctx, logSenders, cfg.EventGenInterval)
+	go runEventGenerators(ctx, logSenders, cfg.EventGenInterval, cfg.EventGen, ramp)
+
+	// Batches that do not fill up in time are sent anyway
+	for _, b := range batchers {
+		go b.run(ctx)
+	}
+
+	// Devices join the load as the ramp goes
+	if ramp != nil {
+		go ramp.run(ctx)
+	}
+
+	// Sends of every device are run by a bounded pool of workers
+	pool := newSendPool(cfg.Workers, cfg.QueueSize)
+	go pool.run(ctx)
+
+	// Admin API to trigger anomalies on demand and report the status of the simulation
+	if cfg.AdminAddr != "" {
+		go newAdminServer(simulated, logSenders, pool, ramp).run(ctx, cfg.AdminAddr)
+	}
+	if err := registerSimulatorObservers(meter, pool, logSenders); err != nil {
+		log.Fatalf("Self telemetry error: %v", err)
+	}
 
 	// Send logs periodically in batches
-	go runLogSenders(ctx, logSenders, cfg.BatchInterval, cfg.BatchSize)
+	go runLogSenders(ctx, pool, logSenders, cfg.BatchInterval, cfg.BatchSize, cfg.SendJitter, ramp)
 
 	// Send metrics periodically
-	go runMetricSenders(ctx, metricSenders, cfg.MetricInterval)
+	go runMetricSenders(ctx, pool, metricSenders, cfg.MetricInterval, cfg.SendJitter, ramp)
 
-	log.Printf("System started with %d devices. Sending metrics every %v", 
-		len(deviceConfigs), cfg.MetricInterval)
+	log.Printf("System started with %d devices. Sending metrics every %v over %s", 
+		len(deviceConfigs), cfg.MetricInterval, cfg.Transport)
 
-	// Wait for shutdown signal
+	// Wait for shutdown signal, then flush the events the devices still hold
 	<-ctx.Done()
+	drainLogSenders(logSenders, cfg.BatchSize, cfg.Workers, cfg.DrainTimeout)
+	spillLogSenders(logSenders)
+	drainMetricBatchers(slices.Collect(maps.Values(batchers)), cfg.DrainTimeout)
 	log.Println("Shutdown complete")
 }
\ No newline at end of file
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"devicetransport"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Scopes of the metric batches
+const (
+	batchScopeDevice = "device" // a batch holds the samples of one device
+	batchScopeAll    = "all"    // a batch holds the samples of every device sharing the endpoint
+)
+
+// MetricBatchConfig sends the metrics Size at a time in one request to the metric batch
+// URL, instead of one request per sample; Size 0 or 1 keeps one request per sample
+type MetricBatchConfig struct {
+	Size     int           `json:"size"`
+	Scope    string        `json:"scope"`     // "device" by default, or "all"
+	MaxDelay time.Duration `json:"max_delay"` // a batch not full is sent after this, the metric interval by default
+}
+
+// enabled reports whether the metrics are batched
+func (c MetricBatchConfig) enabled() bool {
+	return c.Size > 1
+}
+
+// validate checks the batch config and fills in its defaults
+func (c *MetricBatchConfig) validate(metricInterval time.Duration) error {
+	switch c.Scope {
+	case "":
+		c.Scope = batchScopeDevice
+	case batchScopeDevice, batchScopeAll:
+	default:
+		return fmt.Errorf("unknown metric batch scope %q, must be %q or %q", c.Scope, batchScopeDevice, batchScopeAll)
+	}
+	if c.MaxDelay < 0 {
+		return fmt.Errorf("metric batch max_delay must not be negative")
+	}
+	if c.MaxDelay == 0 {
+		c.MaxDelay = metricInterval
+	}
+	return nil
+}
+
+// pendingBatch is a batch being filled, with the time of its first sample
+type pendingBatch struct {
+	metrics []interface{}
+	since   time.Time
+}
+
+// metricBatcher collects the metrics of the devices sending to one endpoint and sends
+// them in batches; a batch that fails after the retries is dropped, it is not buffered
+type metricBatcher struct {
+	cfg       MetricBatchConfig
+	retry     RetryConfig
+	transport devicetransport.Transport
+
+	mu      sync.Mutex
+	pending map[string]*pendingBatch // by device, or under "" with scope all
+	dropped uint64
+}
+
+// newMetricBatcher creates the batcher of the devices sending through transport
+func newMetricBatcher(cfg MetricBatchConfig, retry RetryConfig, transport devicetransport.Transport) *metricBatcher {
+	return &metricBatcher{
+		cfg:       cfg,
+		retry:     retry,
+		transport: transport,
+		pending:   make(map[string]*pendingBatch),
+	}
+}
+
+// key returns the batch a device adds its metrics to
+func (b *metricBatcher) key(deviceID string) string {
+	if b.cfg.Scope == batchScopeAll {
+		return ""
+	}
+	return deviceID
+}
+
+// add queues a metric of a device, sending the batch once it is full
+func (b *metricBatcher) add(ctx context.Context, deviceID string, m Metrics) error {
+	key := b.key(deviceID)
+
+	b.mu.Lock()
+	p, ok := b.pending[key]
+	if !ok {
+		p = &pendingBatch{since: time.Now()}
+		b.pending[key] = p
+	}
+	p.metrics = append(p.metrics, m)
+	full := len(p.metrics) >= b.cfg.Size
+	if full {
+		delete(b.pending, key)
+	}
+	b.mu.Unlock()
+
+	if !full {
+		return nil
+	}
+	return b.send(ctx, key, p.metrics)
+}
+
+// send sends a batch with retries, dropping it when they all fail
+func (b *metricBatcher) send(ctx context.Context, key string, metrics []interface{}) error {
+	who := key
+	if who == "" {
+		who = "batch"
+	}
+	err := retry(ctx, b.retry, who, func(ctx context.Context) error {
+		return b.transport.SendMetricBatch(ctx, key, metrics)
+	})
+	if err != nil {
+		b.mu.Lock()
+		b.dropped += uint64(len(metrics))
+		dropped := b.dropped
+		b.mu.Unlock()
+		log.Printf("[%s] Metric batch error, %d metrics dropped (%d in total): %v", who, len(metrics), dropped, err)
+		return err
+	}
+	log.Printf("[%s] Metric batch of %d sent", who, len(metrics))
+	return nil
+}
+
+// take removes the pending batches, all of them or only those older than maxDelay
+func (b *metricBatcher) take(all bool) map[string]*pendingBatch {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	taken := make(map[string]*pendingBatch)
+	for key, p := range b.pending {
+		if all || time.Since(p.since) >= b.cfg.MaxDelay {
+			taken[key] = p
+			delete(b.pending, key)
+		}
+	}
+	return taken
+}
+
+// run sends the batches that waited MaxDelay without filling up, until the context is cancelled
+func (b *metricBatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(max(b.cfg.MaxDelay/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for key, p := range b.take(false) {
+			b.send(ctx, key, p.metrics)
+		}
+	}
+}
+
+// drainMetricBatchers sends the batches not full yet once the simulation stops,
+// giving up after timeout; 0 skips the drain
+func drainMetricBatchers(batchers []*metricBatcher, timeout time.Duration) {
+	if timeout <= 0 || len(batchers) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, b := range batchers {
+		for key, p := range b.take(true) {
+			b.send(ctx, key, p.metrics)
+		}
+	}
+}
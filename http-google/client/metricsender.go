@@ -1,19 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"devicetransport"
 	"fmt"
-	"github.com/fxamacker/cbor/v2"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/trace"
-	"gonum.org/v1/gonum/stat/distuv"
-	"log"
-	//"math/rand"
-	"net/http"
-	"time"
 )
 // GeoPosition represents the geographical coordinates of a device
 type GeoPosition struct {
@@ -28,6 +26,8 @@ type ExternalSensors struct {
 	BarometerHPa  float64 `cbor:"barometer_hpa" json:"barometer_hpa"`     // Atmospheric pressure in hPa
 	HygrometerRH  float64 `cbor:"hygrometer_rh" json:"hygrometer_rh"`     // Relative humidity percentage
 	AnemometerMPS float64 `cbor:"anemometer_mps" json:"anemometer_mps"`   // Wind speed in m/s
+	PM25UGM3      float64 `cbor:"pm25_ugm3" json:"pm25_ugm3"`             // Fine particulate matter in µg/m³
+	CO2PPM        float64 `cbor:"co2_ppm" json:"co2_ppm"`                 // Carbon dioxide in ppm
 }
 
 // Metrics represents the telemetry data collected from a device
@@ -37,7 +37,16 @@ type Metrics struct {
 	Timestamp        time.Time       `cbor:"timestamp" json:"timestamp"`
 	MCUUsagePercent  float64         `cbor:"mcu_usage_percent" json:"mcu_usage_percent"`
 	MCUTempC         float64         `cbor:"mcu_temp_c" json:"mcu_temp_c"`
+	BatteryPercent   float64         `cbor:"battery_percent" json:"battery_percent"`
+	RSSIDBm          float64         `cbor:"rssi_dbm" json:"rssi_dbm"` // Radio signal strength in dBm
 	ExternalSensors  ExternalSensors `cbor:"external_sensors" json:"external_sensors"`
+	FirmwareVersion  string          `cbor:"firmware_version" json:"firmware_version"`
+	SchemaVersion    uint32          `cbor:"schema_version,omitempty" json:"schema_version,omitempty"` // Version of the payload schema, devicetransport.PayloadSchemaVersion
+
+	// Fleet of the device, empty when it belongs to none
+	Fleet  string            `cbor:"fleet,omitempty" json:"fleet,omitempty"`
+	Region string            `cbor:"region,omitempty" json:"region,omitempty"`
+	Labels map[string]string `cbor:"labels,omitempty" json:"labels,omitempty"`
 }
 
 // DeviceConfig represents the configuration for a single device
@@ -50,65 +59,174 @@ type DeviceConfig struct {
 	BaseBarometer    float64 `json:"base_barometer"`
 	BaseHygrometer   float64 `json:"base_hygrometer"`
 	BaseAnemometer   float64 `json:"base_anemometer"`
+	BaseRSSI         float64 `json:"base_rssi"`
+	BasePM25         float64 `json:"base_pm25"`
+	BaseCO2          float64 `json:"base_co2"`
+
+	// BatteryLifeHours is how long a full charge lasts at 50% MCU usage
+	BatteryLifeHours float64 `json:"battery_life_hours"`
+
+	// Seed overrides the run seed for this device, so it repeats the same readings
+	Seed uint64 `json:"seed"`
+
+	// Firmware is the version the device starts with and how its OTA updates go
+	Firmware FirmwareConfig `json:"firmware"`
+
+	// Movement makes the device mobile, starting from GeoPosition
+	Movement MovementConfig `json:"movement"`
+
+	// FleetName is the fleet of devices.json the device belongs to, if any
+	FleetName string `json:"fleet"`
+	fleet     FleetConfig
+
+	// Sensors overrides the distribution of single sensors, keyed by sensor name
+	Sensors map[string]SensorConfig `json:"sensors"`
+	sensors map[string]sensorModel
 }
 
 // MetricSender simulates a device sending metrics to a remote server
 type MetricSender struct {
-	Config   DeviceConfig
-	Client   *http.Client
-	Tracer   trace.Tracer
-	URL      string
+	Config    DeviceConfig
+	Transport devicetransport.Transport
+	battery   *battery
+	firmware  *firmware
+	events    eventSink    // log events of the device, linked to the span of the last metric
+	env       *environment // weather read by the external sensors
+	move      *movement    // position of the device, changing when it is mobile
+	rng       *rand.Rand   // readings and anomalies of the device, reproducible with a seed
+
+	// Offline buffering, the metrics not sent are replayed once the server is reachable
+	retry  RetryConfig
+	buffer *metricBuffer
+
+	// batcher sends the metrics in batches in place of the transport, nil sends each alone
+	batcher *metricBatcher
+
+	// control is what the server changed with its commands
+	control deviceControl
 
-	// Anomaly simulation
+	// Anomaly simulation, also started from the admin API while the device sends
+	anomalyMu           sync.Mutex
 	anomalyStartTime    time.Time
 	anomalyDuration     time.Duration
 	anomalyHoldDuration time.Duration
+	anomalyPeakTemp     float64
 	anomalyActive       bool
 }
 
-// NewMetricSender creates and returns a new MetricSender instance
-func NewMetricSender(config DeviceConfig, client *http.Client, tracer trace.Tracer, url string) *MetricSender {
+// Defaults of the anomalies started at random
+const (
+	defaultAnomalyDuration = 4 * time.Minute
+	defaultAnomalyHold     = 3 * time.Minute
+	defaultAnomalyPeakTemp = 100.0
+)
+
+// readEnvironment returns the reading of an external sensor measuring value from the
+// environment model; a sensor whose distribution is declared in devices.json samples it instead
+func (s *MetricSender) readEnvironment(name string, value float64) float64 {
+	m := s.Config.sensor(name)
+	if m.declared {
+		return m.sample(s.rng)
+	}
+	return m.read(s.rng, value)
+}
+
+// NewMetricSender creates and returns a new MetricSender instance; the firmware
+// update events of the device go to events
+func NewMetricSender(config DeviceConfig, transport devicetransport.Transport, retry RetryConfig, rng *rand.Rand, events eventSink) *MetricSender {
 	return &MetricSender{
-		Config: config,
-		Client: client,
-		Tracer: tracer,
-		URL:    url,
+		Config:    config,
+		Transport: transport,
+		battery:   newBattery(config.BatteryLifeHours, rng),
+		firmware:  newFirmware(config.Firmware, events),
+		events:    events,
+		env:       newEnvironment(config, rng),
+		move:      newMovement(config.Movement, config.GeoPosition, rng),
+		rng:       rng,
+		retry:     retry,
+		buffer:    newMetricBuffer(retry.BufferSize),
 	}
 }
 
-// StartAnomaly activates the anomaly simulation for a fixed duration
-func (s *MetricSender) StartAnomaly(duration time.Duration) {
+// StartAnomaly activates the anomaly simulation: the MCU temperature rises to peakTemp
+// over duration and holds there for the hold duration, replacing a running anomaly
+func (s *MetricSender) StartAnomaly(duration, hold time.Duration, peakTemp float64) {
+	s.anomalyMu.Lock()
+	defer s.anomalyMu.Unlock()
+	s.startAnomaly(duration, hold, peakTemp)
+}
+
+// startAnomaly sets the anomaly state; anomalyMu must be held
+func (s *MetricSender) startAnomaly(duration, hold time.Duration, peakTemp float64) {
 	s.anomalyStartTime = time.Now()
 	s.anomalyDuration = duration
-	s.anomalyHoldDuration = 3 * time.Minute
+	s.anomalyHoldDuration = hold
+	s.anomalyPeakTemp = peakTemp
 	s.anomalyActive = true
+	if s.events != nil {
+		s.events.anomalyStarted()
+	}
+}
+
+// StopAnomaly ends a running anomaly, the next reading is back to normal
+func (s *MetricSender) StopAnomaly() {
+	s.anomalyMu.Lock()
+	defer s.anomalyMu.Unlock()
+	s.anomalyActive = false
+}
+
+// anomalyState is a snapshot of the anomaly of a device
+type anomalyState struct {
+	Active   bool       `json:"active"`
+	Started  *time.Time `json:"started_at,omitempty"`
+	Duration string     `json:"duration,omitempty"`
+	Hold     string     `json:"hold,omitempty"`
+	PeakTemp float64    `json:"peak_temp_c,omitempty"`
+	Ends     *time.Time `json:"ends_at,omitempty"`
+}
+
+// AnomalyState returns the current anomaly of the device
+func (s *MetricSender) AnomalyState() anomalyState {
+	s.anomalyMu.Lock()
+	defer s.anomalyMu.Unlock()
+
+	started := s.anomalyStartTime
+	ends := started.Add(s.anomalyDuration + s.anomalyHoldDuration)
+	if !s.anomalyActive || time.Now().After(ends) {
+		return anomalyState{}
+	}
+	return anomalyState{
+		Active:   true,
+		Started:  &started,
+		Duration: s.anomalyDuration.String(),
+		Hold:     s.anomalyHoldDuration.String(),
+		PeakTemp: s.anomalyPeakTemp,
+		Ends:     &ends,
+	}
 }
 
-// maybeTriggerAnomaly probabilistically starts an anomaly based on a normal distribution
+// maybeTriggerAnomaly starts an anomaly with the anomaly probability of the MCU temperature
 func maybeTriggerAnomaly(s *MetricSender) {
+	s.anomalyMu.Lock()
+	defer s.anomalyMu.Unlock()
 	if s.anomalyActive {
 		return
 	}
 
-	normal := distuv.Normal{
-		Mu:    0,
-		Sigma: 1,
-	}
-	z := normal.Rand()
-
-	if z > 2.0 { // ~2.2% chance of triggering
+	if s.rng.Float64() < s.Config.sensor(sensorMCUTemp).anomalyProbability { // 2.2% chance by default
 		log.Printf("[%s] Triggered anomaly!", s.Config.DeviceID)
-		s.StartAnomaly(time.Minute * 4)
+		s.startAnomaly(defaultAnomalyDuration, defaultAnomalyHold, defaultAnomalyPeakTemp)
 	}
 }
 
-// GenerateMetrics generates realistic metrics with external sensors
+// GenerateMetrics generates realistic metrics with external sensors,
+// sampling each sensor with the distribution of the device config
 func (s *MetricSender) GenerateMetrics() Metrics {
-	// Distributions for each metric
-	mcuUsageDist := distuv.Normal{Mu: 45, Sigma: 15}
-	
+	mcuTempSensor := s.Config.sensor(sensorMCUTemp)
+
 	// MCU temperature - can be affected by anomalies
 	var mcuTemp float64
+	s.anomalyMu.Lock()
 	if s.anomalyActive {
 		elapsed := time.Since(s.anomalyStartTime)
 		totalDuration := s.anomalyDuration + s.anomalyHoldDuration
@@ -116,11 +234,10 @@ func (s *MetricSender) GenerateMetrics() Metrics {
 		if elapsed > totalDuration {
 			// Anomaly ends
 			s.anomalyActive = false
-			normalMCUTempDist := distuv.Normal{Mu: s.Config.BaseMCUTemp, Sigma: 3}
-			mcuTemp = clamp(normalMCUTempDist.Rand(), 20, 70)
+			mcuTemp = mcuTempSensor.sampleNormal(s.rng)
 		} else {
-			maxTemp := 100.0
-			if elapsed <= s.anomalyDuration {
+			maxTemp := s.anomalyPeakTemp
+			if elapsed < s.anomalyDuration {
 				// Warming up
 				progress := float64(elapsed) / float64(s.anomalyDuration)
 				mcuTemp = s.Config.BaseMCUTemp + progress*(maxTemp-s.Config.BaseMCUTemp)
@@ -130,75 +247,105 @@ func (s *MetricSender) GenerateMetrics() Metrics {
 			}
 		}
 	} else {
-		normalMCUTempDist := distuv.Normal{Mu: s.Config.BaseMCUTemp, Sigma: 3}
-		mcuTemp = clamp(normalMCUTempDist.Rand(), 20, 70)
+		mcuTemp = mcuTempSensor.sampleNormal(s.rng)
 	}
+	s.anomalyMu.Unlock()
 
-	// External sensors - simulate environmental variations
-	thermometerDist := distuv.Normal{Mu: s.Config.BaseThermometer, Sigma: 2}
-	barometerDist := distuv.Normal{Mu: s.Config.BaseBarometer, Sigma: 5}
-	hygrometerDist := distuv.Normal{Mu: s.Config.BaseHygrometer, Sigma: 8}
-	anemometerDist := distuv.Normal{Mu: s.Config.BaseAnemometer, Sigma: 1.5}
+	// Battery drains faster under MCU load, higher while a firmware image is downloaded
+	mcuUsage := s.Config.sensor(sensorMCUUsage).sample(s.rng)
+	version, phase := s.firmware.current()
+	if phase == firmwareDownloading {
+		mcuUsage = clamp(mcuUsage+downloadMCULoad, 0, 100)
+	}
 
+	// External sensors - read the weather of the device environment
+	now := time.Now()
+	w := s.env.sample(now)
 	return Metrics{
 		DeviceID:    s.Config.DeviceID,
-		GeoPosition: s.Config.GeoPosition,
-		Timestamp:   time.Now(),
-		MCUUsagePercent: clamp(mcuUsageDist.Rand(), 0, 100),
+		GeoPosition: s.move.advance(now),
+		Timestamp:   now,
+		MCUUsagePercent: mcuUsage,
 		MCUTempC:        mcuTemp,
+		BatteryPercent:  s.battery.read(mcuUsage),
+		RSSIDBm:         s.Config.sensor(sensorRSSI).sample(s.rng),
 		ExternalSensors: ExternalSensors{
-			ThermometerC:  clamp(thermometerDist.Rand(), -40, 60),
-			BarometerHPa:  clamp(barometerDist.Rand(), 950, 1050),
-			HygrometerRH:  clamp(hygrometerDist.Rand(), 10, 100),
-			AnemometerMPS: clamp(anemometerDist.Rand(), 0, 25),
+			ThermometerC:  s.readEnvironment(sensorThermometer, w.temperature),
+			BarometerHPa:  s.readEnvironment(sensorBarometer, w.pressure),
+			HygrometerRH:  s.readEnvironment(sensorHygrometer, w.humidity),
+			AnemometerMPS: s.readEnvironment(sensorAnemometer, w.wind),
+			PM25UGM3:      s.Config.sensor(sensorPM25).sample(s.rng),
+			CO2PPM:        s.Config.sensor(sensorCO2).sample(s.rng),
 		},
+		FirmwareVersion: version,
+		SchemaVersion:   devicetransport.PayloadSchemaVersion,
+		Fleet:           s.Config.fleet.Name,
+		Region:          s.Config.fleet.Region,
+		Labels:          s.Config.fleet.Labels,
 	}
 }
 
-// SendMetric sends the generated metrics to the configured HTTP endpoint
-func (s *MetricSender) SendMetric(ctx context.Context) error {
-	maybeTriggerAnomaly(s)
+// startSpan starts the span of a metric of the device; the events the device generates
+// until the next metric are linked to it, so the server logs them in the same trace
+func (s *MetricSender) startSpan(ctx context.Context) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer("device-simulator").Start(ctx, "DeviceMetric",
+		trace.WithAttributes(attribute.String("device.id", s.Config.DeviceID)),
+		trace.WithAttributes(s.Config.fleet.attributes()...))
+	if s.events != nil {
+		s.events.setActiveSpan(span.SpanContext())
+	}
+	return ctx, span
+}
 
-	ctx, span := s.Tracer.Start(ctx, "SendMetric",
-		trace.WithAttributes(attribute.String("device.id", s.Config.DeviceID)))
+// rebooting advances the firmware update cycle and reports whether the device is
+// rebooting, so it sends no metrics
+func (s *MetricSender) rebooting() bool {
+	s.firmware.step(s.Config.DeviceID, s.rng)
+	if _, phase := s.firmware.current(); phase == firmwareRebooting {
+		log.Printf("[%s] Rebooting for a firmware update, no metrics", s.Config.DeviceID)
+		return true
+	}
+	return false
+}
+
+// SendMetric sends the generated metrics through the configured transport
+func (s *MetricSender) SendMetric(ctx context.Context) error {
+	if s.rebooting() {
+		return nil
+	}
+	ctx, span := s.startSpan(ctx)
 	defer span.End()
+	maybeTriggerAnomaly(s)
 
 	metric := s.GenerateMetrics()
+	s.checkThresholds(metric)
 
 	// Print locally
-	fmt.Printf("[%s] Sending metric: MCU: %.1f%% %.1fC, Ext: %.1fC %.1fhPa %.1f%% %.1fm/s\n", 
+	fmt.Printf("[%s] Sending metric: MCU: %.1f%% %.1fC, Bat: %.0f%% %.0fdBm, Ext: %.1fC %.1fhPa %.1f%% %.1fm/s %.1fug/m3 %.0fppm\n", 
 		s.Config.DeviceID,
 		metric.MCUUsagePercent, metric.MCUTempC,
+		metric.BatteryPercent, metric.RSSIDBm,
 		metric.ExternalSensors.ThermometerC, metric.ExternalSensors.BarometerHPa,
-		metric.ExternalSensors.HygrometerRH, metric.ExternalSensors.AnemometerMPS)
+		metric.ExternalSensors.HygrometerRH, metric.ExternalSensors.AnemometerMPS,
+		metric.ExternalSensors.PM25UGM3, metric.ExternalSensors.CO2PPM)
 
-	// Encode to CBOR
-	payload, err := cbor.Marshal(metric)
-	if err != nil {
-		log.Printf("[%s] CBOR marshal error: %v", s.Config.DeviceID, err)
-		return err
+	if s.batcher != nil {
+		return s.batcher.add(ctx, s.Config.DeviceID, metric)
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(payload))
-	if err != nil {
-		log.Printf("[%s] Request build error: %v", s.Config.DeviceID, err)
-		return err
+	// While offline a single attempt probes the server, instead of retrying every metric
+	err := s.replayBuffered(ctx)
+	if err == nil {
+		err = s.sendWithRetry(ctx, metric)
 	}
-	req.Header.Set("Content-Type", "application/cbor")
-
-	// Inject trace context into HTTP headers
-	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
-	
-	// Perform request
-	resp, err := s.Client.Do(req)
 	if err != nil {
-		log.Printf("[%s] Send error: %v", s.Config.DeviceID, err)
+		s.buffer.push(metric)
+		log.Printf("[%s] Send error, %d metrics buffered (%d dropped): %v",
+			s.Config.DeviceID, s.buffer.len(), s.buffer.dropped, err)
 		return err
 	}
-	defer resp.Body.Close()
 
-	log.Printf("[%s] Metric sent, status: %s", s.Config.DeviceID, resp.Status)
+	log.Printf("[%s] Metric sent", s.Config.DeviceID)
 	return nil
 }
 
@@ -213,21 +360,29 @@ func clamp(val, min, max float64) float64 {
 	return val
 }
 
-// runMetricSenders starts all metric senders on a fixed interval.
-func runMetricSenders(ctx context.Context, senders []*MetricSender, interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Stopping metric senders...")
-			return
-		case <-ticker.C:
-			// creo tutti metric sender necessari
-			for _, sender := range senders {
-				go sender.SendMetric(ctx)
-			}
+// deviceMetricSender is a device sending metrics over one of the transports
+type deviceMetricSender interface {
+	ID() string
+	SendMetric(ctx context.Context) error
+}
+
+// ID returns the device the sender simulates
+func (s *MetricSender) ID() string {
+	return s.Config.DeviceID
+}
+
+// runMetricSenders schedules a metric send per device every interval on the send pool
+func runMetricSenders(ctx context.Context, pool *sendPool, senders []deviceMetricSender, interval time.Duration, jitter float64, ramp *loadRamp) {
+	jobs := make([]sendJob, 0, len(senders))
+	for _, sender := range senders {
+		job := newSendJob("metric", sender.ID(), ramp, sender.SendMetric)
+		// the server can change the interval of the devices it sends commands to
+		if s, ok := sender.(*MetricSender); ok {
+			job.interval = s.metricInterval
 		}
+		jobs = append(jobs, job)
 	}
-}
\ No newline at end of file
+
+	pool.schedule(ctx, jobs, interval, jitter)
+	log.Println("Stopping metric senders...")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"devicetransport/telemetrypb"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Proto returns the metrics as a telemetrypb.Metrics, for the protobuf encoding
+func (m Metrics) Proto() proto.Message {
+	return &telemetrypb.Metrics{
+		DeviceId: m.DeviceID,
+		GeoPosition: &telemetrypb.GeoPosition{
+			Latitude:  m.GeoPosition.Latitude,
+			Longitude: m.GeoPosition.Longitude,
+			Altitude:  m.GeoPosition.Altitude,
+		},
+		Timestamp:       timestamppb.New(m.Timestamp),
+		McuUsagePercent: m.MCUUsagePercent,
+		McuTempC:        m.MCUTempC,
+		BatteryPercent:  m.BatteryPercent,
+		RssiDbm:         m.RSSIDBm,
+		ExternalSensors: &telemetrypb.ExternalSensors{
+			ThermometerC:  m.ExternalSensors.ThermometerC,
+			BarometerHpa:  m.ExternalSensors.BarometerHPa,
+			HygrometerRh:  m.ExternalSensors.HygrometerRH,
+			AnemometerMps: m.ExternalSensors.AnemometerMPS,
+			Pm25Ugm3:      m.ExternalSensors.PM25UGM3,
+			Co2Ppm:        m.ExternalSensors.CO2PPM,
+		},
+		FirmwareVersion: m.FirmwareVersion,
+		SchemaVersion:   m.SchemaVersion,
+		Fleet:           m.Fleet,
+		Region:          m.Region,
+		Labels:          m.Labels,
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Movement modes of a device
+const (
+	movementNone       = ""            // the device stays at its geo_position
+	movementRoute      = "route"       // the device drives through the waypoints in order
+	movementRandomWalk = "random_walk" // the device wanders around its geo_position
+)
+
+// Defaults of a moving device
+const (
+	defaultMovementSpeed  = 10.0   // m/s
+	defaultMovementRadius = 1000.0 // m
+
+	// earthRadius is the mean radius of the Earth in meters
+	earthRadius = 6371000.0
+
+	// headingSigma is how much a random walk turns per second, in radians
+	headingSigma = 0.05
+)
+
+// MovementConfig makes a device mobile, so its latitude and longitude change over time.
+// A route starts at geo_position and goes through the waypoints at speed, then stops at
+// the last one or starts over when loop is set; a random walk wanders within radius of
+// geo_position, never faster than speed
+type MovementConfig struct {
+	Mode      string        `json:"mode"`
+	Waypoints []GeoPosition `json:"waypoints"`
+	Speed     float64       `json:"speed"`  // m/s, 10 by default
+	Radius    float64       `json:"radius"` // m, 1000 by default
+	Loop      bool          `json:"loop"`
+}
+
+// resolveMovement fills in the defaults of the movement config and validates it
+func (c *DeviceConfig) resolveMovement() error {
+	mv := &c.Movement
+	switch mv.Mode {
+	case movementNone:
+		return nil
+	case movementRoute:
+		if len(mv.Waypoints) == 0 {
+			return fmt.Errorf("route needs at least one waypoint")
+		}
+	case movementRandomWalk:
+	default:
+		return fmt.Errorf("unknown movement mode %q, must be %q or %q", mv.Mode, movementRoute, movementRandomWalk)
+	}
+	if mv.Speed < 0 || mv.Radius < 0 {
+		return fmt.Errorf("speed and radius must not be negative")
+	}
+	if mv.Speed == 0 {
+		mv.Speed = defaultMovementSpeed
+	}
+	if mv.Radius == 0 {
+		mv.Radius = defaultMovementRadius
+	}
+	return nil
+}
+
+// movement tracks the position of a device; it is read by the admin API while the
+// device sends, so it has its own lock
+type movement struct {
+	cfg    MovementConfig
+	origin GeoPosition
+	route  []GeoPosition // origin then the waypoints
+	rng    *rand.Rand
+
+	mu       sync.Mutex
+	pos      GeoPosition
+	last     time.Time
+	next     int     // point of the route the device is heading to, len(route) once parked
+	heading  float64 // direction of the random walk, radians clockwise from north
+	velocity float64 // speed of the random walk, m/s
+}
+
+// newMovement creates the movement of a device starting at origin
+func newMovement(cfg MovementConfig, origin GeoPosition, rng *rand.Rand) *movement {
+	m := &movement{cfg: cfg, origin: origin, rng: rng, pos: origin}
+	if cfg.Mode == movementRoute {
+		m.route = append([]GeoPosition{origin}, cfg.Waypoints...)
+		m.next = 1
+		if cfg.Loop && routeLength(m.route) == 0 {
+			m.next = len(m.route) // a lap would go nowhere, stay parked
+		}
+	}
+	if cfg.Mode == movementRandomWalk {
+		m.heading = 2 * math.Pi * rng.Float64()
+		m.velocity = cfg.Speed / 2
+	}
+	return m
+}
+
+// position returns where the device was last seen
+func (m *movement) position() GeoPosition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.pos
+}
+
+// advance moves the device to where it is at time now and returns the position
+func (m *movement) advance(now time.Time) GeoPosition {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dt := 0.0
+	if !m.last.IsZero() {
+		dt = now.Sub(m.last).Seconds()
+	}
+	m.last = now
+	if dt <= 0 {
+		return m.pos
+	}
+
+	switch m.cfg.Mode {
+	case movementRoute:
+		m.drive(m.cfg.Speed * dt)
+	case movementRandomWalk:
+		m.wander(dt)
+	}
+	return m.pos
+}
+
+// drive moves the device distance meters along the route
+func (m *movement) drive(distance float64) {
+	for distance > 0 && m.next < len(m.route) {
+		target := m.route[m.next]
+		left := geoDistance(m.pos, target)
+		if distance < left {
+			m.pos = interpolate(m.pos, target, distance/left)
+			return
+		}
+		distance -= left
+		m.pos = target
+		m.next++
+		if m.next == len(m.route) && m.cfg.Loop {
+			m.next = 0 // back to geo_position, then the next lap
+		}
+	}
+}
+
+// routeLength returns the length of a lap of the route in meters, back to the start
+func routeLength(route []GeoPosition) float64 {
+	length := 0.0
+	for i := range route {
+		length += geoDistance(route[i], route[(i+1)%len(route)])
+	}
+	return length
+}
+
+// wander moves the device for dt seconds of a random walk: the heading and the speed
+// drift, and the device turns back toward its origin once past the radius
+func (m *movement) wander(dt float64) {
+	m.heading += headingSigma * math.Sqrt(dt) * m.rng.NormFloat64()
+	m.velocity = clamp(m.velocity+0.1*m.cfg.Speed*math.Sqrt(dt)*m.rng.NormFloat64(), 0, m.cfg.Speed)
+
+	if geoDistance(m.pos, m.origin) > m.cfg.Radius {
+		m.heading = bearing(m.pos, m.origin)
+	}
+	m.pos = offset(m.pos, m.heading, m.velocity*dt)
+}
+
+// geoDistance returns the great circle distance between a and b in meters
+func geoDistance(a, b GeoPosition) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLat, dLon := lat2-lat1, radians(b.Longitude-a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// bearing returns the initial direction from a to b, radians clockwise from north
+func bearing(a, b GeoPosition) float64 {
+	lat1, lat2 := radians(a.Latitude), radians(b.Latitude)
+	dLon := radians(b.Longitude - a.Longitude)
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Atan2(y, x)
+}
+
+// offset returns the position distance meters from p toward heading; over the short
+// hops of a device the Earth is flat enough
+func offset(p GeoPosition, heading, distance float64) GeoPosition {
+	dLat := distance * math.Cos(heading) / earthRadius
+	dLon := distance * math.Sin(heading) / (earthRadius * math.Cos(radians(p.Latitude)))
+	p.Latitude += dLat * 180 / math.Pi
+	p.Longitude += dLon * 180 / math.Pi
+	return p
+}
+
+// interpolate returns the position at fraction f of the way from a to b
+func interpolate(a, b GeoPosition, f float64) GeoPosition {
+	return GeoPosition{
+		Latitude:  a.Latitude + (b.Latitude-a.Latitude)*f,
+		Longitude: a.Longitude + (b.Longitude-a.Longitude)*f,
+		Altitude:  a.Altitude + (b.Altitude-a.Altitude)*f,
+	}
+}
+
+// radians converts degrees to radians
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RetryConfig configures how a device retries metrics and buffers the ones it could not send
+type RetryConfig struct {
+	MaxAttempts    int           `json:"max_attempts"`    // Sends of a metric before it is buffered
+	InitialBackoff time.Duration `json:"initial_backoff"` // Wait before the first retry, doubled at each one
+	MaxBackoff     time.Duration `json:"max_backoff"`
+	BufferSize     int           `json:"buffer_size"` // Metrics kept per device while offline, 0 disables buffering
+}
+
+// metricBuffer is a ring of the metrics a device could not send, oldest first;
+// once full the oldest metric is dropped to make room
+type metricBuffer struct {
+	items   []Metrics
+	head    int
+	size    int
+	dropped uint64
+}
+
+// newMetricBuffer creates a buffer holding up to capacity metrics
+func newMetricBuffer(capacity int) *metricBuffer {
+	return &metricBuffer{items: make([]Metrics, max(capacity, 0))}
+}
+
+// len returns the number of buffered metrics
+func (b *metricBuffer) len() int {
+	return b.size
+}
+
+// push appends a metric, dropping the oldest one if the buffer is full
+func (b *metricBuffer) push(m Metrics) {
+	if len(b.items) == 0 {
+		b.dropped++
+		return
+	}
+	if b.size == len(b.items) {
+		b.head = (b.head + 1) % len(b.items)
+		b.size--
+		b.dropped++
+	}
+	b.items[(b.head+b.size)%len(b.items)] = m
+	b.size++
+}
+
+// peek returns the oldest buffered metric
+func (b *metricBuffer) peek() Metrics {
+	return b.items[b.head]
+}
+
+// pop removes the oldest buffered metric
+func (b *metricBuffer) pop() {
+	b.items[b.head] = Metrics{}
+	b.head = (b.head + 1) % len(b.items)
+	b.size--
+}
+
+// replayBuffered sends the buffered metrics oldest first with their original timestamps,
+// stopping at the first failure so the rest stay buffered
+func (s *MetricSender) replayBuffered(ctx context.Context) error {
+	replayed := 0
+	for s.buffer.len() > 0 {
+		if err := s.Transport.SendMetrics(ctx, s.Config.DeviceID, s.buffer.peek()); err != nil {
+			return err
+		}
+		s.buffer.pop()
+		replayed++
+	}
+	if replayed > 0 {
+		log.Printf("[%s] Server reachable again, replayed %d buffered metrics", s.Config.DeviceID, replayed)
+	}
+	return nil
+}
+
+// sendWithRetry sends a metric, retrying with exponential backoff up to MaxAttempts times
+func (s *MetricSender) sendWithRetry(ctx context.Context, metric Metrics) error {
+	return retry(ctx, s.retry, s.Config.DeviceID, func(ctx context.Context) error {
+		return s.Transport.SendMetrics(ctx, s.Config.DeviceID, metric)
+	})
+}
+
+// retry calls send until it succeeds, up to cfg.MaxAttempts times with exponential backoff;
+// who names the sender in the log
+func retry(ctx context.Context, cfg RetryConfig, who string, send func(ctx context.Context) error) error {
+	backoff := cfg.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := send(ctx)
+		if err == nil || attempt >= cfg.MaxAttempts {
+			return err
+		}
+
+		wait := jittered(backoff, 0.2)
+		log.Printf("[%s] Send attempt %d failed, retrying in %v: %v", who, attempt, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, cfg.MaxBackoff)
+	}
+}
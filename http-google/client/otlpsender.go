@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// transportOTLP exports metrics over OTLP/gRPC straight to a collector, next to the devicetransport protocols
+const transportOTLP = "otlp"
+
+// OTLPConfig configures the collector devices export to with the otlp transport
+type OTLPConfig struct {
+	Endpoint string `json:"endpoint"`
+	Insecure bool   `json:"insecure"`
+}
+
+// newOTLPExporter creates the gRPC exporter shared by all devices, so they use a single connection
+func newOTLPExporter(ctx context.Context, cfg OTLPConfig) (sdkmetric.Exporter, error) {
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+	exporter, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter for %s: %w", cfg.Endpoint, err)
+	}
+	return exporter, nil
+}
+
+// OTLPSender simulates a device exporting its own metrics as OTLP: each device has a
+// meter provider with its own resource, collected on demand and pushed through the exporter.
+// The gauges have the same names and attributes as the ones the server derives from CBOR.
+type OTLPSender struct {
+	metrics  *MetricSender
+	exporter sdkmetric.Exporter
+	reader   *sdkmetric.ManualReader
+	provider *sdkmetric.MeterProvider
+
+	mu     sync.Mutex
+	latest Metrics
+}
+
+// NewOTLPSender creates the meter provider and gauges of a device
+func NewOTLPSender(metrics *MetricSender, exporter sdkmetric.Exporter) (*OTLPSender, error) {
+	cfg := metrics.Config
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "device-simulator"),
+		attribute.String("service.instance.id", cfg.DeviceID),
+	)
+	reader := sdkmetric.NewManualReader()
+	s := &OTLPSender{
+		metrics:  metrics,
+		exporter: exporter,
+		reader:   reader,
+		provider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res)),
+	}
+
+	meter := s.provider.Meter("device-simulator")
+	type deviceGauge struct {
+		gauge metric.Float64ObservableGauge
+		value func(m Metrics) float64
+	}
+	var gauges []deviceGauge
+	instruments := make([]metric.Observable, 0, 10)
+	for _, g := range []struct {
+		name, description string
+		value             func(m Metrics) float64
+	}{
+		{"custom.googleapis.com/mcu_percent", "Percentuale di utilizzo della MCU",
+			func(m Metrics) float64 { return m.MCUUsagePercent }},
+		{"custom.googleapis.com/mcu_temp_celsius", "Temperatura della MCU (gradi Celsius)",
+			func(m Metrics) float64 { return m.MCUTempC }},
+		{"custom.googleapis.com/external_thermometer_celsius", "Temperatura esterna (gradi Celsius)",
+			func(m Metrics) float64 { return m.ExternalSensors.ThermometerC }},
+		{"custom.googleapis.com/barometer_hpa", "Pressione atmosferica (hPa)",
+			func(m Metrics) float64 { return m.ExternalSensors.BarometerHPa }},
+		{"custom.googleapis.com/hygrometer_rh", "Umidità relativa (%)",
+			func(m Metrics) float64 { return m.ExternalSensors.HygrometerRH }},
+		{"custom.googleapis.com/anemometer_mps", "Velocità del vento (m/s)",
+			func(m Metrics) float64 { return m.ExternalSensors.AnemometerMPS }},
+		{"custom.googleapis.com/battery_percent", "Livello della batteria (%)",
+			func(m Metrics) float64 { return m.BatteryPercent }},
+		{"custom.googleapis.com/rssi_dbm", "Potenza del segnale radio (dBm)",
+			func(m Metrics) float64 { return m.RSSIDBm }},
+		{"custom.googleapis.com/pm25_ugm3", "Particolato PM2.5 (µg/m³)",
+			func(m Metrics) float64 { return m.ExternalSensors.PM25UGM3 }},
+		{"custom.googleapis.com/co2_ppm", "Anidride carbonica (ppm)",
+			func(m Metrics) float64 { return m.ExternalSensors.CO2PPM }},
+	} {
+		gauge, err := meter.Float64ObservableGauge(g.name, metric.WithDescription(g.description))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s gauge: %w", g.name, err)
+		}
+		gauges = append(gauges, deviceGauge{gauge: gauge, value: g.value})
+		instruments = append(instruments, gauge)
+	}
+
+	_, err := meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		s.mu.Lock()
+		m := s.latest
+		s.mu.Unlock()
+
+		// The firmware version changes after an OTA update and the position of a mobile
+		// device as it moves, the series show when
+		pos := s.metrics.move.position()
+		labels := metric.WithAttributes(append([]attribute.KeyValue{
+			attribute.String("device_id", cfg.DeviceID),
+			attribute.Float64("latitude", pos.Latitude),
+			attribute.Float64("longitude", pos.Longitude),
+			attribute.Float64("altitude", pos.Altitude),
+			attribute.String("firmware_version", m.FirmwareVersion),
+		}, cfg.fleet.attributes()...)...)
+
+		for _, g := range gauges {
+			observer.ObserveFloat64(g.gauge, g.value(m), labels)
+		}
+		return nil
+	}, instruments...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register gauges of %s: %w", cfg.DeviceID, err)
+	}
+	return s, nil
+}
+
+// ID returns the device the sender simulates
+func (s *OTLPSender) ID() string {
+	return s.metrics.Config.DeviceID
+}
+
+// SendMetric generates a new reading and exports it to the collector
+func (s *OTLPSender) SendMetric(ctx context.Context) error {
+	if s.metrics.rebooting() {
+		return nil
+	}
+	ctx, span := s.metrics.startSpan(ctx)
+	defer span.End()
+	maybeTriggerAnomaly(s.metrics)
+
+	m := s.metrics.GenerateMetrics()
+	s.mu.Lock()
+	s.latest = m
+	s.mu.Unlock()
+
+	var rm metricdata.ResourceMetrics
+	if err := s.reader.Collect(ctx, &rm); err != nil {
+		return fmt.Errorf("failed to collect metrics: %w", err)
+	}
+	if err := s.exporter.Export(ctx, &rm); err != nil {
+		return fmt.Errorf("failed to export metrics: %w", err)
+	}
+
+	log.Printf("[%s] Metric exported over OTLP", s.metrics.Config.DeviceID)
+	return nil
+}
+
+// Shutdown releases the meter provider of the device
+func (s *OTLPSender) Shutdown(ctx context.Context) error {
+	return s.provider.Shutdown(ctx)
+}
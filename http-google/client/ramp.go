@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// Load profiles of the simulated fleet
+const (
+	rampNone   = ""       // every device sends from the start
+	rampLinear = "linear" // start devices, then step more every interval up to max
+	rampSine   = "sine"   // the active devices swing between start and max every period
+)
+
+// rampLogInterval is how often the active devices are checked to log a change
+const rampLogInterval = 10 * time.Second
+
+// RampConfig shapes the load of the simulator so the ingestion stack can be load
+// tested gradually; devices past the active count stay idle, in devices.json order
+type RampConfig struct {
+	Profile  string        `json:"profile"`
+	Start    int           `json:"start"`    // devices active at the start, the low of the sine
+	Step     int           `json:"step"`     // devices added every interval with linear
+	Interval time.Duration `json:"interval"` // time between two steps with linear
+	Max      int           `json:"max"`      // most devices active, all of them when 0
+	Period   time.Duration `json:"period"`   // period of the sine
+}
+
+// loadRamp decides which devices are active at a given time
+type loadRamp struct {
+	cfg   RampConfig
+	begin time.Time
+	index map[string]int // position of each device in devices.json
+}
+
+// newLoadRamp validates the ramp config for the given devices; it returns nil,
+// every device active, when no profile is set
+func newLoadRamp(cfg RampConfig, deviceIDs []string) (*loadRamp, error) {
+	switch cfg.Profile {
+	case rampNone:
+		return nil, nil
+	case rampLinear:
+		if cfg.Step <= 0 || cfg.Interval <= 0 {
+			return nil, fmt.Errorf("linear ramp needs a positive step and interval")
+		}
+	case rampSine:
+		if cfg.Period <= 0 {
+			return nil, fmt.Errorf("sine ramp needs a positive period")
+		}
+	default:
+		return nil, fmt.Errorf("unknown ramp profile %q, must be %q or %q", cfg.Profile, rampLinear, rampSine)
+	}
+
+	if cfg.Max <= 0 || cfg.Max > len(deviceIDs) {
+		cfg.Max = len(deviceIDs)
+	}
+	if cfg.Start < 0 || cfg.Start > cfg.Max {
+		return nil, fmt.Errorf("ramp start %d must be between 0 and max %d", cfg.Start, cfg.Max)
+	}
+
+	index := make(map[string]int, len(deviceIDs))
+	for i, id := range deviceIDs {
+		index[id] = i
+	}
+	return &loadRamp{cfg: cfg, begin: time.Now(), index: index}, nil
+}
+
+// activeDevices returns how many devices send at time now
+func (r *loadRamp) activeDevices(now time.Time) int {
+	elapsed := now.Sub(r.begin)
+	switch r.cfg.Profile {
+	case rampLinear:
+		steps := int(elapsed / r.cfg.Interval)
+		return min(r.cfg.Start+steps*r.cfg.Step, r.cfg.Max)
+	case rampSine:
+		// Starts at the low, peaks at max after half a period
+		phase := 2 * math.Pi * float64(elapsed) / float64(r.cfg.Period)
+		swing := float64(r.cfg.Max-r.cfg.Start) * (1 - math.Cos(phase)) / 2
+		return r.cfg.Start + int(math.Round(swing))
+	}
+	return len(r.index)
+}
+
+// isActive reports whether a device sends now; a nil ramp keeps every device active
+func (r *loadRamp) isActive(deviceID string) bool {
+	if r == nil {
+		return true
+	}
+	return r.index[deviceID] < r.activeDevices(time.Now())
+}
+
+// run logs the active devices whenever their number changes, until the context is cancelled
+func (r *loadRamp) run(ctx context.Context) {
+	ticker := time.NewTicker(rampLogInterval)
+	defer ticker.Stop()
+
+	last := -1
+	for {
+		if n := r.activeDevices(time.Now()); n != last {
+			log.Printf("Load ramp (%s): %d/%d devices active", r.cfg.Profile, n, len(r.index))
+			last = n
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -2,47 +2,172 @@ package main
 
 import(
 	"context"
+	"devicetransport"
+	"fmt"
 	"log"
-	"math/rand"
+	"slices"
+	"sort"
 	"time"
 )
+
+// Processes the random events of a device can be generated by
+const (
+	eventProcessUniform = "uniform" // one event every event_gen_interval, between min and max
+	eventProcessPoisson = "poisson" // events arrive independently, mean_interval apart on average
+)
+
+// Defaults of the error bursts after an anomaly
+const (
+	defaultBurstEvents = 5
+	defaultBurstSpread = 30 * time.Second
+)
+
+// EventGenConfig shapes the log volume of the devices on top of event_gen_interval:
+// the process of the random events, a burst of errors when an anomaly starts and a
+// periodic heartbeat
+type EventGenConfig struct {
+	Process      string        `json:"process"`       // "uniform" by default, or "poisson"
+	MeanInterval time.Duration `json:"mean_interval"` // poisson: mean time between events, midway of the interval range by default
+	Burst        BurstConfig   `json:"burst"`
+	Heartbeat    time.Duration `json:"heartbeat"` // period of the heartbeat event, 0 disables it
+}
+
+// BurstConfig is the burst of correlated events after an anomaly: a high temperature
+// warning, then Events errors spread over Spread
+type BurstConfig struct {
+	Enabled bool          `json:"enabled"`
+	Events  int           `json:"events"` // 5 by default
+	Spread  time.Duration `json:"spread"` // 30s by default
+}
+
+// burstEvents are the errors of a device that is overheating
+var burstEvents = []uint8{17, 18, 19, 20}
+
+// validate checks the event generation config and fills in its defaults
+func (c *EventGenConfig) validate(interval EventIntervalConfig) error {
+	switch c.Process {
+	case "":
+		c.Process = eventProcessUniform
+	case eventProcessUniform:
+	case eventProcessPoisson:
+		if c.MeanInterval < 0 {
+			return fmt.Errorf("event mean_interval must not be negative")
+		}
+		if c.MeanInterval == 0 {
+			c.MeanInterval = (interval.Min + interval.Max) / 2
+		}
+	default:
+		return fmt.Errorf("unknown event process %q, must be %q or %q", c.Process, eventProcessUniform, eventProcessPoisson)
+	}
+	if c.Burst.Events < 0 || c.Burst.Spread < 0 || c.Heartbeat < 0 {
+		return fmt.Errorf("burst events, burst spread and heartbeat must not be negative")
+	}
+	if c.Burst.Events == 0 {
+		c.Burst.Events = defaultBurstEvents
+	}
+	if c.Burst.Spread == 0 {
+		c.Burst.Spread = defaultBurstSpread
+	}
+	return nil
+}
+
 // runEventGenerators starts a random event generator goroutine for each LogSender
-func runEventGenerators(ctx context.Context, senders []*LogSender, intervalRange EventIntervalConfig) {
+func runEventGenerators(ctx context.Context, senders []*LogSender, intervalRange EventIntervalConfig, gen EventGenConfig, ramp *loadRamp) {
 	for _, sender := range senders {
-		go startRandomEventGenerator(ctx, sender, intervalRange)
+		go startRandomEventGenerator(ctx, sender, intervalRange, gen, ramp)
+	}
+}
+
+// nextEventIn returns the wait until the next random event of the device
+func nextEventIn(sender *LogSender, config EventIntervalConfig, gen EventGenConfig) time.Duration {
+	if gen.Process == eventProcessPoisson {
+		return time.Duration(sender.rng.ExpFloat64() * float64(gen.MeanInterval))
 	}
+	// Calculate a random interval between min and max durations
+	intervalRange := config.Max - config.Min
+	return config.Min + time.Duration(sender.rng.Int64N(int64(intervalRange)))
 }
 
-// startRandomEventGenerator starts a random event generator for a single device
-func startRandomEventGenerator(ctx context.Context, sender *LogSender, config EventIntervalConfig) {
+// startRandomEventGenerator starts a random event generator for a single device,
+// generating nothing while the load ramp keeps the device idle
+func startRandomEventGenerator(ctx context.Context, sender *LogSender, config EventIntervalConfig, gen EventGenConfig, ramp *loadRamp) {
 	// Create a slice containing all available event IDs
-	eventIDs := make([]uint8, 0, len(eventDefinitions))
-	for id := range eventDefinitions {
-		eventIDs = append(eventIDs, id)
+	eventIDs := make([]uint8, 0, len(devicetransport.EventDefinitions))
+	for id := range devicetransport.EventDefinitions {
+		if !slices.Contains(firmwareEvents, id) {
+			eventIDs = append(eventIDs, id)
+		}
 	}
+	slices.Sort(eventIDs) // map order is random, a seeded run must pick the same events
 
-	log.Printf("Event generator started for device: %v - Interval range: %v - %v", 
-		sender.DeviceID, config.Min, config.Max)
+	log.Printf("Event generator started for device: %v - %s process, interval range: %v - %v",
+		sender.DeviceID, gen.Process, config.Min, config.Max)
 
 	go func() {
 		defer log.Printf("Event generator stopped for device: %v", sender.DeviceID)
-		for {
-			// Calculate a random interval between min and max durations
-			intervalRange := config.Max - config.Min
-			randomInterval := config.Min + time.Duration(rand.Int63n(int64(intervalRange)))
-			
+
+		// The heartbeat never fires when disabled
+		var heartbeat <-chan time.Time
+		if gen.Heartbeat > 0 {
+			ticker := time.NewTicker(gen.Heartbeat)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+
+		for next := time.After(nextEventIn(sender, config, gen)); ; {
 			select {
 			case <-ctx.Done():
 				// Stop the generator if context is canceled
 				return
-			case <-time.After(randomInterval):
+			case <-next:
 				// Generate a random event ID and add it to the sender's log cache
-				randomEventID := eventIDs[rand.Intn(len(eventIDs))]
-				sender.addEvent(randomEventID)
+				randomEventID := eventIDs[sender.rng.IntN(len(eventIDs))]
+				if ramp.isActive(sender.DeviceID) {
+					sender.addEvent(randomEventID)
+				}
+				next = time.After(nextEventIn(sender, config, gen))
+			case <-heartbeat:
+				if ramp.isActive(sender.DeviceID) {
+					sender.addEvent(devicetransport.EventHeartbeat)
+				}
+			case <-sender.anomalies:
+				if gen.Burst.Enabled {
+					go emitBurst(ctx, sender, planBurst(sender, gen.Burst))
+				}
 			}
 		}
 	}()
 }
 
+// burstEvent is an event of a burst, at its delay from the start of the anomaly
+type burstEvent struct {
+	after time.Duration
+	id    uint8
+}
 
+// planBurst draws the events of a burst with the device rng, so a seeded run repeats it
+func planBurst(sender *LogSender, cfg BurstConfig) []burstEvent {
+	events := []burstEvent{{after: 0, id: devicetransport.EventHighTemperature}}
+	for i := 0; i < cfg.Events; i++ {
+		events = append(events, burstEvent{
+			after: time.Duration(sender.rng.Int64N(int64(cfg.Spread))),
+			id:    burstEvents[sender.rng.IntN(len(burstEvents))],
+		})
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].after < events[j].after })
+	return events
+}
 
+// emitBurst adds the events of a burst to the log cache at their time
+func emitBurst(ctx context.Context, sender *LogSender, events []burstEvent) {
+	log.Printf("Device %s anomaly, burst of %d events", sender.DeviceID, len(events))
+	start := time.Now()
+	for _, e := range events {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(start.Add(e.after))):
+			sender.addEvent(e.id)
+		}
+	}
+}
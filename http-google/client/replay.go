@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"devicetransport"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReplayConfig drives the client from recorded metrics instead of simulated devices: every
+// record is sent with its original device ID, at its original pace divided by Speed
+type ReplayConfig struct {
+	File           string  `json:"file"`            // NDJSON of metrics payloads, or CSV when it ends in .csv
+	Speed          float64 `json:"speed"`           // 1 replays in real time, 60 an hour in a minute
+	Loop           bool    `json:"loop"`            // Start over at the end of the file
+	KeepTimestamps bool    `json:"keep_timestamps"` // Send the recorded timestamps instead of the send time
+}
+
+// csvColumns are the columns a replay CSV can have, named as the JSON fields of Metrics
+var csvColumns = map[string]func(m *Metrics, v string) error{
+	"device_id":         func(m *Metrics, v string) error { m.DeviceID = v; return nil },
+	"timestamp":         func(m *Metrics, v string) (err error) { m.Timestamp, err = time.Parse(time.RFC3339Nano, v); return err },
+	"latitude":          floatColumn(func(m *Metrics) *float64 { return &m.GeoPosition.Latitude }),
+	"longitude":         floatColumn(func(m *Metrics) *float64 { return &m.GeoPosition.Longitude }),
+	"altitude":          floatColumn(func(m *Metrics) *float64 { return &m.GeoPosition.Altitude }),
+	"mcu_usage_percent": floatColumn(func(m *Metrics) *float64 { return &m.MCUUsagePercent }),
+	"mcu_temp_c":        floatColumn(func(m *Metrics) *float64 { return &m.MCUTempC }),
+	"battery_percent":   floatColumn(func(m *Metrics) *float64 { return &m.BatteryPercent }),
+	"rssi_dbm":          floatColumn(func(m *Metrics) *float64 { return &m.RSSIDBm }),
+	"thermometer_c":     floatColumn(func(m *Metrics) *float64 { return &m.ExternalSensors.ThermometerC }),
+	"barometer_hpa":     floatColumn(func(m *Metrics) *float64 { return &m.ExternalSensors.BarometerHPa }),
+	"hygrometer_rh":     floatColumn(func(m *Metrics) *float64 { return &m.ExternalSensors.HygrometerRH }),
+	"anemometer_mps":    floatColumn(func(m *Metrics) *float64 { return &m.ExternalSensors.AnemometerMPS }),
+	"pm25_ugm3":         floatColumn(func(m *Metrics) *float64 { return &m.ExternalSensors.PM25UGM3 }),
+	"co2_ppm":           floatColumn(func(m *Metrics) *float64 { return &m.ExternalSensors.CO2PPM }),
+	"firmware_version":  func(m *Metrics, v string) error { m.FirmwareVersion = v; return nil },
+	"fleet":             func(m *Metrics, v string) error { m.Fleet = v; return nil },
+	"region":            func(m *Metrics, v string) error { m.Region = v; return nil },
+}
+
+// floatColumn parses a numeric CSV column into the field returned by field; empty cells stay 0
+func floatColumn(field func(m *Metrics) *float64) func(m *Metrics, v string) error {
+	return func(m *Metrics, v string) error {
+		if v == "" {
+			return nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		*field(m) = f
+		return nil
+	}
+}
+
+// loadReplay reads the recorded metrics of a replay file, sorted by timestamp
+func loadReplay(filename string) ([]Metrics, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	var records []Metrics
+	if strings.EqualFold(filepath.Ext(filename), ".csv") {
+		records, err = readReplayCSV(file)
+	} else {
+		records, err = readReplayNDJSON(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay file %s: %w", filename, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("replay file %s has no metrics", filename)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Timestamp.Before(records[j].Timestamp) })
+	return records, nil
+}
+
+// readReplayNDJSON reads one metrics payload per line, skipping blank lines
+func readReplayNDJSON(r io.Reader) ([]Metrics, error) {
+	var records []Metrics
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		var m Metrics
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if m.DeviceID == "" || m.Timestamp.IsZero() {
+			return nil, fmt.Errorf("line %d: device_id and timestamp are required", line)
+		}
+		records = append(records, m)
+	}
+	return records, scanner.Err()
+}
+
+// readReplayCSV reads metrics from a CSV whose header names the columns
+func readReplayCSV(r io.Reader) ([]Metrics, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	for _, name := range []string{"device_id", "timestamp"} {
+		if !slices.Contains(header, name) {
+			return nil, fmt.Errorf("column %s is required", name)
+		}
+	}
+	for _, name := range header {
+		if _, ok := csvColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	var records []Metrics
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var m Metrics
+		for i, name := range header {
+			if err := csvColumns[name](&m, row[i]); err != nil {
+				return nil, fmt.Errorf("line %d, column %s: %w", line, name, err)
+			}
+		}
+		records = append(records, m)
+	}
+}
+
+// runReplay sends the recorded metrics through the transport on the send pool, at the
+// recorded pace sped up by cfg.Speed, until the file ends or the context is cancelled
+func runReplay(ctx context.Context, pool *sendPool, transport devicetransport.Transport, records []Metrics, cfg ReplayConfig) {
+	speed := cfg.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	first, last := records[0].Timestamp, records[len(records)-1].Timestamp
+	devices := make(map[string]bool)
+	for _, m := range records {
+		devices[m.DeviceID] = true
+	}
+	log.Printf("Replaying %d metrics of %d devices recorded over %v at %gx", len(records), len(devices), last.Sub(first), speed)
+
+	for round := 1; ; round++ {
+		start := time.Now()
+		for _, m := range records {
+			due := start.Add(time.Duration(float64(m.Timestamp.Sub(first)) / speed))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(due)):
+			}
+
+			if !cfg.KeepTimestamps {
+				m.Timestamp = time.Now()
+			}
+			job := newSendJob("replay", m.DeviceID, nil, func(ctx context.Context) error {
+				return transport.SendMetrics(ctx, m.DeviceID, m)
+			})
+			if !pool.submit(ctx, job) {
+				return
+			}
+		}
+
+		if !cfg.Loop {
+			pool.waitIdle(ctx)
+			log.Printf("Replay complete")
+			return
+		}
+		log.Printf("Replay round %d complete, starting over", round)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+)
+
+// newDeviceRand creates the random source of one stream ("metrics", "events") of a device.
+// With a seed the stream is the same at every run, and devices and streams differ by
+// their name; a zero seed makes the run random.
+func newDeviceRand(seed uint64, deviceID, stream string) *rand.Rand {
+	if seed == 0 {
+		return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+	}
+	h := fnv.New64a()
+	h.Write([]byte(deviceID))
+	h.Write([]byte{0})
+	h.Write([]byte(stream))
+	return rand.New(rand.NewPCG(seed, h.Sum64()))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"devicetransport"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// SelfTelemetryConfig exports the metrics of the simulator itself to the OTLP collector,
+// so the health of the load generator shows next to the system under test
+type SelfTelemetryConfig struct {
+	Enabled  bool          `json:"enabled"`
+	Interval time.Duration `json:"interval"` // Export interval, 15s by default
+}
+
+// defaultSelfTelemetryInterval is how often the simulator exports its own metrics
+const defaultSelfTelemetryInterval = 15 * time.Second
+
+// requestDurationBuckets are the bounds of the request latency histogram in seconds,
+// fine below a second where the SLOs of the ingestion path sit, up to the 30s timeout
+var requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// instrumentedTransport records the latency and the outcome of every request of the devices,
+// by endpoint and fleet of the device
+type instrumentedTransport struct {
+	devicetransport.Transport
+	requests  metric.Int64Counter
+	latency   metric.Float64Histogram
+	endpoints map[string]string // path of the endpoint of each kind of request
+	fleets    map[string]string // fleet of each device, filled in before the devices send
+}
+
+// instrumentTransport wraps a transport sending to the endpoints of cfg with the request instruments
+func instrumentTransport(transport devicetransport.Transport, cfg devicetransport.Config, meter metric.Meter) (*instrumentedTransport, error) {
+	requests, err := meter.Int64Counter("simulator.requests",
+		metric.WithDescription("Richieste inviate dai dispositivi simulati, per tipo, endpoint, flotta ed esito"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create simulator.requests counter: %w", err)
+	}
+	latency, err := meter.Float64Histogram("simulator.request.duration",
+		metric.WithDescription("Durata delle richieste dei dispositivi simulati, per tipo, endpoint, flotta ed esito"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(requestDurationBuckets...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create simulator.request.duration histogram: %w", err)
+	}
+	return &instrumentedTransport{
+		Transport: transport,
+		requests:  requests,
+		latency:   latency,
+		endpoints: map[string]string{
+			"metric":       endpointPath(cfg.MetricURL),
+			"metric_batch": endpointPath(cfg.MetricBatchURL),
+			"logs":         endpointPath(cfg.LogURL),
+		},
+		fleets: make(map[string]string),
+	}, nil
+}
+
+// endpointPath returns the path of an endpoint URL, like /batchLog, or the URL if it has none
+func endpointPath(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Path == "" {
+		return raw
+	}
+	return u.Path
+}
+
+// assign records the fleet of a device for its requests
+func (t *instrumentedTransport) assign(deviceID, fleet string) {
+	t.fleets[deviceID] = fleet
+}
+
+// record adds a request of the given kind to the instruments. Batches of all devices
+// have no device and no fleet.
+func (t *instrumentedTransport) record(ctx context.Context, kind, deviceID string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("kind", kind),
+		attribute.String("endpoint", t.endpoints[kind]),
+		attribute.String("fleet", t.fleets[deviceID]),
+		attribute.String("outcome", outcome),
+	)
+	t.latency.Record(ctx, time.Since(start).Seconds(), attrs)
+	t.requests.Add(ctx, 1, attrs)
+}
+
+// SendMetrics sends the metrics of a device, recording the request
+func (t *instrumentedTransport) SendMetrics(ctx context.Context, deviceID string, metrics interface{}) error {
+	start := time.Now()
+	err := t.Transport.SendMetrics(ctx, deviceID, metrics)
+	t.record(ctx, "metric", deviceID, start, err)
+	return err
+}
+
+// SendMetricBatch sends a batch of metrics, recording the request
+func (t *instrumentedTransport) SendMetricBatch(ctx context.Context, deviceID string, metrics []interface{}) error {
+	start := time.Now()
+	err := t.Transport.SendMetricBatch(ctx, deviceID, metrics)
+	t.record(ctx, "metric_batch", deviceID, start, err)
+	return err
+}
+
+// SendLogBatch sends a log batch of a device, recording the request
+func (t *instrumentedTransport) SendLogBatch(ctx context.Context, deviceID string, entries []LogEntryCompact, links []SpanLink) error {
+	start := time.Now()
+	err := t.Transport.SendLogBatch(ctx, deviceID, entries, links)
+	t.record(ctx, "logs", deviceID, start, err)
+	return err
+}
+
+// registerSimulatorObservers exports the counters of the send pool and the log events
+// each device has queued but not sent yet
+func registerSimulatorObservers(meter metric.Meter, pool *sendPool, logSenders []*LogSender) error {
+	sends, err := meter.Int64ObservableCounter("simulator.pool.sends",
+		metric.WithDescription("Invii eseguiti dal pool, per esito (sent, failed, skipped)"))
+	if err != nil {
+		return fmt.Errorf("failed to create simulator.pool.sends counter: %w", err)
+	}
+	queue, err := meter.Int64ObservableGauge("simulator.pool.queue",
+		metric.WithDescription("Invii in coda nel pool"))
+	if err != nil {
+		return fmt.Errorf("failed to create simulator.pool.queue gauge: %w", err)
+	}
+	queuedLogs, err := meter.Int64ObservableGauge("simulator.logs.queued",
+		metric.WithDescription("Eventi in attesa di invio per dispositivo"))
+	if err != nil {
+		return fmt.Errorf("failed to create simulator.logs.queued gauge: %w", err)
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		observer.ObserveInt64(sends, int64(pool.sent.Load()), metric.WithAttributes(attribute.String("outcome", "sent")))
+		observer.ObserveInt64(sends, int64(pool.failed.Load()), metric.WithAttributes(attribute.String("outcome", "failed")))
+		observer.ObserveInt64(sends, int64(pool.skipped.Load()), metric.WithAttributes(attribute.String("outcome", "skipped")))
+		observer.ObserveInt64(queue, int64(len(pool.queue)))
+		for _, s := range logSenders {
+			observer.ObserveInt64(queuedLogs, int64(s.queued()), metric.WithAttributes(attribute.String("device_id", s.DeviceID)))
+		}
+		return nil
+	}, sends, queue, queuedLogs)
+	if err != nil {
+		return fmt.Errorf("failed to register simulator observers: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// Sensors of a device, as named in the "sensors" object of devices.json
+const (
+	sensorMCUUsage    = "mcu_usage"
+	sensorMCUTemp     = "mcu_temp"
+	sensorThermometer = "thermometer"
+	sensorBarometer   = "barometer"
+	sensorHygrometer  = "hygrometer"
+	sensorAnemometer  = "anemometer"
+	sensorRSSI        = "rssi"
+	sensorPM25        = "pm25"
+	sensorCO2         = "co2"
+)
+
+// Distributions a sensor reading can be sampled from
+const (
+	distNormal    = "normal"
+	distLogNormal = "lognormal"
+	distUniform   = "uniform"
+)
+
+// SensorConfig declares how the readings of a sensor are sampled; fields left out
+// keep the defaults of the sensor, with mu defaulting to the device base value.
+// For lognormal, mu and sigma are those of the logarithm of the reading; uniform
+// samples between min and max and ignores mu and sigma.
+type SensorConfig struct {
+	Distribution       string   `json:"distribution"`
+	Mu                 *float64 `json:"mu"`
+	Sigma              *float64 `json:"sigma"`
+	Min                *float64 `json:"min"`
+	Max                *float64 `json:"max"`
+	AnomalyProbability *float64 `json:"anomaly_probability"`
+}
+
+// sensorModel is the resolved sampling model of a sensor
+type sensorModel struct {
+	distribution string
+	mu, sigma    float64
+	min, max     float64
+
+	// anomalyProbability is the chance per reading of an anomaly; for mcu_temp
+	// it is the chance per send of starting the overheating ramp instead
+	anomalyProbability float64
+
+	// declared is set when devices.json gives the distribution of the sensor, which then
+	// replaces the environment model for thermometer, barometer, hygrometer and anemometer
+	declared bool
+}
+
+// defaultSensorModels are the models of a device that declares no sensors
+func (c DeviceConfig) defaultSensorModels() map[string]sensorModel {
+	return map[string]sensorModel{
+		sensorMCUUsage:    {distribution: distNormal, mu: 45, sigma: 15, min: 0, max: 100},
+		sensorMCUTemp:     {distribution: distNormal, mu: c.BaseMCUTemp, sigma: 3, min: 20, max: 70, anomalyProbability: 0.022},
+		sensorThermometer: {distribution: distNormal, mu: c.BaseThermometer, sigma: 2, min: -40, max: 60},
+		sensorBarometer:   {distribution: distNormal, mu: c.BaseBarometer, sigma: 5, min: 950, max: 1050},
+		sensorHygrometer:  {distribution: distNormal, mu: c.BaseHygrometer, sigma: 8, min: 10, max: 100},
+		sensorAnemometer:  {distribution: distNormal, mu: c.BaseAnemometer, sigma: 1.5, min: 0, max: 25},
+		sensorRSSI:        {distribution: distNormal, mu: orDefault(c.BaseRSSI, -70), sigma: 6, min: -120, max: -30},
+		sensorPM25:        {distribution: distLogNormal, mu: math.Log(orDefault(c.BasePM25, 12)), sigma: 0.5, min: 0, max: 500},
+		sensorCO2:         {distribution: distNormal, mu: orDefault(c.BaseCO2, 420), sigma: 25, min: 350, max: 5000},
+	}
+}
+
+// orDefault returns def for base values missing from older device configs
+func orDefault(base, def float64) float64 {
+	if base == 0 {
+		return def
+	}
+	return base
+}
+
+// resolveSensors merges the declared sensors over the defaults and validates them
+func (c *DeviceConfig) resolveSensors() error {
+	models := c.defaultSensorModels()
+	for name, sc := range c.Sensors {
+		m, ok := models[name]
+		if !ok {
+			return fmt.Errorf("unknown sensor %q", name)
+		}
+
+		// The default mu stays on the same reading when switching to or from lognormal
+		if sc.Distribution != "" && sc.Distribution != m.distribution && sc.Mu == nil {
+			switch {
+			case sc.Distribution == distLogNormal && m.mu > 0:
+				m.mu = math.Log(m.mu)
+			case m.distribution == distLogNormal:
+				m.mu = math.Exp(m.mu)
+			}
+		}
+		if sc.Distribution != "" {
+			m.distribution = sc.Distribution
+		}
+		if sc.Mu != nil {
+			m.mu = *sc.Mu
+		}
+		if sc.Sigma != nil {
+			m.sigma = *sc.Sigma
+		}
+		if sc.Min != nil {
+			m.min = *sc.Min
+		}
+		if sc.Max != nil {
+			m.max = *sc.Max
+		}
+		if sc.AnomalyProbability != nil {
+			m.anomalyProbability = *sc.AnomalyProbability
+		}
+		m.declared = sc.Distribution != "" || sc.Mu != nil || sc.Sigma != nil
+
+		switch m.distribution {
+		case distNormal, distLogNormal, distUniform:
+		default:
+			return fmt.Errorf("sensor %s: unknown distribution %q, must be %q, %q or %q",
+				name, m.distribution, distNormal, distLogNormal, distUniform)
+		}
+		if m.sigma < 0 {
+			return fmt.Errorf("sensor %s: sigma must not be negative", name)
+		}
+		if m.min > m.max {
+			return fmt.Errorf("sensor %s: min %v is above max %v", name, m.min, m.max)
+		}
+		if m.anomalyProbability < 0 || m.anomalyProbability > 1 {
+			return fmt.Errorf("sensor %s: anomaly_probability must be between 0 and 1", name)
+		}
+		models[name] = m
+	}
+
+	c.sensors = models
+	return nil
+}
+
+// sensor returns the model of a sensor, the default one if the device was not resolved
+func (c DeviceConfig) sensor(name string) sensorModel {
+	if m, ok := c.sensors[name]; ok {
+		return m
+	}
+	return c.defaultSensorModels()[name]
+}
+
+// sample draws a reading within the sensor bounds; an anomalous reading sticks
+// at one of the bounds, like a saturated or faulty sensor
+func (m sensorModel) sample(rng *rand.Rand) float64 {
+	if v, ok := m.anomaly(rng); ok {
+		return v
+	}
+	return m.sampleNormal(rng)
+}
+
+// read returns value as measured by the sensor: within its bounds, or stuck at one
+// of them on an anomaly
+func (m sensorModel) read(rng *rand.Rand, value float64) float64 {
+	if v, ok := m.anomaly(rng); ok {
+		return v
+	}
+	return clamp(value, m.min, m.max)
+}
+
+// anomaly reports whether this reading is anomalous, with the bound it sticks at
+func (m sensorModel) anomaly(rng *rand.Rand) (float64, bool) {
+	if m.anomalyProbability > 0 && rng.Float64() < m.anomalyProbability {
+		if rng.IntN(2) == 0 {
+			return m.min, true
+		}
+		return m.max, true
+	}
+	return 0, false
+}
+
+// sampleNormal draws a reading from the distribution of the sensor, ignoring anomalies
+func (m sensorModel) sampleNormal(rng *rand.Rand) float64 {
+	var v float64
+	switch m.distribution {
+	case distLogNormal:
+		v = distuv.LogNormal{Mu: m.mu, Sigma: m.sigma, Src: rng}.Rand()
+	case distUniform:
+		v = distuv.Uniform{Min: m.min, Max: m.max, Src: rng}.Rand()
+	default:
+		v = distuv.Normal{Mu: m.mu, Sigma: m.sigma, Src: rng}.Rand()
+	}
+	return clamp(v, m.min, m.max)
+}
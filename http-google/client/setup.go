@@ -4,6 +4,9 @@ import (
 	"context"
 
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	"go.opentelemetry.io/otel/propagation"
 )
@@ -18,3 +21,28 @@ func setupTracer() (shutdown func(context.Context) error, err error) {
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 	return tp.Shutdown, nil
 }
+
+// setupMeter sets up the meter provider of the simulator's own metrics, exported to the
+// OTLP collector; when self telemetry is disabled the global meter stays a no-op.
+func setupMeter(ctx context.Context, cfg SelfTelemetryConfig, otlp OTLPConfig) (shutdown func(context.Context) error, err error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultSelfTelemetryInterval
+	}
+
+	exporter, err := newOTLPExporter(ctx, otlp)
+	if err != nil {
+		return nil, err
+	}
+	// Separate from the resources of the devices exporting over OTLP
+	res := resource.NewSchemaless(attribute.String("service.name", "device-simulator-self"))
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(mp)
+	return mp.Shutdown, nil
+}
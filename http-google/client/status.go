@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// simulatorStatus is the answer of GET /status, the health of a long running simulation
+type simulatorStatus struct {
+	StartedAt       time.Time             `json:"started_at"`
+	Uptime          string                `json:"uptime"`
+	DeviceCount     int                   `json:"device_count"`
+	ActiveDevices   int                   `json:"active_devices"` // sending under the load ramp
+	ActiveAnomalies int                   `json:"active_anomalies"`
+	Pool            poolStatus            `json:"pool"`
+	Devices         []deviceRuntimeStatus `json:"devices"`
+}
+
+// poolStatus are the counters and the queue of the send pool
+type poolStatus struct {
+	Workers       int    `json:"workers"`
+	Queue         int    `json:"queue"`
+	QueueCapacity int    `json:"queue_capacity"`
+	Sent          uint64 `json:"sent"`
+	Failed        uint64 `json:"failed"`
+	Skipped       uint64 `json:"skipped"`
+}
+
+// deviceRuntimeStatus is how a device is doing: its sends by kind, the log events it
+// has not sent yet, in memory and on disk, and its anomaly
+type deviceRuntimeStatus struct {
+	DeviceID    string                 `json:"device_id"`
+	Active      bool                   `json:"active"`
+	Sends       map[string]deviceSends `json:"sends"` // by kind, "metric" and "logs"
+	LogsQueued  int                    `json:"logs_queued"`
+	LogsSpilled int                    `json:"logs_spilled"` // waiting on disk
+	Anomaly     anomalyState           `json:"anomaly"`
+}
+
+// handleStatus lists the simulator counters and every device, sorted by ID
+func (a *adminServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	st := simulatorStatus{
+		StartedAt:   a.started,
+		Uptime:      time.Since(a.started).Round(time.Second).String(),
+		DeviceCount: len(a.devices),
+		Pool: poolStatus{
+			Workers:       a.pool.workers,
+			Queue:         len(a.pool.queue),
+			QueueCapacity: cap(a.pool.queue),
+			Sent:          a.pool.sent.Load(),
+			Failed:        a.pool.failed.Load(),
+			Skipped:       a.pool.skipped.Load(),
+		},
+		Devices: make([]deviceRuntimeStatus, 0, len(a.devices)),
+	}
+
+	for id, s := range a.devices {
+		d := deviceRuntimeStatus{
+			DeviceID: id,
+			Active:   a.ramp.isActive(id),
+			Sends:    a.pool.deviceStats(id),
+			Anomaly:  s.AnomalyState(),
+		}
+		if logs, ok := a.logs[id]; ok {
+			d.LogsQueued = logs.queued()
+			d.LogsSpilled = logs.spilled()
+		}
+		if d.Active {
+			st.ActiveDevices++
+		}
+		if d.Anomaly.Active {
+			st.ActiveAnomalies++
+		}
+		st.Devices = append(st.Devices, d)
+	}
+	sort.Slice(st.Devices, func(i, j int) bool { return st.Devices[i].DeviceID < st.Devices[j].DeviceID })
+
+	writeJSON(w, http.StatusOK, st)
+}
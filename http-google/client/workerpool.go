@@ -0,0 +1,271 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// poolStatsInterval is how often the send pool logs its counters
+const poolStatsInterval = time.Minute
+
+// sendJob is one periodic send of a device; busy is set while it is queued or running
+type sendJob struct {
+	kind     string
+	deviceID string
+	send     func(ctx context.Context) error
+	busy     *atomic.Bool
+	active   func() bool // false while the load ramp keeps the device idle
+
+	// interval overrides the interval of the schedule when set and not 0
+	interval func() time.Duration
+}
+
+// every returns the interval of the job, def unless the job has its own
+func (j sendJob) every(def time.Duration) time.Duration {
+	if j.interval != nil {
+		if d := j.interval(); d > 0 {
+			return d
+		}
+	}
+	return def
+}
+
+// newSendJob creates the periodic job of a device, sent while the ramp keeps it active
+func newSendJob(kind, deviceID string, ramp *loadRamp, send func(ctx context.Context) error) sendJob {
+	return sendJob{
+		kind:     kind,
+		deviceID: deviceID,
+		send:     send,
+		busy:     new(atomic.Bool),
+		active:   func() bool { return ramp.isActive(deviceID) },
+	}
+}
+
+// sendPool runs the sends of all devices on a fixed number of workers fed by a bounded queue
+type sendPool struct {
+	queue   chan sendJob
+	workers int
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	skipped atomic.Uint64
+	pending atomic.Int64 // jobs queued or running
+
+	// Sends of each device by kind, for the status endpoint
+	statsMu sync.Mutex
+	stats   map[string]map[string]*deviceSends
+}
+
+// deviceSends are the sends of one kind of a device
+type deviceSends struct {
+	Sent      uint64     `json:"sent"`
+	Failed    uint64     `json:"failed"`
+	Skipped   uint64     `json:"skipped"`
+	LastSend  *time.Time `json:"last_send,omitempty"`  // last send that succeeded
+	LastError string     `json:"last_error,omitempty"` // error of the last send that failed
+}
+
+// newSendPool creates a pool of workers reading from a queue of queueSize jobs
+func newSendPool(workers, queueSize int) *sendPool {
+	return &sendPool{
+		queue:   make(chan sendJob, queueSize),
+		workers: workers,
+		stats:   make(map[string]map[string]*deviceSends),
+	}
+}
+
+// run starts the workers and blocks until the context is cancelled
+func (p *sendPool) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+
+	ticker := time.NewTicker(poolStatsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			log.Println("Send pool stopped")
+			return
+		case <-ticker.C:
+			log.Printf("Send pool: %d sent, %d failed, %d skipped, queue %d/%d",
+				p.sent.Load(), p.failed.Load(), p.skipped.Load(), len(p.queue), cap(p.queue))
+		}
+	}
+}
+
+// work executes queued jobs until the context is cancelled
+func (p *sendPool) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-p.queue:
+			err := job.send(ctx)
+			if err != nil {
+				p.failed.Add(1)
+				log.Printf("[Device %s] Error sending %s: %v", job.deviceID, job.kind, err)
+			} else {
+				p.sent.Add(1)
+			}
+			p.record(job, func(d *deviceSends) {
+				if err != nil {
+					d.Failed++
+					d.LastError = err.Error()
+				} else {
+					now := time.Now()
+					d.Sent++
+					d.LastSend = &now
+				}
+			})
+			job.busy.Store(false)
+			p.pending.Add(-1)
+		}
+	}
+}
+
+// submit queues a job, waiting while the queue is full so a slow server slows the schedule down.
+// A device whose previous send has not completed yet skips this one. It returns false once ctx is done.
+func (p *sendPool) submit(ctx context.Context, job sendJob) bool {
+	if !job.busy.CompareAndSwap(false, true) {
+		p.skipped.Add(1)
+		p.record(job, func(d *deviceSends) { d.Skipped++ })
+		return true
+	}
+	p.pending.Add(1)
+	select {
+	case p.queue <- job:
+		return true
+	case <-ctx.Done():
+		job.busy.Store(false)
+		p.pending.Add(-1)
+		return false
+	}
+}
+
+// record updates the sends of the device and kind of job
+func (p *sendPool) record(job sendJob, update func(d *deviceSends)) {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	kinds, ok := p.stats[job.deviceID]
+	if !ok {
+		kinds = make(map[string]*deviceSends)
+		p.stats[job.deviceID] = kinds
+	}
+	d, ok := kinds[job.kind]
+	if !ok {
+		d = &deviceSends{}
+		kinds[job.kind] = d
+	}
+	update(d)
+}
+
+// deviceStats returns a copy of the sends of a device by kind
+func (p *sendPool) deviceStats(deviceID string) map[string]deviceSends {
+	p.statsMu.Lock()
+	defer p.statsMu.Unlock()
+	stats := make(map[string]deviceSends, len(p.stats[deviceID]))
+	for kind, d := range p.stats[deviceID] {
+		stats[kind] = *d
+	}
+	return stats
+}
+
+// waitIdle waits until every submitted job has run or the context is cancelled
+func (p *sendPool) waitIdle(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for p.pending.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduledSend is the next run of a job
+type scheduledSend struct {
+	at  time.Time
+	job sendJob
+}
+
+// sendSchedule is a min-heap of scheduled sends ordered by time
+type sendSchedule []*scheduledSend
+
+func (s sendSchedule) Len() int            { return len(s) }
+func (s sendSchedule) Less(i, j int) bool  { return s[i].at.Before(s[j].at) }
+func (s sendSchedule) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *sendSchedule) Push(x interface{}) { *s = append(*s, x.(*scheduledSend)) }
+func (s *sendSchedule) Pop() interface{} {
+	old := *s
+	n := len(old)
+	item := old[n-1]
+	*s = old[:n-1]
+	return item
+}
+
+// jittered returns interval moved randomly by up to ±jitter of itself
+func jittered(interval time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return interval
+	}
+	delta := (rand.Float64()*2 - 1) * jitter * float64(interval)
+	return interval + time.Duration(delta)
+}
+
+// schedule submits every job once per interval until the context is cancelled.
+// Devices start at a random offset within the first interval so their sends are
+// spread out instead of firing together, and each run is jittered.
+func (p *sendPool) schedule(ctx context.Context, jobs []sendJob, interval time.Duration, jitter float64) {
+	if len(jobs) == 0 {
+		return
+	}
+
+	now := time.Now()
+	h := make(sendSchedule, 0, len(jobs))
+	for _, job := range jobs {
+		offset := time.Duration(rand.Int63n(int64(interval)))
+		h = append(h, &scheduledSend{at: now.Add(offset), job: job})
+	}
+	heap.Init(&h)
+
+	timer := time.NewTimer(time.Until(h[0].at))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		// submit every job that is due, then wait for the next one; idle devices keep
+		// their place in the schedule without sending
+		for h[0].at.Before(time.Now()) {
+			next := h[0]
+			if next.job.active() && !p.submit(ctx, next.job) {
+				return
+			}
+			every := next.job.every(interval)
+			next.at = next.at.Add(jittered(every, jitter))
+			if next.at.Before(time.Now()) {
+				// the queue held the schedule back for a whole interval, don't burst to catch up
+				next.at = time.Now().Add(jittered(every, jitter))
+			}
+			heap.Fix(&h, 0)
+		}
+		timer.Reset(time.Until(h[0].at))
+	}
+}
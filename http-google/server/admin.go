@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"devicetransport"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// adminKeyHeader is the header the operators send their admin key in
+const adminKeyHeader = "X-Admin-Key"
+
+// adminExportTimeout bounds a forced export, so a slow collector doesn't hang the request
+const adminExportTimeout = 30 * time.Second
+
+// loadAdminKeys reads ADMIN_API_KEYS, a comma separated list of keys; without keys the
+// admin API is not served
+func loadAdminKeys() [][]byte {
+	var keys [][]byte
+	for _, key := range strings.Split(os.Getenv("ADMIN_API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return keys
+}
+
+// requireAdmin answers 401 to requests without a valid admin key, compared in constant
+// time with every accepted one; the device keys and tokens are never admin keys
+func requireAdmin(keys [][]byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := []byte(r.Header.Get(adminKeyHeader))
+		valid := 0
+		for _, k := range keys {
+			valid |= subtle.ConstantTimeCompare(key, k)
+		}
+		if len(key) == 0 || valid == 0 {
+			slog.WarnContext(r.Context(), "Unauthorized admin request",
+				slog.String("path", r.URL.Path), slog.String("remote_addr", r.RemoteAddr))
+			writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, "missing or invalid admin key")
+			return
+		}
+		slog.InfoContext(r.Context(), "Admin request",
+			slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.String("remote_addr", r.RemoteAddr))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// registerAdminRoutes serves the admin API under /admin when ADMIN_API_KEYS is set
+func registerAdminRoutes(mux *http.ServeMux) {
+	keys := loadAdminKeys()
+	if len(keys) == 0 {
+		return
+	}
+	slog.Info("Admin API enabled", slog.Int("admin_keys", len(keys)))
+	routes := []struct {
+		pattern string
+		handler http.HandlerFunc
+	}{
+		{"GET /admin/cache", handleAdminCache},
+		{"DELETE /admin/cache/{id}", handleAdminEvict},
+		{"POST /admin/export", handleAdminExport},
+		{"POST /admin/reload", handleAdminReload},
+		{"POST /admin/devices/{id}/commands", handleEnqueueCommand},
+	}
+	for _, route := range routes {
+		_, path, _ := strings.Cut(route.pattern, " ")
+		mux.Handle(route.pattern, otelhttp.NewHandler(requireAdmin(keys, route.handler), path))
+	}
+}
+
+// cacheEntry is a sample of the metric cache as the admin API shows it
+type cacheEntry struct {
+	Tenant     string    `json:"tenant,omitempty"`
+	DeviceID   string    `json:"device_id"`
+	ReceivedAt time.Time `json:"received_at"`
+	TraceID    string    `json:"trace_id,omitempty"`
+	Metrics    Metrics   `json:"metrics"`
+}
+
+// handleAdminCache dumps the latest sample of every device in the metric cache, only the
+// ones of a tenant with the tenant query parameter
+func handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	samples, err := globalMetricCache.snapshot(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to read the metric cache", slog.Any("error", err))
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, "failed to read the metric cache")
+		return
+	}
+	tenant := r.URL.Query().Get("tenant")
+	entries := make([]cacheEntry, 0, len(samples))
+	for _, m := range samples {
+		if tenant != "" && m.Tenant != tenant {
+			continue
+		}
+		e := cacheEntry{Tenant: m.Tenant, DeviceID: m.DeviceID, ReceivedAt: m.receivedAt, Metrics: m}
+		if m.span.HasTraceID() {
+			e.TraceID = m.span.TraceID().String()
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return tenantDeviceKey(entries[i].Tenant, entries[i].DeviceID) < tenantDeviceKey(entries[j].Tenant, entries[j].DeviceID)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Count   int          `json:"count"`
+		Samples []cacheEntry `json:"samples"`
+	}{len(entries), entries})
+}
+
+// handleAdminEvict drops the device in the path, of the tenant query parameter, from the
+// metric cache and the rolling aggregates, so its gauges stop being reported
+func handleAdminEvict(w http.ResponseWriter, r *http.Request) {
+	tenant, deviceID := r.URL.Query().Get("tenant"), r.PathValue("id")
+	if err := checkKeyParts(tenant, deviceID); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		return
+	}
+	key := tenantDeviceKey(tenant, deviceID)
+	rolling.evict(key)
+	found, err := globalMetricCache.evict(r.Context(), key)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to evict from the metric cache", slog.String("key", key), slog.Any("error", err))
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, "failed to evict from the metric cache")
+		return
+	}
+	if !found {
+		writeProblem(w, r, http.StatusNotFound, codeNotFound, "no cached sample of device "+r.PathValue("id"))
+		return
+	}
+	slog.InfoContext(r.Context(), "Device evicted from the metric cache", slog.String("key", key))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminExport exports the metrics to the collector now instead of at the next interval
+func handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	if meterProvider == nil {
+		writeProblem(w, r, http.StatusServiceUnavailable, codeInternal, "metrics are not exported")
+		return
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(r.Context(), adminExportTimeout)
+	defer cancel()
+	if err := meterProvider.ForceFlush(ctx); err != nil {
+		slog.ErrorContext(r.Context(), "Forced metric export failed", slog.Any("error", err))
+		writeProblem(w, r, http.StatusBadGateway, codeInternal, "metric export failed: "+err.Error())
+		return
+	}
+	slog.InfoContext(r.Context(), "Metrics exported on request", slog.Duration("duration", time.Since(start)))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reloadResult is what an admin reload read again
+type reloadResult struct {
+	Thresholds          string `json:"thresholds"`            // file read, "default" without THRESHOLDS_FILE
+	EventCatalogVersion int    `json:"event_catalog_version"` // version in use after the reload
+}
+
+// handleAdminReload reads THRESHOLDS_FILE and EVENT_CATALOG_FILE again without waiting for
+// the file watch; on an invalid file both keep the configuration in use
+func handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	result := reloadResult{Thresholds: "default"}
+	var t *thresholdsConfig
+	if path := os.Getenv("THRESHOLDS_FILE"); path != "" {
+		var err error
+		if t, err = loadThresholds(path); err != nil {
+			writeProblem(w, r, http.StatusUnprocessableEntity, codeInvalidPayload, err.Error())
+			return
+		}
+		result.Thresholds = path
+	}
+	catalog, err := devicetransport.LoadEventCatalog(os.Getenv("EVENT_CATALOG_FILE"))
+	if err != nil {
+		writeProblem(w, r, http.StatusUnprocessableEntity, codeInvalidPayload, err.Error())
+		return
+	}
+
+	if t != nil {
+		thresholds.Store(t)
+	}
+	eventCatalog.Store(&catalog)
+	result.EventCatalogVersion = catalog.Version
+	slog.InfoContext(r.Context(), "Configuration reloaded",
+		slog.String("thresholds", result.Thresholds), slog.Int("event_catalog_version", catalog.Version))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// rollingWindows are the windows the samples of a device are aggregated over
+var rollingWindows = []struct {
+	name string
+	span time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+}
+
+// rollingMetrics are the readings aggregated, one gauge each named after the gauge of the
+// reading with a _rolling suffix
+var rollingMetrics = []struct {
+	name, description string
+	value             func(m Metrics) float64
+}{
+	{"mcu_percent", "Utilizzo della MCU", func(m Metrics) float64 { return m.MCUUsagePercent }},
+	{"mcu_temp_celsius", "Temperatura della MCU", func(m Metrics) float64 { return m.MCUTempC }},
+	{"external_thermometer_celsius", "Temperatura esterna", func(m Metrics) float64 { return m.ExternalSensors.ThermometerC }},
+	{"barometer_hpa", "Pressione atmosferica", func(m Metrics) float64 { return m.ExternalSensors.BarometerHPa }},
+	{"hygrometer_rh", "Umidità relativa", func(m Metrics) float64 { return m.ExternalSensors.HygrometerRH }},
+	{"anemometer_mps", "Velocità del vento", func(m Metrics) float64 { return m.ExternalSensors.AnemometerMPS }},
+	{"battery_percent", "Livello della batteria", func(m Metrics) float64 { return m.BatteryPercent }},
+	{"rssi_dbm", "Potenza del segnale radio", func(m Metrics) float64 { return m.RSSIDBm }},
+	{"pm25_ugm3", "Particolato PM2.5", func(m Metrics) float64 { return m.ExternalSensors.PM25UGM3 }},
+	{"co2_ppm", "Anidride carbonica", func(m Metrics) float64 { return m.ExternalSensors.CO2PPM }},
+}
+
+// rollingSample is a sample of a device as the aggregation keeps it
+type rollingSample struct {
+	at     time.Time
+	values []float64 // in the order of rollingMetrics
+}
+
+// rollingDevice are the samples of a device within the longest window, oldest first
+type rollingDevice struct {
+	samples []rollingSample
+	attrs   []attribute.KeyValue
+}
+
+// rollingAggregator keeps the recent samples of every device to report their average,
+// minimum and maximum over the rolling windows
+type rollingAggregator struct {
+	mu      sync.Mutex
+	devices map[string]*rollingDevice
+}
+
+// rolling aggregates the samples recorded by the handlers
+var rolling = &rollingAggregator{devices: make(map[string]*rollingDevice)}
+
+// add keeps a sample of a device received at now
+func (a *rollingAggregator) add(m Metrics, now time.Time) {
+	values := make([]float64, len(rollingMetrics))
+	for i, rm := range rollingMetrics {
+		values[i] = rm.value(m)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := tenantDeviceKey(m.Tenant, m.DeviceID)
+	d, ok := a.devices[key]
+	if !ok {
+		d = &rollingDevice{}
+		a.devices[key] = d
+	}
+	// Clipped, the observations append the window and the stat to it
+	d.attrs = slices.Clip(append([]attribute.KeyValue{attribute.String("device_id", m.DeviceID)}, fleetAttributes(m)...))
+	d.samples = append(d.samples, rollingSample{at: now, values: values})
+	d.prune(now)
+}
+
+// evict forgets the samples of the tenantDeviceKey key
+func (a *rollingAggregator) evict(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.devices, key)
+}
+
+// prune drops the samples older than the longest window
+func (d *rollingDevice) prune(now time.Time) {
+	cutoff := now.Add(-rollingWindows[len(rollingWindows)-1].span)
+	i := 0
+	for i < len(d.samples) && d.samples[i].at.Before(cutoff) {
+		i++
+	}
+	d.samples = d.samples[i:]
+}
+
+// rollingStats are the aggregates of a reading over a window
+type rollingStats struct {
+	avg, min, max float64
+}
+
+// stats aggregates every reading of the samples since cutoff, false if there are none
+func (d *rollingDevice) stats(cutoff time.Time) ([]rollingStats, bool) {
+	stats := make([]rollingStats, len(rollingMetrics))
+	for i := range stats {
+		stats[i] = rollingStats{min: math.Inf(1), max: math.Inf(-1)}
+	}
+	n := 0
+	for _, s := range d.samples {
+		if s.at.Before(cutoff) {
+			continue
+		}
+		n++
+		for i, v := range s.values {
+			stats[i].avg += v
+			stats[i].min = math.Min(stats[i].min, v)
+			stats[i].max = math.Max(stats[i].max, v)
+		}
+	}
+	if n == 0 {
+		return nil, false
+	}
+	for i := range stats {
+		stats[i].avg /= float64(n)
+	}
+	return stats, true
+}
+
+// registerRollingObservers creates the rolling gauges and the callback reporting, for every
+// device with samples in a window, the average, minimum and maximum of each reading
+func registerRollingObservers(meter metric.Meter) error {
+	gauges := make([]metric.Float64ObservableGauge, len(rollingMetrics))
+	instruments := make([]metric.Observable, len(rollingMetrics))
+	for i, rm := range rollingMetrics {
+		g, err := meter.Float64ObservableGauge("custom.googleapis.com/"+rm.name+"_rolling",
+			metric.WithDescription(rm.description+", media, minimo e massimo su finestre mobili (window, stat)"))
+		if err != nil {
+			return err
+		}
+		gauges[i], instruments[i] = g, g
+	}
+
+	_, err := meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		now := time.Now()
+		rolling.mu.Lock()
+		defer rolling.mu.Unlock()
+		for id, d := range rolling.devices {
+			d.prune(now)
+			if len(d.samples) == 0 {
+				// No sample in the longest window, the device stops being reported
+				delete(rolling.devices, id)
+				continue
+			}
+			for _, w := range rollingWindows {
+				stats, ok := d.stats(now.Add(-w.span))
+				if !ok {
+					continue
+				}
+				for i, g := range gauges {
+					for _, s := range []struct {
+						name  string
+						value float64
+					}{{"avg", stats[i].avg}, {"min", stats[i].min}, {"max", stats[i].max}} {
+						observer.ObserveFloat64(g, s.value, metric.WithAttributes(append(d.attrs,
+							attribute.String("window", w.name), attribute.String("stat", s.name))...))
+					}
+				}
+			}
+		}
+		return nil
+	}, instruments...)
+	return err
+}
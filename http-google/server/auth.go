@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// apiKeyHeader is the header the devices send their static API key in
+const apiKeyHeader = "X-API-Key"
+
+// authConfig holds the accepted credentials, read from the environment:
+// AUTH_API_KEYS is a comma separated list of keys, each optionally "tenant:key", AUTH_JWT_SECRET
+// the HS256 secret of the bearer tokens, checked against AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE
+// when set. With neither keys nor secret every request is accepted.
+type authConfig struct {
+	apiKeys   []apiKey
+	jwtSecret []byte
+	jwtOpts   []jwt.ParserOption
+}
+
+// apiKey is an accepted static key and the tenant it authenticates, if any
+type apiKey struct {
+	key    []byte
+	tenant string
+}
+
+// loadAuthConfig reads the credentials from the environment
+func loadAuthConfig() authConfig {
+	var cfg authConfig
+	for _, key := range strings.Split(os.Getenv("AUTH_API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			var k apiKey
+			if tenant, secret, ok := strings.Cut(key, ":"); ok {
+				if err := checkKeyParts(tenant, ""); err != nil {
+					slog.Warn("Invalid AUTH_API_KEYS entry, skipping it", slog.Any("error", err))
+					continue
+				}
+				k = apiKey{key: []byte(secret), tenant: tenant}
+			} else {
+				k = apiKey{key: []byte(key)}
+			}
+			cfg.apiKeys = append(cfg.apiKeys, k)
+		}
+	}
+
+	if secret := os.Getenv("AUTH_JWT_SECRET"); secret != "" {
+		cfg.jwtSecret = []byte(secret)
+		cfg.jwtOpts = []jwt.ParserOption{
+			jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+			jwt.WithExpirationRequired(),
+		}
+		if iss := os.Getenv("AUTH_JWT_ISSUER"); iss != "" {
+			cfg.jwtOpts = append(cfg.jwtOpts, jwt.WithIssuer(iss))
+		}
+		if aud := os.Getenv("AUTH_JWT_AUDIENCE"); aud != "" {
+			cfg.jwtOpts = append(cfg.jwtOpts, jwt.WithAudience(aud))
+		}
+	}
+	return cfg
+}
+
+// enabled reports whether the requests must carry credentials
+func (c authConfig) enabled() bool {
+	return len(c.apiKeys) > 0 || c.jwtSecret != nil
+}
+
+// authIdentity is who sent an authenticated request, attached to its context for logging
+type authIdentity struct {
+	Method   string // "api_key" or "jwt"
+	Subject  string // sub of the token
+	Tenant   string // tenant of the key or tenant claim of the token
+	DeviceID string // device_id claim: the token only sends for this device
+}
+
+// identityKey is the context key of the authIdentity of a request
+type identityKey struct{}
+
+// withIdentity returns ctx carrying the identity of the request
+func withIdentity(ctx context.Context, id authIdentity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// identityFromContext returns the identity of an authenticated request
+func identityFromContext(ctx context.Context) (authIdentity, bool) {
+	id, ok := ctx.Value(identityKey{}).(authIdentity)
+	return id, ok
+}
+
+// attrs are the log attributes of the identity
+func (id authIdentity) attrs() []any {
+	attrs := []any{slog.String("method", id.Method)}
+	if id.Subject != "" {
+		attrs = append(attrs, slog.String("subject", id.Subject))
+	}
+	if id.Tenant != "" {
+		attrs = append(attrs, slog.String("tenant", id.Tenant))
+	}
+	if id.DeviceID != "" {
+		attrs = append(attrs, slog.String("device_id", id.DeviceID))
+	}
+	return attrs
+}
+
+// deviceClaims are the claims of a device token
+type deviceClaims struct {
+	Tenant   string `json:"tenant,omitempty"`
+	DeviceID string `json:"device_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// apiKeyIdentity compares the key in constant time with every accepted one
+func (c authConfig) apiKeyIdentity(key string) (authIdentity, bool) {
+	valid, tenant := 0, ""
+	for _, k := range c.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), k.key) == 1 {
+			valid, tenant = 1, k.tenant
+		}
+	}
+	return authIdentity{Method: "api_key", Tenant: tenant}, valid == 1
+}
+
+// tokenIdentity verifies the signature and the claims of a bearer token
+func (c authConfig) tokenIdentity(raw string) (authIdentity, error) {
+	if c.jwtSecret == nil {
+		return authIdentity{}, errors.New("bearer tokens are not accepted")
+	}
+	var claims deviceClaims
+	if _, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (interface{}, error) {
+		return c.jwtSecret, nil
+	}, c.jwtOpts...); err != nil {
+		return authIdentity{}, err
+	}
+	return authIdentity{Method: "jwt", Subject: claims.Subject, Tenant: claims.Tenant, DeviceID: claims.DeviceID}, nil
+}
+
+// requireAuth answers 401 to requests without a valid API key or bearer token and
+// attaches the identity of the others to their context
+func requireAuth(cfg authConfig, next http.Handler) http.Handler {
+	if !cfg.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			id      authIdentity
+			message string
+		)
+		key := r.Header.Get(apiKeyHeader)
+		raw, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		switch {
+		case key != "":
+			var ok bool
+			if id, ok = cfg.apiKeyIdentity(key); !ok {
+				message = "invalid API key"
+			}
+		case bearer:
+			var err error
+			if id, err = cfg.tokenIdentity(raw); err != nil {
+				message = "invalid bearer token: " + err.Error()
+			}
+		default:
+			message = "missing API key or bearer token"
+		}
+
+		if message != "" {
+			slog.WarnContext(r.Context(), "Unauthorized request",
+				slog.String("path", r.URL.Path), slog.String("remote_addr", r.RemoteAddr), slog.String("reason", message))
+			writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, message)
+			return
+		}
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.String("auth.method", id.Method), attribute.String("auth.tenant", id.Tenant))
+		next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), id)))
+	})
+}
+
+// authorizeDevice answers 403 and returns false when the request was authenticated
+// with a token of another device than deviceID
+func authorizeDevice(w http.ResponseWriter, r *http.Request, deviceID string) bool {
+	id, ok := identityFromContext(r.Context())
+	if !ok || id.DeviceID == "" || id.DeviceID == deviceID {
+		return true
+	}
+	slog.WarnContext(r.Context(), "Forbidden request",
+		slog.String("path", r.URL.Path), slog.String("device_id", deviceID))
+	writeProblem(w, r, http.StatusForbidden, codeForbiddenDevice, fmt.Sprintf("token of device %s can't send for device %s", id.DeviceID, deviceID))
+	return false
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxPendingCommands bounds the commands waiting for a device that stopped sending
+const maxPendingCommands = 32
+
+// Types of the commands a device can receive
+const (
+	commandSetInterval      = "set_interval"      // send metrics every IntervalSeconds
+	commandSelfTest         = "self_test"         // run the diagnostics and log their events
+	commandUpdateThresholds = "update_thresholds" // alert locally past Thresholds
+)
+
+// deviceCommand is a command waiting for a device, handed out in the response of its
+// next log or metric request
+type deviceCommand struct {
+	ID              string             `cbor:"id" json:"id"`
+	Type            string             `cbor:"type" json:"type"`
+	IntervalSeconds int                `cbor:"interval_s,omitempty" json:"interval_s,omitempty"`
+	Thresholds      map[string]float64 `cbor:"thresholds,omitempty" json:"thresholds,omitempty"`
+	CreatedAt       time.Time          `cbor:"created_at" json:"created_at"`
+}
+
+// validate checks the command has the arguments of its type
+func (c deviceCommand) validate() error {
+	switch c.Type {
+	case commandSetInterval:
+		if c.IntervalSeconds <= 0 {
+			return fmt.Errorf("%s needs a positive interval_s", c.Type)
+		}
+	case commandSelfTest:
+	case commandUpdateThresholds:
+		if len(c.Thresholds) == 0 {
+			return fmt.Errorf("%s needs thresholds", c.Type)
+		}
+	default:
+		return fmt.Errorf("unknown command type %q, must be %s, %s or %s",
+			c.Type, commandSetInterval, commandSelfTest, commandUpdateThresholds)
+	}
+	return nil
+}
+
+// commandQueue keeps the pending commands of every device by tenant
+type commandQueue struct {
+	mu      sync.Mutex
+	pending map[string][]deviceCommand
+}
+
+// commands are the commands waiting for the devices
+var commands = &commandQueue{pending: make(map[string][]deviceCommand)}
+
+// enqueue adds a command for a device, giving it an ID
+func (q *commandQueue) enqueue(tenant, deviceID string, c deviceCommand) (deviceCommand, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	c.ID, c.CreatedAt = hex.EncodeToString(id), time.Now().UTC()
+
+	key := tenantDeviceKey(tenant, deviceID)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending[key]) >= maxPendingCommands {
+		return deviceCommand{}, fmt.Errorf("device %s already has %d pending commands", deviceID, maxPendingCommands)
+	}
+	q.pending[key] = append(q.pending[key], c)
+	return c, nil
+}
+
+// remove drops the delivered commands of a device, keeping those queued since they were listed
+func (q *commandQueue) remove(tenant, deviceID string, delivered []deviceCommand) {
+	ids := make(map[string]bool, len(delivered))
+	for _, c := range delivered {
+		ids[c.ID] = true
+	}
+	key := tenantDeviceKey(tenant, deviceID)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var kept []deviceCommand
+	for _, c := range q.pending[key] {
+		if !ids[c.ID] {
+			kept = append(kept, c)
+		}
+	}
+	if len(kept) == 0 {
+		delete(q.pending, key)
+		return
+	}
+	q.pending[key] = kept
+}
+
+// list returns a copy of the pending commands of a device
+func (q *commandQueue) list(tenant, deviceID string) []deviceCommand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]deviceCommand{}, q.pending[tenantDeviceKey(tenant, deviceID)]...)
+}
+
+// commandsResponse is the body of a response carrying the commands of its device
+type commandsResponse struct {
+	Commands []deviceCommand `cbor:"commands" json:"commands"`
+}
+
+// batchCommandsResponse is the body of a metric batch response carrying the commands of
+// the devices of the batch, by device ID
+type batchCommandsResponse struct {
+	Devices map[string][]deviceCommand `cbor:"devices" json:"devices"`
+}
+
+// writeStatusWithCommands answers a device request with status and, if the device has
+// pending commands, a body listing them: JSON to JSON requests, CBOR to the others.
+// The commands leave the queue only once the body is written, so a failed write hands
+// them out again with the next request; a device may see a command twice, never zero
+// times, and tells them apart by ID.
+func writeStatusWithCommands(w http.ResponseWriter, r *http.Request, status int, deviceID string) {
+	tenant := tenantFromContext(r.Context())
+	cmds := commands.list(tenant, deviceID)
+	if len(cmds) == 0 {
+		w.WriteHeader(status)
+		return
+	}
+	if writeCommands(w, r, status, commandsResponse{cmds}) {
+		commands.remove(tenant, deviceID, cmds)
+		slog.InfoContext(r.Context(), "Commands sent to device", slog.String("device_id", deviceID), slog.Int("commands", len(cmds)))
+	}
+}
+
+// writeStatusWithBatchCommands answers a metric batch like writeStatusWithCommands, with
+// the pending commands of every device of deviceIDs
+func writeStatusWithBatchCommands(w http.ResponseWriter, r *http.Request, status int, deviceIDs []string) {
+	tenant := tenantFromContext(r.Context())
+	pending := make(map[string][]deviceCommand)
+	for _, id := range deviceIDs {
+		if _, ok := pending[id]; ok {
+			continue
+		}
+		if cmds := commands.list(tenant, id); len(cmds) > 0 {
+			pending[id] = cmds
+		}
+	}
+	if len(pending) == 0 {
+		w.WriteHeader(status)
+		return
+	}
+	if writeCommands(w, r, status, batchCommandsResponse{pending}) {
+		for id, cmds := range pending {
+			commands.remove(tenant, id, cmds)
+			slog.InfoContext(r.Context(), "Commands sent to device", slog.String("device_id", id), slog.Int("commands", len(cmds)))
+		}
+	}
+}
+
+// writeCommands answers with status and body, JSON to JSON requests and CBOR to the
+// others, reporting whether the body was written
+func writeCommands(w http.ResponseWriter, r *http.Request, status int, body any) bool {
+	var data []byte
+	var err error
+	contentType := contentTypeCBOR
+	if payloadType(r) == contentTypeJSON {
+		contentType = contentTypeJSON
+		data, err = json.Marshal(body)
+	} else {
+		data, err = cbor.Marshal(body)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to encode device commands", slog.Any("error", err))
+		w.WriteHeader(status)
+		return false
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.WarnContext(r.Context(), "Failed to send device commands, keeping them queued", slog.Any("error", err))
+		return false
+	}
+	return true
+}
+
+// handleEnqueueCommand queues a command for the device in the path, of the tenant query
+// parameter, sent with the response of its next log or metric request; it is an admin
+// route, the device and tenant credentials can't command the devices
+func handleEnqueueCommand(w http.ResponseWriter, r *http.Request) {
+	var c deviceCommand
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&c); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidPayload, "invalid command: "+err.Error())
+		return
+	}
+	if err := c.validate(); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidPayload, err.Error())
+		return
+	}
+	tenant, deviceID := r.URL.Query().Get("tenant"), r.PathValue("id")
+	if err := checkKeyParts(tenant, deviceID); err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidQuery, err.Error())
+		return
+	}
+	c, err := commands.enqueue(tenant, deviceID, c)
+	if err != nil {
+		writeProblem(w, r, http.StatusTooManyRequests, codeTooManyCommands, err.Error())
+		return
+	}
+	slog.InfoContext(r.Context(), "Command queued", slog.String("device_id", deviceID),
+		slog.String("command_id", c.ID), slog.String("command", c.Type))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(c)
+}
+
+// handlePendingCommands lists the commands still waiting for the device in the path
+func handlePendingCommands(w http.ResponseWriter, r *http.Request) {
+	if !allowRegistryRead(w, r) {
+		return
+	}
+	cmds := commands.list(tenantFromContext(r.Context()), r.PathValue("id"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Count    int             `json:"count"`
+		Commands []deviceCommand `json:"commands"`
+	}{len(cmds), cmds})
+}
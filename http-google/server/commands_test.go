@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TestEnqueueCommandNeedsAdminKey checks the device and tenant credentials can't queue
+// commands, only the admin keys can
+func TestEnqueueCommandNeedsAdminKey(t *testing.T) {
+	t.Setenv("AUTH_API_KEYS", "acme:tenant-key")
+	t.Setenv("AUTH_JWT_SECRET", "jwt-secret")
+	t.Setenv("ADMIN_API_KEYS", "admin-key")
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, deviceClaims{
+		Tenant:           "acme",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString([]byte("jwt-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const body = `{"type":"self_test"}`
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{"tenant key", "/admin/devices/device-001/commands?tenant=acme", apiKeyHeader, "tenant-key", http.StatusUnauthorized},
+		{"tenant token", "/admin/devices/device-001/commands?tenant=acme", "Authorization", "Bearer " + token, http.StatusUnauthorized},
+		{"tenant key as admin key", "/admin/devices/device-001/commands?tenant=acme", adminKeyHeader, "tenant-key", http.StatusUnauthorized},
+		{"tenant token on the old route", "/devices/device-001/commands", "Authorization", "Bearer " + token, http.StatusMethodNotAllowed},
+		{"admin key", "/admin/devices/device-001/commands?tenant=acme", adminKeyHeader, "admin-key", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body))
+			req.Header.Set(tt.header, tt.value)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("POST %s with %s: got %d, want %d: %s", tt.path, tt.name, rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	if cmds := commands.list("acme", "device-001"); len(cmds) != 1 || cmds[0].Type != commandSelfTest {
+		t.Fatalf("pending commands of acme/device-001: got %+v, want the self_test of the admin", cmds)
+	}
+}
+
+// failingWriter is a response whose body never reaches the device
+type failingWriter struct{ *httptest.ResponseRecorder }
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+// TestMetricBatchDeliversCommands checks a metric batch answers with the commands of each
+// of its devices, and only drops them from the queue once the response is written
+func TestMetricBatchDeliversCommands(t *testing.T) {
+	t.Setenv("AUTH_API_KEYS", "")
+	t.Setenv("AUTH_JWT_SECRET", "")
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	for _, id := range []string{"batch-a", "batch-b"} {
+		if _, err := commands.enqueue("", id, deviceCommand{Type: commandSelfTest}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body := "[" + sample(t, "batch-a") + "," + sample(t, "batch-b") + "," + sample(t, "batch-c") + "]"
+	post := func(w http.ResponseWriter) {
+		req := httptest.NewRequest(http.MethodPost, "/batchMetrics", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		mux.ServeHTTP(w, req)
+	}
+
+	post(failingWriter{httptest.NewRecorder()})
+	for _, id := range []string{"batch-a", "batch-b"} {
+		if cmds := commands.list("", id); len(cmds) != 1 {
+			t.Fatalf("%s has %d pending commands after a failed response, want 1", id, len(cmds))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	post(rec)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("got %d, want 202: %s", rec.Code, rec.Body)
+	}
+	var resp batchCommandsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid commands body %q: %v", rec.Body, err)
+	}
+	if len(resp.Devices) != 2 || len(resp.Devices["batch-a"]) != 1 || len(resp.Devices["batch-b"]) != 1 {
+		t.Fatalf("got the commands %+v, want one for batch-a and one for batch-b", resp.Devices)
+	}
+	for _, id := range []string{"batch-a", "batch-b"} {
+		if cmds := commands.list("", id); len(cmds) != 0 {
+			t.Fatalf("%s still has %d pending commands after they were delivered", id, len(cmds))
+		}
+	}
+}
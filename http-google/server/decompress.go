@@ -0,0 +1,62 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// maxDecompressedBytes bounds a decompressed request body, so a small compressed
+// payload cannot expand without limit
+const maxDecompressedBytes = 10 << 20
+
+// zstdReadCloser closes the zstd decoder along with the request body
+type zstdReadCloser struct {
+	*zstd.Decoder
+	body io.Closer
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return z.body.Close()
+}
+
+// decompressBody replaces the request body with its decompressed form when the
+// device sent it with a gzip or zstd Content-Encoding
+func decompressBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.ReadCloser
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeProblem(w, r, http.StatusBadRequest, codeInvalidPayload, "invalid gzip body")
+				return
+			}
+			body = zr
+		case "zstd":
+			zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				writeProblem(w, r, http.StatusBadRequest, codeInvalidPayload, "invalid zstd body")
+				return
+			}
+			body = zstdReadCloser{Decoder: zr, body: r.Body}
+		default:
+			writeProblem(w, r, http.StatusUnsupportedMediaType, codeUnsupportedEncoding, fmt.Sprintf("unsupported Content-Encoding %q", encoding))
+			return
+		}
+		defer body.Close()
+
+		r.Body = http.MaxBytesReader(w, body, maxDecompressedBytes)
+		r.Header.Del("Content-Encoding")
+		r.ContentLength = -1
+		next.ServeHTTP(w, r)
+	})
+}
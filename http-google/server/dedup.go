@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// defaultLogBatchDedupWindow is how long a log batch ID is remembered by default, longer
+// than a device keeps retrying a batch
+const defaultLogBatchDedupWindow = 15 * time.Minute
+
+// duplicateLogBatches counts the log batches answered without logging them again
+var duplicateLogBatches metric.Int64Counter
+
+// batchDedup remembers the IDs of the recent log batches of every device, so a batch
+// retried after a timeout the server already handled is not logged twice
+type batchDedup struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time // first arrival by tenant, device and batch ID
+}
+
+// logBatchDedup is the dedup window of the log batches, 0 disables it
+var logBatchDedup = newBatchDedup(loadLogBatchDedupWindow())
+
+func newBatchDedup(window time.Duration) *batchDedup {
+	return &batchDedup{window: window, seen: make(map[string]time.Time)}
+}
+
+// loadLogBatchDedupWindow reads LOG_BATCH_DEDUP_WINDOW, "0" turns deduplication off
+func loadLogBatchDedupWindow() time.Duration {
+	raw := os.Getenv("LOG_BATCH_DEDUP_WINDOW")
+	if raw == "" {
+		return defaultLogBatchDedupWindow
+	}
+	window, err := time.ParseDuration(raw)
+	if err != nil || window < 0 {
+		slog.Warn("Invalid LOG_BATCH_DEDUP_WINDOW, using the default",
+			slog.String("value", raw), slog.Duration("default", defaultLogBatchDedupWindow))
+		return defaultLogBatchDedupWindow
+	}
+	return window
+}
+
+// initDedupMetrics creates the counter of the duplicate log batches
+func initDedupMetrics(meter metric.Meter) {
+	var err error
+	duplicateLogBatches, err = meter.Int64Counter("custom.googleapis.com/duplicate_log_batches",
+		metric.WithDescription("Batch di log già ricevuti e scartati, per dispositivo"))
+	if err != nil {
+		log.Fatalf("failed to create duplicate_log_batches counter: %v", err)
+	}
+}
+
+// firstSeen records the batch of a device and reports whether it is the first time it
+// arrived within the window; batches without an ID are always new
+func (d *batchDedup) firstSeen(tenant, deviceID, batchID string, now time.Time) bool {
+	if batchID == "" || d.window <= 0 {
+		return true
+	}
+	key := tenantDeviceKey(tenant, deviceID) + "\x00" + batchID
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if at, ok := d.seen[key]; ok && now.Sub(at) < d.window {
+		return false
+	}
+	d.seen[key] = now
+	return true
+}
+
+// expire forgets the batches older than the window
+func (d *batchDedup) expire(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, at := range d.seen {
+		if now.Sub(at) >= d.window {
+			delete(d.seen, key)
+		}
+	}
+}
+
+// run expires the old batch IDs every window until ctx is done
+func (d *batchDedup) run(ctx context.Context) {
+	if d.window <= 0 {
+		return
+	}
+	ticker := time.NewTicker(d.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.expire(now)
+		}
+	}
+}
+
+// recordDuplicateLogBatch counts a log batch dropped as a duplicate, by device and tenant
+func recordDuplicateLogBatch(ctx context.Context, deviceID string) {
+	if duplicateLogBatches == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("device_id", deviceID)}
+	if tenant := tenantFromContext(ctx); tenant != "" {
+		attrs = append(attrs, attribute.String("tenant", tenant))
+	}
+	duplicateLogBatches.Add(ctx, 1, metric.WithAttributes(attrs...))
+}
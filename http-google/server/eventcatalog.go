@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"devicetransport"
+)
+
+// eventCatalog decodes the log events, the catalog built into devicetransport unless
+// EVENT_CATALOG_FILE is set, swapped on reload
+var eventCatalog atomic.Pointer[devicetransport.EventCatalog]
+
+func init() {
+	eventCatalog.Store(&devicetransport.DefaultEventCatalog)
+}
+
+// handleEventCatalog returns the catalog the devices should send their events with,
+// tagged with its version so a device only downloads it again after it changes
+func handleEventCatalog(w http.ResponseWriter, r *http.Request) {
+	catalog := eventCatalog.Load()
+	etag := `"` + strconv.Itoa(catalog.Version) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(catalog)
+}
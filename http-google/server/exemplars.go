@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// deviceGaugeNames are the gauges observed from the metric cache, the ones that get exemplars
+var deviceGaugeNames = func() map[string]bool {
+	names := make(map[string]bool, len(rollingMetrics))
+	for _, rm := range rollingMetrics {
+		names["custom.googleapis.com/"+rm.name] = true
+	}
+	return names
+}()
+
+// exemplarExporter attaches to every point of the device gauges the trace of the request that
+// carried the sample, so a spike in Grafana leads to its ingestion trace. The SDK records
+// exemplars only for synchronous instruments, the gauges are observed without a span.
+type exemplarExporter struct {
+	metric.Exporter
+}
+
+// Export adds the exemplars, then exports with the wrapped exporter
+func (e exemplarExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	addGaugeExemplars(ctx, rm)
+	return e.Exporter.Export(ctx, rm)
+}
+
+// addGaugeExemplars sets the exemplar of each device gauge point to the span of the cached
+// sample of its device, when that span was sampled
+func addGaugeExemplars(ctx context.Context, rm *metricdata.ResourceMetrics) {
+	samples, err := globalMetricCache.snapshot(ctx)
+	if err != nil {
+		return
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			gauge, ok := m.Data.(metricdata.Gauge[float64])
+			if !ok || !deviceGaugeNames[m.Name] {
+				continue
+			}
+			// The points share their array with m.Data, so they are updated in place
+			for i, p := range gauge.DataPoints {
+				deviceID, _ := p.Attributes.Value(attribute.Key("device_id"))
+				tenant, _ := p.Attributes.Value(attribute.Key("tenant"))
+				sample, ok := samples[tenantDeviceKey(tenant.AsString(), deviceID.AsString())]
+				if !ok || !sample.span.IsSampled() {
+					continue
+				}
+				traceID, spanID := sample.span.TraceID(), sample.span.SpanID()
+				gauge.DataPoints[i].Exemplars = []metricdata.Exemplar[float64]{{
+					Time:    sample.receivedAt,
+					Value:   p.Value,
+					TraceID: traceID[:],
+					SpanID:  spanID[:],
+				}}
+			}
+		}
+	}
+}
@@ -1,104 +1,135 @@
 package main
 
 import (
-	"github.com/fxamacker/cbor/v2"
+	"fmt"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"log"
 	"log/slog"
 	"net/http"
 	"strings"
 	"time"
+
+	"devicetransport/gcplog"
 )
 
 // IncomingLogBatch represents the structure of a log batch sent by a device
 type IncomingLogBatch struct {
-	DeviceID string    `cbor:"device_id"`
-	Logs     [][]int64 `cbor:"logs"` // Each log is a pair: [event_id, timestamp]
+	DeviceID string     `cbor:"device_id" json:"device_id"`
+	BatchID  string     `cbor:"batch_id,omitempty" json:"batch_id,omitempty"` // Same for every retry of a batch, optional
+	Logs     [][]int64  `cbor:"logs" json:"logs"`                       // Each log is a pair: [event_id, timestamp]
+	Links    []SpanLink `cbor:"links,omitempty" json:"links,omitempty"` // Span of each log, by index, if sent
+}
+
+// SpanLink is the hex trace and span that were active on the device when a log happened
+type SpanLink struct {
+	TraceID string `cbor:"trace_id" json:"trace_id"`
+	SpanID  string `cbor:"span_id" json:"span_id"`
+}
+
+// spanContext returns the device span of the log at index i, invalid when it was not sent
+func (b IncomingLogBatch) spanContext(i int) trace.SpanContext {
+	if len(b.Links) != len(b.Logs) {
+		return trace.SpanContext{}
+	}
+	traceID, err := trace.TraceIDFromHex(b.Links[i].TraceID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	spanID, err := trace.SpanIDFromHex(b.Links[i].SpanID)
+	if err != nil {
+		return trace.SpanContext{}
+	}
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
 }
 
-// Map of event IDs to their severity and message descriptions
-var eventDefinitions = map[uint8]struct {
-	Severity string
-	Message  string
-}{
-	1: {"DEBUG", "Dispositivo in fase di inizializzazione"},
-	2: {"DEBUG", "Controllo stato rete"},
-	3: {"DEBUG", "Avvio modulo sensore"},
-	4: {"DEBUG", "Sincronizzazione orologio"},
-
-	5: {"INFO", "Avvio completato"},
-	6: {"INFO", "Temperatura normale"},
-	7: {"INFO", "CPU sotto soglia"},
-	8: {"INFO", "Heartbeat inviato"},
-
-	9:  {"NOTICE", "Cambio configurazione"},
-	10: {"NOTICE", "Aggiornamento firmware disponibile"},
-	11: {"NOTICE", "Sensore temporaneamente inattivo"},
-	12: {"NOTICE", "Collegamento rete ristabilito"},
-
-	13: {"WARNING", "Temperatura elevata"},
-	14: {"WARNING", "Consumo CPU sopra la soglia"},
-	15: {"WARNING", "Batteria in esaurimento"},
-	16: {"WARNING", "Perdita pacchetti rilevata"},
-
-	17: {"ERROR", "Impossibile connettersi al server"},
-	18: {"ERROR", "Errore lettura sensore"},
-	19: {"ERROR", "Timeout nella risposta del server"},
-	20: {"ERROR", "Scrittura su memoria fallita"},
-
-	21: {"CRITICAL", "Perdita connessione permanente"},
-	22: {"CRITICAL", "Dati corrotti nella memoria"},
-
-	23: {"ALERT", "Accesso non autorizzato rilevato"},
-	24: {"ALERT", "Possibile attacco DoS in corso"},
-
-	25: {"EMERGENCY", "Sistema in stato critico - riavvio necessario"},
-	26: {"EMERGENCY", "Errore hardware irreversibile"},
-	27: {"EMERGENCY", "Guasto alimentazione principale"},
+// spanLinks returns the distinct device spans of the batch, to link them to the batch span
+func (b IncomingLogBatch) spanLinks() []trace.Link {
+	var links []trace.Link
+	seen := make(map[trace.SpanID]bool)
+	for i := range b.Logs {
+		if sc := b.spanContext(i); sc.IsValid() && !seen[sc.SpanID()] {
+			seen[sc.SpanID()] = true
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return links
 }
 
 // Maps severity string to slog.Level
 func mapSeverityToLevel(sev string) slog.Level {
 	switch strings.ToUpper(sev) {
 	case "DEBUG":
-		return LevelDebug
+		return gcplog.LevelDebug
 	case "INFO":
-		return LevelInfo
+		return gcplog.LevelInfo
 	case "NOTICE":
-		return LevelNotice
+		return gcplog.LevelNotice
 	case "WARNING":
-		return LevelWarning
+		return gcplog.LevelWarning
 	case "ERROR":
-		return LevelError
+		return gcplog.LevelError
 	case "CRITICAL":
-		return LevelCritical
+		return gcplog.LevelCritical
 	case "ALERT":
-		return LevelAlert
+		return gcplog.LevelAlert
 	case "EMERGENCY":
-		return LevelEmergency
+		return gcplog.LevelEmergency
 	default:
-		return LevelInfo
+		return gcplog.LevelInfo
 	}
 }
 
 // HTTP handler for processing a batch of logs
 func handleBatchLog(w http.ResponseWriter, r *http.Request) {
-	var batch IncomingLogBatch
-
-	// Decode the CBOR-encoded request body into IncomingLogBatch
-	if err := cbor.NewDecoder(r.Body).Decode(&batch); err != nil {
-		http.Error(w, "invalid cbor", http.StatusBadRequest)
+	// Decode the CBOR, JSON or protobuf request body into IncomingLogBatch
+	batch, err := decodeLogBatch(r)
+	if err != nil {
+		writeDecodeProblem(w, r, fmt.Errorf("invalid log batch: %w", err))
 		return
 	}
+	if !authorizeDevice(w, r, batch.DeviceID) {
+		return
+	}
+	tenant := tenantFromContext(r.Context())
+	if !admitDevices(w, r, batch.DeviceID) {
+		return
+	}
+	registry.seenLogs(tenant, batch.DeviceID, payloadType(r))
 
 	// Extract tracing context and start a span
 	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
-	ctx, span := otel.Tracer("http-server").Start(ctx, "handleBatchLog")
+	ctx, span := otel.Tracer("http-server").Start(ctx, "handleBatchLog", trace.WithLinks(batch.spanLinks()...))
 	defer span.End()
 
+	// A retry of a batch already handled is confirmed again without logging its events twice
+	if batch.BatchID != "" {
+		span.SetAttributes(attribute.String("batch_id", batch.BatchID))
+	}
+	if !logBatchDedup.firstSeen(tenant, batch.DeviceID, batch.BatchID, time.Now()) {
+		span.SetAttributes(attribute.Bool("duplicate", true))
+		slog.InfoContext(ctx, "Duplicate log batch ignored",
+			slog.String("device_id", batch.DeviceID), slog.String("batch_id", batch.BatchID))
+		recordDuplicateLogBatch(ctx, batch.DeviceID)
+		writeStatusWithCommands(w, r, http.StatusOK, batch.DeviceID)
+		return
+	}
+
+	recordBatchSize(ctx, "/batchLog", len(batch.Logs))
+	bySeverity := make(map[string]int)
+	dropped := make(map[droppedKey]int)
+	now := time.Now()
+	catalog := eventCatalog.Load()
+
 	// Iterate over each compressed log entry
-	for _, entry := range batch.Logs {
+	for i, entry := range batch.Logs {
 		// Each entry must be [eventID, timestamp]
 		if len(entry) != 2 {
 			log.Println("Invalid log entry, skipping:", entry)
@@ -108,23 +139,42 @@ func handleBatchLog(w http.ResponseWriter, r *http.Request) {
 		id := uint8(entry[0])
 		ts := entry[1]
 
-		def, ok := eventDefinitions[id]
+		def, ok := catalog.Events[id]
 		if !ok {
 			log.Printf("Unknown event ID %d", id)
 			continue
 		}
+		bySeverity[def.Severity]++
+
+		// Chatty devices are sampled before the event reaches Cloud Logging
+		if keep, reason := deviceLogSampler.keep(tenant, batch.DeviceID, def.Severity, now); !keep {
+			dropped[droppedKey{def.Severity, reason}]++
+			continue
+		}
 
 		t := time.Unix(ts, 0).UTC()
 		formattedTime := t.Format(time.RFC3339)
 
+		// Log the event in the trace of the device metric it happened during, if linked
+		logCtx := ctx
+		if sc := batch.spanContext(i); sc.IsValid() {
+			logCtx = trace.ContextWithRemoteSpanContext(ctx, sc)
+		}
+
 		// Log the message with context and attributes
-		slog.LogAttrs(ctx, mapSeverityToLevel(def.Severity), def.Message,
+		attrs := []slog.Attr{
 			slog.String("device_id", batch.DeviceID),
 			slog.String("timestamp", formattedTime),
 			slog.String("type", "devicelog"),
-		)
+		}
+		if tenant != "" {
+			attrs = append(attrs, slog.String("tenant", tenant))
+		}
+		slog.LogAttrs(logCtx, mapSeverityToLevel(def.Severity), def.Message, attrs...)
 	}
+	recordLogEvents(ctx, batch.DeviceID, bySeverity)
+	recordDroppedLogEvents(ctx, batch.DeviceID, dropped)
 
-	// Send HTTP 200 OK to confirm successful processing
-	w.WriteHeader(http.StatusOK)
+	// Send HTTP 200 OK to confirm successful processing, with the commands queued for the device
+	writeStatusWithCommands(w, r, http.StatusOK, batch.DeviceID)
 }
@@ -2,87 +2,158 @@
 package main
 
 import (
-	"github.com/fxamacker/cbor/v2"
+	"context"
+	"fmt"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log"
 	"log/slog"
 	"net/http"
-	"sync"
+	"time"
 
 )
 
-// Global in-memory cache for metrics
-var (
-	globalMetricCache = make(map[string]Metrics)
-	cacheMu           sync.RWMutex
-)
+// Latest sample of every device, in memory unless shared in Redis
+var globalMetricCache metricCache = newMemoryMetricCache()
 
-// Convert temperature to a severity string
-func tempToSeverityString(temp float64) string {
-	switch {
-	case temp < 75:
-		return "INFO"
-	case temp < 85:
-		return "WARNING"
-	case temp < 95:
-		return "CRITICAL"
-	case temp <= 100:
-		return "EMERGENCY"
-	default:
-		return "INFO"
-	}
-}
+// HTTP handler for receiving and logging device metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
 
-// Convert temperature to a human-readable message
-func tempToMessage(temp float64) string {
-	switch {
-	case temp < 75:
-		return "Temperature is fine"
-	case temp < 85:
-		return "Temperature rising – monitor closely"
-	case temp < 95:
-		return "Critical temperature – action needed"
-	case temp <= 100:
-		return "Emergency – device may fail"
-	default:
-		return "Temperature is fine"
+	ctx, span := otel.Tracer("http-server").Start(r.Context(), "handleMetrics")
+	defer span.End()
+
+	// Decode the CBOR, JSON or protobuf payload into the Metrics struct
+	m, err := decodeMetrics(r)
+	if err != nil {
+		log.Printf("Metrics decode error: %v", err)
+		writeDecodeProblem(w, r, fmt.Errorf("invalid metrics: %w", err))
+		return
 	}
+	if !authorizeDevice(w, r, m.DeviceID) {
+		return
+	}
+	m.Tenant = tenantFromContext(ctx)
+	// A rejected sample never registers its device, so it doesn't take up the quota of the tenant
+	if ok, errs := checkMetrics(ctx, metricValidation, m); !ok {
+		span.SetAttributes(attribute.Bool("metrics.rejected", true))
+		writeValidationError(w, r, m.DeviceID, errs)
+		return
+	}
+	if !admitDevices(w, r, m.DeviceID) {
+		return
+	}
+	recordMetrics(ctx, m)
+	registry.seenMetrics(m, payloadType(r))
+
+	// The commands queued for the device ride back on the response
+	writeStatusWithCommands(w, r, http.StatusAccepted, m.DeviceID)
 }
 
-// HTTP handler for receiving and logging device metrics
-func handleMetrics(w http.ResponseWriter, r *http.Request) {
+// HTTP handler for receiving batches of metrics, of one or more devices, in one request
+func handleMetricBatch(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	ctx, span := otel.Tracer("http-server").Start(r.Context(), "handleMetrics")
+	ctx, span := otel.Tracer("http-server").Start(r.Context(), "handleMetricBatch")
 	defer span.End()
 
-	var m Metrics
+	batch, err := decodeMetricBatch(r)
+	if err != nil {
+		log.Printf("Metric batch decode error: %v", err)
+		writeDecodeProblem(w, r, fmt.Errorf("invalid metric batch: %w", err))
+		return
+	}
+	span.SetAttributes(attribute.Int("batch.size", len(batch)))
+	recordBatchSize(ctx, "/batchMetrics", len(batch))
+	for i, m := range batch {
+		if !authorizeDevice(w, r, m.DeviceID) {
+			return
+		}
+		batch[i].Tenant = tenantFromContext(ctx)
+	}
+
+	// Invalid samples are left out before the admission, so their devices don't take up the
+	// quota of the tenant; the batch is rejected only when none is valid
+	valid := make([]Metrics, 0, len(batch))
+	var lastErrs []fieldError
+	for _, m := range batch {
+		if ok, errs := checkMetrics(ctx, metricValidation, m); !ok {
+			lastErrs = errs
+			continue
+		}
+		valid = append(valid, m)
+	}
+	rejected := len(batch) - len(valid)
+	span.SetAttributes(attribute.Int("batch.rejected", rejected))
+	if rejected > 0 && len(valid) == 0 {
+		writeValidationError(w, r, batch[len(batch)-1].DeviceID, lastErrs)
+		return
+	}
 
-	// Decode the CBOR payload into the Metrics struct
-	if err := cbor.NewDecoder(r.Body).Decode(&m); err != nil {
-		log.Printf("CBOR decode error: %v", err)
-		http.Error(w, "Invalid CBOR", http.StatusBadRequest)
+	deviceIDs := make([]string, 0, len(valid))
+	for _, m := range valid {
+		deviceIDs = append(deviceIDs, m.DeviceID)
+	}
+	if !admitDevices(w, r, deviceIDs...) {
 		return
 	}
-	// Update the in-memory cache with the latest metrics
-	updateMetricCache(m)
+	for _, m := range valid {
+		recordMetrics(ctx, m)
+		registry.seenMetrics(m, payloadType(r))
+	}
+
+	// The commands queued for the devices of the batch ride back on the response
+	writeStatusWithBatchCommands(w, r, http.StatusAccepted, deviceIDs)
+}
+
+// recordMetrics caches the metrics of a device for the gauges and logs the sample with the
+// severity of its most severe reading, as classified by the thresholds of the device; a
+// sample older than METRIC_MAX_AGE is only logged
+func recordMetrics(ctx context.Context, m Metrics) {
+	// Update the in-memory cache with the latest metrics, and the rolling aggregates
+	m.span, m.receivedAt = trace.SpanContextFromContext(ctx), time.Now()
+	timeliness := checkTimeliness(ctx, m, m.receivedAt)
+	if !timeliness.stale {
+		updateMetricCache(ctx, m)
+		rolling.add(m, m.receivedAt)
+	}
 
 	// Determine severity and log the metric
-	severityStr := tempToSeverityString(m.MCUTempC)
+	severityStr, message := "INFO", "Metrics within thresholds"
+	alerts := classifyMetrics(m)
+	if len(alerts) > 0 {
+		severityStr, message = alerts[0].Severity, alerts[0].message
+	}
 	level := mapSeverityToLevel(severityStr)
 
-	slog.LogAttrs(ctx, level, tempToMessage(m.MCUTempC),
+	attrs := []slog.Attr{
 		slog.String("device_id", m.DeviceID),
 		slog.Float64("value", m.MCUTempC),
+		slog.String("firmware_version", m.FirmwareVersion),
+		slog.String("fleet", m.Fleet),
+		slog.String("region", m.Region),
 		slog.String("type", "devicemetric"),
-	)
-
-	w.WriteHeader(http.StatusAccepted)
+	}
+	if m.Tenant != "" {
+		attrs = append(attrs, slog.String("tenant", m.Tenant))
+	}
+	if len(alerts) > 0 {
+		attrs = append(attrs, slog.Any("alerts", alerts))
+	}
+	if !m.Timestamp.IsZero() {
+		attrs = append(attrs, slog.String("timestamp", m.Timestamp.UTC().Format(time.RFC3339)))
+	}
+	if timeliness.kind != "" {
+		attrs = append(attrs, slog.String("timeliness", timeliness.kind))
+	}
+	slog.LogAttrs(ctx, level, message, attrs...)
 }
 
-// Save or update the latest metric in the cache
-func updateMetricCache(m Metrics) {
-	cacheMu.Lock()
-	defer cacheMu.Unlock()
-	globalMetricCache[m.DeviceID] = m
+// Save or update the latest metric in the cache; a cache that can't be reached misses
+// the sample, which is still logged
+func updateMetricCache(ctx context.Context, m Metrics) {
+	if err := globalMetricCache.put(ctx, m); err != nil {
+		slog.WarnContext(ctx, "Failed to cache metrics", slog.String("device_id", m.DeviceID), slog.Any("error", err))
+	}
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Instruments of the ingestion layer itself, created by initIngestMetrics
+var (
+	ingestRequests  metric.Int64Counter     // requests by endpoint and status
+	ingestDuration  metric.Float64Histogram // handling time by endpoint and status
+	ingestBatchSize metric.Int64Histogram   // decoded entries per request by endpoint
+	deviceLogEvents metric.Int64Counter     // log events by device and severity
+)
+
+// ingestDurationBuckets cover the handling of a request, from a cached auth check to a large batch
+var ingestDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// ingestBatchSizeBuckets cover the batches of the devices, from a single sample up to a full log cache
+var ingestBatchSizeBuckets = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 5000}
+
+// initIngestMetrics creates the instruments measuring the requests of the devices
+func initIngestMetrics(meter metric.Meter) {
+	var err error
+	ingestRequests, err = meter.Int64Counter("custom.googleapis.com/ingest_requests",
+		metric.WithDescription("Richieste dei dispositivi, per endpoint e stato HTTP"))
+	if err != nil {
+		log.Fatalf("failed to create ingest_requests counter: %v", err)
+	}
+
+	ingestDuration, err = meter.Float64Histogram("custom.googleapis.com/ingest_duration",
+		metric.WithDescription("Tempo di gestione delle richieste dei dispositivi"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(ingestDurationBuckets...))
+	if err != nil {
+		log.Fatalf("failed to create ingest_duration histogram: %v", err)
+	}
+
+	ingestBatchSize, err = meter.Int64Histogram("custom.googleapis.com/ingest_batch_size",
+		metric.WithDescription("Campioni o eventi di log decodificati per richiesta"),
+		metric.WithExplicitBucketBoundaries(ingestBatchSizeBuckets...))
+	if err != nil {
+		log.Fatalf("failed to create ingest_batch_size histogram: %v", err)
+	}
+
+	deviceLogEvents, err = meter.Int64Counter("custom.googleapis.com/device_log_events",
+		metric.WithDescription("Eventi di log ricevuti, per dispositivo e severità"))
+	if err != nil {
+		log.Fatalf("failed to create device_log_events counter: %v", err)
+	}
+}
+
+// statusRecorder keeps the status code a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader keeps the first status written
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write answers 200 when the handler did not write a status
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// measureIngest counts the requests of a route and times their handling by status,
+// including the ones rejected before decoding
+func measureIngest(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if ingestRequests == nil {
+			return
+		}
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		attrs := metric.WithAttributes(attribute.String("endpoint", route), attribute.String("status", strconv.Itoa(rec.status)))
+		ingestRequests.Add(r.Context(), 1, attrs)
+		ingestDuration.Record(r.Context(), time.Since(start).Seconds(), attrs)
+	})
+}
+
+// recordBatchSize records how many samples or log events a request of route carried
+func recordBatchSize(ctx context.Context, route string, size int) {
+	if ingestBatchSize != nil {
+		ingestBatchSize.Record(ctx, int64(size), metric.WithAttributes(attribute.String("endpoint", route)))
+	}
+}
+
+// recordLogEvents counts the log events of a device by severity, and tenant if the request has one
+func recordLogEvents(ctx context.Context, deviceID string, bySeverity map[string]int) {
+	if deviceLogEvents == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("device_id", deviceID)}
+	if tenant := tenantFromContext(ctx); tenant != "" {
+		attrs = append(attrs, attribute.String("tenant", tenant))
+	}
+	for severity, n := range bySeverity {
+		deviceLogEvents.Add(ctx, int64(n), metric.WithAttributes(append(attrs, attribute.String("severity", severity))...))
+	}
+}
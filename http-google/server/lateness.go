@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Defaults of the age of the samples: older than defaultLateAfter a sample is logged as
+// late, older than defaultMaxSampleAge it no longer updates the gauges
+const (
+	defaultLateAfter    = time.Minute
+	defaultMaxSampleAge = 10 * time.Minute
+)
+
+// aheadTolerance is how far ahead of the server clock a sample is stamped before it is
+// logged as ahead of time; validation rejects it past maxClockSkew
+const aheadTolerance = 30 * time.Second
+
+// ingestLagBuckets cover the delay of a sample, from a live send to a day-old replay
+var ingestLagBuckets = []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600, 21600, 86400}
+
+// Instruments of the delay of the samples, created by initLatenessMetrics
+var (
+	ingestLag       metric.Float64Histogram // receive time minus device timestamp
+	untimelySamples metric.Int64Counter     // late or ahead of time samples by device
+)
+
+// sampleAges are the thresholds of the age of the samples, read at startup
+var sampleAges = loadSampleAges()
+
+// sampleAgeConfig is when a sample is late and when it is too old for the gauges
+type sampleAgeConfig struct {
+	lateAfter time.Duration
+	maxAge    time.Duration
+}
+
+// loadSampleAges reads METRIC_LATE_AFTER and METRIC_MAX_AGE, Go durations
+func loadSampleAges() sampleAgeConfig {
+	return sampleAgeConfig{
+		lateAfter: durationEnv("METRIC_LATE_AFTER", defaultLateAfter),
+		maxAge:    durationEnv("METRIC_MAX_AGE", defaultMaxSampleAge),
+	}
+}
+
+// durationEnv reads a positive duration from the environment variable name, def if unset or invalid
+func durationEnv(name string, def time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid "+name+", using the default", slog.String("value", raw), slog.Duration("default", def))
+		return def
+	}
+	return d
+}
+
+// initLatenessMetrics creates the instruments measuring the delay of the samples
+func initLatenessMetrics(meter metric.Meter) {
+	var err error
+	ingestLag, err = meter.Float64Histogram("custom.googleapis.com/ingest_lag",
+		metric.WithDescription("Ritardo tra il timestamp del dispositivo e la ricezione del campione"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(ingestLagBuckets...))
+	if err != nil {
+		log.Fatalf("failed to create ingest_lag histogram: %v", err)
+	}
+
+	untimelySamples, err = meter.Int64Counter("custom.googleapis.com/untimely_samples",
+		metric.WithDescription("Campioni in ritardo o in anticipo, per dispositivo e tipo"))
+	if err != nil {
+		log.Fatalf("failed to create untimely_samples counter: %v", err)
+	}
+}
+
+// sampleTimeliness is how the timestamp of a sample compares with its arrival
+type sampleTimeliness struct {
+	lag   time.Duration // receive time minus device timestamp, negative when ahead
+	kind  string        // "late" or "ahead", empty when on time or not stamped
+	stale bool          // too old to update the gauges
+}
+
+// checkTimeliness records the lag of a sample received at now and logs it when the sample
+// is late or ahead of time; samples without a timestamp are on time
+func checkTimeliness(ctx context.Context, m Metrics, now time.Time) sampleTimeliness {
+	if m.Timestamp.IsZero() {
+		return sampleTimeliness{}
+	}
+	t := sampleTimeliness{lag: now.Sub(m.Timestamp)}
+	switch {
+	case t.lag > sampleAges.lateAfter:
+		t.kind, t.stale = "late", t.lag > sampleAges.maxAge
+	case t.lag < -aheadTolerance:
+		t.kind = "ahead"
+	}
+
+	attrs := []attribute.KeyValue{}
+	if m.Tenant != "" {
+		attrs = append(attrs, attribute.String("tenant", m.Tenant))
+	}
+	if ingestLag != nil {
+		ingestLag.Record(ctx, max(t.lag, 0).Seconds(), metric.WithAttributes(attrs...))
+	}
+	if t.kind == "" {
+		return t
+	}
+	if untimelySamples != nil {
+		untimelySamples.Add(ctx, 1, metric.WithAttributes(append(attrs,
+			attribute.String("device_id", m.DeviceID), attribute.String("kind", t.kind))...))
+	}
+	slog.WarnContext(ctx, "Sample "+t.kind+" of the server clock",
+		slog.String("device_id", m.DeviceID),
+		slog.String("timestamp", m.Timestamp.Format(time.RFC3339)),
+		slog.Duration("lag", t.lag),
+		slog.Bool("stale", t.stale))
+	return t
+}
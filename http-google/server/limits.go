@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// defaultMaxBodyBytes bounds a request body as sent, before decompressing it, unless
+// MAX_BODY_BYTES sets another limit
+const defaultMaxBodyBytes = 1 << 20
+
+// supportedContentTypes are the encodings the server decodes
+var supportedContentTypes = map[string]bool{
+	contentTypeCBOR:     true,
+	contentTypeJSON:     true,
+	contentTypeProtobuf: true,
+}
+
+// cborDecMode bounds the arrays, maps and nesting of the CBOR payloads, so a small body
+// declaring huge or deep items can't exhaust memory in the decoder
+var cborDecMode = func() cbor.DecMode {
+	mode, err := cbor.DecOptions{
+		MaxNestedLevels:  16,
+		MaxArrayElements: 65536,
+		MaxMapPairs:      1024,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// loadMaxBodyBytes reads the body size limit from MAX_BODY_BYTES
+func loadMaxBodyBytes() int64 {
+	raw := os.Getenv("MAX_BODY_BYTES")
+	if raw == "" {
+		return defaultMaxBodyBytes
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		slog.Warn("Invalid MAX_BODY_BYTES, using the default",
+			slog.String("value", raw), slog.Int64("default", defaultMaxBodyBytes))
+		return defaultMaxBodyBytes
+	}
+	return n
+}
+
+// limitPayload answers 415 to bodies in an encoding the server does not decode and 413
+// to bodies over maxBytes, before anything reads them
+func limitPayload(maxBytes int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := payloadType(r); !supportedContentTypes[ct] {
+			writeProblem(w, r, http.StatusUnsupportedMediaType, codeUnsupportedType, fmt.Sprintf("unsupported Content-Type %q, send %s, %s or %s",
+				r.Header.Get("Content-Type"), contentTypeCBOR, contentTypeProtobuf, contentTypeJSON))
+			return
+		}
+		if r.ContentLength > maxBytes {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("payload of %d bytes exceeds the limit of %d bytes", r.ContentLength, maxBytes))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge describes a payload cut off by a MaxBytesReader
+func bodyTooLarge(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("payload exceeds the limit of %d bytes: %w", tooLarge.Limit, err)
+	}
+	return err
+}
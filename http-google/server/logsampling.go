@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"devicetransport"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// logVolumeWindow is the window the volume limit of the low severity events counts over
+const logVolumeWindow = time.Minute
+
+// Reasons a device log event is not emitted
+const (
+	dropSampled = "sampled"
+	dropVolume  = "volume"
+)
+
+// droppedLogEvents counts the device log events received but not emitted
+var droppedLogEvents metric.Int64Counter
+
+// logSampler keeps the emitted device logs within budget: each severity is kept with its
+// rate, and the events below WARNING of a device past the volume limit of the minute are dropped
+type logSampler struct {
+	rates       map[string]float64 // fraction kept by severity, all when not listed
+	volumeLimit int                // low severity events per device per minute, 0 for no limit
+
+	mu      sync.Mutex
+	volumes map[string]*logVolume // by tenant and device
+}
+
+// logVolume counts the low severity events of a device in the current window
+type logVolume struct {
+	start time.Time
+	count int
+}
+
+// deviceLogSampler is the sampling of the device logs, read at startup
+var deviceLogSampler = &logSampler{volumes: make(map[string]*logVolume)}
+
+// loadLogSampler reads LOG_SAMPLING, comma separated severity=rate entries with the rate
+// between 0 and 1, and LOG_VOLUME_LIMIT, the events below WARNING a device may log per minute
+func loadLogSampler() *logSampler {
+	s := &logSampler{rates: make(map[string]float64), volumes: make(map[string]*logVolume)}
+	for _, entry := range strings.Split(os.Getenv("LOG_SAMPLING"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		severity, raw, _ := strings.Cut(entry, "=")
+		severity = strings.ToUpper(strings.TrimSpace(severity))
+		rate, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil || rate < 0 || rate > 1 || severityRank(severity) < 0 {
+			slog.Warn("Invalid LOG_SAMPLING entry, skipping it", slog.String("entry", entry))
+			continue
+		}
+		s.rates[severity] = rate
+	}
+	if raw := os.Getenv("LOG_VOLUME_LIMIT"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			slog.Warn("Invalid LOG_VOLUME_LIMIT, logging every event", slog.String("value", raw))
+		} else {
+			s.volumeLimit = n
+		}
+	}
+	return s
+}
+
+// severityRank returns the position of a severity among devicetransport.Severities, -1 if unknown
+func severityRank(severity string) int {
+	return slices.Index(devicetransport.Severities, severity)
+}
+
+// initLogSamplingMetrics creates the counter of the device log events not emitted
+func initLogSamplingMetrics(meter metric.Meter) {
+	var err error
+	droppedLogEvents, err = meter.Int64Counter("custom.googleapis.com/device_log_events_dropped",
+		metric.WithDescription("Eventi di log ricevuti ma non registrati, per dispositivo, severità e motivo"))
+	if err != nil {
+		log.Fatalf("failed to create device_log_events_dropped counter: %v", err)
+	}
+}
+
+// keep reports whether an event of a device is emitted, and why not when it is dropped
+func (s *logSampler) keep(tenant, deviceID, severity string, now time.Time) (bool, string) {
+	if rate, ok := s.rates[severity]; ok && rand.Float64() >= rate {
+		return false, dropSampled
+	}
+	if s.volumeLimit == 0 || severityRank(severity) >= severityRank("WARNING") {
+		return true, ""
+	}
+
+	key := tenantDeviceKey(tenant, deviceID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.volumes[key]
+	if !ok || now.Sub(v.start) >= logVolumeWindow {
+		v = &logVolume{start: now}
+		s.volumes[key] = v
+	}
+	if v.count >= s.volumeLimit {
+		return false, dropVolume
+	}
+	v.count++
+	return true, ""
+}
+
+// run forgets the volume of the devices that stopped logging, every window until ctx is done
+func (s *logSampler) run(ctx context.Context) {
+	if s.volumeLimit == 0 {
+		return
+	}
+	ticker := time.NewTicker(logVolumeWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, v := range s.volumes {
+				if now.Sub(v.start) >= logVolumeWindow {
+					delete(s.volumes, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// droppedKey is the severity of the dropped events and the reason they were dropped
+type droppedKey struct {
+	severity, reason string
+}
+
+// recordDroppedLogEvents counts the events of a device not emitted, by severity and reason
+func recordDroppedLogEvents(ctx context.Context, deviceID string, dropped map[droppedKey]int) {
+	if droppedLogEvents == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("device_id", deviceID)}
+	if tenant := tenantFromContext(ctx); tenant != "" {
+		attrs = append(attrs, attribute.String("tenant", tenant))
+	}
+	for key, n := range dropped {
+		droppedLogEvents.Add(ctx, int64(n), metric.WithAttributes(append(attrs,
+			attribute.String("severity", key.severity), attribute.String("reason", key.reason))...))
+	}
+}
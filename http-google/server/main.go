@@ -6,6 +6,8 @@ import (
 	"log"
 	"log/slog"
 	"os"
+
+	"devicetransport"
 )
 
 func main() {
@@ -30,12 +32,57 @@ func main() {
 
 	// Initialize metrics instruments (e.g., counters, gauges) with the Meter
 	initMetrics(meter)
+	initValidationMetrics(meter)
+	initIngestMetrics(meter)
+	initQuarantine(meter)
+	initDedupMetrics(meter)
+	initLogSamplingMetrics(meter)
+	initLatenessMetrics(meter)
+	metricValidation = validationMode()
+
+	// Load the device inventory persisted by the previous run, if DEVICE_REGISTRY_FILE is set
+	if registry, err = loadDeviceRegistry(os.Getenv("DEVICE_REGISTRY_FILE")); err != nil {
+		log.Fatalf("failed to load device registry: %v", err)
+	}
+	go registry.run(ctx, registryFlushInterval())
+	deviceQuotas = loadTenantQuotas()
+	go logBatchDedup.run(ctx)
+	deviceLogSampler = loadLogSampler()
+	go deviceLogSampler.run(ctx)
+
+	// Decode the log events with the catalog of EVENT_CATALOG_FILE, the built-in one if unset
+	catalog, err := devicetransport.LoadEventCatalog(os.Getenv("EVENT_CATALOG_FILE"))
+	if err != nil {
+		log.Fatalf("failed to load event catalog: %v", err)
+	}
+	eventCatalog.Store(&catalog)
+	slog.InfoContext(ctx, "Event catalog loaded", slog.Int("version", catalog.Version), slog.Int("events", len(catalog.Events)))
+
+	// Load the alert thresholds from THRESHOLDS_FILE, reloaded when the file changes
+	if path := os.Getenv("THRESHOLDS_FILE"); path != "" {
+		if err := watchThresholds(ctx, path); err != nil {
+			log.Fatalf("failed to load thresholds: %v", err)
+		}
+	}
+
+	// Share the latest samples between the instances in Redis if METRIC_CACHE_REDIS_URL is set
+	if globalMetricCache, err = loadMetricCache(ctx); err != nil {
+		log.Fatalf("failed to set up the metric cache: %v", err)
+	}
 
 	// Register all gauge observers that read data from the globalMetricCache
 	// Observers periodically collect metric values for reporting
 	if err := registerObservers(meter); err != nil {
 		log.Fatalf("failed to register observers: %v", err)
 	}
+	// and the rolling 1-minute and 5-minute aggregates of every device
+	if err := registerRollingObservers(meter); err != nil {
+		log.Fatalf("failed to register rolling observers: %v", err)
+	}
+	// and the aggregates of every region, for map dashboards
+	if err := registerRegionObservers(meter); err != nil {
+		log.Fatalf("failed to register region observers: %v", err)
+	}
 	// Start the HTTP server which will handle incoming requests
 	startHTTPServer(ctx)
 }
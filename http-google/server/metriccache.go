@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultRedisCacheKey is the Redis hash holding the latest sample of every device
+const defaultRedisCacheKey = "device_metrics"
+
+// metricCacheTimeout bounds every call to a remote cache, so a slow Redis doesn't hold
+// up the requests of the devices or the collection of the gauges
+const metricCacheTimeout = 2 * time.Second
+
+// metricCache keeps the latest sample of every device, the source of the device gauges
+type metricCache interface {
+	// put stores m as the latest sample of its device
+	put(ctx context.Context, m Metrics) error
+	// snapshot returns the latest sample of every device by tenantDeviceKey
+	snapshot(ctx context.Context) (map[string]Metrics, error)
+	// evict drops the sample of the tenantDeviceKey key, false if there was none
+	evict(ctx context.Context, key string) (bool, error)
+}
+
+// memoryMetricCache is the cache of a single instance
+type memoryMetricCache struct {
+	mu      sync.RWMutex
+	samples map[string]Metrics
+}
+
+func newMemoryMetricCache() *memoryMetricCache {
+	return &memoryMetricCache{samples: make(map[string]Metrics)}
+}
+
+// put stores the sample in memory
+func (c *memoryMetricCache) put(_ context.Context, m Metrics) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples[tenantDeviceKey(m.Tenant, m.DeviceID)] = m
+	return nil
+}
+
+// snapshot returns a copy of the samples
+func (c *memoryMetricCache) snapshot(context.Context) (map[string]Metrics, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	samples := make(map[string]Metrics, len(c.samples))
+	for key, m := range c.samples {
+		samples[key] = m
+	}
+	return samples, nil
+}
+
+// evict drops the sample from memory
+func (c *memoryMetricCache) evict(_ context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.samples[key]
+	delete(c.samples, key)
+	return ok, nil
+}
+
+// redisMetricCache shares the samples between the instances of the server through a Redis
+// hash, so every instance reports the whole fleet whichever received the sample
+type redisMetricCache struct {
+	client *redis.Client
+	key    string
+}
+
+// cachedSample is a sample as stored in Redis, with the fields the payloads never carry
+type cachedSample struct {
+	Metrics    Metrics   `json:"metrics"`
+	Tenant     string    `json:"tenant,omitempty"`
+	TraceID    string    `json:"trace_id,omitempty"`
+	SpanID     string    `json:"span_id,omitempty"`
+	Sampled    bool      `json:"sampled,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// put writes the sample to the hash, replacing the previous one of the device
+func (c *redisMetricCache) put(ctx context.Context, m Metrics) error {
+	s := cachedSample{Metrics: m, Tenant: m.Tenant, ReceivedAt: m.receivedAt}
+	if m.span.IsValid() {
+		s.TraceID, s.SpanID, s.Sampled = m.span.TraceID().String(), m.span.SpanID().String(), m.span.IsSampled()
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, metricCacheTimeout)
+	defer cancel()
+	return c.client.HSet(ctx, c.key, tenantDeviceKey(m.Tenant, m.DeviceID), data).Err()
+}
+
+// snapshot reads every sample of the hash, skipping the ones that fail to decode
+func (c *redisMetricCache) snapshot(ctx context.Context) (map[string]Metrics, error) {
+	ctx, cancel := context.WithTimeout(ctx, metricCacheTimeout)
+	defer cancel()
+	raw, err := c.client.HGetAll(ctx, c.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	samples := make(map[string]Metrics, len(raw))
+	for key, data := range raw {
+		var s cachedSample
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			slog.Warn("Corrupt sample in the metric cache, skipping it", slog.String("key", key), slog.Any("error", err))
+			continue
+		}
+		m := s.Metrics
+		m.Tenant, m.receivedAt = s.Tenant, s.ReceivedAt
+		traceID, _ := trace.TraceIDFromHex(s.TraceID)
+		spanID, _ := trace.SpanIDFromHex(s.SpanID)
+		var flags trace.TraceFlags
+		if s.Sampled {
+			flags = trace.FlagsSampled
+		}
+		m.span = trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: flags, Remote: true})
+		samples[key] = m
+	}
+	return samples, nil
+}
+
+// evict deletes the sample from the hash, for every instance
+func (c *redisMetricCache) evict(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, metricCacheTimeout)
+	defer cancel()
+	n, err := c.client.HDel(ctx, c.key, key).Result()
+	return n > 0, err
+}
+
+// loadMetricCache returns the Redis cache of METRIC_CACHE_REDIS_URL (redis:// or rediss://
+// for TLS, as for Memorystore) in the hash METRIC_CACHE_REDIS_KEY, the in-memory cache if unset
+func loadMetricCache(ctx context.Context) (metricCache, error) {
+	url := os.Getenv("METRIC_CACHE_REDIS_URL")
+	if url == "" {
+		return newMemoryMetricCache(), nil
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid METRIC_CACHE_REDIS_URL: %w", err)
+	}
+	c := &redisMetricCache{client: redis.NewClient(opts), key: os.Getenv("METRIC_CACHE_REDIS_KEY")}
+	if c.key == "" {
+		c.key = defaultRedisCacheKey
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, metricCacheTimeout)
+	defer cancel()
+	if err := c.client.Ping(pingCtx).Err(); err != nil {
+		return nil, fmt.Errorf("redis metric cache at %s unreachable: %w", opts.Addr, err)
+	}
+	slog.InfoContext(ctx, "Metric cache shared in Redis", slog.String("addr", opts.Addr), slog.String("key", c.key))
+	return c, nil
+}
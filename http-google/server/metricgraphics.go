@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
 	"log"
 	"time"
 )
@@ -21,6 +23,8 @@ type ExternalSensors struct {
 	BarometerHPa  float64 `cbor:"barometer_hpa" json:"barometer_hpa"`     // Atmospheric pressure in hPa
 	HygrometerRH  float64 `cbor:"hygrometer_rh" json:"hygrometer_rh"`     // Relative humidity percentage
 	AnemometerMPS float64 `cbor:"anemometer_mps" json:"anemometer_mps"`   // Wind speed in m/s
+	PM25UGM3      float64 `cbor:"pm25_ugm3" json:"pm25_ugm3"`             // Fine particulate matter in µg/m³
+	CO2PPM        float64 `cbor:"co2_ppm" json:"co2_ppm"`                 // Carbon dioxide in ppm
 }
 
 // Metrics represents the telemetry data collected from a device
@@ -30,7 +34,23 @@ type Metrics struct {
 	Timestamp        time.Time       `cbor:"timestamp" json:"timestamp"`
 	MCUUsagePercent  float64         `cbor:"mcu_usage_percent" json:"mcu_usage_percent"`
 	MCUTempC         float64         `cbor:"mcu_temp_c" json:"mcu_temp_c"`
+	BatteryPercent   float64         `cbor:"battery_percent" json:"battery_percent"`
+	RSSIDBm          float64         `cbor:"rssi_dbm" json:"rssi_dbm"` // Radio signal strength in dBm
 	ExternalSensors  ExternalSensors `cbor:"external_sensors" json:"external_sensors"`
+	FirmwareVersion  string          `cbor:"firmware_version" json:"firmware_version"` // Empty for devices without firmware info
+	SchemaVersion    uint32          `cbor:"schema_version" json:"schema_version"`     // Version of the payload schema, 0 for devices older than it
+
+	// Fleet, region and labels of the device, empty when it belongs to no fleet
+	Fleet  string            `cbor:"fleet" json:"fleet"`
+	Region string            `cbor:"region" json:"region"`
+	Labels map[string]string `cbor:"labels" json:"labels"`
+
+	// Tenant of the request that carried the sample, set by the server and never decoded
+	Tenant string `cbor:"-" json:"-"`
+
+	// Span that recorded the sample and when, for the exemplars of the gauges
+	span       trace.SpanContext
+	receivedAt time.Time
 }
 
 var (
@@ -41,6 +61,10 @@ var (
 	BarometerHPaGauge metric.Float64ObservableGauge
 	HygrometerRHGauge  metric.Float64ObservableGauge
 	AnemometerMPSGauge metric.Float64ObservableGauge
+	BatteryPercentGauge metric.Float64ObservableGauge
+	RSSIDBmGauge        metric.Float64ObservableGauge
+	PM25UGM3Gauge       metric.Float64ObservableGauge
+	CO2PPMGauge         metric.Float64ObservableGauge
 )
 
 // initMetrics initializes all the metric instruments (gauges) that will be used
@@ -89,6 +113,59 @@ func initMetrics(meter metric.Meter) {
 	if err != nil {
 		log.Fatalf("failed to create anemometer_mps gauge: %v", err)
 	}
+
+	// Create a gauge for battery level percentage
+	BatteryPercentGauge, err = meter.Float64ObservableGauge("custom.googleapis.com/battery_percent",
+		metric.WithDescription("Livello della batteria (%)"))
+	if err != nil {
+		log.Fatalf("failed to create battery_percent gauge: %v", err)
+	}
+
+	// Create a gauge for radio signal strength in dBm
+	RSSIDBmGauge, err = meter.Float64ObservableGauge("custom.googleapis.com/rssi_dbm",
+		metric.WithDescription("Potenza del segnale radio (dBm)"))
+	if err != nil {
+		log.Fatalf("failed to create rssi_dbm gauge: %v", err)
+	}
+
+	// Create a gauge for PM2.5 concentration in µg/m³
+	PM25UGM3Gauge, err = meter.Float64ObservableGauge("custom.googleapis.com/pm25_ugm3",
+		metric.WithDescription("Particolato PM2.5 (µg/m³)"))
+	if err != nil {
+		log.Fatalf("failed to create pm25_ugm3 gauge: %v", err)
+	}
+
+	// Create a gauge for CO2 concentration in ppm
+	CO2PPMGauge, err = meter.Float64ObservableGauge("custom.googleapis.com/co2_ppm",
+		metric.WithDescription("Anidride carbonica (ppm)"))
+	if err != nil {
+		log.Fatalf("failed to create co2_ppm gauge: %v", err)
+	}
+}
+
+// reservedLabels are the attributes of every gauge, a fleet label can't replace them
+var reservedLabels = map[string]bool{
+	"device_id": true, "latitude": true, "longitude": true, "altitude": true,
+	"firmware_version": true, "fleet": true, "region": true, "tenant": true,
+}
+
+// fleetAttributes returns the tenant, fleet, region and labels of a device as attributes,
+// none for a device outside any tenant and fleet
+func fleetAttributes(m Metrics) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if m.Tenant != "" {
+		attrs = append(attrs, attribute.String("tenant", m.Tenant))
+	}
+	if m.Fleet == "" {
+		return attrs
+	}
+	attrs = append(attrs, attribute.String("fleet", m.Fleet), attribute.String("region", m.Region))
+	for k, v := range m.Labels {
+		if !reservedLabels[k] {
+			attrs = append(attrs, attribute.String(k, v))
+		}
+	}
+	return attrs
 }
 
 // registerObservers registers a callback function that OpenTelemetry calls periodically
@@ -96,25 +173,32 @@ func initMetrics(meter metric.Meter) {
 func registerObservers(meter metric.Meter) error {
 	_, err := meter.RegisterCallback(
 		func(ctx context.Context, observer metric.Observer) error {
-			// Lock the cache for safe concurrent access
-			cacheMu.RLock()
-			defer cacheMu.RUnlock()
+			// Read the latest sample of every device, shared by the instances when in Redis
+			samples, err := globalMetricCache.snapshot(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to read the metric cache: %w", err)
+			}
 
 			// Iterate over all cached metrics and observe each gauge value with the device ID label
-			for _, m := range globalMetricCache {
+			for _, m := range samples {
 
-				labels := metric.WithAttributes(
+				labels := metric.WithAttributes(append([]attribute.KeyValue{
 					attribute.String("device_id", m.DeviceID),
 					attribute.Float64("latitude", m.GeoPosition.Latitude),
                     attribute.Float64("longitude", m.GeoPosition.Longitude),
                     attribute.Float64("altitude", m.GeoPosition.Altitude),
-					)
+					attribute.String("firmware_version", m.FirmwareVersion),
+					}, fleetAttributes(m)...)...)
 				observer.ObserveFloat64(MCUUsageGauge, m.MCUUsagePercent, labels)
 				observer.ObserveFloat64(MCUTempCGauge, m.MCUTempC, labels)
 				observer.ObserveFloat64(ThermometerCGauge, m.ExternalSensors.ThermometerC, labels)
 				observer.ObserveFloat64(BarometerHPaGauge, m.ExternalSensors.BarometerHPa, labels)
 				observer.ObserveFloat64(HygrometerRHGauge, m.ExternalSensors.HygrometerRH, labels)
 				observer.ObserveFloat64(AnemometerMPSGauge, m.ExternalSensors.AnemometerMPS, labels)
+				observer.ObserveFloat64(BatteryPercentGauge, m.BatteryPercent, labels)
+				observer.ObserveFloat64(RSSIDBmGauge, m.RSSIDBm, labels)
+				observer.ObserveFloat64(PM25UGM3Gauge, m.ExternalSensors.PM25UGM3, labels)
+				observer.ObserveFloat64(CO2PPMGauge, m.ExternalSensors.CO2PPM, labels)
 
 				// Uncomment for debug logging localy:
 				// log.Printf("Observed metrics for device %s: CPU %.2f%%, Temp %.2f°C", m.DeviceID, m.CPUPercent, m.TempC)
@@ -123,6 +207,7 @@ func registerObservers(meter metric.Meter) error {
 		},
 		// List all instruments to be observed in this callback
 		MCUUsageGauge, MCUTempCGauge, ThermometerCGauge, BarometerHPaGauge, HygrometerRHGauge, AnemometerMPSGauge,
+		BatteryPercentGauge, RSSIDBmGauge, PM25UGM3Gauge, CO2PPMGauge,
 	)
 	return err
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"devicetransport"
+)
+
+// openAPIVersion is the OpenAPI release the document follows
+const openAPIVersion = "3.1.0"
+
+// schemaBuilder derives the JSON schemas of the payloads from their Go types, so the document
+// can't drift from what the handlers decode; CBOR uses the same field names as JSON
+type schemaBuilder struct {
+	components map[string]any
+}
+
+// ref returns a reference to the schema of the struct type t, building it on first use.
+// path is where the struct sits in a Metrics sample, to attach the bounds of the validation.
+func (b *schemaBuilder) ref(t reflect.Type, path string) map[string]any {
+	name := componentName(t)
+	if _, ok := b.components[name]; !ok {
+		b.components[name] = nil // Placeholder against recursive types
+		b.components[name] = b.object(t, path)
+	}
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// componentName is the exported form of the name of a type, problem becoming Problem
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// object returns the schema of the fields of a struct with a json tag; fields without
+// omitempty are required
+func (b *schemaBuilder) object(t reflect.Type, path string) map[string]any {
+	properties := make(map[string]any)
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "" || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		s := b.schema(f.Type, path+name+".")
+		if bound, ok := boundOf(path + name); ok {
+			s["minimum"], s["maximum"] = bound.min, bound.max
+		}
+		properties[name] = s
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{"type": "object", "properties": properties, "required": required}
+}
+
+// schema returns the schema of a value of type t
+func (b *schemaBuilder) schema(t reflect.Type, path string) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		return b.ref(t, path)
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": b.schema(t.Elem(), path)}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": b.schema(t.Elem(), path)}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	default:
+		return map[string]any{"type": "integer"}
+	}
+}
+
+// boundOf returns the validation range of the reading at path, like "geo_position.latitude"
+func boundOf(path string) (metricBound, bool) {
+	for _, b := range metricBounds {
+		if b.field == path {
+			return b, true
+		}
+	}
+	return metricBound{}, false
+}
+
+// content is a request or response body of schema in each of the media types
+func content(schema map[string]any, mediaTypes ...string) map[string]any {
+	c := make(map[string]any, len(mediaTypes))
+	for _, mt := range mediaTypes {
+		if mt == contentTypeProtobuf {
+			c[mt] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary",
+				"description": "Message of devicetransport/telemetrypb/telemetry.proto"}}
+			continue
+		}
+		c[mt] = map[string]any{"schema": schema}
+	}
+	return c
+}
+
+// response describes a response, with a JSON body when schema is not nil
+func response(description string, schema map[string]any, mediaTypes ...string) map[string]any {
+	r := map[string]any{"description": description}
+	if schema != nil {
+		r["content"] = content(schema, mediaTypes...)
+	}
+	return r
+}
+
+// buildOpenAPI describes the routes of the server and the schemas of their payloads
+func buildOpenAPI() map[string]any {
+	b := &schemaBuilder{components: make(map[string]any)}
+	metrics := b.ref(reflect.TypeOf(Metrics{}), "")
+	logBatch := b.ref(reflect.TypeOf(IncomingLogBatch{}), "")
+	commandsBody := b.ref(reflect.TypeOf(commandsResponse{}), "")
+	batchCommandsBody := b.ref(reflect.TypeOf(batchCommandsResponse{}), "")
+	command := b.ref(reflect.TypeOf(deviceCommand{}), "")
+	record := b.ref(reflect.TypeOf(deviceRecord{}), "")
+	catalog := b.ref(reflect.TypeOf(devicetransport.EventCatalog{}), "")
+	problemBody := b.ref(reflect.TypeOf(problem{}), "")
+
+	problemResponse := func(description string) map[string]any {
+		return response(description, problemBody, problemContentType)
+	}
+	deviceErrors := map[string]any{
+		"400": problemResponse("Payload that fails to decode or samples out of range"),
+		"401": problemResponse("Missing or invalid credentials"),
+		"403": problemResponse("Token of another device or tenant, or device quota exceeded"),
+		"413": problemResponse("Payload larger than MAX_BODY_BYTES"),
+		"415": problemResponse("Unsupported Content-Type or Content-Encoding"),
+	}
+	withErrors := func(responses map[string]any, errors map[string]any) map[string]any {
+		for status, r := range errors {
+			responses[status] = r
+		}
+		return responses
+	}
+	payloadTypes := []string{contentTypeCBOR, contentTypeJSON, contentTypeProtobuf}
+	deviceID := map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"}}
+
+	paths := map[string]any{
+		"/batchMetric": map[string]any{"post": map[string]any{
+			"summary":     "Send a sample of a device",
+			"requestBody": map[string]any{"required": true, "content": content(metrics, payloadTypes...)},
+			"responses": withErrors(map[string]any{
+				"202": response("Sample accepted, with the commands queued for the device if any",
+					commandsBody, contentTypeCBOR, contentTypeJSON),
+			}, deviceErrors),
+		}},
+		"/batchMetrics": map[string]any{"post": map[string]any{
+			"summary": "Send the samples of one or more devices",
+			"requestBody": map[string]any{"required": true, "content": content(
+				map[string]any{"type": "array", "items": metrics}, payloadTypes...)},
+			"responses": withErrors(map[string]any{
+				"202": response("Valid samples accepted, the batch is rejected only when none is valid, with the commands queued for its devices if any",
+					batchCommandsBody, contentTypeCBOR, contentTypeJSON),
+			}, deviceErrors),
+		}},
+		"/batchLog": map[string]any{"post": map[string]any{
+			"summary":     "Send a batch of log events of a device, as [event_id, unix_timestamp] pairs",
+			"requestBody": map[string]any{"required": true, "content": content(logBatch, payloadTypes...)},
+			"responses": withErrors(map[string]any{
+				"200": response("Batch logged or already logged, with the commands queued for the device if any",
+					commandsBody, contentTypeCBOR, contentTypeJSON),
+			}, deviceErrors),
+		}},
+		"/devices": map[string]any{"get": map[string]any{
+			"summary": "List the devices of the tenant",
+			"parameters": []any{
+				map[string]any{"name": "fleet", "in": "query", "schema": map[string]any{"type": "string"}},
+				map[string]any{"name": "region", "in": "query", "schema": map[string]any{"type": "string"}},
+				map[string]any{"name": "seen_since", "in": "query", "description": "Go duration, like 15m",
+					"schema": map[string]any{"type": "string"}},
+			},
+			"responses": map[string]any{
+				"200": response("Registered devices", map[string]any{"type": "object", "properties": map[string]any{
+					"count":   map[string]any{"type": "integer"},
+					"devices": map[string]any{"type": "array", "items": record},
+				}}, contentTypeJSON),
+				"400": problemResponse("Invalid query"),
+				"403": problemResponse("Device token"),
+			},
+		}},
+		"/devices/{id}": map[string]any{"get": map[string]any{
+			"summary":    "Get a device of the tenant",
+			"parameters": []any{deviceID},
+			"responses": map[string]any{
+				"200": response("Device record", record, contentTypeJSON),
+				"403": problemResponse("Device token"),
+				"404": problemResponse("Device never seen"),
+			},
+		}},
+		"/devices/{id}/commands": map[string]any{"get": map[string]any{
+			"summary":    "List the commands queued for a device",
+			"parameters": []any{deviceID},
+			"responses": map[string]any{
+				"200": response("Queued commands", map[string]any{"type": "object", "properties": map[string]any{
+					"count":    map[string]any{"type": "integer"},
+					"commands": map[string]any{"type": "array", "items": command},
+				}}, contentTypeJSON),
+				"403": problemResponse("Device token"),
+			},
+		}},
+		"/events/catalog": map[string]any{"get": map[string]any{
+			"summary": "Get the catalog of the log event IDs, tagged with its version",
+			"responses": map[string]any{
+				"200": response("Event catalog", catalog, contentTypeJSON),
+				"304": response("Catalog unchanged since If-None-Match", nil),
+			},
+		}},
+		"/openapi.json": map[string]any{"get": map[string]any{
+			"summary":  "Get this document",
+			"security": []any{},
+			"responses": map[string]any{
+				"200": response("OpenAPI document", map[string]any{"type": "object"}, contentTypeJSON),
+			},
+		}},
+	}
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title":       "Observability-Monitoring HTTP server",
+			"description": "Ingestion of the metrics and logs of the devices. Request bodies may be compressed with Content-Encoding gzip or zstd.",
+			"version":     serviceVersion(),
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.components,
+			"securitySchemes": map[string]any{
+				"apiKey":      map[string]any{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+				"bearerToken": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+		"security": []any{map[string]any{"apiKey": []any{}}, map[string]any{"bearerToken": []any{}}},
+	}
+}
+
+// openAPIDocument is the encoded document, built on the first request
+var openAPIDocument = sync.OnceValues(func() ([]byte, error) {
+	return json.MarshalIndent(buildOpenAPI(), "", "  ")
+})
+
+// handleOpenAPI serves the OpenAPI document of the server, without credentials
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	doc, err := openAPIDocument()
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, codeInternal, "failed to encode the OpenAPI document")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(doc)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+)
+
+// defaultOTLPEndpoint is the collector on Cloud Run the server exports to without configuration
+const defaultOTLPEndpoint = "https://otel-collector-1094805005874.europe-west1.run.app"
+
+// defaultMetricExportInterval is how often the metrics are exported without OTEL_METRIC_EXPORT_INTERVAL
+const defaultMetricExportInterval = time.Minute
+
+// otlpConfig is where and how the traces and metrics are exported. It is read from the
+// OTLP_CONFIG_FILE, then the standard OTEL_EXPORTER_OTLP_* variables override it.
+type otlpConfig struct {
+	Endpoint        string            `json:"endpoint"`         // Base URL, /v1/traces and /v1/metrics are appended
+	TracesEndpoint  string            `json:"traces_endpoint"`  // Full URL of the traces, replaces the base one
+	MetricsEndpoint string            `json:"metrics_endpoint"` // Full URL of the metrics, replaces the base one
+	Headers         map[string]string `json:"headers"`          // Sent with every export, like an API key
+	Protocol        string            `json:"protocol"`         // Only http/protobuf is built in
+	Insecure        bool              `json:"insecure"`         // Plain HTTP even for an https endpoint
+
+	// Interval of the metric exports, in nanoseconds like the other durations of the configs
+	MetricExportInterval time.Duration `json:"metric_export_interval"`
+}
+
+// loadOTLPConfig reads the exporter config from OTLP_CONFIG_FILE, if set, and the environment
+func loadOTLPConfig() (otlpConfig, error) {
+	var c otlpConfig
+	if path := os.Getenv("OTLP_CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to read OTLP config: %w", err)
+		}
+		if err := json.Unmarshal(data, &c); err != nil {
+			return c, fmt.Errorf("invalid OTLP config %s: %w", path, err)
+		}
+	}
+
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); v != "" {
+		c.Endpoint = v
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); v != "" {
+		c.TracesEndpoint = v
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"); v != "" {
+		c.MetricsEndpoint = v
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); v != "" {
+		c.Protocol = v
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_INSECURE"); v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_INSECURE %q: %w", v, err)
+		}
+		c.Insecure = insecure
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"); v != "" {
+		headers, err := parseOTLPHeaders(v)
+		if err != nil {
+			return c, err
+		}
+		if c.Headers == nil {
+			c.Headers = make(map[string]string)
+		}
+		for k, v := range headers {
+			c.Headers[k] = v
+		}
+	}
+	if v := os.Getenv("OTEL_METRIC_EXPORT_INTERVAL"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			return c, fmt.Errorf("invalid OTEL_METRIC_EXPORT_INTERVAL %q, must be milliseconds", v)
+		}
+		c.MetricExportInterval = time.Duration(ms) * time.Millisecond
+	}
+
+	if c.Endpoint == "" {
+		c.Endpoint = defaultOTLPEndpoint
+	}
+	if c.MetricExportInterval <= 0 {
+		c.MetricExportInterval = defaultMetricExportInterval
+	}
+	if c.Protocol != "" && c.Protocol != "http/protobuf" {
+		return c, fmt.Errorf("unsupported OTLP protocol %q, only http/protobuf is built in", c.Protocol)
+	}
+	return c, nil
+}
+
+// parseOTLPHeaders parses OTEL_EXPORTER_OTLP_HEADERS, comma separated key=value pairs
+// with URL-encoded values
+func parseOTLPHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q, must be key=value", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS value of %s: %w", k, err)
+		}
+		headers[strings.TrimSpace(k)] = value
+	}
+	return headers, nil
+}
+
+// signalURL returns the URL a signal is exported to: its own endpoint, or the base one
+// with the signal path. A base without scheme is taken as https.
+func (c otlpConfig) signalURL(own, path string) (string, error) {
+	raw := own
+	if raw == "" {
+		raw = strings.TrimSuffix(c.Endpoint, "/") + path
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid OTLP endpoint %q", raw)
+	}
+	return u.String(), nil
+}
+
+// traceOptions returns the options of the trace exporter
+func (c otlpConfig) traceOptions() ([]otlptracehttp.Option, error) {
+	endpoint, err := c.signalURL(c.TracesEndpoint, "/v1/traces")
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+	if len(c.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(c.Headers))
+	}
+	if c.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	return opts, nil
+}
+
+// metricOptions returns the options of the metric exporter
+func (c otlpConfig) metricOptions() ([]otlpmetrichttp.Option, error) {
+	endpoint, err := c.signalURL(c.MetricsEndpoint, "/v1/metrics")
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(endpoint)}
+	if len(c.Headers) > 0 {
+		opts = append(opts, otlpmetrichttp.WithHeaders(c.Headers))
+	}
+	if c.Insecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	return opts, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"google.golang.org/protobuf/proto"
+
+	"devicetransport/telemetrypb"
+)
+
+// Content types the devices can send their payloads with, CBOR when not set
+const (
+	contentTypeCBOR     = "application/cbor"
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// errUnsupportedContentType is returned for payloads in an encoding the server does not decode
+var errUnsupportedContentType = errors.New("unsupported content type")
+
+// payloadType returns the media type of the request body
+func payloadType(r *http.Request) string {
+	header := r.Header.Get("Content-Type")
+	if header == "" {
+		return contentTypeCBOR
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return header
+	}
+	return mediaType
+}
+
+// decodePayload decodes the request body into v for CBOR and JSON, or into msg for protobuf.
+// A body that fails to decode is quarantined.
+func decodePayload(r *http.Request, v interface{}, msg proto.Message) error {
+	ct := payloadType(r)
+	if ct != contentTypeCBOR && ct != contentTypeJSON && ct != contentTypeProtobuf {
+		return fmt.Errorf("%w %q", errUnsupportedContentType, ct)
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return bodyTooLarge(err)
+	}
+
+	switch ct {
+	case contentTypeCBOR:
+		err = cborDecMode.NewDecoder(bytes.NewReader(data)).Decode(v)
+	case contentTypeJSON:
+		err = json.NewDecoder(bytes.NewReader(data)).Decode(v)
+	case contentTypeProtobuf:
+		err = proto.Unmarshal(data, msg)
+	}
+	if err != nil {
+		quarantinePayload(r.Context(), r, data, err)
+	}
+	return err
+}
+
+// decodeMetrics decodes the metrics of a device in any of the supported encodings
+func decodeMetrics(r *http.Request) (Metrics, error) {
+	var m Metrics
+	var pb telemetrypb.Metrics
+	if err := decodePayload(r, &m, &pb); err != nil {
+		return Metrics{}, err
+	}
+	if payloadType(r) == contentTypeProtobuf {
+		m = metricsFromProto(&pb)
+	}
+	return m, nil
+}
+
+// decodeMetricBatch decodes a batch of metrics, of one or more devices, in any of the supported encodings
+func decodeMetricBatch(r *http.Request) ([]Metrics, error) {
+	var batch []Metrics
+	var pb telemetrypb.MetricsBatch
+	if err := decodePayload(r, &batch, &pb); err != nil {
+		return nil, err
+	}
+	if payloadType(r) == contentTypeProtobuf {
+		batch = make([]Metrics, 0, len(pb.GetMetrics()))
+		for _, m := range pb.GetMetrics() {
+			batch = append(batch, metricsFromProto(m))
+		}
+	}
+	return batch, nil
+}
+
+// decodeLogBatch decodes a log batch of a device in any of the supported encodings
+func decodeLogBatch(r *http.Request) (IncomingLogBatch, error) {
+	var batch IncomingLogBatch
+	var pb telemetrypb.IncomingLogBatch
+	if err := decodePayload(r, &batch, &pb); err != nil {
+		return IncomingLogBatch{}, err
+	}
+	if payloadType(r) == contentTypeProtobuf {
+		batch.DeviceID = pb.GetDeviceId()
+		batch.BatchID = pb.GetBatchId()
+		batch.Logs = make([][]int64, 0, len(pb.GetLogs()))
+		batch.Links = make([]SpanLink, 0, len(pb.GetLogs()))
+		for _, e := range pb.GetLogs() {
+			batch.Logs = append(batch.Logs, []int64{int64(e.GetEventId()), e.GetTimestamp()})
+			batch.Links = append(batch.Links, SpanLink{TraceID: e.GetTraceId(), SpanID: e.GetSpanId()})
+		}
+	}
+	return batch, nil
+}
+
+// metricsFromProto converts protobuf metrics to the struct the CBOR payloads decode to
+func metricsFromProto(pb *telemetrypb.Metrics) Metrics {
+	m := Metrics{
+		DeviceID:        pb.GetDeviceId(),
+		MCUUsagePercent: pb.GetMcuUsagePercent(),
+		MCUTempC:        pb.GetMcuTempC(),
+		BatteryPercent:  pb.GetBatteryPercent(),
+		RSSIDBm:         pb.GetRssiDbm(),
+		GeoPosition: GeoPosition{
+			Latitude:  pb.GetGeoPosition().GetLatitude(),
+			Longitude: pb.GetGeoPosition().GetLongitude(),
+			Altitude:  pb.GetGeoPosition().GetAltitude(),
+		},
+		ExternalSensors: ExternalSensors{
+			ThermometerC:  pb.GetExternalSensors().GetThermometerC(),
+			BarometerHPa:  pb.GetExternalSensors().GetBarometerHpa(),
+			HygrometerRH:  pb.GetExternalSensors().GetHygrometerRh(),
+			AnemometerMPS: pb.GetExternalSensors().GetAnemometerMps(),
+			PM25UGM3:      pb.GetExternalSensors().GetPm25Ugm3(),
+			CO2PPM:        pb.GetExternalSensors().GetCo2Ppm(),
+		},
+		FirmwareVersion: pb.GetFirmwareVersion(),
+		SchemaVersion:   pb.GetSchemaVersion(),
+		Fleet:           pb.GetFleet(),
+		Region:          pb.GetRegion(),
+		Labels:          pb.GetLabels(),
+	}
+	if pb.GetTimestamp() != nil {
+		m.Timestamp = pb.GetTimestamp().AsTime()
+	}
+	return m
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// problemContentType is the media type of the error responses, RFC 9457
+const problemContentType = "application/problem+json"
+
+// requestIDHeader carries the ID of a request, chosen by the device or set by the server
+const requestIDHeader = "X-Request-ID"
+
+// Codes of the error responses, stable for the firmware and the test harnesses to act on
+const (
+	codeUnauthorized        = "unauthorized"
+	codeForbiddenDevice     = "forbidden_device"
+	codeForbiddenTenant     = "forbidden_tenant"
+	codeInvalidTenant       = "invalid_tenant"
+	codeDeviceQuotaExceeded = "device_quota_exceeded"
+	codeUnsupportedType     = "unsupported_content_type"
+	codeUnsupportedEncoding = "unsupported_content_encoding"
+	codePayloadTooLarge     = "payload_too_large"
+	codeInvalidPayload      = "invalid_payload"
+	codeInvalidMetrics      = "invalid_metrics"
+	codeInvalidQuery        = "invalid_query"
+	codeNotFound            = "not_found"
+	codeTooManyCommands     = "too_many_commands"
+	codeInternal            = "internal_error"
+)
+
+// problem is the JSON body of every error response: the RFC 9457 members plus a code,
+// the request ID and, for rejected samples, the fields that failed validation
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance"`
+	RequestID string       `json:"request_id,omitempty"`
+	DeviceID  string       `json:"device_id,omitempty"`
+	Fields    []fieldError `json:"fields,omitempty"`
+}
+
+// requestID returns the X-Request-ID of the request, otherwise the ID of its trace
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// writeProblem answers a request with an error status and its problem body
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblemBody(w, r, problem{Status: status, Code: code, Detail: detail})
+}
+
+// writeProblemBody answers a request with p, filling in its standard members
+func writeProblemBody(w http.ResponseWriter, r *http.Request, p problem) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	p.Instance = r.URL.Path
+	p.RequestID = requestID(r)
+
+	if p.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="devices"`)
+	}
+	if p.RequestID != "" {
+		w.Header().Set(requestIDHeader, p.RequestID)
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeDecodeProblem answers a payload that failed to decode: 415 for an unknown encoding,
+// 413 for a payload over the size limit and 400 for a malformed payload
+func writeDecodeProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, errUnsupportedContentType):
+		writeProblem(w, r, http.StatusUnsupportedMediaType, codeUnsupportedType, err.Error())
+	case errors.As(err, &tooLarge):
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, codePayloadTooLarge, err.Error())
+	default:
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidPayload, err.Error())
+	}
+}
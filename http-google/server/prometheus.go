@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
+)
+
+// prometheusReader collects the metrics served on /metrics, nil when PROMETHEUS_METRICS=false
+var prometheusReader *otelprom.Exporter
+
+// prometheusRegistry is the registry the reader registers its collector in, served on /metrics
+var prometheusRegistry = prometheus.NewRegistry()
+
+// newPrometheusReader creates the reader of /metrics unless PROMETHEUS_METRICS disables it
+func newPrometheusReader() (*otelprom.Exporter, error) {
+	if enabled, err := strconv.ParseBool(os.Getenv("PROMETHEUS_METRICS")); err == nil && !enabled {
+		return nil, nil
+	}
+	// The instrumentation scope stays out of the labels, it would be repeated on every series
+	exporter, err := otelprom.New(otelprom.WithRegisterer(prometheusRegistry), otelprom.WithoutScopeInfo())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Prometheus exporter: %w", err)
+	}
+	return exporter, nil
+}
+
+// handlePrometheus serves the device gauges and the server request metrics in the
+// Prometheus exposition format, so a scraper can read them without a collector
+var handlePrometheus = promhttp.HandlerFor(prometheusRegistry, promhttp.HandlerOpts{})
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// defaultQuarantineMaxBytes caps the quarantine folder by default
+const defaultQuarantineMaxBytes = 100 << 20
+
+// quarantinedPayloads counts the payloads that failed to decode, by endpoint and content type
+var quarantinedPayloads metric.Int64Counter
+
+// quarantine keeps the undecodable payloads, nil when QUARANTINE_DIR is not set
+var quarantine *quarantineStore
+
+// quarantinedPayload is a quarantine file: the body as the handler read it, after the
+// Content-Encoding was removed, with what identifies the sender
+type quarantinedPayload struct {
+	ReceivedAt  time.Time           `json:"received_at"`
+	Path        string              `json:"path"`
+	ContentType string              `json:"content_type"`
+	RemoteAddr  string              `json:"remote_addr"`
+	DeviceID    string              `json:"device_id,omitempty"` // Of the token, when it is bound to a device
+	Headers     map[string][]string `json:"headers"`
+	Error       string              `json:"error"`
+	Body        []byte              `json:"body"` // base64 in the file
+}
+
+// secretHeaders are left out of the quarantine files
+var secretHeaders = []string{"Authorization", apiKeyHeader, "Cookie"}
+
+// quarantineFile is a file of the folder, for the cap
+type quarantineFile struct {
+	name string
+	size int64
+}
+
+// quarantineStore writes the undecodable payloads to a folder, one JSON file each, removing
+// the oldest ones past maxBytes so the latest payloads of a misbehaving firmware are kept
+type quarantineStore struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	files []quarantineFile // oldest first
+	size  int64
+}
+
+// newQuarantineStore opens the quarantine folder, counting the files a previous run left there
+func newQuarantineStore(dir string, maxBytes int64) (*quarantineStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine dir: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	q := &quarantineStore{dir: dir, maxBytes: maxBytes}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		q.files = append(q.files, quarantineFile{e.Name(), info.Size()})
+		q.size += info.Size()
+	}
+	// The names start with the time they were written
+	sort.Slice(q.files, func(i, j int) bool { return q.files[i].name < q.files[j].name })
+	return q, nil
+}
+
+// store writes a payload, then removes the oldest files while the folder is over its cap
+func (q *quarantineStore) store(p quarantinedPayload) (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > q.maxBytes {
+		return "", fmt.Errorf("payload of %d bytes over the quarantine cap", len(data))
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := p.ReceivedAt.UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".json"
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(q.dir, name), data, 0o644); err != nil {
+		return "", err
+	}
+	q.files = append(q.files, quarantineFile{name, int64(len(data))})
+	q.size += int64(len(data))
+	for q.size > q.maxBytes && len(q.files) > 1 {
+		oldest := q.files[0]
+		if err := os.Remove(filepath.Join(q.dir, oldest.name)); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove quarantined payload", slog.String("file", oldest.name), slog.Any("error", err))
+		}
+		q.files = q.files[1:]
+		q.size -= oldest.size
+	}
+	return name, nil
+}
+
+// initQuarantine creates the counter of the undecodable payloads and, when QUARANTINE_DIR is
+// set, the folder they are kept in, capped at QUARANTINE_MAX_BYTES
+func initQuarantine(meter metric.Meter) {
+	var err error
+	quarantinedPayloads, err = meter.Int64Counter("custom.googleapis.com/quarantined_payloads",
+		metric.WithDescription("Payload dei dispositivi non decodificabili, per endpoint e content type"))
+	if err != nil {
+		log.Fatalf("failed to create quarantined_payloads counter: %v", err)
+	}
+
+	dir := os.Getenv("QUARANTINE_DIR")
+	if dir == "" {
+		return
+	}
+	maxBytes := int64(defaultQuarantineMaxBytes)
+	if raw := os.Getenv("QUARANTINE_MAX_BYTES"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			slog.Warn("Invalid QUARANTINE_MAX_BYTES, using the default",
+				slog.String("value", raw), slog.Int64("default", maxBytes))
+		} else {
+			maxBytes = n
+		}
+	}
+	if quarantine, err = newQuarantineStore(dir, maxBytes); err != nil {
+		log.Fatalf("failed to open quarantine: %v", err)
+	}
+	slog.Info("Quarantine of undecodable payloads enabled", slog.String("dir", dir), slog.Int64("max_bytes", maxBytes))
+}
+
+// quarantinePayload counts a payload that failed to decode and keeps it in the quarantine, if enabled
+func quarantinePayload(ctx context.Context, r *http.Request, body []byte, decodeErr error) {
+	contentType := payloadType(r)
+	if quarantinedPayloads != nil {
+		quarantinedPayloads.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("endpoint", r.URL.Path), attribute.String("content_type", contentType)))
+	}
+	if quarantine == nil {
+		return
+	}
+
+	headers := make(map[string][]string, len(r.Header))
+	for k, v := range r.Header {
+		if !slices.ContainsFunc(secretHeaders, func(h string) bool { return strings.EqualFold(h, k) }) {
+			headers[k] = v
+		}
+	}
+	p := quarantinedPayload{
+		ReceivedAt:  time.Now().UTC(),
+		Path:        r.URL.Path,
+		ContentType: contentType,
+		RemoteAddr:  r.RemoteAddr,
+		Headers:     headers,
+		Error:       decodeErr.Error(),
+		Body:        body,
+	}
+	if id, ok := identityFromContext(ctx); ok {
+		p.DeviceID = id.DeviceID
+	}
+	name, err := quarantine.store(p)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to quarantine payload", slog.String("path", r.URL.Path), slog.Any("error", err))
+		return
+	}
+	slog.WarnContext(ctx, "Undecodable payload quarantined",
+		slog.String("path", r.URL.Path),
+		slog.String("file", name),
+		slog.Int("bytes", len(body)),
+		slog.Any("error", decodeErr),
+	)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// defaultGeohashPrecision groups the devices without a region in cells of about 39x20 km
+const defaultGeohashPrecision = 4
+
+// geohashAlphabet is the base32 alphabet of the geohashes
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohash encodes a position in a geohash of precision characters
+func geohash(lat, lon float64, precision int) string {
+	latRange, lonRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	hash := make([]byte, 0, precision)
+	bit, ch, even := 0, 0, true
+	for len(hash) < precision {
+		// Even bits halve the longitude, odd bits the latitude
+		r, v := &latRange, lat
+		if even {
+			r, v = &lonRange, lon
+		}
+		mid := (r[0] + r[1]) / 2
+		if v >= mid {
+			ch |= 1 << (4 - bit)
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			hash = append(hash, geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// regionAggregates are the fleet readings aggregated per region, one gauge each
+var regionAggregates = []struct {
+	name, description, stat string
+	value                   func(m Metrics) float64
+}{
+	{"region_external_thermometer_celsius", "Temperatura esterna media dei dispositivi della regione", "avg",
+		func(m Metrics) float64 { return m.ExternalSensors.ThermometerC }},
+	{"region_anemometer_mps", "Velocità del vento massima dei dispositivi della regione", "max",
+		func(m Metrics) float64 { return m.ExternalSensors.AnemometerMPS }},
+}
+
+// regionKey is the region a device is aggregated in, per tenant
+type regionKey struct {
+	tenant, region, kind string
+}
+
+// regionOf returns the region of a sample: the region of its fleet, otherwise the geohash
+// of its position with precision characters
+func regionOf(m Metrics, precision int) (region, kind string) {
+	if m.Region != "" {
+		return m.Region, "fleet"
+	}
+	return geohash(m.GeoPosition.Latitude, m.GeoPosition.Longitude, precision), "geohash"
+}
+
+// loadGeohashPrecision reads REGION_GEOHASH_PRECISION, between 1 and 12 characters
+func loadGeohashPrecision() int {
+	raw := os.Getenv("REGION_GEOHASH_PRECISION")
+	if raw == "" {
+		return defaultGeohashPrecision
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 1 || n > 12 {
+		slog.Warn("Invalid REGION_GEOHASH_PRECISION, using the default",
+			slog.String("value", raw), slog.Int("default", defaultGeohashPrecision))
+		return defaultGeohashPrecision
+	}
+	return n
+}
+
+// registerRegionObservers creates the regional gauges and the callback reporting, for every
+// region with devices in the metric cache, how many there are and the aggregates of their
+// latest samples, so maps don't need a series per device
+func registerRegionObservers(meter metric.Meter) error {
+	precision := loadGeohashPrecision()
+	devices, err := meter.Int64ObservableGauge("custom.googleapis.com/region_devices",
+		metric.WithDescription("Dispositivi della regione con un campione nella cache"))
+	if err != nil {
+		return err
+	}
+	gauges := make([]metric.Float64ObservableGauge, len(regionAggregates))
+	instruments := []metric.Observable{devices}
+	for i, ra := range regionAggregates {
+		g, err := meter.Float64ObservableGauge("custom.googleapis.com/"+ra.name, metric.WithDescription(ra.description))
+		if err != nil {
+			return err
+		}
+		gauges[i] = g
+		instruments = append(instruments, g)
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		samples, err := globalMetricCache.snapshot(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read the metric cache: %w", err)
+		}
+
+		counts := make(map[regionKey]int)
+		values := make(map[regionKey][]float64)
+		for _, m := range samples {
+			region, kind := regionOf(m, precision)
+			key := regionKey{m.Tenant, region, kind}
+			if _, ok := values[key]; !ok {
+				values[key] = make([]float64, len(regionAggregates))
+				for i, ra := range regionAggregates {
+					if ra.stat == "max" {
+						values[key][i] = math.Inf(-1)
+					}
+				}
+			}
+			counts[key]++
+			for i, ra := range regionAggregates {
+				v := ra.value(m)
+				if ra.stat == "max" {
+					values[key][i] = math.Max(values[key][i], v)
+				} else {
+					values[key][i] += v
+				}
+			}
+		}
+
+		for key, n := range counts {
+			attrs := []attribute.KeyValue{attribute.String("region", key.region), attribute.String("region_kind", key.kind)}
+			if key.tenant != "" {
+				attrs = append(attrs, attribute.String("tenant", key.tenant))
+			}
+			opt := metric.WithAttributes(attrs...)
+			observer.ObserveInt64(devices, int64(n), opt)
+			for i, ra := range regionAggregates {
+				v := values[key][i]
+				if ra.stat == "avg" {
+					v /= float64(n)
+				}
+				observer.ObserveFloat64(gauges[i], v, opt)
+			}
+		}
+		return nil
+	}, instruments...)
+	return err
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRegistryFlushInterval is how often the registry is written to its file by default
+const defaultRegistryFlushInterval = 30 * time.Second
+
+// deviceRecord is the inventory entry of a device
+type deviceRecord struct {
+	DeviceID        string       `json:"device_id"`
+	Tenant          string       `json:"tenant,omitempty"`
+	FirstSeen       time.Time    `json:"first_seen"`
+	LastSeen        time.Time    `json:"last_seen"`
+	GeoPosition     *GeoPosition `json:"geo_position,omitempty"` // Last known position, nil until a metric arrives
+	ContentType     string       `json:"content_type"`           // Content type of the last payload
+	SchemaVersion   uint32       `json:"schema_version"`         // Payload schema version of the last sample, 0 if the device sends none
+	FirmwareVersion string       `json:"firmware_version,omitempty"`
+	Fleet           string       `json:"fleet,omitempty"`
+	Region          string       `json:"region,omitempty"`
+	Metrics         uint64       `json:"metrics"`     // Samples received
+	LogBatches      uint64       `json:"log_batches"` // Log batches received
+}
+
+// deviceRegistry keeps the devices that contacted the server, written to path when it is
+// set so the inventory survives a restart
+type deviceRegistry struct {
+	path string
+
+	mu      sync.Mutex
+	devices map[string]*deviceRecord
+	dirty   bool // changed since the last flush
+}
+
+// registry is the device inventory of the server
+var registry = &deviceRegistry{devices: make(map[string]*deviceRecord)}
+
+// loadDeviceRegistry opens the registry persisted in path, empty if the file does not
+// exist yet; with no path the registry is kept in memory only
+func loadDeviceRegistry(path string) (*deviceRegistry, error) {
+	r := &deviceRegistry{path: path, devices: make(map[string]*deviceRecord)}
+	if path == "" {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*deviceRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("corrupt device registry %s: %w", path, err)
+	}
+	for _, rec := range records {
+		r.devices[tenantDeviceKey(rec.Tenant, rec.DeviceID)] = rec
+	}
+	return r, nil
+}
+
+// record returns the record of a device of a tenant, created on its first contact
+func (r *deviceRegistry) record(tenant, deviceID string, now time.Time) *deviceRecord {
+	key := tenantDeviceKey(tenant, deviceID)
+	rec, ok := r.devices[key]
+	if !ok {
+		rec = &deviceRecord{DeviceID: deviceID, Tenant: tenant, FirstSeen: now, LastSeen: now}
+		r.devices[key] = rec
+		r.dirty = true
+		slog.Info("New device registered", slog.String("device_id", deviceID), slog.String("tenant", tenant))
+	}
+	return rec
+}
+
+// touch returns the record of a device and marks it seen now
+func (r *deviceRegistry) touch(tenant, deviceID, contentType string, now time.Time) *deviceRecord {
+	rec := r.record(tenant, deviceID, now)
+	rec.LastSeen = now
+	rec.ContentType = contentType
+	r.dirty = true
+	return rec
+}
+
+// admit registers a device of a tenant unless the tenant already has quota devices,
+// quota 0 meaning no limit; the devices already registered are always admitted
+func (r *deviceRegistry) admit(tenant, deviceID string, quota int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.devices[tenantDeviceKey(tenant, deviceID)]; ok || quota <= 0 {
+		return true
+	}
+	n := 0
+	for _, rec := range r.devices {
+		if rec.Tenant == tenant {
+			n++
+		}
+	}
+	if n >= quota {
+		return false
+	}
+	r.record(tenant, deviceID, time.Now().UTC())
+	return true
+}
+
+// seenMetrics records a sample of a device
+func (r *deviceRegistry) seenMetrics(m Metrics, contentType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec := r.touch(m.Tenant, m.DeviceID, contentType, time.Now().UTC())
+	geo := m.GeoPosition
+	rec.GeoPosition = &geo
+	rec.SchemaVersion = m.SchemaVersion
+	rec.FirmwareVersion = m.FirmwareVersion
+	rec.Fleet = m.Fleet
+	rec.Region = m.Region
+	rec.Metrics++
+}
+
+// seenLogs records a log batch of a device of a tenant
+func (r *deviceRegistry) seenLogs(tenant, deviceID, contentType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.touch(tenant, deviceID, contentType, time.Now().UTC()).LogBatches++
+}
+
+// get returns a copy of the record of a device of a tenant
+func (r *deviceRegistry) get(tenant, deviceID string) (deviceRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.devices[tenantDeviceKey(tenant, deviceID)]
+	if !ok {
+		return deviceRecord{}, false
+	}
+	return *rec, true
+}
+
+// list returns a copy of the records matching keep, sorted by tenant and device ID
+func (r *deviceRegistry) list(keep func(deviceRecord) bool) []deviceRecord {
+	r.mu.Lock()
+	records := make([]deviceRecord, 0, len(r.devices))
+	for _, rec := range r.devices {
+		if keep(*rec) {
+			records = append(records, *rec)
+		}
+	}
+	r.mu.Unlock()
+	slices.SortFunc(records, func(a, b deviceRecord) int {
+		return strings.Compare(tenantDeviceKey(a.Tenant, a.DeviceID), tenantDeviceKey(b.Tenant, b.DeviceID))
+	})
+	return records
+}
+
+// flush writes the registry to its file if it changed, through a temporary file so a
+// crash never leaves it half written
+func (r *deviceRegistry) flush() error {
+	r.mu.Lock()
+	if r.path == "" || !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	r.dirty = false
+	r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r.list(func(deviceRecord) bool { return true }), "", "  ")
+	if err == nil {
+		tmp := r.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o644); err == nil {
+			err = os.Rename(tmp, r.path)
+		}
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.dirty = true // retried at the next flush
+		r.mu.Unlock()
+	}
+	return err
+}
+
+// run flushes the registry every interval until ctx is done, then a last time
+func (r *deviceRegistry) run(ctx context.Context, interval time.Duration) {
+	if r.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err := r.flush(); err != nil {
+				slog.Error("Failed to write the device registry", slog.Any("error", err))
+			}
+			return
+		}
+		if err := r.flush(); err != nil {
+			slog.Error("Failed to write the device registry", slog.Any("error", err))
+		}
+	}
+}
+
+// registryFlushInterval reads how often the registry is written from DEVICE_REGISTRY_FLUSH
+func registryFlushInterval() time.Duration {
+	raw := os.Getenv("DEVICE_REGISTRY_FLUSH")
+	if raw == "" {
+		return defaultRegistryFlushInterval
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		slog.Warn("Invalid DEVICE_REGISTRY_FLUSH, using the default",
+			slog.String("value", raw), slog.Duration("default", defaultRegistryFlushInterval))
+		return defaultRegistryFlushInterval
+	}
+	return interval
+}
+
+// allowRegistryRead answers 403 and returns false to the tokens of a single device,
+// which may send for it but not read the inventory of the fleet
+func allowRegistryRead(w http.ResponseWriter, r *http.Request) bool {
+	if id, ok := identityFromContext(r.Context()); ok && id.DeviceID != "" {
+		writeProblem(w, r, http.StatusForbidden, codeForbiddenDevice, "device tokens can't read the device registry")
+		return false
+	}
+	return true
+}
+
+// handleDevices lists the registered devices of the tenant of the request, filtered by the
+// fleet, region and seen_since (a Go duration like 15m) query parameters when given; a
+// request without tenant lists the devices of every tenant
+func handleDevices(w http.ResponseWriter, r *http.Request) {
+	if !allowRegistryRead(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	var since time.Time
+	if raw := q.Get("seen_since"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidQuery, "invalid seen_since: "+err.Error())
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	fleet, region := q.Get("fleet"), q.Get("region")
+	tenant := tenantFromContext(r.Context())
+
+	records := registry.list(func(rec deviceRecord) bool {
+		return (tenant == "" || rec.Tenant == tenant) &&
+			(fleet == "" || rec.Fleet == fleet) &&
+			(region == "" || rec.Region == region) &&
+			!rec.LastSeen.Before(since)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Count   int            `json:"count"`
+		Devices []deviceRecord `json:"devices"`
+	}{len(records), records})
+}
+
+// handleDevice returns the record of the device in the path of the tenant of the request,
+// 404 if it never contacted the server
+func handleDevice(w http.ResponseWriter, r *http.Request) {
+	if !allowRegistryRead(w, r) {
+		return
+	}
+	rec, ok := registry.get(tenantFromContext(r.Context()), r.PathValue("id"))
+	if !ok {
+		writeProblem(w, r, http.StatusNotFound, codeNotFound, "unknown device "+r.PathValue("id"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec)
+}
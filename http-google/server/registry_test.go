@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRegistryRecordsSchemaVersion checks the inventory keeps the payload schema version
+// of the last sample of a device apart from the content type it was sent with
+func TestRegistryRecordsSchemaVersion(t *testing.T) {
+	t.Setenv("AUTH_API_KEYS", "")
+	t.Setenv("AUTH_JWT_SECRET", "")
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	tests := []struct {
+		name, body string
+		want       uint32
+	}{
+		{"device without a schema version", sample(t, "schema-device"), 0},
+		{"device with a schema version", strings.Replace(sample(t, "schema-device"), `"schema_version":0`, `"schema_version":3`, 1), 3},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/batchMetric", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("%s: got %d, want 202: %s", tt.name, rec.Code, rec.Body)
+		}
+
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/devices/schema-device", nil))
+		var device deviceRecord
+		if err := json.Unmarshal(rec.Body.Bytes(), &device); err != nil {
+			t.Fatalf("%s: invalid device %q: %v", tt.name, rec.Body, err)
+		}
+		if device.SchemaVersion != tt.want || device.ContentType != "application/json" {
+			t.Fatalf("%s: got schema version %d and content type %q, want %d and application/json",
+				tt.name, device.SchemaVersion, device.ContentType, tt.want)
+		}
+	}
+}
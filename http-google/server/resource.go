@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"runtime/debug"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// defaultServiceName tells the traces and metrics of this server from the CoAP server and the simulator
+const defaultServiceName = "http-server"
+
+// serviceResource describes the server on its traces and metrics: its name, version and,
+// on Cloud Run, the service, revision and region it runs in. OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES override them.
+func serviceResource() (*resource.Resource, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", defaultServiceName),
+		attribute.String("service.version", serviceVersion()),
+	}
+	if host, err := os.Hostname(); err == nil {
+		attrs = append(attrs, attribute.String("service.instance.id", host))
+	}
+	// K_SERVICE and K_REVISION are set by Cloud Run, the region by the deploy
+	if svc := os.Getenv("K_SERVICE"); svc != "" {
+		attrs = append(attrs,
+			attribute.String("cloud.provider", "gcp"),
+			attribute.String("cloud.platform", "gcp_cloud_run"),
+			attribute.String("faas.name", svc),
+			attribute.String("faas.version", os.Getenv("K_REVISION")),
+		)
+	}
+	if region := os.Getenv("CLOUD_REGION"); region != "" {
+		attrs = append(attrs, attribute.String("cloud.region", region))
+	}
+	return resource.Merge(resource.NewSchemaless(attrs...), resource.Environment())
+}
+
+// serviceVersion returns SERVICE_VERSION, or the module version or VCS revision the
+// binary was built from, "dev" when there is none
+func serviceVersion() string {
+	if v := os.Getenv("SERVICE_VERSION"); v != "" {
+		return v
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" && s.Value != "" {
+			return s.Value
+		}
+	}
+	return "dev"
+}
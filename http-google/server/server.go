@@ -13,13 +13,40 @@ import (
 // *http.ServeMux is Go's HTTP request multiplexer that matches URL paths to handlers.
 // This function also wraps handlers with OpenTelemetry instrumentation for tracing.
 func registerRoutes(mux *http.ServeMux) {
-	registerInstrumentedRoute(mux, "/batchLog", handleBatchLog)
-	registerInstrumentedRoute(mux, "/batchMetric", handleMetrics)
+	auth := loadAuthConfig()
+	if auth.enabled() {
+		slog.Info("Device authentication enabled",
+			slog.Int("api_keys", len(auth.apiKeys)), slog.Bool("jwt", auth.jwtSecret != nil))
+	}
+	maxBodyBytes := loadMaxBodyBytes()
+	registerInstrumentedRoute(mux, "/batchLog", auth, maxBodyBytes, handleBatchLog)
+	registerInstrumentedRoute(mux, "/batchMetric", auth, maxBodyBytes, handleMetrics)
+	registerInstrumentedRoute(mux, "/batchMetrics", auth, maxBodyBytes, handleMetricBatch)
+
+	// The device inventory, the pending commands and the event catalog are used with the same
+	// credentials the devices send with, not with the token of a single device; commands are
+	// queued with the admin API
+	mux.Handle("GET /devices", otelhttp.NewHandler(requireAuth(auth, resolveTenant(http.HandlerFunc(handleDevices))), "/devices"))
+	mux.Handle("GET /devices/{id}", otelhttp.NewHandler(requireAuth(auth, resolveTenant(http.HandlerFunc(handleDevice))), "/devices/{id}"))
+	mux.Handle("GET /devices/{id}/commands", otelhttp.NewHandler(requireAuth(auth, resolveTenant(http.HandlerFunc(handlePendingCommands))), "/devices/{id}/commands"))
+	mux.Handle("GET /events/catalog", otelhttp.NewHandler(requireAuth(auth, http.HandlerFunc(handleEventCatalog)), "/events/catalog"))
+
+	// The contract of the API is public, for client implementers and test tools
+	mux.Handle("GET /openapi.json", otelhttp.NewHandler(http.HandlerFunc(handleOpenAPI), "/openapi.json"))
+
+	// The admin API takes its own keys, the device credentials never reach it
+	registerAdminRoutes(mux)
+
+	// The scrapes are not traced, they would outnumber the device requests
+	if prometheusReader != nil {
+		mux.Handle("/metrics", handlePrometheus)
+	}
 }
 
 // startHTTPServer starts the HTTP server with the given context.
 // It reads the port from the environment variable "PORT", defaults to 8080 if not set.
-// Then it creates a new ServeMux, registers routes, logs server start info, and listens.
+// Then it creates a new ServeMux, registers routes, logs server start info, and listens,
+// over TLS when TLS_CERT_FILE and TLS_KEY_FILE are set.
 func startHTTPServer(ctx context.Context) {
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -30,17 +57,30 @@ func startHTTPServer(ctx context.Context) {
 	mux := http.NewServeMux()
 	registerRoutes(mux)
 
-	slog.InfoContext(ctx, "Starting HTTP server", slog.String("addr", "0.0.0.0"+addr))
+	tlsConfig, err := loadTLSConfig(ctx)
+	if err != nil {
+		log.Fatalf("failed to set up TLS: %v", err)
+	}
+	server := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfig}
+
+	slog.InfoContext(ctx, "Starting HTTP server", slog.String("addr", "0.0.0.0"+addr), slog.Bool("tls", tlsConfig != nil))
 
 	// Start HTTP server and log fatal error if it fails
-	log.Fatal(http.ListenAndServe(addr, mux))
+	if tlsConfig != nil {
+		// The certificate comes from TLSConfig, reloaded when it is rotated
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
+	log.Fatal(server.ListenAndServe())
 }
 
 // registerInstrumentedRoute wraps the given HTTP handler with OpenTelemetry instrumentation
 // so that each request is automatically traced and metrics are collected.
 // It then registers the instrumented handler with the given route path on the mux.
-func registerInstrumentedRoute(mux *http.ServeMux, route string, handler http.HandlerFunc) {
-	// Wrap the handler with OpenTelemetry HTTP instrumentation, adding the route as a tag
-	instrumentedHandler := otelhttp.NewHandler(otelhttp.WithRouteTag(route, handler), route)
+func registerInstrumentedRoute(mux *http.ServeMux, route string, auth authConfig, maxBodyBytes int64, handler http.HandlerFunc) {
+	// Wrap the handler with OpenTelemetry HTTP instrumentation, adding the route as a tag;
+	// unauthenticated requests, unknown content types and oversized bodies are rejected,
+	// still traced and counted, before decompressing the payload
+	instrumentedHandler := otelhttp.NewHandler(otelhttp.WithRouteTag(route, measureIngest(route,
+		requireAuth(auth, resolveTenant(limitPayload(maxBodyBytes, decompressBody(handler)))))), route)
 	mux.Handle(route, instrumentedHandler)
 }
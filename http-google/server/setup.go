@@ -5,7 +5,6 @@ import (
 	"errors"
 	"log/slog"
 	"os"
-	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
@@ -15,8 +14,13 @@ import (
 	"go.opentelemetry.io/otel/sdk/trace"
 	//"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
 	//"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+
+	"devicetransport/gcplog"
 )
 
+// meterProvider exports the metrics, kept to force an export from the admin API
+var meterProvider *metric.MeterProvider
+
 // setupOpentelemetry configures OpenTelemetry tracing and metrics exporters to send data
 // to a remote OpenTelemetry Collector. It returns a shutdown function to clean up resources.
 func setupOpentelemetry(ctx context.Context) (shutdown func(context.Context) error, err error) {
@@ -35,41 +39,67 @@ func setupOpentelemetry(ctx context.Context) (shutdown func(context.Context) err
 	// Set the global propagator to TraceContext for trace context propagation over HTTP
 	otel.SetTextMapPropagator(propagation.TraceContext{})
 
-	// Create a new OTLP trace exporter sending to a specific endpoint and URL path of the collector
-	tExporter, err := otlptracehttp.New(ctx,
-		otlptracehttp.WithEndpoint("otel-collector-1094805005874.europe-west1.run.app"),
-		otlptracehttp.WithURLPath("/v1/traces"),
-	)
+	// Read the collector endpoint, headers and protocol from OTLP_CONFIG_FILE and the
+	// OTEL_EXPORTER_OTLP_* variables, the Cloud Run collector by default
+	cfg, err := loadOTLPConfig()
+	if err != nil {
+		return
+	}
+	traceOpts, err := cfg.traceOptions()
+	if err != nil {
+		return
+	}
+	metricOpts, err := cfg.metricOptions()
+	if err != nil {
+		return
+	}
+
+	// Describe the server on all its telemetry, to tell it from the CoAP server and the simulator
+	res, err := serviceResource()
+	if err != nil {
+		return
+	}
+
+	// Create a new OTLP trace exporter sending to the traces URL of the collector
+	tExporter, err := otlptracehttp.New(ctx, traceOpts...)
 	if err != nil {
 		err = errors.Join(err, shutdown(ctx))
 		return
 	}
 
 	// Create a tracer provider using the trace exporter and batch processing
-	tp := trace.NewTracerProvider(trace.WithBatcher(tExporter))
+	tp := trace.NewTracerProvider(trace.WithBatcher(tExporter), trace.WithResource(res))
 	shutdownFuncs = append(shutdownFuncs, tp.Shutdown)
 	// Set the global tracer provider for the application
 	otel.SetTracerProvider(tp)
 
-	// Create a new OTLP metric exporter to the same collector endpoint for metrics
-	mExporter, err := otlpmetrichttp.New(ctx,
-		otlpmetrichttp.WithEndpoint("otel-collector-1094805005874.europe-west1.run.app"),
-		otlpmetrichttp.WithURLPath("/v1/metrics"),
-	)
+	// Create a new OTLP metric exporter to the metrics URL of the same collector
+	mExporter, err := otlpmetrichttp.New(ctx, metricOpts...)
 	if err != nil {
 		err = errors.Join(err, shutdown(ctx))
 		return
 	}
 
-	// Create a metric provider with a periodic reader that exports metrics every 1 minute
-	mp := metric.NewMeterProvider(
+	// Create a metric provider with a periodic reader that exports metrics every minute by default,
+	// and the reader of the Prometheus endpoint unless it is disabled
+	opts := []metric.Option{
+		metric.WithResource(res),
 		metric.WithReader(
-			metric.NewPeriodicReader(mExporter,
-				metric.WithInterval(1*time.Minute), // Export metrics every 1 minute
+			metric.NewPeriodicReader(exemplarExporter{mExporter},
+				metric.WithInterval(cfg.MetricExportInterval),
 			),
 		),
-	)
+	}
+	if prometheusReader, err = newPrometheusReader(); err != nil {
+		err = errors.Join(err, shutdown(ctx))
+		return
+	}
+	if prometheusReader != nil {
+		opts = append(opts, metric.WithReader(prometheusReader))
+	}
+	mp := metric.NewMeterProvider(opts...)
 	shutdownFuncs = append(shutdownFuncs, mp.Shutdown)
+	meterProvider = mp
 
 	// Set the global meter provider for metrics
 	otel.SetMeterProvider(mp)
@@ -81,10 +111,10 @@ func setupOpentelemetry(ctx context.Context) (shutdown func(context.Context) err
 // with log levels, attribute replacements for compatibility, and
 // OpenTelemetry span context injected into logs.
 func setupLogging() {
-	// Create a JSON handler for slog that outputs to stdout and replaces attributes using replacer function
+	// Create a JSON handler for slog that outputs to stdout and replaces attributes for Cloud Logging
 	jsonHandler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level:       slog.LevelDebug, // Log all levels >= Debug
-		ReplaceAttr: replacer})	// Customize attribute keys and values
+		ReplaceAttr: gcplog.ReplaceAttr})	// Customize attribute keys and values
 	
 	// Wrap the handler so it automatically adds OpenTelemetry span context to each log record
 	instrumentedHandler := handlerWithSpanContext(jsonHandler)
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tenantHeader names the tenant of a request authenticated without one, like a key shared
+// by the environments of a deployment
+const tenantHeader = "X-Tenant-ID"
+
+// tenantKey is the context key of the tenant of a request
+type tenantKey struct{}
+
+// withTenant returns a context carrying the tenant of the request
+func withTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantFromContext returns the tenant of the request, empty for single-tenant deployments
+func tenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// keySeparator joins the tenant and the device ID in tenantDeviceKey; neither can contain
+// it, or tenant acme with device x and device acme/x without tenant would share a key
+const keySeparator = "/"
+
+// tenantDeviceKey namespaces a device ID by tenant, so two customers can use the same IDs;
+// the tenants and the device IDs that reach it passed checkKeyParts
+func tenantDeviceKey(tenant, deviceID string) string {
+	if tenant == "" {
+		return deviceID
+	}
+	return tenant + keySeparator + deviceID
+}
+
+// checkKeyParts rejects a tenant or a device ID containing keySeparator
+func checkKeyParts(tenant, deviceID string) error {
+	if strings.Contains(tenant, keySeparator) {
+		return fmt.Errorf("tenant %q must not contain %q", tenant, keySeparator)
+	}
+	if strings.Contains(deviceID, keySeparator) {
+		return fmt.Errorf("device ID %q must not contain %q", deviceID, keySeparator)
+	}
+	return nil
+}
+
+// resolveTenant attaches the tenant of the request to its context: the one of its API key
+// or token, otherwise the X-Tenant-ID header. A header naming another tenant than the
+// credential is answered 403, a tenant containing keySeparator 400.
+func resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := strings.TrimSpace(r.Header.Get(tenantHeader))
+		tenant := header
+		if id, ok := identityFromContext(r.Context()); ok && id.Tenant != "" {
+			if header != "" && header != id.Tenant {
+				slog.WarnContext(r.Context(), "Forbidden request",
+					slog.String("path", r.URL.Path), slog.String("tenant", header))
+				writeProblem(w, r, http.StatusForbidden, codeForbiddenTenant, fmt.Sprintf("credentials of tenant %s can't act for tenant %s", id.Tenant, header))
+				return
+			}
+			tenant = id.Tenant
+		}
+		if err := checkKeyParts(tenant, ""); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidTenant, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), tenant)))
+	})
+}
+
+// tenantQuotas are the most devices each tenant can register, from TENANT_DEVICE_QUOTAS
+type tenantQuotas struct {
+	quotas       map[string]int
+	defaultQuota int // for the tenants not listed, 0 for no limit
+}
+
+// deviceQuotas is the device quota of the tenants, read at startup
+var deviceQuotas tenantQuotas
+
+// loadTenantQuotas reads TENANT_DEVICE_QUOTAS, comma separated tenant=devices entries where
+// the tenant * sets the quota of the tenants not listed
+func loadTenantQuotas() tenantQuotas {
+	q := tenantQuotas{quotas: make(map[string]int)}
+	for _, entry := range strings.Split(os.Getenv("TENANT_DEVICE_QUOTAS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		tenant, raw, _ := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || n <= 0 {
+			slog.Warn("Invalid TENANT_DEVICE_QUOTAS entry, skipping it", slog.String("entry", entry))
+			continue
+		}
+		if tenant = strings.TrimSpace(tenant); tenant == "*" {
+			q.defaultQuota = n
+		} else {
+			q.quotas[tenant] = n
+		}
+	}
+	return q
+}
+
+// of returns the device quota of a tenant, 0 for no limit
+func (q tenantQuotas) of(tenant string) int {
+	if n, ok := q.quotas[tenant]; ok {
+		return n
+	}
+	return q.defaultQuota
+}
+
+// admitDevices answers 403 and returns false when a device the tenant of the request has
+// not registered yet would take it past its quota, and 400 to a device ID containing
+// keySeparator; the admitted ones are registered
+func admitDevices(w http.ResponseWriter, r *http.Request, deviceIDs ...string) bool {
+	tenant := tenantFromContext(r.Context())
+	for _, id := range deviceIDs {
+		if err := checkKeyParts(tenant, id); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeInvalidPayload, err.Error())
+			return false
+		}
+	}
+	quota := deviceQuotas.of(tenant)
+	for _, id := range deviceIDs {
+		if registry.admit(tenant, id, quota) {
+			continue
+		}
+		slog.WarnContext(r.Context(), "Device quota exceeded",
+			slog.String("tenant", tenant), slog.String("device_id", id), slog.Int("quota", quota))
+		writeProblem(w, r, http.StatusForbidden, codeDeviceQuotaExceeded, fmt.Sprintf("tenant %q is at its quota of %d devices, device %s can't be added", tenant, quota, id))
+		return false
+	}
+	return true
+}
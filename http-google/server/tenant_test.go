@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sample returns a sample of deviceID that passes validation
+func sample(t *testing.T, deviceID string) string {
+	t.Helper()
+	m := Metrics{
+		DeviceID:        deviceID,
+		GeoPosition:     GeoPosition{Latitude: 45.46, Longitude: 9.19, Altitude: 120},
+		Timestamp:       time.Now(),
+		MCUUsagePercent: 30,
+		MCUTempC:        40,
+		BatteryPercent:  80,
+		RSSIDBm:         -70,
+		ExternalSensors: ExternalSensors{ThermometerC: 20, BarometerHPa: 1013, HygrometerRH: 50, AnemometerMPS: 3, PM25UGM3: 10, CO2PPM: 420},
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// TestDeviceKeysDontCollide checks a tenant or a device ID containing the separator of the
+// registry keys is rejected, so acme + a/b and acme/a + b can't share a device
+func TestDeviceKeysDontCollide(t *testing.T) {
+	t.Setenv("AUTH_API_KEYS", "")
+	t.Setenv("AUTH_JWT_SECRET", "")
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	tests := []struct {
+		name, tenant, deviceID string
+		want                   int
+	}{
+		{"device ID with the separator", "acme", "a/b", http.StatusBadRequest},
+		{"tenant with the separator", "acme/a", "b", http.StatusBadRequest},
+		{"plain keys", "acme", "a-b", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/batchMetric", strings.NewReader(sample(t, tt.deviceID)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(tenantHeader, tt.tenant)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+// TestRejectedSampleTakesNoQuota checks a sample failing validation doesn't register its
+// device, so it can't fill the quota of the tenant
+func TestRejectedSampleTakesNoQuota(t *testing.T) {
+	t.Setenv("AUTH_API_KEYS", "")
+	t.Setenv("AUTH_JWT_SECRET", "")
+	t.Setenv("TENANT_DEVICE_QUOTAS", "quota-test=1")
+	deviceQuotas = loadTenantQuotas()
+	t.Cleanup(func() { deviceQuotas = tenantQuotas{} })
+	mux := http.NewServeMux()
+	registerRoutes(mux)
+
+	invalid := strings.Replace(sample(t, "device-001"), `"battery_percent":80`, `"battery_percent":180`, 1)
+	tests := []struct {
+		name, body string
+		want       int
+	}{
+		{"invalid sample", invalid, http.StatusUnprocessableEntity},
+		{"valid sample of another device", sample(t, "device-002"), http.StatusAccepted},
+		{"valid sample past the quota", sample(t, "device-003"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/batchMetric", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(tenantHeader, "quota-test")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Fatalf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// thresholdsReloadInterval is how often the thresholds file is checked for changes
+const thresholdsReloadInterval = 10 * time.Second
+
+// threshold classifies a reading of a metric: past Warning, Critical or Emergency the
+// sample gets that severity, below them it is INFO. Unset levels never fire.
+type threshold struct {
+	Warning   *float64 `json:"warning"`
+	Critical  *float64 `json:"critical"`
+	Emergency *float64 `json:"emergency"`
+	Below     bool     `json:"below"` // Fire when the reading drops under the levels, like a low battery
+
+	// Messages logged for each severity, a generic one for the missing severities
+	Messages map[string]string `json:"messages"`
+}
+
+// thresholdSet are the thresholds of some metrics, by the field names of the validation
+type thresholdSet map[string]threshold
+
+// thresholdsConfig is the THRESHOLDS_FILE: the thresholds of a device replace the ones
+// of its fleet, which replace the defaults, metric by metric
+type thresholdsConfig struct {
+	Defaults thresholdSet            `json:"defaults"`
+	Fleets   map[string]thresholdSet `json:"fleets"`
+	Devices  map[string]thresholdSet `json:"devices"`
+}
+
+// defaultThresholds are the MCU temperature levels used without a THRESHOLDS_FILE
+var defaultThresholds = thresholdsConfig{
+	Defaults: thresholdSet{
+		"mcu_temp_c": {
+			Warning: ptr(75.0), Critical: ptr(85.0), Emergency: ptr(95.0),
+			Messages: map[string]string{
+				"WARNING":   "Temperature rising – monitor closely",
+				"CRITICAL":  "Critical temperature – action needed",
+				"EMERGENCY": "Emergency – device may fail",
+			},
+		},
+	},
+}
+
+// thresholds are the thresholds in use, swapped on reload
+var thresholds atomic.Pointer[thresholdsConfig]
+
+func init() {
+	thresholds.Store(&defaultThresholds)
+}
+
+// ptr returns a pointer to v, for the optional levels
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// validate checks that the thresholds name known metrics and that their levels are in order
+func (c thresholdsConfig) validate() error {
+	check := func(scope string, set thresholdSet) error {
+		for field, t := range set {
+			if !slices.ContainsFunc(metricBounds, func(b metricBound) bool { return b.field == field }) {
+				return fmt.Errorf("%s: unknown metric %q", scope, field)
+			}
+			var levels []float64
+			for _, l := range []*float64{t.Warning, t.Critical, t.Emergency} {
+				if l != nil {
+					levels = append(levels, *l)
+				}
+			}
+			ordered := slices.IsSorted(levels)
+			if t.Below {
+				ordered = slices.IsSortedFunc(levels, func(a, b float64) int { return cmp.Compare(b, a) })
+			}
+			if !ordered {
+				return fmt.Errorf("%s: levels of %s out of order", scope, field)
+			}
+		}
+		return nil
+	}
+	if err := check("defaults", c.Defaults); err != nil {
+		return err
+	}
+	for fleet, set := range c.Fleets {
+		if err := check("fleet "+fleet, set); err != nil {
+			return err
+		}
+	}
+	for device, set := range c.Devices {
+		if err := check("device "+device, set); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadThresholds reads and validates a thresholds file
+func loadThresholds(path string) (*thresholdsConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c thresholdsConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid thresholds file %s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid thresholds file %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// watchThresholds loads the thresholds from path, then reloads them whenever the file
+// changes until ctx is done; a file that fails to load keeps the previous thresholds
+func watchThresholds(ctx context.Context, path string) error {
+	c, err := loadThresholds(path)
+	if err != nil {
+		return err
+	}
+	thresholds.Store(c)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		modTime := info.ModTime()
+		ticker := time.NewTicker(thresholdsReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			c, err := loadThresholds(path)
+			if err != nil {
+				slog.Error("Failed to reload thresholds, keeping the previous ones", slog.Any("error", err))
+				continue
+			}
+			thresholds.Store(c)
+			slog.Info("Thresholds reloaded", slog.String("file", path))
+		}
+	}()
+	return nil
+}
+
+// forDevice returns the threshold of every metric of a device after the overrides
+func (c *thresholdsConfig) forDevice(deviceID, fleet string) thresholdSet {
+	set := make(thresholdSet, len(c.Defaults))
+	for _, overrides := range []thresholdSet{c.Defaults, c.Fleets[fleet], c.Devices[deviceID]} {
+		for field, t := range overrides {
+			set[field] = t
+		}
+	}
+	return set
+}
+
+// severity returns the severity of a reading, INFO when no level is crossed
+func (t threshold) severity(v float64) string {
+	crossed := func(level *float64) bool {
+		if level == nil {
+			return false
+		}
+		if t.Below {
+			return v < *level
+		}
+		return v >= *level
+	}
+	switch {
+	case crossed(t.Emergency):
+		return "EMERGENCY"
+	case crossed(t.Critical):
+		return "CRITICAL"
+	case crossed(t.Warning):
+		return "WARNING"
+	default:
+		return "INFO"
+	}
+}
+
+// metricAlert is a reading of a sample past one of its thresholds
+type metricAlert struct {
+	Field    string  `json:"field"`
+	Value    float64 `json:"value"`
+	Severity string  `json:"severity"`
+	message  string
+}
+
+// classifyMetrics returns the readings of a sample past their thresholds, most severe first
+func classifyMetrics(m Metrics) []metricAlert {
+	set := thresholds.Load().forDevice(m.DeviceID, m.Fleet)
+	var alerts []metricAlert
+	for _, b := range metricBounds {
+		t, ok := set[b.field]
+		if !ok {
+			continue
+		}
+		v := b.value(m)
+		sev := t.severity(v)
+		if sev == "INFO" {
+			continue
+		}
+		message, ok := t.Messages[sev]
+		if !ok {
+			message = fmt.Sprintf("%s at %g past the %s threshold", b.field, v, sev)
+		}
+		alerts = append(alerts, metricAlert{Field: b.field, Value: v, Severity: sev, message: message})
+	}
+	slices.SortStableFunc(alerts, func(a, b metricAlert) int {
+		return cmp.Compare(mapSeverityToLevel(b.Severity), mapSeverityToLevel(a.Severity))
+	})
+	return alerts
+}
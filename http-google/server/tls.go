@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// certReloadInterval is how often the certificate files are checked for a rotation
+const certReloadInterval = 30 * time.Second
+
+// certReloader serves the certificate of TLS_CERT_FILE and TLS_KEY_FILE, swapped without a
+// restart when either file changes, like after a certbot or cert-manager renewal
+type certReloader struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+	modTime           time.Time // latest change of the two files when last loaded
+}
+
+// newCertReloader loads the certificate and its key, failing if they don't match
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// filesModTime returns the latest change of the certificate and the key
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads the certificate and the key into use
+func (r *certReloader) load() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	r.modTime = modTime
+	return nil
+}
+
+// watch reloads the certificate whenever its files change until ctx is done; a pair that
+// fails to load, like a certificate written before its key, keeps the previous one
+func (r *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modTime, err := r.filesModTime()
+		if err != nil || !modTime.After(r.modTime) {
+			continue
+		}
+		if err := r.load(); err != nil {
+			slog.Error("Failed to reload TLS certificate, keeping the previous one", slog.Any("error", err))
+			continue
+		}
+		slog.Info("TLS certificate reloaded", slog.String("cert_file", r.certFile))
+	}
+}
+
+// getCertificate is the tls.Config.GetCertificate of the server
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// loadTLSConfig returns the TLS config of the server when TLS_CERT_FILE and TLS_KEY_FILE
+// are set, nil to serve plain HTTP behind a TLS-terminating proxy like Cloud Run's
+func loadTLSConfig(ctx context.Context) (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS needs both TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.watch(ctx)
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.getCertificate,
+	}, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// maxClockSkew is how far in the future a sample can be stamped, for device clocks running ahead
+const maxClockSkew = 5 * time.Minute
+
+// What the server does with a sample that fails validation, set with METRIC_VALIDATION
+const (
+	validationReject = "reject" // the sample is not recorded (default)
+	validationFlag   = "flag"   // the sample is recorded and logged as invalid
+	validationOff    = "off"    // samples are not checked
+)
+
+// metricValidation is the validation mode of the samples, read at startup
+var metricValidation = validationReject
+
+// validationFailures counts the samples that failed validation, by field and action
+var validationFailures metric.Int64Counter
+
+// metricBound is the physical range of a reading of the samples
+type metricBound struct {
+	field    string
+	min, max float64
+	value    func(m Metrics) float64
+}
+
+// metricBounds are the physical ranges of the readings, beyond which a sample is garbage
+var metricBounds = []metricBound{
+	{"geo_position.latitude", -90, 90, func(m Metrics) float64 { return m.GeoPosition.Latitude }},
+	{"geo_position.longitude", -180, 180, func(m Metrics) float64 { return m.GeoPosition.Longitude }},
+	{"geo_position.altitude", -500, 9000, func(m Metrics) float64 { return m.GeoPosition.Altitude }},
+	{"mcu_usage_percent", 0, 100, func(m Metrics) float64 { return m.MCUUsagePercent }},
+	{"mcu_temp_c", -40, 150, func(m Metrics) float64 { return m.MCUTempC }},
+	{"battery_percent", 0, 100, func(m Metrics) float64 { return m.BatteryPercent }},
+	{"rssi_dbm", -150, 0, func(m Metrics) float64 { return m.RSSIDBm }},
+	{"external_sensors.thermometer_c", -90, 60, func(m Metrics) float64 { return m.ExternalSensors.ThermometerC }},
+	{"external_sensors.barometer_hpa", 300, 1100, func(m Metrics) float64 { return m.ExternalSensors.BarometerHPa }},
+	{"external_sensors.hygrometer_rh", 0, 100, func(m Metrics) float64 { return m.ExternalSensors.HygrometerRH }},
+	{"external_sensors.anemometer_mps", 0, 120, func(m Metrics) float64 { return m.ExternalSensors.AnemometerMPS }},
+	{"external_sensors.pm25_ugm3", 0, 2000, func(m Metrics) float64 { return m.ExternalSensors.PM25UGM3 }},
+	{"external_sensors.co2_ppm", 0, 40000, func(m Metrics) float64 { return m.ExternalSensors.CO2PPM }},
+}
+
+// fieldError is a field of a sample that failed validation
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// writeValidationError answers 422 with the fields of the rejected sample
+func writeValidationError(w http.ResponseWriter, r *http.Request, deviceID string, errs []fieldError) {
+	fields := make([]string, 0, len(errs))
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	writeProblemBody(w, r, problem{
+		Status:   http.StatusUnprocessableEntity,
+		Code:     codeInvalidMetrics,
+		Detail:   fmt.Sprintf("sample of device %s failed validation: %s", deviceID, strings.Join(fields, ", ")),
+		DeviceID: deviceID,
+		Fields:   errs,
+	})
+}
+
+// validationMode reads the action on invalid samples from METRIC_VALIDATION
+func validationMode() string {
+	switch mode := strings.ToLower(os.Getenv("METRIC_VALIDATION")); mode {
+	case "":
+		return validationReject
+	case validationReject, validationFlag, validationOff:
+		return mode
+	default:
+		slog.Warn("Invalid METRIC_VALIDATION, rejecting invalid samples", slog.String("value", mode))
+		return validationReject
+	}
+}
+
+// initValidationMetrics creates the counter of the samples that failed validation
+func initValidationMetrics(meter metric.Meter) {
+	var err error
+	validationFailures, err = meter.Int64Counter("custom.googleapis.com/metric_validation_failures",
+		metric.WithDescription("Campioni di metriche non validi, per campo e azione"))
+	if err != nil {
+		log.Fatalf("failed to create metric_validation_failures counter: %v", err)
+	}
+}
+
+// validateMetrics checks the device ID, the range of every reading and the timestamp,
+// returning the fields that are out of bounds; NaN and infinities are always out
+func validateMetrics(m Metrics, now time.Time) []fieldError {
+	var errs []fieldError
+	if strings.TrimSpace(m.DeviceID) == "" {
+		errs = append(errs, fieldError{"device_id", "must not be empty"})
+	}
+	for _, b := range metricBounds {
+		if v := b.value(m); !(v >= b.min && v <= b.max) {
+			errs = append(errs, fieldError{b.field, fmt.Sprintf("%g is outside [%g, %g]", v, b.min, b.max)})
+		}
+	}
+	if m.Timestamp.After(now.Add(maxClockSkew)) {
+		errs = append(errs, fieldError{"timestamp", fmt.Sprintf("%s is more than %v in the future", m.Timestamp.Format(time.RFC3339), maxClockSkew)})
+	}
+	return errs
+}
+
+// checkMetrics validates a sample under mode, counting and logging its failures, and
+// reports whether the sample can be recorded
+func checkMetrics(ctx context.Context, mode string, m Metrics) (bool, []fieldError) {
+	if mode == validationOff {
+		return true, nil
+	}
+	errs := validateMetrics(m, time.Now())
+	if len(errs) == 0 {
+		return true, nil
+	}
+
+	action := "rejected"
+	if mode == validationFlag {
+		action = "flagged"
+	}
+	fields := make([]string, 0, len(errs))
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+		if validationFailures != nil {
+			validationFailures.Add(ctx, 1, metric.WithAttributes(attribute.String("field", e.Field), attribute.String("action", action)))
+		}
+	}
+	slog.WarnContext(ctx, "Invalid metrics sample",
+		slog.String("device_id", m.DeviceID),
+		slog.String("action", action),
+		slog.Any("fields", fields),
+		slog.Any("errors", errs),
+		slog.String("type", "devicemetric"),
+	)
+	return mode == validationFlag, errs
+}